@@ -0,0 +1,125 @@
+package cp
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// ArchiveWriter packs a copied tree into an archive, such as a tarball.
+// Entries are added one at a time; paths are slash separated and relative to
+// the root of the archive.
+type ArchiveWriter interface {
+	// WriteDir adds a directory.
+	WriteDir(path string, info os.FileInfo) error
+	// WriteFile adds a file with the contents read from r.
+	WriteFile(path string, info os.FileInfo, r io.Reader) error
+}
+
+// CopyToArchive copies the tree at from into the archive, without
+// touching the destination filesystem. The archive is left open for the
+// caller to close.
+func (c *Copier) CopyToArchive(from string, archive ArchiveWriter) (Report, error) {
+	defer c.start()()
+	fromFi, err := c.srcFs().Stat(from)
+	if err != nil {
+		return Report{}, errors.Wrap(err, "reading file metadata")
+	}
+	cp := c.copier()
+	cp.archive = archive
+	cp.archiveMu = &sync.Mutex{}
+	// Entries can't be read back to hash, so aren't in manifests.
+	cp.hashing = false
+	if !fromFi.IsDir() {
+		r := result{FileReport: FileReport{From: from, To: filepath.Base(from)}}
+		r.Bytes, r.Err = cp.archiveFile(from, filepath.Base(from))
+		report := Report{}
+		report.add(r)
+		c.finished(r)
+		return report, r.Err
+	}
+	cp.autoParallel(from, "")
+	return cp.run(func() {
+		cp.walkArchive(from)
+	})
+}
+
+// walkArchive adds directories to the archive as they are found and queues
+// their files. Directories always precede their contents since the files are
+// queued after their directory has been written.
+func (c *copier) walkArchive(from string) {
+	c.log(slog.LevelDebug, "walk started", "from", from)
+	c.emit(Event{Kind: WalkStarted, File: FileReport{From: from}})
+	ignores := c.ignores()
+	walker := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := filepath.ToSlash(strings.TrimPrefix(strings.Replace(path, from, "", 1), string(filepath.Separator)))
+		if c.excluded(rel, info) || ignores.ignored(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if err := ignores.read(path, rel); err != nil {
+				return err
+			}
+			if rel == "" {
+				return nil
+			}
+			c.archiveMu.Lock()
+			defer c.archiveMu.Unlock()
+			if err := c.archive.WriteDir(rel, info); err != nil {
+				return errors.Wrapf(err, "archiving %s", path)
+			}
+			return nil
+		}
+		c.enqueue(path, rel, info)
+		return nil
+	}
+	if err := afero.Walk(c.src, from, walker); err != nil {
+		c.results <- result{
+			FileReport: FileReport{From: from, Err: errors.Wrap(err, "walking file system")},
+		}
+	}
+}
+
+// archiveFile adds a single file to the archive. Entries cannot be
+// interleaved, so only one worker writes at a time.
+func (c *copier) archiveFile(from, to string) (int64, error) {
+	if c.fds != nil {
+		c.fds.acquire(1)
+		defer c.fds.release(1)
+	}
+	fromFile, err := c.src.Open(from)
+	if err != nil {
+		return 0, errors.Wrapf(err, "opening %s", from)
+	}
+	defer fromFile.Close()
+	fromFi, err := fromFile.Stat()
+	if err != nil {
+		return 0, errors.Wrap(err, "reading file metadata")
+	}
+	var r io.Reader = fromFile
+	if c.limit != nil {
+		r = throttledReader{r, c.limit}
+	}
+	counted := &countingReader{Reader: r, c: c.stats}
+	c.archiveMu.Lock()
+	err = c.archive.WriteFile(to, fromFi, counted)
+	c.archiveMu.Unlock()
+	if err != nil {
+		return counted.n, errors.Wrapf(err, "archiving %s", from)
+	}
+	atomic.AddInt64(&c.stats.files, 1)
+	return counted.n, nil
+}
@@ -0,0 +1,14 @@
+//go:build freebsd || netbsd || openbsd || dragonfly
+
+package cp
+
+// copyAttributes copies the file flags, such as nodump and uchg.
+func copyAttributes(from, to string) error {
+	return copyFlags(from, to)
+}
+
+// unlock clears the immutable and append only flags so that the file can
+// be overwritten.
+func unlock(path string) error {
+	return unlockFlags(path)
+}
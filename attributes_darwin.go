@@ -0,0 +1,18 @@
+//go:build darwin
+
+package cp
+
+// copyAttributes copies the Finder information and the quarantine applied
+// to downloads, then the file flags, among them hidden and locked.
+func copyAttributes(from, to string) error {
+	if err := copyXattrs(from, to, "com.apple.FinderInfo", "com.apple.quarantine"); err != nil {
+		return err
+	}
+	return copyFlags(from, to)
+}
+
+// unlock clears the locked flag, as the Finder's lock sets it, so that the
+// file can be overwritten.
+func unlock(path string) error {
+	return unlockFlags(path)
+}
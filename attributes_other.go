@@ -0,0 +1,13 @@
+//go:build !windows && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package cp
+
+// copyAttributes does nothing where there are no attributes to copy.
+func copyAttributes(from, to string) error {
+	return nil
+}
+
+// unlock does nothing where files cannot be locked against writing.
+func unlock(path string) error {
+	return nil
+}
@@ -0,0 +1,48 @@
+//go:build windows
+
+package cp
+
+import "syscall"
+
+// attributeMask is the attributes PreserveAttributes copies.
+const attributeMask = syscall.FILE_ATTRIBUTE_READONLY |
+	syscall.FILE_ATTRIBUTE_HIDDEN |
+	syscall.FILE_ATTRIBUTE_SYSTEM
+
+// copyAttributes copies the read-only, hidden and system attributes.
+func copyAttributes(from, to string) error {
+	src, err := syscall.UTF16PtrFromString(from)
+	if err != nil {
+		return err
+	}
+	dst, err := syscall.UTF16PtrFromString(to)
+	if err != nil {
+		return err
+	}
+	want, err := syscall.GetFileAttributes(src)
+	if err != nil {
+		return err
+	}
+	have, err := syscall.GetFileAttributes(dst)
+	if err != nil {
+		return err
+	}
+	return syscall.SetFileAttributes(dst, have&^attributeMask|want&attributeMask)
+}
+
+// unlock clears the read-only attribute so that the file can be
+// overwritten.
+func unlock(path string) error {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	attrs, err := syscall.GetFileAttributes(p)
+	if err != nil {
+		return err
+	}
+	if attrs&syscall.FILE_ATTRIBUTE_READONLY == 0 {
+		return nil
+	}
+	return syscall.SetFileAttributes(p, attrs&^syscall.FILE_ATTRIBUTE_READONLY)
+}
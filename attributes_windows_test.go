@@ -0,0 +1,58 @@
+//go:build windows
+
+package cp
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// TestCopier_PreserveAttributes tests that hidden and read-only attributes
+// survive a copy, and that a read-only copy can be copied over again.
+func TestCopier_PreserveAttributes(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "from")
+	if err := os.MkdirAll(filepath.Join(from, "config"), 0755); err != nil {
+		t.Fatalf("unexpected error creating directory: %v", err)
+	}
+	file := filepath.Join(from, "config", "settings.ini")
+	if err := os.WriteFile(file, []byte("settings"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	set := func(path string, attrs uint32) {
+		p, _ := syscall.UTF16PtrFromString(path)
+		if err := syscall.SetFileAttributes(p, attrs); err != nil {
+			t.Fatalf("unexpected error setting attributes: %v", err)
+		}
+	}
+	get := func(path string) uint32 {
+		p, _ := syscall.UTF16PtrFromString(path)
+		attrs, err := syscall.GetFileAttributes(p)
+		if err != nil {
+			t.Fatalf("unexpected error reading attributes: %v", err)
+		}
+		return attrs
+	}
+	set(filepath.Join(from, "config"), syscall.FILE_ATTRIBUTE_HIDDEN|syscall.FILE_ATTRIBUTE_DIRECTORY)
+	set(file, syscall.FILE_ATTRIBUTE_HIDDEN|syscall.FILE_ATTRIBUTE_READONLY)
+	defer set(file, syscall.FILE_ATTRIBUTE_NORMAL)
+	to := filepath.Join(dir, "to")
+	copier := Copier{PreserveAttributes: true, Clobber: true}
+	for ii := 0; ii < 2; ii++ {
+		if err := copier.Copy(from, to); err != nil {
+			t.Fatalf("unexpected error copying: %v", err)
+		}
+		// A fresh Copier, since one skips the files it has already copied.
+		copier = Copier{PreserveAttributes: true, Clobber: true}
+	}
+	copied := filepath.Join(to, "config", "settings.ini")
+	defer set(copied, syscall.FILE_ATTRIBUTE_NORMAL)
+	if got := get(copied) & attributeMask; got != syscall.FILE_ATTRIBUTE_HIDDEN|syscall.FILE_ATTRIBUTE_READONLY {
+		t.Errorf("want file hidden and read-only, got %#x", got)
+	}
+	if got := get(filepath.Join(to, "config")) & attributeMask; got != syscall.FILE_ATTRIBUTE_HIDDEN {
+		t.Errorf("want directory hidden, got %#x", got)
+	}
+}
@@ -0,0 +1,139 @@
+// Package azurefs copies to and from Azure Blob Storage by presenting a
+// container as an afero.Fs.
+//
+//	client, err := container.NewClientWithNoCredential(sasURL, nil)
+//	copier := cp.Copier{DstFs: azurefs.New(client)}
+//	err = copier.Copy("/data", "/prefix")
+//
+// Blobs are uploaded as block blobs, in blocks sent concurrently.
+package azurefs
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blockblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+
+	"github.com/jackmordaunt/cp/objectfs"
+)
+
+// Store is an objectfs.Store backed by an Azure container.
+type Store struct {
+	client *container.Client
+	// BlockSize is the size of each block uploaded, 4 MiB by default, and
+	// Concurrency the number of blocks of a blob uploaded at once, 4 by
+	// default.
+	BlockSize   int64
+	Concurrency int
+}
+
+var _ objectfs.Store = &Store{}
+
+// New presents the container as a filesystem.
+func New(client *container.Client) *objectfs.Fs {
+	return objectfs.New(NewStore(client))
+}
+
+// NewStore creates a Store for the container, authenticated however the
+// client is, such as by a SAS token in its URL.
+func NewStore(client *container.Client) *Store {
+	return &Store{client: client, BlockSize: 4 << 20, Concurrency: 4}
+}
+
+// Head describes the blob at key.
+func (s *Store) Head(ctx context.Context, key string) (objectfs.Object, error) {
+	props, err := s.client.NewBlobClient(key).GetProperties(ctx, nil)
+	if err != nil {
+		return objectfs.Object{}, translate(err)
+	}
+	return objectfs.Object{
+		Key:     key,
+		Size:    deref(props.ContentLength),
+		ModTime: deref(props.LastModified),
+	}, nil
+}
+
+// List describes the blobs and virtual directories directly under prefix.
+func (s *Store) List(ctx context.Context, prefix string) ([]objectfs.Object, error) {
+	var objects []objectfs.Object
+	pages := s.client.NewListBlobsHierarchyPager("/", &container.ListBlobsHierarchyOptions{
+		Prefix: to.Ptr(prefix),
+	})
+	for pages.More() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, translate(err)
+		}
+		for _, p := range page.Segment.BlobPrefixes {
+			objects = append(objects, objectfs.Object{
+				Key: strings.TrimSuffix(deref(p.Name), "/"),
+				Dir: true,
+			})
+		}
+		for _, item := range page.Segment.BlobItems {
+			name := deref(item.Name)
+			if name == prefix || item.Properties == nil {
+				continue
+			}
+			objects = append(objects, objectfs.Object{
+				Key:     name,
+				Size:    deref(item.Properties.ContentLength),
+				ModTime: deref(item.Properties.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+// Get reads the blob at key from offset.
+func (s *Store) Get(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	resp, err := s.client.NewBlobClient(key).DownloadStream(ctx, &blob.DownloadStreamOptions{
+		Range: blob.HTTPRange{Offset: offset},
+	})
+	if err != nil {
+		return nil, translate(err)
+	}
+	return resp.Body, nil
+}
+
+// Put uploads the blob at key in blocks, committed once all are sent, so a
+// failed upload leaves no blob behind.
+func (s *Store) Put(ctx context.Context, key string, r io.Reader) error {
+	_, err := s.client.NewBlockBlobClient(key).UploadStream(ctx, r, &blockblob.UploadStreamOptions{
+		BlockSize:   s.BlockSize,
+		Concurrency: s.Concurrency,
+	})
+	return translate(err)
+}
+
+// Delete removes the blob at key.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.NewBlobClient(key).Delete(ctx, nil)
+	return translate(err)
+}
+
+// deref is what p points to, or the zero value if it is nil.
+func deref[T any](p *T) T {
+	var v T
+	if p != nil {
+		v = *p
+	}
+	return v
+}
+
+// translate maps missing blobs and containers onto os.ErrNotExist.
+func translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+		return os.ErrNotExist
+	}
+	return err
+}
@@ -0,0 +1,287 @@
+package azurefs
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/spf13/afero"
+
+	"github.com/jackmordaunt/cp"
+	"github.com/jackmordaunt/cp/objectfs"
+)
+
+// fakeContainer serves the parts of the Blob service REST API the Store
+// uses, for the container named "c", keeping blobs in memory.
+type fakeContainer struct {
+	sync.Mutex
+	blobs map[string][]byte
+	// blocks are those staged and not yet committed, by block ID.
+	blocks map[string][]byte
+	// staged counts the blocks staged, to tell uploads in blocks from those
+	// made whole.
+	staged int
+}
+
+func (f *fakeContainer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutPrefix(r.URL.Path, "/c")
+	if !ok {
+		fail(w, http.StatusNotFound, "ContainerNotFound")
+		return
+	}
+	name = strings.TrimPrefix(name, "/")
+	q := r.URL.Query()
+	f.Lock()
+	defer f.Unlock()
+	switch {
+	case r.Method == "GET" && q.Get("comp") == "list":
+		f.list(w, q.Get("prefix"), q.Get("delimiter"))
+	case r.Method == "HEAD" || r.Method == "GET":
+		data, ok := f.blobs[name]
+		if !ok {
+			fail(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		offset := int64(0)
+		if rng := r.Header.Get("x-ms-range"); rng != "" {
+			fmt.Sscanf(rng, "bytes=%d-", &offset)
+		}
+		data = data[offset:]
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("x-ms-blob-type", "BlockBlob")
+		if r.Method == "HEAD" {
+			return
+		}
+		w.Write(data)
+	case r.Method == "PUT" && q.Get("comp") == "block":
+		data, _ := io.ReadAll(r.Body)
+		f.blocks[q.Get("blockid")] = data
+		f.staged++
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PUT" && q.Get("comp") == "blocklist":
+		var list struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&list); err != nil {
+			fail(w, http.StatusBadRequest, "InvalidXmlDocument")
+			return
+		}
+		var data []byte
+		for _, id := range list.Latest {
+			data = append(data, f.blocks[id]...)
+			delete(f.blocks, id)
+		}
+		f.blobs[name] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "PUT":
+		data, _ := io.ReadAll(r.Body)
+		f.blobs[name] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "DELETE":
+		if _, ok := f.blobs[name]; !ok {
+			fail(w, http.StatusNotFound, "BlobNotFound")
+			return
+		}
+		delete(f.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		fail(w, http.StatusBadRequest, "UnsupportedHttpVerb")
+	}
+}
+
+// list answers a hierarchical listing in one page.
+func (f *fakeContainer) list(w http.ResponseWriter, prefix, delimiter string) {
+	type properties struct {
+		LastModified  string `xml:"Last-Modified"`
+		ContentLength int    `xml:"Content-Length"`
+		BlobType      string `xml:"BlobType"`
+	}
+	type blob struct {
+		Name       string     `xml:"Name"`
+		Properties properties `xml:"Properties"`
+	}
+	type blobPrefix struct {
+		Name string `xml:"Name"`
+	}
+	var out struct {
+		XMLName  xml.Name     `xml:"EnumerationResults"`
+		Prefix   string       `xml:"Prefix"`
+		Blobs    []blob       `xml:"Blobs>Blob"`
+		Prefixes []blobPrefix `xml:"Blobs>BlobPrefix"`
+	}
+	out.Prefix = prefix
+	seen := map[string]bool{}
+	var names []string
+	for name := range f.blobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rest, ok := strings.CutPrefix(name, prefix)
+		if !ok {
+			continue
+		}
+		if ii := strings.Index(rest, delimiter); delimiter != "" && ii >= 0 {
+			if dir := prefix + rest[:ii+1]; !seen[dir] {
+				seen[dir] = true
+				out.Prefixes = append(out.Prefixes, blobPrefix{dir})
+			}
+			continue
+		}
+		out.Blobs = append(out.Blobs, blob{name, properties{
+			LastModified:  time.Now().UTC().Format(http.TimeFormat),
+			ContentLength: len(f.blobs[name]),
+			BlobType:      "BlockBlob",
+		}})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(out)
+}
+
+// fail answers with a storage error code, as the service does.
+func fail(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("x-ms-error-code", code)
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+// client connects to the container named name on srv.
+func client(t *testing.T, srv *httptest.Server, name string) *container.Client {
+	t.Helper()
+	c, err := container.NewClientWithNoCredential(srv.URL+"/"+name, nil)
+	if err != nil {
+		t.Fatalf("unexpected error while making client: %v", err)
+	}
+	return c
+}
+
+// TestStore tests that uploads are committed whole, that virtual
+// directories are listed and stat as directories, and that missing blobs
+// and containers are os.ErrNotExist.
+func TestStore(t *testing.T) {
+	fake := &fakeContainer{blobs: map[string][]byte{}, blocks: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	store := NewStore(client(t, srv, "c"))
+	// Blocks are at least 1 MiB, so a is uploaded in two and the rest whole.
+	store.BlockSize = 1 << 20
+	large := strings.Repeat("a", 1<<20+5)
+	fs := objectfs.New(store)
+	for path, data := range map[string]string{"/prefix/a": large, "/prefix/dir/b": "abc", "/prefix/dir/sub/c": "c"} {
+		f, err := fs.Create(path)
+		if err != nil {
+			t.Fatalf("unexpected error while creating %s: %v", path, err)
+		}
+		if _, err := f.Write([]byte(data)); err != nil {
+			t.Fatalf("unexpected error while writing %s: %v", path, err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("unexpected error while closing %s: %v", path, err)
+		}
+	}
+	if got := string(fake.blobs["prefix/a"]); got != large {
+		t.Errorf("want prefix/a committed from its blocks, got %d bytes", len(got))
+	}
+	if fake.staged != 2 || len(fake.blocks) != 0 {
+		t.Errorf("want the 2 blocks of prefix/a staged and committed, got %d staged and %d left", fake.staged, len(fake.blocks))
+	}
+	if got := string(fake.blobs["prefix/dir/b"]); got != "abc" {
+		t.Errorf("want prefix/dir/b uploaded whole, got %q", got)
+	}
+
+	objects, err := store.List(context.Background(), "prefix/")
+	if err != nil {
+		t.Fatalf("unexpected error while listing: %v", err)
+	}
+	if len(objects) != 2 || !objects[0].Dir || objects[0].Key != "prefix/dir" || objects[1].Key != "prefix/a" || objects[1].Size != int64(len(large)) {
+		t.Errorf("want prefix/dir and prefix/a listed, got %+v", objects)
+	}
+	for path, dir := range map[string]bool{"/prefix": true, "/prefix/dir": true, "/prefix/dir/sub": true, "/prefix/a": false} {
+		fi, err := fs.Stat(path)
+		if err != nil {
+			t.Fatalf("unexpected error while stating %s: %v", path, err)
+		}
+		if fi.IsDir() != dir {
+			t.Errorf("want %s a directory %v, got %v", path, dir, fi.IsDir())
+		}
+	}
+	if fi, _ := fs.Stat("/prefix/a"); fi.Size() != int64(len(large)) {
+		t.Errorf("want prefix/a %d bytes, got %d", len(large), fi.Size())
+	}
+	f, err := fs.Open("/prefix/a")
+	if err != nil {
+		t.Fatalf("unexpected error while opening: %v", err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != large {
+		t.Errorf("want prefix/a read back, got %d bytes, %v", len(data), err)
+	}
+
+	if _, err := fs.Stat("/prefix/missing"); !os.IsNotExist(err) {
+		t.Errorf("want os.ErrNotExist stating a missing blob, got %v", err)
+	}
+	if _, err := store.Get(context.Background(), "prefix/missing", 0); err != os.ErrNotExist {
+		t.Errorf("want os.ErrNotExist getting a missing blob, got %v", err)
+	}
+	if err := store.Delete(context.Background(), "prefix/missing"); err != os.ErrNotExist {
+		t.Errorf("want os.ErrNotExist deleting a missing blob, got %v", err)
+	}
+	missing := NewStore(client(t, srv, "missing"))
+	if _, err := missing.Head(context.Background(), "a"); err != os.ErrNotExist {
+		t.Errorf("want os.ErrNotExist in a missing container, got %v", err)
+	}
+	if _, err := missing.List(context.Background(), ""); err != os.ErrNotExist {
+		t.Errorf("want os.ErrNotExist listing a missing container, got %v", err)
+	}
+}
+
+// TestFs_RoundTrip tests that a tree copied into the container and back out
+// is unchanged.
+func TestFs_RoundTrip(t *testing.T) {
+	fake := &fakeContainer{blobs: map[string][]byte{}, blocks: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	local := afero.NewMemMapFs()
+	files := map[string]string{
+		"/from/foo.exe":         "foo",
+		"/from/dir/bar.exe":     "bar",
+		"/from/dir/sub/baz.exe": "baz",
+	}
+	for path, data := range files {
+		if err := afero.WriteFile(local, path, []byte(data), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	remote := New(client(t, srv, "c"))
+	up := cp.Copier{SrcFs: local, DstFs: remote}
+	if err := up.Copy("/from", "/prefix"); err != nil {
+		t.Fatalf("unexpected error while uploading: %v", err)
+	}
+	down := cp.Copier{SrcFs: remote, DstFs: local}
+	if err := down.Copy("/prefix", "/to"); err != nil {
+		t.Fatalf("unexpected error while downloading: %v", err)
+	}
+	for path, want := range files {
+		got, err := afero.ReadFile(local, strings.Replace(path, "/from", "/to", 1))
+		if err != nil {
+			t.Fatalf("unexpected error while reading %s: %v", path, err)
+		}
+		if string(got) != want {
+			t.Errorf("want %s to contain %q, got %q", path, want, got)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package cp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/spf13/afero"
+)
+
+// batchFileSize is the largest file batched.
+const batchFileSize = 64 << 10
+
+// batching reports whether small files are copied in batches, which only
+// applies to plain copies from one file to another.
+func (c *copier) batching() bool {
+	return c.batch > 1 && !c.metadataOnly && c.archive == nil && c.digests == nil &&
+		c.inodes == nil && c.reflink == ReflinkNever
+}
+
+// batch is a worker's buffers for batching, reused from one to the next.
+type batch struct {
+	jobs []job
+	data []byte
+}
+
+// copyBatch copies first, along with the small files queued behind it if it
+// is small itself: all of them are read into memory before any is written,
+// so that each phase keeps to one side of the copy.
+func (c *copier) copyBatch(first job, b *batch) {
+	if !c.batching() || first.Size > batchFileSize {
+		c.send(c.copyJob(first))
+		return
+	}
+	b.jobs = c.work.popSmall(append(b.jobs[:0], first), c.batch-1, batchFileSize)
+	atomic.AddInt64(&c.stats.queued, -int64(len(b.jobs)-1))
+	b.data = b.data[:0]
+	for ii := range b.jobs {
+		if c.ctx.Err() != nil {
+			break
+		}
+		b.data = c.readAhead(&b.jobs[ii], b.data)
+	}
+	for _, j := range b.jobs {
+		c.send(c.copyJob(j))
+	}
+}
+
+// readAhead reads the source of j onto the end of data for it to be written
+// from. Files that can't be read in full are left to be copied as usual,
+// which reports why.
+func (c *copier) readAhead(j *job, data []byte) []byte {
+	if c.fds != nil {
+		c.fds.acquire(1)
+		defer c.fds.release(1)
+	}
+	f, err := c.src.Open(j.From)
+	if err != nil {
+		return data
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() > batchFileSize {
+		return data
+	}
+	start, end := len(data), len(data)+int(info.Size())
+	data = append(data, make([]byte, info.Size())...)
+	if _, err := io.ReadFull(f, data[start:end]); err != nil {
+		return data[:start]
+	}
+	// A file that grew since it was looked at is copied whole later.
+	if n, _ := f.Read(make([]byte, 1)); n > 0 {
+		return data[:start]
+	}
+	j.ahead = &readAhead{Reader: bytes.NewReader(data[start:end:end]), name: f.Name(), info: info}
+	return data
+}
+
+// readAhead is a source file read into memory, standing in for the file
+// itself when it is written. Only the methods copying needs of a source are
+// its own; the afero.File embedded for the others is nil.
+type readAhead struct {
+	afero.File
+	*bytes.Reader
+	name string
+	info os.FileInfo
+}
+
+func (r *readAhead) Read(p []byte) (int, error)                { return r.Reader.Read(p) }
+func (r *readAhead) ReadAt(p []byte, off int64) (int, error)   { return r.Reader.ReadAt(p, off) }
+func (r *readAhead) Seek(off int64, whence int) (int64, error) { return r.Reader.Seek(off, whence) }
+func (r *readAhead) Name() string                              { return r.name }
+func (r *readAhead) Stat() (os.FileInfo, error)                { return r.info, nil }
+func (r *readAhead) Close() error                              { return nil }
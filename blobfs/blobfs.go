@@ -0,0 +1,124 @@
+// Package blobfs copies to and from any gocloud.dev bucket by presenting it
+// as an afero.Fs, covering GCS, Azure Blob Storage, S3 and in-memory buckets
+// behind one interface.
+//
+//	bucket, err := blob.OpenBucket(ctx, "gs://bucket")
+//	copier := cp.Copier{DstFs: blobfs.New(bucket)}
+//	err = copier.Copy("/data", "/prefix")
+//
+// Only the drivers imported by the program are linked in, so depending on
+// this package costs no more than the buckets it is used with.
+package blobfs
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+
+	"gocloud.dev/blob"
+	"gocloud.dev/gcerrors"
+
+	"github.com/jackmordaunt/cp/objectfs"
+)
+
+// Store is an objectfs.Store backed by a bucket.
+type Store struct {
+	bucket *blob.Bucket
+}
+
+var _ objectfs.Store = &Store{}
+
+// New presents the bucket as a filesystem.
+func New(bucket *blob.Bucket) *objectfs.Fs {
+	return objectfs.New(NewStore(bucket))
+}
+
+// NewStore creates a Store for the bucket. The caller remains responsible
+// for closing the bucket.
+func NewStore(bucket *blob.Bucket) *Store {
+	return &Store{bucket: bucket}
+}
+
+// Head describes the object at key.
+func (s *Store) Head(ctx context.Context, key string) (objectfs.Object, error) {
+	attrs, err := s.bucket.Attributes(ctx, key)
+	if err != nil {
+		return objectfs.Object{}, translate(err)
+	}
+	return objectfs.Object{
+		Key:     key,
+		Size:    attrs.Size,
+		ModTime: attrs.ModTime,
+	}, nil
+}
+
+// List describes the objects and common prefixes directly under prefix.
+func (s *Store) List(ctx context.Context, prefix string) ([]objectfs.Object, error) {
+	var objects []objectfs.Object
+	it := s.bucket.List(&blob.ListOptions{
+		Prefix:    prefix,
+		Delimiter: "/",
+	})
+	for {
+		obj, err := it.Next(ctx)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, translate(err)
+		}
+		if obj.Key == prefix {
+			continue
+		}
+		objects = append(objects, objectfs.Object{
+			Key:     strings.TrimSuffix(obj.Key, "/"),
+			Size:    obj.Size,
+			ModTime: obj.ModTime,
+			Dir:     obj.IsDir,
+		})
+	}
+	return objects, nil
+}
+
+// Get reads the object at key from offset.
+func (s *Store) Get(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	r, err := s.bucket.NewRangeReader(ctx, key, offset, -1, nil)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return r, nil
+}
+
+// Put writes the object at key. A failed write is abandoned rather than
+// leaving a partial object behind.
+func (s *Store) Put(ctx context.Context, key string, r io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := s.bucket.NewWriter(ctx, key, nil)
+	if err != nil {
+		return translate(err)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		w.Close()
+		return err
+	}
+	return translate(w.Close())
+}
+
+// Delete removes the object at key.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	return translate(s.bucket.Delete(ctx, key))
+}
+
+// translate maps missing objects onto os.ErrNotExist.
+func translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return os.ErrNotExist
+	}
+	return err
+}
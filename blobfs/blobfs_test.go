@@ -0,0 +1,51 @@
+package blobfs
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"gocloud.dev/blob/memblob"
+
+	"github.com/jackmordaunt/cp"
+)
+
+// TestStore_RoundTrip tests that a tree survives being uploaded to and
+// downloaded from a bucket.
+func TestStore_RoundTrip(t *testing.T) {
+	local := afero.NewMemMapFs()
+	files := map[string]string{
+		"/from/foo.exe":         "foo",
+		"/from/dir/bar.exe":     "bar",
+		"/from/dir/sub/baz.exe": "baz",
+	}
+	for path, data := range files {
+		if err := afero.WriteFile(local, path, []byte(data), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	bucket := memblob.OpenBucket(nil)
+	defer bucket.Close()
+	store := New(bucket)
+	up := cp.Copier{SrcFs: local, DstFs: store}
+	if err := up.Copy("/from", "/prefix"); err != nil {
+		t.Fatalf("unexpected error while uploading: %v", err)
+	}
+	down := cp.Copier{SrcFs: store, DstFs: local}
+	if err := down.Copy("/prefix", "/to"); err != nil {
+		t.Fatalf("unexpected error while downloading: %v", err)
+	}
+	for path, want := range files {
+		got, err := afero.ReadFile(local, strings.Replace(path, "/from", "/to", 1))
+		if err != nil {
+			t.Fatalf("unexpected error while reading %s: %v", path, err)
+		}
+		if string(got) != want {
+			t.Errorf("want %s to contain %q, got %q", path, want, got)
+		}
+	}
+	if _, err := store.Stat("/prefix/missing.exe"); !os.IsNotExist(err) {
+		t.Fatalf("want missing object not to exist, got %v", err)
+	}
+}
@@ -0,0 +1,27 @@
+package cp
+
+import (
+	"io"
+	"sync"
+)
+
+// buffers holds a pool of buffers for each BufferSize in use, so that
+// copies don't allocate one per file.
+var buffers sync.Map
+
+// buffered copies r to w through a buffer of bufferSize bytes, or as io.Copy
+// does if it isn't set.
+func (c *copier) buffered(w io.Writer, r io.Reader) (int64, error) {
+	if c.bufferSize <= 0 {
+		return io.Copy(w, r)
+	}
+	pool, _ := buffers.LoadOrStore(c.bufferSize, &sync.Pool{New: func() any {
+		b := make([]byte, c.bufferSize)
+		return &b
+	}})
+	buf := pool.(*sync.Pool).Get().(*[]byte)
+	defer pool.(*sync.Pool).Put(buf)
+	// Files copy themselves with WriteTo, through a buffer of their
+	// choosing, unless hidden behind a plain reader.
+	return io.CopyBuffer(w, struct{ io.Reader }{r}, *buf)
+}
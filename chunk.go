@@ -0,0 +1,150 @@
+package cp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// chunked reports whether the file is big enough to be split into ranges
+// copied concurrently.
+func (c *copier) chunked(info os.FileInfo) bool {
+	return c.chunkThreshold > 0 &&
+		info.Mode().IsRegular() &&
+		info.Size() >= c.chunkThreshold
+}
+
+// copyChunked copies a file as a number of ranges at once, each through its
+// own pair of handles, then checks the result is the size it should be and
+// hashes it and the source by Hasher, so that a range written wrongly or to
+// the wrong place fails the copy.
+func (c *copier) copyChunked(from, to string, info os.FileInfo) (int64, bool, error) {
+	if err := c.dst.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return 0, false, errors.Wrapf(err, "preparing directories for %s", to)
+	}
+	toFi, err := c.dst.Stat(to)
+	overwrote := err == nil
+	if overwrote && os.SameFile(info, toFi) {
+		return 0, false, ErrSameFile{From: from, To: to}
+	}
+	if overwrote {
+		if err := c.prepare(to); err != nil {
+			return 0, overwrote, err
+		}
+	} else {
+		c.create(to)
+	}
+	toFile, err := c.dst.OpenFile(to, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return 0, overwrote, errors.Wrapf(err, "creating %s", to)
+	}
+	if err := c.preallocate(toFile, to, info.Size()); err != nil {
+		toFile.Close()
+		return 0, overwrote, err
+	}
+	if err := toFile.Truncate(info.Size()); err != nil {
+		toFile.Close()
+		return 0, overwrote, errors.Wrapf(err, "sizing %s", to)
+	}
+	if err := toFile.Close(); err != nil {
+		return 0, overwrote, errors.Wrapf(err, "creating %s", to)
+	}
+	chunks := int64(c.chunks)
+	if chunks < 1 {
+		chunks = 1
+	}
+	size := (info.Size() + chunks - 1) / chunks
+	var (
+		written int64
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		failure error
+	)
+	for off := int64(0); off < info.Size(); off += size {
+		n := size
+		if off+n > info.Size() {
+			n = info.Size() - off
+		}
+		wg.Add(1)
+		go func(off, n int64) {
+			defer wg.Done()
+			c.background()
+			w, err := c.copyRange(from, to, off, n)
+			atomic.AddInt64(&written, w)
+			if err != nil {
+				mu.Lock()
+				if failure == nil {
+					failure = err
+				}
+				mu.Unlock()
+			}
+		}(off, n)
+	}
+	wg.Wait()
+	if failure != nil {
+		c.discard(nil, to, overwrote)
+		return written, overwrote, errors.Wrapf(failure, "copying file from %s to %s", from, to)
+	}
+	toFi, err = c.dst.Stat(to)
+	if err != nil {
+		return written, overwrote, errors.Wrap(err, "reading file metadata")
+	}
+	if toFi.Size() != info.Size() || written != info.Size() {
+		return written, overwrote, errors.Errorf("copying file from %s to %s: wrote %d of %d bytes",
+			from, to, toFi.Size(), info.Size())
+	}
+	want, err := c.prefixSum(c.src, from, info.Size())
+	if err != nil {
+		return written, overwrote, err
+	}
+	got, err := c.prefixSum(c.dst, to, info.Size())
+	if err != nil {
+		return written, overwrote, err
+	}
+	if !bytes.Equal(want, got) {
+		return written, overwrote, errors.Errorf("copying file from %s to %s: copy doesn't match the source", from, to)
+	}
+	if err := c.preserve(from, to, info); err != nil {
+		return written, overwrote, err
+	}
+	atomic.AddInt64(&c.stats.files, 1)
+	return written, overwrote, nil
+}
+
+// copyRange copies n bytes at offset off from one file into the other, by
+// ReadAt and WriteAt, creating the destination if need be.
+func (c *copier) copyRange(from, to string, off, n int64) (int64, error) {
+	if c.fds != nil {
+		c.fds.acquire(2)
+		defer c.fds.release(2)
+	}
+	fromFile, err := c.src.Open(from)
+	if err != nil {
+		return 0, errors.Wrapf(err, "opening %s", from)
+	}
+	defer fromFile.Close()
+	toFile, err := c.dst.OpenFile(to, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, errors.Wrapf(err, "opening %s", to)
+	}
+	defer toFile.Close()
+	var r io.Reader = c.cancellable(io.NewSectionReader(fromFile, off, n))
+	if c.limit != nil {
+		r = throttledReader{r, c.limit}
+	}
+	w, err := io.CopyN(countingWriter{io.NewOffsetWriter(toFile, off), c.stats}, r, n)
+	if err != nil {
+		return w, err
+	}
+	if c.journal != nil {
+		if err := toFile.Sync(); err != nil {
+			return w, err
+		}
+	}
+	return w, toFile.Close()
+}
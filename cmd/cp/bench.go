@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jackmordaunt/cp"
+	"github.com/spf13/cobra"
+)
+
+// benchCommand times copies of a generated tree with a range of settings.
+func benchCommand() *cobra.Command {
+	var (
+		files    int
+		minSize  = sizeFlag(1 << 10)
+		maxSize  = sizeFlag(1 << 20)
+		parallel []int
+		buffers  []string
+		seed     uint64
+	)
+	cmd := &cobra.Command{
+		Use:   "bench [flags] [DIR [DEST]]",
+		Short: "Time copies with different settings to tune them for this machine",
+		Long: `Generate a tree of files of random data in DIR, by default the system's
+temporary directory, and copy it to DEST, by default DIR too, once for each
+combination of --parallel and --buffer-size, reporting how fast each was.
+File sizes are spread between --min-size and --max-size with as many small
+files as large ones at every scale, as real trees tend to be. Everything
+generated is removed afterwards.
+
+The source is likely to be read from memory after the first copy, so the
+results mostly measure writing to DEST.`,
+		Args: func(_ *cobra.Command, args []string) error {
+			if files < 1 {
+				return fmt.Errorf("--files must be at least 1")
+			}
+			if minSize > maxSize {
+				return fmt.Errorf("--min-size is larger than --max-size")
+			}
+			for _, s := range buffers {
+				if _, err := parseSize(s); err != nil {
+					return fmt.Errorf("--buffer-size: %v", err)
+				}
+			}
+			return cobra.MaximumNArgs(2)(nil, args)
+		},
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Run: func(_ *cobra.Command, args []string) {
+			dir := os.TempDir()
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			dest := dir
+			if len(args) > 1 {
+				dest = args[1]
+			}
+			src, err := os.MkdirTemp(dir, "cp-bench-src-")
+			if err != nil {
+				fatal("%v\n", err)
+			}
+			to, err := os.MkdirTemp(dest, "cp-bench-dst-")
+			if err != nil {
+				os.RemoveAll(src)
+				fatal("%v\n", err)
+			}
+			// fatal exits without running deferred calls, so failures
+			// clean up first.
+			cleanup := func() {
+				os.RemoveAll(src)
+				os.RemoveAll(to)
+			}
+			defer cleanup()
+			fmt.Fprintf(os.Stderr, "generating %d files in %s\n", files, src)
+			total, err := generate(src, files, int64(minSize), int64(maxSize), rand.New(rand.NewPCG(seed, seed)))
+			if err != nil {
+				cleanup()
+				fatal("generating files: %v\n", err)
+			}
+			fmt.Fprintf(os.Stderr, "copying %s to %s\n", bytes(total), to)
+			const row = "%8v  %10v  %8v  %8v  %8v\n"
+			fmt.Printf(row, "PARALLEL", "BUFFER", "TIME", "FILES/S", "MIB/S")
+			var best struct {
+				parallel int
+				buffer   string
+				took     time.Duration
+			}
+			for _, p := range parallel {
+				for _, b := range buffers {
+					size, _ := parseSize(b)
+					out := filepath.Join(to, "copy")
+					if err := os.RemoveAll(out); err != nil {
+						cleanup()
+						fatal("%v\n", err)
+					}
+					copier := &cp.Copier{Parallel: p, BufferSize: int(size)}
+					start := time.Now()
+					if err := copier.Copy(src, out); err != nil {
+						cleanup()
+						fatal("copying with --parallel %d --buffer-size %s: %v\n", p, b, err)
+					}
+					took := time.Since(start)
+					fmt.Printf(row,
+						p,
+						bytes(size),
+						took.Round(time.Millisecond),
+						fmt.Sprintf("%.0f", float64(files)/took.Seconds()),
+						fmt.Sprintf("%.1f", float64(total)/(1<<20)/took.Seconds()),
+					)
+					if best.took == 0 || took < best.took {
+						best.parallel, best.buffer, best.took = p, b, took
+					}
+				}
+			}
+			fmt.Printf("\nfastest: --parallel %d --buffer-size %s\n", best.parallel, best.buffer)
+		},
+	}
+	flags := cmd.Flags()
+	flags.IntVar(&files, "files", 1000, "number of files to generate")
+	flags.Var(&minSize, "min-size", "smallest file to generate, such as 4K")
+	flags.Var(&maxSize, "max-size", "largest file to generate, such as 64MiB")
+	flags.IntSliceVar(&parallel, "parallel", []int{1, 4, 16}, "numbers of files to copy in parallel to try")
+	flags.StringSliceVar(&buffers, "buffer-size", []string{"32K", "256K", "1M"}, "buffer `SIZES` to try")
+	flags.Uint64Var(&seed, "seed", 1, "seed for the generated sizes and contents, to compare runs")
+	return cmd
+}
+
+// generate writes n files of random data of smallest to largest bytes
+// beneath dir, their sizes spread log-uniformly and a hundred to a
+// directory, returning their total size.
+func generate(dir string, n int, smallest, largest int64, r *rand.Rand) (int64, error) {
+	var total int64
+	lo, hi := math.Log(float64(smallest+1)), math.Log(float64(largest+1))
+	buf := make([]byte, largest)
+	for ii := 0; ii < n; ii++ {
+		size := int64(math.Exp(lo+r.Float64()*(hi-lo))) - 1
+		for jj := range buf[:size] {
+			buf[jj] = byte(r.Uint32())
+		}
+		sub := filepath.Join(dir, fmt.Sprintf("%03d", ii/100))
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			return total, err
+		}
+		if err := os.WriteFile(filepath.Join(sub, fmt.Sprintf("%05d.bin", ii)), buf[:size], 0644); err != nil {
+			return total, err
+		}
+		total += size
+	}
+	return total, nil
+}
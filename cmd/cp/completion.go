@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// schemes are the URL schemes completed for SOURCE and DEST.
+var schemes = []string{"s3://", "gs://", "az://", "container://", "http://", "https://"}
+
+// completeArgs completes SOURCE and DEST as local paths, or the schemes of
+// the URLs they may be when what's typed so far begins one. Remote paths
+// aren't listed.
+func completeArgs(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if strings.Contains(toComplete, "://") {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var matches []string
+	if toComplete != "" {
+		for _, scheme := range schemes {
+			if strings.HasPrefix(scheme, toComplete) {
+				matches = append(matches, scheme)
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+	return matches, cobra.ShellCompDirectiveNoSpace
+}
+
+// completeValues completes a flag from a fixed set of values.
+func completeValues(values ...string) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return values, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// completePreserve completes the comma separated classes of --preserve,
+// each after those typed so far.
+func completePreserve(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	typed := toComplete[:strings.LastIndex(toComplete, ",")+1]
+	var classes []string
+	for _, class := range append(preserveClasses, "all") {
+		if !strings.Contains(","+typed, ","+class+",") && strings.HasPrefix(typed+class, toComplete) {
+			classes = append(classes, typed+class)
+		}
+	}
+	return classes, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+}
+
+// registerCompletions sets up the completion of the arguments and flag
+// values of the root command.
+func registerCompletions(root *cobra.Command) {
+	root.ValidArgsFunction = completeArgs
+	for flag, complete := range map[string]func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective){
+		"reflink":  completeValues("auto", "always", "never"),
+		"sparse":   completeValues("auto", "always", "never"),
+		"color":    completeValues("auto", "always", "never"),
+		"preserve": completePreserve,
+		"target-directory": func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+			return nil, cobra.ShellCompDirectiveFilterDirs
+		},
+	} {
+		root.RegisterFlagCompletionFunc(flag, complete)
+	}
+}
+
+// completionCommand writes the script completing cp for a shell. It stands
+// in for cobra's default command, which shows its help for a shell it
+// doesn't know rather than failing.
+func completionCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "completion bash|zsh|fish|powershell",
+		Short: "Write the script completing cp for a shell",
+		Long: `Write the script completing cp's flags, their values and URL schemes for
+bash, zsh, fish or PowerShell to stdout. Load it in the current shell with,
+for example,
+
+  source <(cp completion bash)
+
+or save it where the shell loads completions from.`,
+		Args:          cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		ValidArgs:     []string{"bash", "zsh", "fish", "powershell"},
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			out, root := cmd.OutOrStdout(), cmd.Root()
+			switch args[0] {
+			case "bash":
+				return root.GenBashCompletionV2(out, true)
+			case "zsh":
+				return root.GenZshCompletion(out)
+			case "fish":
+				return root.GenFishCompletion(out, true)
+			default:
+				return root.GenPowerShellCompletionWithDesc(out)
+			}
+		},
+	}
+}
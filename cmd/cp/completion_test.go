@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestCompletion tests that a completion script is written for each shell,
+// completing the values of flags, and that other shells are refused.
+func TestCompletion(t *testing.T) {
+	for _, shell := range []string{"bash", "zsh", "fish", "powershell"} {
+		root, _ := rootCommand()
+		out := &strings.Builder{}
+		root.SetOut(out)
+		root.SetArgs([]string{"completion", shell})
+		if err := root.Execute(); err != nil {
+			t.Fatalf("%s: unexpected error: %v", shell, err)
+		}
+		if out.Len() == 0 {
+			t.Errorf("%s: want a script, got nothing", shell)
+		}
+	}
+	root, _ := rootCommand()
+	root.SetOut(&strings.Builder{})
+	root.SetErr(&strings.Builder{})
+	root.SetArgs([]string{"completion", "tcsh"})
+	if err := root.Execute(); err == nil {
+		t.Errorf("want an error for an unknown shell")
+	}
+
+	root, _ = rootCommand()
+	out := &strings.Builder{}
+	root.SetOut(out)
+	root.SetErr(&strings.Builder{})
+	root.SetArgs([]string{"__complete", "--sparse", ""})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("unexpected error completing --sparse: %v", err)
+	}
+	for _, value := range []string{"auto", "always", "never"} {
+		if !strings.Contains(out.String(), value+"\n") {
+			t.Errorf("want %s completed for --sparse, got %q", value, out.String())
+		}
+	}
+}
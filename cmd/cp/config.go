@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/cobra"
+)
+
+// configPath is the file default options are read from: CP_CONFIG if set,
+// or else cp/config.toml in the user's configuration directory, such as
+// ~/.config on Linux.
+func configPath() string {
+	if path := os.Getenv("CP_CONFIG"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "cp", "config.toml")
+}
+
+// loadConfig sets each flag of cmd left unset on the command line from the
+// TOML configuration file at path, if there is one. Its keys are the long
+// names of the flags, and a list sets a flag once for each value, so
+//
+//	parallel = 16
+//	preserve = ["mode", "timestamps"]
+//	exclude = ['^\.git$']
+//
+// is the same as --parallel 16 --preserve mode,timestamps --exclude '^\.git$'.
+// Keys at the top apply to every command with the flag, and those in a
+// table named after a subcommand, such as [mirror], to it alone, taking
+// precedence. A key no command has is an error.
+func loadConfig(cmd *cobra.Command, path string) error {
+	if path == "" {
+		return nil
+	}
+	var config map[string]any
+	if _, err := toml.DecodeFile(path, &config); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("reading %s: %v", path, err)
+	}
+	root := cmd.Root()
+	settings := map[string]any{}
+	for key, value := range config {
+		if table, ok := value.(map[string]any); ok {
+			sub := subcommand(root, key)
+			if sub == nil {
+				return fmt.Errorf("%s: no command %q", path, key)
+			}
+			for k := range table {
+				if sub.Flags().Lookup(k) == nil || k == "help" {
+					return fmt.Errorf("%s: %s has no option %q", path, key, k)
+				}
+			}
+			continue
+		}
+		if !anyFlag(root, key) {
+			return fmt.Errorf("%s: no option %q", path, key)
+		}
+		settings[key] = value
+	}
+	if table, ok := config[cmd.Name()].(map[string]any); ok && cmd != root {
+		for key, value := range table {
+			settings[key] = value
+		}
+	}
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	flags := cmd.Flags()
+	for _, key := range keys {
+		flag := flags.Lookup(key)
+		if flag == nil || flag.Changed {
+			continue
+		}
+		values, ok := settings[key].([]any)
+		if !ok {
+			values = []any{settings[key]}
+		}
+		for _, v := range values {
+			if err := flags.Set(key, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("%s: %s: %v", path, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// subcommand is the command of root named name, or nil.
+func subcommand(root *cobra.Command, name string) *cobra.Command {
+	for _, cmd := range root.Commands() {
+		if cmd.Name() == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// anyFlag reports whether root or any of its subcommands has the flag name.
+func anyFlag(root *cobra.Command, name string) bool {
+	if name == "help" {
+		return false
+	}
+	if root.Flags().Lookup(name) != nil || root.PersistentFlags().Lookup(name) != nil {
+		return true
+	}
+	for _, cmd := range root.Commands() {
+		if cmd.Flags().Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestLoadConfig tests that the configuration fills in the flags of the
+// command run that the command line leaves unset, with a subcommand's table
+// taking precedence over the keys at the top, and that keys and tables no
+// command has are refused.
+func TestLoadConfig(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		config  string
+		args    []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "top level",
+			config: "parallel = 16\nexclude = ['^\\.git$', 'tmp']\ncolor = 'never'",
+			args:   []string{"a", "b"},
+			want:   map[string]string{"parallel": "16", "exclude": `[^\.git$,tmp]`, "color": "never"},
+		},
+		{
+			name:   "flag over file",
+			config: "parallel = 16",
+			args:   []string{"--parallel", "2", "a", "b"},
+			want:   map[string]string{"parallel": "2"},
+		},
+		{
+			name:   "table left to its command",
+			config: "[mirror]\nparallel = 4",
+			args:   []string{"a", "b"},
+			want:   map[string]string{"parallel": "0"},
+		},
+		{
+			name:   "subcommand",
+			config: "parallel = 16\nexclude = ['tmp']\ncolor = 'never'\n[mirror]\nparallel = 4\nverbose = 2",
+			args:   []string{"mirror", "a", "b"},
+			want:   map[string]string{"parallel": "4", "exclude": "[tmp]", "color": "never", "verbose": "2"},
+		},
+		{
+			name:   "flag over table",
+			config: "[mirror]\nparallel = 4",
+			args:   []string{"mirror", "--parallel", "1", "a", "b"},
+			want:   map[string]string{"parallel": "1"},
+		},
+		{
+			name:   "key of another command",
+			config: "dry-run = true\nreflink = 'auto'",
+			args:   []string{"mirror", "a", "b"},
+			want:   map[string]string{"dry-run": "true"},
+		},
+		{name: "unknown key", config: "bogus = 1", args: []string{"a", "b"}, wantErr: true},
+		{name: "unknown table", config: "[bogus]\nparallel = 1", args: []string{"a", "b"}, wantErr: true},
+		{name: "key not of the table's command", config: "[mirror]\nreflink = 'auto'", args: []string{"mirror", "a", "b"}, wantErr: true},
+		{name: "bad value", config: "parallel = 'many'", args: []string{"a", "b"}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.toml")
+			if err := os.WriteFile(path, []byte(tt.config), 0644); err != nil {
+				t.Fatalf("unexpected error while writing config: %v", err)
+			}
+			root, _ := rootCommand()
+			cmd, rest, err := root.Find(tt.args)
+			if err != nil {
+				t.Fatalf("unexpected error while finding command: %v", err)
+			}
+			if err := cmd.ParseFlags(rest); err != nil {
+				t.Fatalf("unexpected error while parsing flags: %v", err)
+			}
+			err = loadConfig(cmd, path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("want an error loading %q", tt.config)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error while loading config: %v", err)
+			}
+			for name, want := range tt.want {
+				flag := cmd.Flags().Lookup(name)
+				if flag == nil {
+					t.Fatalf("%s has no flag %s", cmd.Name(), name)
+				}
+				if got := flag.Value.String(); got != want {
+					t.Errorf("want --%s %s, got %s", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+// TestConfigPath tests that CP_CONFIG names the configuration file, and that
+// a missing file is no error.
+func TestConfigPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.toml")
+	t.Setenv("CP_CONFIG", path)
+	if got := configPath(); got != path {
+		t.Errorf("want %s, got %s", path, got)
+	}
+	root, _ := rootCommand()
+	if err := loadConfig(root, configPath()); err != nil {
+		t.Errorf("unexpected error loading a missing config: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/afero"
+
+	"github.com/jackmordaunt/cp/azurefs"
+	"github.com/jackmordaunt/cp/containerfs"
+	"github.com/jackmordaunt/cp/gcsfs"
+	"github.com/jackmordaunt/cp/s3fs"
+	"github.com/jackmordaunt/cp/sftpfs"
+)
+
+// endpoint is a filesystem and a path on it, as named by an argument such
+// as "s3://bucket/prefix", "gs://bucket/prefix", "az://account/container",
+// "container://id/path", "user@host:path" or a plain local path.
+type endpoint struct {
+	// name identifies the filesystem, so that arguments on the same one
+	// can be grouped.
+	name string
+	fs   afero.Fs
+	path string
+}
+
+// azure is the container named first in path on the Azure Blob Storage
+// host, authorised by the SAS token sas, and the prefix after it.
+func azure(host, path, sas string) (endpoint, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if bucket == "" {
+		return endpoint{}, fmt.Errorf("no container in %s%s", host, path)
+	}
+	u := url.URL{Scheme: "https", Host: host, Path: "/" + bucket, RawQuery: strings.TrimPrefix(sas, "?")}
+	client, err := container.NewClientWithNoCredential(u.String(), nil)
+	if err != nil {
+		return endpoint{}, fmt.Errorf("connecting to %s: %v", host, err)
+	}
+	return endpoint{
+		name: "az://" + host + "/" + bucket,
+		fs:   azurefs.New(client),
+		path: "/" + prefix,
+	}, nil
+}
+
+// remotes holds the SFTP connections made so far, by name, so that arguments
+// on the same host share one.
+var remotes = map[string]*sftpfs.Fs{}
+
+// parseEndpoint resolves an argument to its filesystem. Only known schemes
+// are treated as URLs, so Windows paths like "C:\dir" stay local. SFTP
+// endpoints open that many sessions over their connection.
+func parseEndpoint(ctx context.Context, arg string, sessions int) (endpoint, error) {
+	scheme := ""
+	if ii := strings.Index(arg, "://"); ii > 0 {
+		scheme = arg[:ii]
+	}
+	switch scheme {
+	case "s3":
+		u, err := url.Parse(arg)
+		if err != nil {
+			return endpoint{}, fmt.Errorf("parsing %q: %v", arg, err)
+		}
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			return endpoint{}, fmt.Errorf("loading AWS configuration: %v", err)
+		}
+		return endpoint{
+			name: "s3://" + u.Host,
+			fs:   s3fs.New(s3.NewFromConfig(cfg), u.Host),
+			path: "/" + strings.TrimPrefix(u.Path, "/"),
+		}, nil
+	case "gs":
+		u, err := url.Parse(arg)
+		if err != nil {
+			return endpoint{}, fmt.Errorf("parsing %q: %v", arg, err)
+		}
+		client, err := storage.NewClient(ctx)
+		if err != nil {
+			return endpoint{}, fmt.Errorf("connecting to GCS: %v", err)
+		}
+		return endpoint{
+			name: "gs://" + u.Host,
+			fs:   gcsfs.New(client, u.Host),
+			path: "/" + strings.TrimPrefix(u.Path, "/"),
+		}, nil
+	case "container":
+		id, path, _ := strings.Cut(strings.TrimPrefix(arg, "container://"), "/")
+		return endpoint{name: "container://" + id, fs: containerfs.New(id), path: "/" + path}, nil
+	case "az":
+		u, err := url.Parse(arg)
+		if err != nil {
+			return endpoint{}, fmt.Errorf("parsing %q: %v", arg, err)
+		}
+		return azure(u.Host+".blob.core.windows.net", u.Path, os.Getenv("AZURE_STORAGE_SAS_TOKEN"))
+	case "http", "https":
+		if u, err := url.Parse(arg); err == nil && strings.HasSuffix(u.Host, ".blob.core.windows.net") {
+			return azure(u.Host, u.Path, u.RawQuery)
+		}
+		// Downloads are fetched by URL rather than through a filesystem.
+		return endpoint{name: "http", path: arg}, nil
+	case "":
+		login, host, path, ok := remote(arg)
+		if !ok {
+			break
+		}
+		name := arg[:strings.Index(arg, ":")+1]
+		if fs, ok := remotes[name]; ok {
+			return endpoint{name: name, fs: fs, path: path}, nil
+		}
+		cfg, err := sshConfig(login)
+		if err != nil {
+			return endpoint{}, err
+		}
+		fs, err := sftpfs.Dial(net.JoinHostPort(host, "22"), cfg, sessions)
+		if err != nil {
+			return endpoint{}, err
+		}
+		remotes[name] = fs
+		return endpoint{name: name, fs: fs, path: path}, nil
+	}
+	return endpoint{fs: afero.NewOsFs(), path: arg}, nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jackmordaunt/cp"
+)
+
+// Job states.
+const (
+	jobRunning   = "running"
+	jobDone      = "done"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// jobRequest is a copy submitted to the daemon, its ends named as on the
+// command line.
+type jobRequest struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Clobber     bool   `json:"clobber"`
+	IncludeRoot bool   `json:"include_root"`
+	Parallel    int    `json:"parallel"`
+}
+
+// jobStatus is how far a job has got.
+type jobStatus struct {
+	ID          string     `json:"id"`
+	From        string     `json:"from"`
+	To          string     `json:"to"`
+	State       string     `json:"state"`
+	Error       string     `json:"error,omitempty"`
+	Files       int64      `json:"files"`
+	Bytes       int64      `json:"bytes"`
+	FoundFiles  int64      `json:"found_files"`
+	FoundBytes  int64      `json:"found_bytes"`
+	Throughput  float64    `json:"throughput"`
+	ETA         float64    `json:"eta"`
+	Copied      int        `json:"copied"`
+	Overwritten int        `json:"overwritten"`
+	Linked      int        `json:"linked"`
+	Skipped     int        `json:"skipped"`
+	Failed      int        `json:"failed"`
+	Started     time.Time  `json:"started"`
+	Finished    *time.Time `json:"finished,omitempty"`
+}
+
+// job is a copy running, or run, in the background.
+type job struct {
+	id      string
+	req     jobRequest
+	copier  *cp.Copier
+	cancel  context.CancelFunc
+	started time.Time
+
+	mu       sync.Mutex
+	state    string
+	report   cp.Report
+	err      error
+	finished time.Time
+}
+
+// run carries out the copy and records how it ended.
+func (j *job) run(ctx context.Context, from, to string) {
+	report, err := j.copier.CopyContext(ctx, from, to)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.report, j.err, j.finished = report, err, time.Now()
+	switch {
+	case ctx.Err() != nil:
+		j.state = jobCancelled
+	case err != nil:
+		j.state = jobFailed
+	default:
+		j.state = jobDone
+	}
+}
+
+func (j *job) status() jobStatus {
+	stats := j.copier.Stats()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := jobStatus{
+		ID:          j.id,
+		From:        j.req.From,
+		To:          j.req.To,
+		State:       j.state,
+		Files:       stats.Files,
+		Bytes:       stats.Bytes,
+		FoundFiles:  stats.Found.Files,
+		FoundBytes:  stats.Found.Bytes,
+		Throughput:  stats.Throughput,
+		ETA:         stats.ETA.Seconds(),
+		Copied:      len(j.report.Copied),
+		Overwritten: len(j.report.Overwritten),
+		Linked:      len(j.report.Linked),
+		Skipped:     len(j.report.Skipped),
+		Failed:      len(j.report.Failed),
+		Started:     j.started,
+	}
+	if j.err != nil {
+		s.Error = j.err.Error()
+	}
+	if !j.finished.IsZero() {
+		s.Finished = &j.finished
+	}
+	return s
+}
+
+// jobs are the copies the daemon has been given, in the order they were.
+type jobs struct {
+	mu   sync.Mutex
+	next int
+	byID map[string]*job
+	all  []*job
+	// sessions is the number of SFTP sessions opened per host.
+	sessions int
+}
+
+func newJobs(sessions int) *jobs {
+	return &jobs{byID: map[string]*job{}, sessions: sessions}
+}
+
+// submit starts a copy in the background.
+func (js *jobs) submit(req jobRequest) (*job, error) {
+	if req.From == "" || req.To == "" {
+		return nil, fmt.Errorf("a job needs both from and to")
+	}
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	// Endpoints are resolved under the lock, since SFTP connections are
+	// shared between them.
+	src, err := parseEndpoint(context.Background(), req.From, js.sessions)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseEndpoint(context.Background(), req.To, js.sessions)
+	if err != nil {
+		return nil, err
+	}
+	if src.name == "http" || dst.name == "http" {
+		return nil, fmt.Errorf("jobs copy between filesystems, not URLs")
+	}
+	copier := &cp.Copier{
+		SrcFs:       src.fs,
+		DstFs:       dst.fs,
+		Clobber:     req.Clobber,
+		IncludeRoot: req.IncludeRoot,
+		Parallel:    req.Parallel,
+	}
+	return js.start(req, copier, src.path, dst.path), nil
+}
+
+// start runs copier from from to to as the next job. The caller holds js.mu.
+func (js *jobs) start(req jobRequest, copier *cp.Copier, from, to string) *job {
+	js.next++
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		id:      strconv.Itoa(js.next),
+		req:     req,
+		copier:  copier,
+		cancel:  cancel,
+		started: time.Now(),
+		state:   jobRunning,
+	}
+	js.byID[j.id] = j
+	js.all = append(js.all, j)
+	go j.run(ctx, from, to)
+	return j
+}
+
+func (js *jobs) get(id string) (*job, bool) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	j, ok := js.byID[id]
+	return j, ok
+}
+
+// list is the status of every job in the state given, or of all of them.
+func (js *jobs) list(state string) []jobStatus {
+	js.mu.Lock()
+	all := append([]*job(nil), js.all...)
+	js.mu.Unlock()
+	list := []jobStatus{}
+	for _, j := range all {
+		if s := j.status(); state == "" || s.State == state {
+			list = append(list, s)
+		}
+	}
+	return list
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+
+	"github.com/jackmordaunt/cp"
+)
+
+// jsonLogger writes one JSON object per line for each file copied, linked,
+// skipped or failed, with the event in "msg".
+func jsonLogger(w io.Writer) *slog.Logger {
+	return slog.New(fileEvents{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})})
+}
+
+// fileEvents drops the Copier's progress chatter about walks and queued
+// files, leaving the outcome of each file.
+type fileEvents struct {
+	slog.Handler
+}
+
+func (h fileEvents) Handle(ctx context.Context, r slog.Record) error {
+	switch r.Message {
+	case "walk started", "file queued":
+		return nil
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h fileEvents) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return fileEvents{h.Handler.WithAttrs(attrs)}
+}
+
+func (h fileEvents) WithGroup(name string) slog.Handler {
+	return fileEvents{h.Handler.WithGroup(name)}
+}
+
+// summarize writes the final summary event, if writing JSON.
+func summarize(copier *cp.Copier, report cp.Report) {
+	if copier.Logger == nil {
+		return
+	}
+	stats := copier.Stats()
+	copier.Logger.Info("summary",
+		"copied", len(report.Copied),
+		"overwritten", len(report.Overwritten),
+		"linked", len(report.Linked),
+		"skipped", len(report.Skipped),
+		"failed", len(report.Failed),
+		"removed", len(report.Removed),
+		"renamed", len(report.Renamed),
+		"bytes", stats.Bytes,
+		"elapsed", stats.Elapsed.Seconds(),
+		"throughput", stats.Throughput,
+	)
+}
@@ -1,33 +1,588 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"regexp"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/jackmordaunt/cp"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
 )
 
+// Exit codes.
+const (
+	// exitUsage is for invalid invocations.
+	exitUsage = 1
+	// exitFailure is for copies that failed outright.
+	exitFailure = 2
+	// exitPartial is for copies where some files failed and others did not.
+	exitPartial = 3
+	// exitMismatch is for verifications that found differences.
+	exitMismatch = 4
+	// exitInterrupted is for copies stopped by SIGINT or SIGTERM, following
+	// the shell convention of 128 plus the signal number of SIGINT.
+	exitInterrupted = 130
+)
+
+// oops reports a usage error and exits.
 func oops(f string, v ...interface{}) {
-	fmt.Printf(fmt.Sprintf("%s %s", color.New(color.FgBlue).Sprintf("oops:"), f), v...)
-	os.Exit(0)
+	fmt.Fprintf(os.Stderr, fmt.Sprintf("%s %s", color.New(color.FgBlue).Sprintf("oops:"), f), v...)
+	os.Exit(exitUsage)
 }
 
+// fatal reports a failed copy and exits.
 func fatal(f string, v ...interface{}) {
-	fmt.Printf(fmt.Sprintf("%s %s", color.New(color.FgRed).Sprintf("error:"), f), v...)
-	os.Exit(0)
+	failed(exitFailure, f, v...)
+}
+
+func failed(code int, f string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, fmt.Sprintf("%s %s", color.New(color.FgRed).Sprintf("error:"), f), v...)
+	os.Exit(code)
+}
+
+// options are the command line flags.
+type options struct {
+	recursive bool
+	archive   bool
+	target    string
+	parents   bool
+	reflink   string
+	sparse    string
+	preserve  []string
+	niceIO    bool
+	clobber   bool
+	parallel  int
+	batch     int
+	pipeline  int
+	buffer    sizeFlag
+	quiet     bool
+	verbose   int
+	filesFrom string
+	checksum  string
+	retries   int
+	json      bool
+	prompt    bool
+	rollback  bool
+	exclude   []string
+	color     string
+
+	// follow, noFollow and followArgs are -L, -P and -H.
+	follow, noFollow, followArgs bool
+}
+
+// reflinks are the values of --reflink.
+var reflinks = map[string]cp.Reflink{
+	"never":  cp.ReflinkNever,
+	"auto":   cp.ReflinkAuto,
+	"always": cp.ReflinkAlways,
+}
+
+// sparses are the values of --sparse.
+var sparses = map[string]cp.Sparse{
+	"never":  cp.SparseNever,
+	"auto":   cp.SparseAuto,
+	"always": cp.SparseAlways,
+}
+
+// colors are the values of --color, whether to color output; auto colors it
+// on terminals unless NO_COLOR is set.
+var colors = map[string]bool{"auto": true, "always": true, "never": true}
+
+// preserveClasses are the classes of metadata --preserve can keep.
+var preserveClasses = []string{"mode", "timestamps", "ownership", "links", "xattr"}
+
+// rootCommand is cp and its subcommands, along with the options its flags
+// set.
+func rootCommand() (*cobra.Command, *options) {
+	opts := options{}
+	root := &cobra.Command{
+		Use:   "cp [flags] SOURCE... DEST\n  cp [flags] -t DEST SOURCE...",
+		Short: "Copy files and directories concurrently",
+		Long: `Copy SOURCE to DEST, or multiple SOURCEs into the directory DEST.
+
+SOURCE and DEST may be local paths, s3://bucket/prefix URLs using the
+standard AWS configuration for credentials, gs://bucket/prefix URLs using
+Google's application default credentials, or [user@]host:path for SFTP
+using the SSH agent or keys in ~/.ssh and verified against known_hosts.
+Azure containers are named by az://account/container/prefix URLs, with a SAS
+token from AZURE_STORAGE_SAS_TOKEN, or by their blob.core.windows.net URLs
+with the SAS token as the query. Running containers are named by
+container://ID/path, reached through the Docker daemon at DOCKER_HOST.
+
+As with rsync, a directory SOURCE is copied into DEST as DEST/SOURCE, while a
+SOURCE with a trailing slash has its contents copied into DEST.
+
+A SOURCE may also be an http:// or https:// URL, which is downloaded as a
+single file, retried on failure and, with --checksum, verified.
+
+With -t, DEST is given first and every argument is a SOURCE copied into it,
+which suits xargs and find -exec ... +.
+
+With --parents, each SOURCE is copied to its whole path beneath the
+directory DEST, so a/b/c.txt is copied to DEST/a/b/c.txt.
+
+With --files-from, the only argument is DEST and the paths to copy are read
+one per line from the given file, or stdin if it is "-".
+
+On SIGINT or SIGTERM the copy stops, removing any file it was part way
+through creating, and summarises what was done. With --rollback, everything
+the copy created is removed and everything it overwrote restored, whether it
+is interrupted or fails.
+
+With -a, directories are copied recursively keeping the mode, modification
+time, owner and extended attributes of everything copied, along with hard
+links, and symlinks are copied as symlinks rather than as what they point
+to. --preserve keeps only the classes of metadata listed, out of mode,
+timestamps, ownership, links and xattr, or all of them.
+
+With -L, symlinked directories are descended into and what every symlink
+points to is copied. With -P, symlinks are copied as symlinks, SOURCEs
+included, as -a does by default. -H is -P but for SOURCEs, which are
+followed.
+
+With --json, each file copied, linked, skipped or failed is written to stdout
+as a JSON object on its own line, named by its "msg", and followed by a
+"summary" object with the totals.
+
+Defaults for any of the flags are read from ~/.config/cp/config.toml, or the
+file named by CP_CONFIG, keyed by their long names, as in "parallel = 16" or
+"exclude = ['^\.git$']". These apply to every command with the flag, while
+those under a table such as [mirror] apply to that command alone. Flags
+given on the command line take precedence.
+
+cp move moves files as mv does, copying them between devices, cp mirror
+makes a copy match its source, deleting what the source doesn't have, cp
+verify compares a copy with its source or a manifest, cp serve runs copies
+submitted over an HTTP API instead, cp bench times copies with different
+settings to tune --parallel and --buffer-size, and cp completion writes a
+script completing flags, their values and URL schemes for bash, zsh, fish or
+PowerShell.`,
+		Args: func(_ *cobra.Command, args []string) error {
+			if _, ok := reflinks[opts.reflink]; !ok {
+				return fmt.Errorf("--reflink must be auto, always or never, not %q", opts.reflink)
+			}
+			if _, ok := sparses[opts.sparse]; !ok {
+				return fmt.Errorf("--sparse must be auto, always or never, not %q", opts.sparse)
+			}
+			for _, class := range opts.preserve {
+				if class != "all" && !slices.Contains(preserveClasses, class) {
+					return fmt.Errorf("--preserve has no class %q", class)
+				}
+			}
+			for _, expr := range opts.exclude {
+				if _, err := regexp.Compile(expr); err != nil {
+					return fmt.Errorf("--exclude %q: %v", expr, err)
+				}
+			}
+			if opts.prompt && opts.filesFrom == "-" {
+				return fmt.Errorf("-i cannot read answers from stdin while reading --files-from from it")
+			}
+			if opts.target != "" {
+				if opts.filesFrom != "" && len(args) > 0 {
+					return fmt.Errorf("--files-from with -t takes no arguments")
+				}
+				if opts.filesFrom == "" && len(args) < 1 {
+					return fmt.Errorf("not enough arguments")
+				}
+				return nil
+			}
+			if opts.filesFrom != "" {
+				if len(args) != 1 {
+					return fmt.Errorf("--files-from takes exactly one argument, the destination")
+				}
+				return nil
+			}
+			if len(args) < 2 {
+				return fmt.Errorf("not enough arguments")
+			}
+			return nil
+		},
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Run: func(_ *cobra.Command, args []string) {
+			if opts.archive {
+				opts.recursive = true
+			}
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			sources, dest := args, opts.target
+			if dest == "" {
+				sources, dest = args[:len(args)-1], args[len(args)-1]
+			}
+			if opts.filesFrom != "" {
+				runList(ctx, opts, dest)
+				return
+			}
+			run(ctx, opts, sources, dest)
+		},
+	}
+	root.AddCommand(serveCommand())
+	root.AddCommand(verifyCommand())
+	root.AddCommand(mirrorCommand())
+	root.AddCommand(moveCommand())
+	root.AddCommand(benchCommand())
+	root.AddCommand(completionCommand())
+	flags := root.Flags()
+	flags.BoolVarP(&opts.recursive, "recursive", "r", false, "copy directories recursively")
+	flags.BoolVarP(&opts.archive, "archive", "a", false, "copy recursively, preserving metadata and symlinks")
+	flags.StringVarP(&opts.target, "target-directory", "t", "", "copy every SOURCE into `DEST`")
+	flags.BoolVar(&opts.parents, "parents", false, "copy each SOURCE to its whole path beneath DEST")
+	flags.BoolVarP(&opts.follow, "dereference", "L", false, "follow every symlink")
+	flags.BoolVarP(&opts.noFollow, "no-dereference", "P", false, "copy symlinks as symlinks")
+	flags.BoolVarP(&opts.followArgs, "dereference-args", "H", false, "follow symlinks named as SOURCE, copying others as symlinks")
+	root.MarkFlagsMutuallyExclusive("dereference", "no-dereference", "dereference-args")
+	flags.StringVar(&opts.reflink, "reflink", "never", "clone files `WHEN` auto, always or never, sharing their blocks on btrfs, XFS or APFS")
+	flags.Lookup("reflink").NoOptDefVal = "always"
+	flags.StringVar(&opts.sparse, "sparse", "auto", "leave runs of zeros as holes `WHEN` auto, always or never; auto if the source has them")
+	flags.StringSliceVar(&opts.preserve, "preserve", nil, "keep the metadata `CLASSES` listed: mode, timestamps, ownership, links, xattr or all")
+	flags.BoolVar(&opts.niceIO, "nice-io", false, "copy at idle IO priority, giving way to other work on the same disks")
+	flags.BoolVarP(&opts.clobber, "clobber", "f", false, "overwrite existing files")
+	flags.BoolVarP(&opts.prompt, "interactive", "i", false, "prompt before overwriting each existing file")
+	flags.BoolVar(&opts.rollback, "rollback", false, "undo the whole copy if it fails or is interrupted")
+	flags.IntVar(&opts.parallel, "parallel", 0, "number of files to copy in parallel, by default chosen to suit the disks and tuned as it goes")
+	flags.IntVar(&opts.batch, "batch", 0, "copy files of 64 KiB or less up to `N` at a time in each worker, for trees of tiny files")
+	flags.IntVar(&opts.pipeline, "pipeline", 0, "read each file up to `N` buffers ahead of writing it, overlapping the two on network filesystems")
+	flags.Var(&opts.buffer, "buffer-size", "read and write files `SIZE` bytes at a time, such as 1MiB, instead of 32 KiB")
+	flags.BoolVarP(&opts.quiet, "quiet", "q", false, "print nothing but errors")
+	flags.CountVarP(&opts.verbose, "verbose", "v", "print each file as it is copied, and with -vv its size and duration")
+	flags.StringVar(&opts.filesFrom, "files-from", "", "read the paths to copy from `FILE` (- for stdin)")
+	flags.StringVar(&opts.checksum, "checksum", "", "verify a downloaded file against `ALGORITHM:HEX`, such as sha256:...")
+	flags.IntVar(&opts.retries, "retries", 3, "number of times to retry a file or download failing with an error that may pass")
+	flags.BoolVar(&opts.json, "json", false, "write an event per file and a summary to stdout as JSON lines")
+	flags.StringArrayVar(&opts.exclude, "exclude", nil, "leave out files whose slash separated path beneath a SOURCE directory matches `REGEXP`")
+	root.PersistentFlags().StringVar(&opts.color, "color", "auto", "color output `WHEN` auto, always or never")
+	registerCompletions(root)
+	return root, &opts
 }
 
 func main() {
-	args := os.Args[1:]
-	if len(args) < 2 {
-		oops("not enough arguments\n")
+	root, opts := rootCommand()
+	// The configuration fills in the flags of the command run once the
+	// command line is parsed, before it is validated.
+	cobra.OnInitialize(func() {
+		cmd, _, err := root.Find(os.Args[1:])
+		if err != nil {
+			cmd = root
+		}
+		if err := loadConfig(cmd, configPath()); err != nil {
+			oops("%v\n", err)
+		}
+		if !colors[opts.color] {
+			oops("--color must be auto, always or never, not %q\n", opts.color)
+		}
+		if opts.color != "auto" {
+			color.NoColor = opts.color == "never"
+		}
+	})
+	if err := root.Execute(); err != nil {
+		oops("%v\n", err)
+	}
+}
+
+func run(ctx context.Context, opts options, args []string, dest string) {
+	to, err := parseEndpoint(ctx, dest, sessions(opts))
+	if err != nil {
+		oops("%v\n", err)
+	}
+	src, err := parseEndpoint(ctx, args[0], sessions(opts))
+	if err != nil {
+		oops("%v\n", err)
+	}
+	for ii, arg := range args {
+		e, err := parseEndpoint(ctx, arg, sessions(opts))
+		if err != nil {
+			oops("%v\n", err)
+		}
+		if e.name != src.name {
+			oops("sources must all be on the same filesystem\n")
+		}
+		args[ii] = e.path
+	}
+	if src.name == "http" {
+		runDownload(ctx, opts, args, to)
+		return
+	}
+	if opts.checksum != "" {
+		oops("--checksum only applies to downloads\n")
+	}
+	sources, globbed, err := expand(src.fs, args)
+	if err != nil {
+		oops("%v\n", err)
+	}
+	// Like rsync, directories are copied into the destination unless they
+	// have a trailing slash, in which case their contents are.
+	into := opts.target != "" || opts.parents || len(sources) > 1 || globbed
+	for _, from := range sources {
+		fi, err := src.fs.Stat(from)
+		if err != nil {
+			fatal("%v\n", err)
+		}
+		if fi.IsDir() {
+			if !opts.recursive {
+				oops("-r not specified; omitting directory %q\n", from)
+			}
+			into = true
+		}
+	}
+	if fi, err := to.fs.Stat(to.path); err == nil {
+		if fi.IsDir() {
+			into = true
+		} else if into {
+			oops("target %q is not a directory\n", dest)
+		}
+	}
+	copier := newCopier(opts)
+	copier.SrcFs = src.fs
+	copier.DstFs = to.fs
+	var b *bar
+	if !opts.quiet && opts.verbose == 0 && !opts.json && !opts.prompt && isatty.IsTerminal(os.Stderr.Fd()) {
+		total := cp.Totals{}
+		for _, from := range sources {
+			t, err := copier.Measure(from)
+			if err != nil {
+				fatal("sizing %s: %v\n", from, err)
+			}
+			total.Files += t.Files
+			total.Bytes += t.Bytes
+		}
+		copier.Expected = total
+		b = newBar(os.Stderr, copier, total)
+		copier.Progress = b.Progress
+		b.Start()
+	}
+	var report cp.Report
+	if !into {
+		report, err = copier.CopyContext(ctx, sources[0], to.path)
+	} else {
+		report, err = copier.CopyAllContext(ctx, sources, to.path)
 	}
-	from, to := args[0], args[1]
-	copier := cp.Copier{
-		Clobber: true,
+	if b != nil {
+		b.Stop()
 	}
-	if err := copier.Copy(from, to); err != nil {
+	summarize(copier, report)
+	if ctx.Err() != nil {
+		interrupted(report)
+	}
+	if err != nil {
+		exit(report, err)
+	}
+}
+
+// runDownload fetches each of the URLs to dest, which must be a directory if
+// there are several of them.
+func runDownload(ctx context.Context, opts options, urls []string, to endpoint) {
+	if to.name == "http" {
+		oops("cannot copy to %s\n", to.path)
+	}
+	if len(urls) > 1 && opts.checksum != "" {
+		oops("--checksum applies to a single download\n")
+	}
+	if len(urls) > 1 || opts.target != "" {
+		if fi, err := to.fs.Stat(to.path); err != nil || !fi.IsDir() {
+			oops("target %q is not a directory\n", to.path)
+		}
+	}
+	copier := newCopier(opts)
+	copier.DstFs = to.fs
+	var report cp.Report
+	for _, u := range urls {
+		if ctx.Err() != nil {
+			summarize(copier, report)
+			interrupted(report)
+		}
+		r, err := copier.Download(cp.Download{
+			URL:      u,
+			Checksum: opts.checksum,
+			Retries:  opts.retries,
+		}, to.path)
+		report.Copied = append(report.Copied, r.Copied...)
+		report.Overwritten = append(report.Overwritten, r.Overwritten...)
+		report.Failed = append(report.Failed, r.Failed...)
+		if err != nil {
+			summarize(copier, report)
+			exit(report, err)
+		}
+	}
+	summarize(copier, report)
+}
+
+// expand replaces any source containing shell patterns with its matches, for
+// shells that don't expand them (such as on Windows) or when quoted.
+// Reports whether any pattern was expanded, in which case the destination is
+// treated as a directory to copy into.
+func expand(fs afero.Fs, sources []string) ([]string, bool, error) {
+	var (
+		expanded []string
+		globbed  bool
+	)
+	for _, src := range sources {
+		if !strings.ContainsAny(src, "*?[") {
+			expanded = append(expanded, src)
+			continue
+		}
+		if _, err := fs.Stat(src); err == nil {
+			expanded = append(expanded, src)
+			continue
+		}
+		matches, err := cp.Glob(fs, src)
+		if err != nil {
+			return nil, false, fmt.Errorf("bad pattern %q: %v", src, err)
+		}
+		if len(matches) == 0 {
+			return nil, false, cp.ErrNoMatch{Pattern: src}
+		}
+		expanded = append(expanded, matches...)
+		globbed = true
+	}
+	return expanded, globbed, nil
+}
+
+// runList copies the paths listed in the --files-from file into dest.
+func runList(ctx context.Context, opts options, dest string) {
+	to, err := parseEndpoint(ctx, dest, sessions(opts))
+	if err != nil {
+		oops("%v\n", err)
+	}
+	in := os.Stdin
+	if opts.filesFrom != "-" {
+		f, err := os.Open(opts.filesFrom)
+		if err != nil {
+			fatal("opening file list: %v\n", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	var paths []string
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fatal("reading file list: %v\n", err)
+	}
+	copier := newCopier(opts)
+	copier.DstFs = to.fs
+	report, err := copier.CopyPathsContext(ctx, paths, to.path)
+	summarize(copier, report)
+	if ctx.Err() != nil {
+		interrupted(report)
+	}
+	if err != nil {
+		exit(report, err)
+	}
+}
+
+// sessions is the number of SFTP sessions to open per host, one per worker.
+func sessions(opts options) int {
+	if opts.parallel < 1 {
+		return 10
+	}
+	return opts.parallel
+}
+
+// newCopier configures a Copier from the flags.
+func newCopier(opts options) *cp.Copier {
+	copier := &cp.Copier{
+		Clobber:    opts.clobber,
+		Parallel:   opts.parallel,
+		Rollback:   opts.rollback,
+		Parents:    opts.parents,
+		Reflink:    reflinks[opts.reflink],
+		Sparse:     sparses[opts.sparse],
+		NiceIO:     opts.niceIO,
+		Batch:      opts.batch,
+		Pipeline:   opts.pipeline,
+		BufferSize: int(opts.buffer),
+		Retries:    opts.retries,
+	}
+	for _, expr := range opts.exclude {
+		copier.ExcludeRegexp = append(copier.ExcludeRegexp, regexp.MustCompile(expr))
+	}
+	keep := map[string]bool{}
+	for _, class := range opts.preserve {
+		keep[class] = true
+	}
+	if opts.archive {
+		keep["all"] = true
+		copier.CopySymlinks = !opts.follow
+		copier.LinkRoots = !opts.followArgs
+	}
+	if keep["all"] {
+		for _, class := range preserveClasses {
+			keep[class] = true
+		}
+	}
+	copier.PreserveMode = keep["mode"]
+	copier.PreserveTimes = keep["timestamps"]
+	copier.PreserveOwner = keep["ownership"]
+	copier.PreserveLinks = keep["links"]
+	copier.PreserveXattrs = keep["xattr"]
+	switch {
+	case opts.follow:
+		copier.FollowSymlinks = true
+	case opts.noFollow:
+		copier.CopySymlinks = true
+		copier.LinkRoots = true
+	case opts.followArgs:
+		copier.CopySymlinks = true
+	}
+	if opts.prompt {
+		copier.OnConflict = newPrompter(os.Stdin, os.Stderr).OnConflict
+	}
+	if opts.json {
+		copier.Logger = jsonLogger(os.Stdout)
+		return copier
+	}
+	if opts.verbose > 0 && !opts.quiet {
+		copier.Progress = func(p cp.Progress) {
+			switch {
+			case p.File.Err != nil, p.Skipped:
+			case p.Removed:
+				fmt.Printf("deleted %s\n", p.File.To)
+			case opts.verbose > 1:
+				fmt.Printf("%s -> %s (%s in %s)\n", p.File.From, p.File.To, bytes(p.File.Bytes), p.File.Duration.Round(time.Microsecond))
+			default:
+				fmt.Printf("%s -> %s\n", p.File.From, p.File.To)
+			}
+		}
+	}
+	return copier
+}
+
+// interrupted reports how far a cancelled copy got and exits.
+func interrupted(report cp.Report) {
+	failed(exitInterrupted, "interrupted: %d copied, %d overwritten, %d not copied\n",
+		len(report.Copied),
+		len(report.Overwritten),
+		len(report.Skipped)+len(report.Failed))
+}
+
+// exit reports a failed copy, listing each file that failed, and exits with
+// a code distinguishing total from partial failure.
+func exit(report cp.Report, err error) {
+	if len(report.Failed) == 0 {
 		fatal("copying files: %v\n", err)
 	}
+	for _, f := range report.Failed {
+		fmt.Fprintf(os.Stderr, "%s %v\n", color.New(color.FgRed).Sprintf("failed:"), f.Err)
+	}
+	code := exitFailure
+	if len(report.Copied)+len(report.Overwritten) > 0 {
+		code = exitPartial
+	}
+	failed(code, "%d of %d files failed to copy\n",
+		len(report.Failed),
+		len(report.Failed)+len(report.Copied)+len(report.Overwritten))
 }
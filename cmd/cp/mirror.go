@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
+	"syscall"
+
+	"github.com/fatih/color"
+	"github.com/jackmordaunt/cp"
+	"github.com/spf13/cobra"
+)
+
+// mirrorCommand makes a destination match its source, deleting what the
+// source doesn't have.
+func mirrorCommand() *cobra.Command {
+	var (
+		opts           = options{recursive: true}
+		dryRun         bool
+		deleteExcluded bool
+	)
+	cmd := &cobra.Command{
+		Use:   "mirror [flags] SOURCE DEST",
+		Short: "Make a copy match its source, deleting extra files",
+		Long: `Make DEST match the directory SOURCE, as rsync -a --delete does: files
+new or changed, by size or modification time, are copied over whatever is
+there, and files in DEST that aren't in SOURCE are deleted. As with copying,
+SOURCE is mirrored into DEST/SOURCE, or into DEST itself if it has a
+trailing slash. Modification times are always kept, so that the next mirror
+can tell what changed.
+
+Files left out by --exclude are left alone in DEST, unless --delete-excluded
+is given. With --dry-run, what would be copied and deleted is listed and
+nothing is changed.`,
+		Args: func(_ *cobra.Command, args []string) error {
+			for _, expr := range opts.exclude {
+				if _, err := regexp.Compile(expr); err != nil {
+					return fmt.Errorf("--exclude %q: %v", expr, err)
+				}
+			}
+			return cobra.ExactArgs(2)(nil, args)
+		},
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Run: func(_ *cobra.Command, args []string) {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			src, err := parseEndpoint(ctx, args[0], sessions(opts))
+			if err != nil {
+				oops("%v\n", err)
+			}
+			dst, err := parseEndpoint(ctx, args[1], sessions(opts))
+			if err != nil {
+				oops("%v\n", err)
+			}
+			if src.name == "http" || dst.name == "http" {
+				oops("cannot mirror over HTTP\n")
+			}
+			copier := newCopier(opts)
+			copier.SrcFs, copier.DstFs = src.fs, dst.fs
+			copier.DeleteExcluded = deleteExcluded
+			to := copied(src, dst)
+			if dryRun {
+				p, err := copier.PlanMirror(src.path, to)
+				if err != nil {
+					fatal("planning: %v\n", err)
+				}
+				printPlan(p)
+				return
+			}
+			report, err := copier.Mirror(src.path, to)
+			summarize(copier, report)
+			if err != nil {
+				exit(report, err)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.BoolVar(&dryRun, "dry-run", false, "list what would be copied and deleted without changing anything")
+	flags.BoolVar(&deleteExcluded, "delete-excluded", false, "delete files left out by --exclude from DEST too")
+	flags.BoolVarP(&opts.archive, "archive", "a", false, "keep the mode, owner and extended attributes too, and copy symlinks as symlinks")
+	flags.StringArrayVar(&opts.exclude, "exclude", nil, "leave out files whose slash separated path beneath SOURCE matches `REGEXP`")
+	flags.IntVar(&opts.parallel, "parallel", 0, "number of files to copy in parallel")
+	flags.BoolVarP(&opts.quiet, "quiet", "q", false, "print nothing but errors")
+	flags.CountVarP(&opts.verbose, "verbose", "v", "print each file as it is copied or deleted")
+	flags.BoolVar(&opts.json, "json", false, "write an event per file and a summary to stdout as JSON lines")
+	return cmd
+}
+
+// printPlan lists the files a plan would copy, overwrite or delete, one per
+// line.
+func printPlan(p cp.Plan) {
+	for _, op := range p.Ops {
+		switch op.Kind {
+		case cp.OpCopy:
+			fmt.Printf("%s      %s -> %s\n", color.New(color.FgGreen).Sprint("copy"), op.From, op.To)
+		case cp.OpOverwrite:
+			fmt.Printf("%s %s -> %s\n", color.New(color.FgBlue).Sprint("overwrite"), op.From, op.To)
+		case cp.OpRemove:
+			fmt.Printf("%s    %s\n", color.New(color.FgRed).Sprint("delete"), op.To)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/jackmordaunt/cp"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+)
+
+// moveCommand moves files and directories, as mv does.
+func moveCommand() *cobra.Command {
+	var (
+		opts      = options{recursive: true, archive: true, clobber: true}
+		noClobber bool
+	)
+	cmd := &cobra.Command{
+		Use:   "move [flags] SOURCE... DEST",
+		Short: "Move files and directories, copying between devices",
+		Long: `Move SOURCE to DEST, or multiple SOURCEs into the directory DEST, as mv
+does. A SOURCE is moved into DEST under its own name if DEST is an existing
+directory, and over any existing file unless -n is given.
+
+Where SOURCE and DEST are on the same device, SOURCE is renamed in one step.
+Otherwise it is copied, keeping its metadata and symlinks, and each file
+removed once its copy is in place. A file that fails to copy is left where
+it was and reported, so running the move again finishes it.`,
+		Args:          cobra.MinimumNArgs(2),
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Run: func(_ *cobra.Command, args []string) {
+			opts.clobber = !noClobber
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			sources, dest := args[:len(args)-1], args[len(args)-1]
+			to, err := parseEndpoint(ctx, dest, sessions(opts))
+			if err != nil {
+				oops("%v\n", err)
+			}
+			from, err := parseEndpoint(ctx, sources[0], sessions(opts))
+			if err != nil {
+				oops("%v\n", err)
+			}
+			for ii, arg := range sources {
+				e, err := parseEndpoint(ctx, arg, sessions(opts))
+				if err != nil {
+					oops("%v\n", err)
+				}
+				if e.name != from.name {
+					oops("sources must all be on the same filesystem\n")
+				}
+				if e.name == "http" || to.name == "http" {
+					oops("cannot move over HTTP\n")
+				}
+				sources[ii] = e.path
+			}
+			into := len(sources) > 1
+			if fi, err := to.fs.Stat(to.path); err == nil && fi.IsDir() {
+				into = true
+			} else if into {
+				oops("target %q is not a directory\n", dest)
+			}
+			copier := newCopier(opts)
+			copier.SrcFs, copier.DstFs = from.fs, to.fs
+			var b *bar
+			if !opts.quiet && opts.verbose == 0 && !opts.json && isatty.IsTerminal(os.Stderr.Fd()) {
+				total := cp.Totals{}
+				for _, path := range sources {
+					t, err := copier.Measure(path)
+					if err != nil {
+						fatal("sizing %s: %v\n", path, err)
+					}
+					total.Files += t.Files
+					total.Bytes += t.Bytes
+				}
+				copier.Expected = total
+				b = newBar(os.Stderr, copier, total)
+				copier.Progress = b.Progress
+				b.Start()
+			}
+			report := cp.Report{}
+			var errs []error
+			for _, path := range sources {
+				target := to.path
+				if into {
+					target = filepath.Join(to.path, filepath.Base(filepath.Clean(path)))
+				}
+				r, err := copier.MoveContext(ctx, path, target)
+				report.Copied = append(report.Copied, r.Copied...)
+				report.Overwritten = append(report.Overwritten, r.Overwritten...)
+				report.Renamed = append(report.Renamed, r.Renamed...)
+				report.Skipped = append(report.Skipped, r.Skipped...)
+				report.Linked = append(report.Linked, r.Linked...)
+				report.Failed = append(report.Failed, r.Failed...)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("moving %s: %v", path, err))
+				}
+				if ctx.Err() != nil {
+					break
+				}
+			}
+			if b != nil {
+				b.Stop()
+			}
+			summarize(copier, report)
+			if ctx.Err() != nil {
+				interrupted(report)
+			}
+			if len(errs) > 0 {
+				exit(report, errors.Join(errs...))
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.BoolVarP(&noClobber, "no-clobber", "n", false, "leave existing files in DEST alone")
+	flags.IntVar(&opts.parallel, "parallel", 0, "number of files to copy in parallel between devices")
+	flags.BoolVarP(&opts.quiet, "quiet", "q", false, "print nothing but errors")
+	flags.CountVarP(&opts.verbose, "verbose", "v", "print each file as it is moved")
+	flags.BoolVar(&opts.json, "json", false, "write an event per file and a summary to stdout as JSON lines")
+	return cmd
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackmordaunt/cp"
+)
+
+// bar renders a single line progress bar for a copy.
+type bar struct {
+	out    io.Writer
+	total  cp.Totals
+	copier *cp.Copier
+	width  int
+
+	mu   sync.Mutex
+	done int64
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newBar(out io.Writer, copier *cp.Copier, total cp.Totals) *bar {
+	return &bar{
+		out:    out,
+		total:  total,
+		copier: copier,
+		width:  30,
+		stop:   make(chan struct{}),
+	}
+}
+
+// Progress is used as the Copier's progress callback.
+func (b *bar) Progress(p cp.Progress) {
+	b.mu.Lock()
+	b.done++
+	b.mu.Unlock()
+}
+
+// Start redraws the bar periodically until Stop is called.
+func (b *bar) Start() {
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		tick := time.NewTicker(200 * time.Millisecond)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				b.render()
+			case <-b.stop:
+				b.render()
+				fmt.Fprintln(b.out)
+				return
+			}
+		}
+	}()
+}
+
+// Stop draws the final state of the bar.
+func (b *bar) Stop() {
+	close(b.stop)
+	b.wg.Wait()
+}
+
+func (b *bar) render() {
+	b.mu.Lock()
+	done := b.done
+	b.mu.Unlock()
+	stats := b.copier.Stats()
+	ratio := 1.0
+	if b.total.Bytes > 0 {
+		ratio = float64(stats.Bytes) / float64(b.total.Bytes)
+	} else if b.total.Files > 0 {
+		ratio = float64(done) / float64(b.total.Files)
+	}
+	if ratio > 1 {
+		ratio = 1
+	}
+	filled := int(ratio * float64(b.width))
+	eta := "--"
+	switch {
+	case stats.ETA > 0:
+		eta = stats.ETA.Round(time.Second).String()
+	case stats.Bytes >= b.total.Bytes:
+		eta = "0s"
+	}
+	// The rolling rate stops with the copy, leaving the average to show.
+	rate := stats.Rate
+	if rate == 0 {
+		rate = stats.Throughput
+	}
+	fmt.Fprintf(b.out, "\r[%s%s] %d/%d files  %s/%s  %.1f MB/s  ETA %s ",
+		strings.Repeat("=", filled),
+		strings.Repeat(" ", b.width-filled),
+		done,
+		b.total.Files,
+		bytes(stats.Bytes),
+		bytes(b.total.Bytes),
+		rate,
+		eta,
+	)
+}
+
+// bytes formats n as a human readable size.
+func bytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jackmordaunt/cp"
+)
+
+// prompter asks before each file is overwritten, for -i. Answering "all"
+// overwrites the rest without asking and "quit" stops the copy.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+	all bool
+}
+
+func newPrompter(in io.Reader, out io.Writer) *prompter {
+	return &prompter{in: bufio.NewReader(in), out: out}
+}
+
+// OnConflict is used as the Copier's conflict callback.
+func (p *prompter) OnConflict(from, to string) cp.Conflict {
+	if p.all {
+		return cp.ConflictOverwrite
+	}
+	for {
+		fmt.Fprintf(p.out, "overwrite %s? [y]es, [n]o, [a]ll, [q]uit: ", to)
+		line, err := p.in.ReadString('\n')
+		if err != nil && line == "" {
+			return cp.ConflictAbort
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return cp.ConflictOverwrite
+		case "n", "no":
+			return cp.ConflictSkip
+		case "a", "all":
+			p.all = true
+			return cp.ConflictOverwrite
+		case "q", "quit":
+			return cp.ConflictAbort
+		}
+	}
+}
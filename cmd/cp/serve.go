@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+// serveCommand runs the daemon, taking copy jobs over HTTP.
+func serveCommand() *cobra.Command {
+	var addr string
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run copies submitted over an HTTP API",
+		Long: `Serve an HTTP API for running copies in the background.
+
+  POST   /jobs       start a copy given {"from", "to", "clobber",
+                     "include_root", "parallel"}, named as on the command line
+  GET    /jobs       list the jobs, or with ?state= those running, done,
+                     failed or cancelled
+  GET    /jobs/{id}  report a job's progress
+  DELETE /jobs/{id}  cancel a job
+
+Jobs are answered with their status as JSON, and kept until the daemon
+exits. There is no authentication, so listen only where it is trusted.`,
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		RunE: func(*cobra.Command, []string) error {
+			fmt.Printf("listening on %s\n", addr)
+			return http.ListenAndServe(addr, newServer(newJobs(sessions(options{}))))
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", "localhost:8080", "`ADDRESS` to listen on")
+	return cmd
+}
+
+// newServer routes the job API.
+func newServer(js *jobs) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", func(w http.ResponseWriter, r *http.Request) {
+		var req jobRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("decoding job: %v", err))
+			return
+		}
+		j, err := js.submit(req)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, j.status())
+	})
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, js.list(r.URL.Query().Get("state")))
+	})
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		j, ok := js.get(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", r.PathValue("id")))
+			return
+		}
+		writeJSON(w, http.StatusOK, j.status())
+	})
+	mux.HandleFunc("DELETE /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		j, ok := js.get(r.PathValue("id"))
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", r.PathValue("id")))
+			return
+		}
+		j.cancel()
+		writeJSON(w, http.StatusOK, j.status())
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackmordaunt/cp"
+	"github.com/spf13/afero"
+)
+
+func TestServer_Jobs(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "from"), filepath.Join(dir, "to")
+	if err := os.MkdirAll(from, 0755); err != nil {
+		t.Fatalf("unexpected error while making directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(from, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	srv := httptest.NewServer(newServer(newJobs(1)))
+	defer srv.Close()
+
+	var submitted jobStatus
+	body := `{"from": ` + quote(from) + `, "to": ` + quote(to) + `}`
+	if code := call(t, srv, "POST", "/jobs", body, &submitted); code != http.StatusAccepted {
+		t.Fatalf("submitting: want %d, got %d", http.StatusAccepted, code)
+	}
+	s := wait(t, srv, submitted.ID)
+	if s.State != jobDone || s.Copied != 1 || s.Finished == nil {
+		t.Errorf("want the job done having copied 1 file, got %+v", s)
+	}
+	if got, err := os.ReadFile(filepath.Join(to, "a.txt")); err != nil || string(got) != "a" {
+		t.Errorf("want a.txt copied, got %q, %v", got, err)
+	}
+
+	for state, want := range map[string]int{"": 1, jobDone: 1, jobRunning: 0} {
+		var list []jobStatus
+		if code := call(t, srv, "GET", "/jobs?state="+state, "", &list); code != http.StatusOK {
+			t.Fatalf("listing %q: want %d, got %d", state, http.StatusOK, code)
+		}
+		if len(list) != want {
+			t.Errorf("listing %q: want %d jobs, got %d", state, want, len(list))
+		}
+	}
+
+	var cancelled jobStatus
+	if code := call(t, srv, "DELETE", "/jobs/"+submitted.ID, "", &cancelled); code != http.StatusOK {
+		t.Fatalf("cancelling: want %d, got %d", http.StatusOK, code)
+	}
+	if cancelled.State != jobDone {
+		t.Errorf("want a finished job to stay %s after cancelling, got %s", jobDone, cancelled.State)
+	}
+
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"POST", "/jobs", "{", http.StatusBadRequest},
+		{"POST", "/jobs", `{"from": "a"}`, http.StatusBadRequest},
+		{"GET", "/jobs/9", "", http.StatusNotFound},
+		{"DELETE", "/jobs/9", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		var e map[string]string
+		if code := call(t, srv, tt.method, tt.path, tt.body, &e); code != tt.want {
+			t.Errorf("%s %s %s: want %d, got %d", tt.method, tt.path, tt.body, tt.want, code)
+		}
+		if e["error"] == "" {
+			t.Errorf("%s %s %s: want an error message", tt.method, tt.path, tt.body)
+		}
+	}
+}
+
+func TestServer_Cancel(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "from/a.txt", []byte("a"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	opened, release := make(chan struct{}), make(chan struct{})
+	js := newJobs(1)
+	js.mu.Lock()
+	j := js.start(jobRequest{From: "from", To: "to"}, &cp.Copier{
+		SrcFs: blockingFs{Fs: fs, once: &sync.Once{}, opened: opened, release: release},
+		DstFs: fs,
+	}, "from", "to")
+	js.mu.Unlock()
+	srv := httptest.NewServer(newServer(js))
+	defer srv.Close()
+
+	select {
+	case <-opened:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("timed out waiting for the job to open a file")
+	}
+	var s jobStatus
+	if code := call(t, srv, "DELETE", "/jobs/"+j.id, "", &s); code != http.StatusOK {
+		t.Fatalf("cancelling: want %d, got %d", http.StatusOK, code)
+	}
+	close(release)
+	s = wait(t, srv, j.id)
+	if s.State != jobCancelled || s.Finished == nil {
+		t.Errorf("want the job %s once finished, got %+v", jobCancelled, s)
+	}
+	var list []jobStatus
+	call(t, srv, "GET", "/jobs?state="+jobCancelled, "", &list)
+	if len(list) != 1 || list[0].ID != j.id {
+		t.Errorf("want the job listed as %s, got %+v", jobCancelled, list)
+	}
+}
+
+// blockingFs is an afero.Fs whose files can't be opened until release is
+// closed, holding a job part way through its copy. opened is closed the
+// first time one is tried.
+type blockingFs struct {
+	afero.Fs
+	once            *sync.Once
+	opened, release chan struct{}
+}
+
+func (fs blockingFs) Open(name string) (afero.File, error) {
+	fs.block(name)
+	return fs.Fs.Open(name)
+}
+
+func (fs blockingFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	fs.block(name)
+	return fs.Fs.OpenFile(name, flag, perm)
+}
+
+func (fs blockingFs) block(name string) {
+	if fi, err := fs.Fs.Stat(name); err != nil || fi.IsDir() {
+		return
+	}
+	fs.once.Do(func() { close(fs.opened) })
+	<-fs.release
+}
+
+// call makes a request to srv, decoding the JSON answered into v and
+// returning the status code.
+func call(t *testing.T, srv *httptest.Server, method, path, body string, v interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error while making request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("%s %s: decoding: %v", method, path, err)
+	}
+	return resp.StatusCode
+}
+
+// wait polls the job id until it is no longer running.
+func wait(t *testing.T, srv *httptest.Server, id string) jobStatus {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var s jobStatus
+		if code := call(t, srv, "GET", "/jobs/"+id, "", &s); code != http.StatusOK {
+			t.Fatalf("getting job %s: want %d, got %d", id, http.StatusOK, code)
+		}
+		if s.State != jobRunning {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for job %s, got %+v", id, s)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func quote(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are the suffixes parseSize accepts, binary whether or not they
+// say so.
+var sizeUnits = map[string]int64{
+	"":    1,
+	"B":   1,
+	"K":   1 << 10,
+	"KB":  1 << 10,
+	"KIB": 1 << 10,
+	"M":   1 << 20,
+	"MB":  1 << 20,
+	"MIB": 1 << 20,
+	"G":   1 << 30,
+	"GB":  1 << 30,
+	"GIB": 1 << 30,
+}
+
+// parseSize reads a size such as "4096", "64K" or "1MiB".
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	digits := strings.IndexFunc(s, func(r rune) bool { return (r < '0' || r > '9') && r != '.' })
+	if digits < 0 {
+		digits = len(s)
+	}
+	unit, ok := sizeUnits[strings.ToUpper(strings.TrimSpace(s[digits:]))]
+	if !ok {
+		return 0, fmt.Errorf("size %q has an unknown unit", s)
+	}
+	n, err := strconv.ParseFloat(s[:digits], 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// sizeFlag is a flag holding a size in bytes, given as parseSize reads them.
+type sizeFlag int64
+
+func (f *sizeFlag) Set(s string) error {
+	n, err := parseSize(s)
+	if err != nil {
+		return err
+	}
+	*f = sizeFlag(n)
+	return nil
+}
+
+func (f *sizeFlag) String() string {
+	if *f == 0 {
+		return "0"
+	}
+	return bytes(int64(*f))
+}
+
+func (f *sizeFlag) Type() string { return "size" }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// remote splits an scp style "[user@]host:path" argument. A single letter
+// before the colon is a Windows drive, not a host.
+func remote(arg string) (login, host, path string, ok bool) {
+	ii := strings.Index(arg, ":")
+	if ii < 2 || strings.ContainsAny(arg[:ii], `/\`) {
+		return "", "", "", false
+	}
+	host, path = arg[:ii], arg[ii+1:]
+	if at := strings.LastIndex(host, "@"); at >= 0 {
+		login, host = host[:at], host[at+1:]
+	}
+	if login == "" {
+		if u, err := user.Current(); err == nil {
+			login = u.Username
+		}
+	}
+	if path == "" {
+		path = "."
+	}
+	return login, host, path, true
+}
+
+// sshConfig authenticates as login using the SSH agent and the default keys
+// in ~/.ssh, verifying the host against ~/.ssh/known_hosts.
+func sshConfig(login string) (*ssh.ClientConfig, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("loading known hosts: %v", err)
+	}
+	var signers []ssh.Signer
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			if s, err := agent.NewClient(conn).Signers(); err == nil {
+				signers = append(signers, s...)
+			}
+		}
+	}
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		key, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		if s, err := ssh.ParsePrivateKey(key); err == nil {
+			signers = append(signers, s)
+		}
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no SSH keys found in the agent or ~/.ssh")
+	}
+	return &ssh.ClientConfig{
+		User:            login,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hosts,
+	}, nil
+}
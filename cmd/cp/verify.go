@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jackmordaunt/cp"
+	"github.com/spf13/cobra"
+)
+
+// hashers are the values of --hash.
+var hashers = map[string]cp.Hasher{
+	"sha256":   cp.SHA256,
+	"sha1":     cp.SHA1,
+	"xxhash64": cp.XXHash64,
+	"blake3":   cp.BLAKE3,
+	"crc32c":   cp.CRC32C,
+}
+
+// verifyCommand compares a copy with its source, or with a manifest.
+func verifyCommand() *cobra.Command {
+	var (
+		manifest string
+		hash     string
+		metadata bool
+		asJSON   bool
+		parallel int
+	)
+	cmd := &cobra.Command{
+		Use:   "verify [flags] SOURCE DEST\n  cp verify [flags] --manifest FILE [DIR]",
+		Short: "Compare a copy with its source or a manifest",
+		Long: `Compare DEST with SOURCE, listing the files missing from DEST, those
+extra in it and those whose contents differ, without copying anything. As
+with copying, a directory SOURCE is compared with DEST/SOURCE, while a SOURCE
+with a trailing slash is compared with DEST itself.
+
+With --manifest, the files listed in FILE, in the format of sha256sum, are
+checked against their hashes instead, with paths relative to DIR or, by
+default, to the directory of FILE.
+
+The exit status is 0 if there are no differences and 4 if there are.`,
+		Args: func(_ *cobra.Command, args []string) error {
+			if _, ok := hashers[hash]; !ok {
+				return fmt.Errorf("--hash must be sha256, sha1, xxhash64, blake3 or crc32c, not %q", hash)
+			}
+			if manifest != "" {
+				return cobra.MaximumNArgs(1)(nil, args)
+			}
+			return cobra.ExactArgs(2)(nil, args)
+		},
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Run: func(_ *cobra.Command, args []string) {
+			ctx := context.Background()
+			copier := &cp.Copier{Parallel: parallel, Hasher: hashers[hash]}
+			var (
+				diff cp.Diff
+				err  error
+			)
+			if manifest != "" {
+				root := filepath.Dir(manifest)
+				if len(args) > 0 {
+					root = args[0]
+				}
+				diff, err = copier.VerifyManifest(manifest, root)
+			} else {
+				src, serr := parseEndpoint(ctx, args[0], sessions(options{parallel: parallel}))
+				if serr != nil {
+					oops("%v\n", serr)
+				}
+				dst, derr := parseEndpoint(ctx, args[1], sessions(options{parallel: parallel}))
+				if derr != nil {
+					oops("%v\n", derr)
+				}
+				if src.name == "http" || dst.name == "http" {
+					oops("cannot verify over HTTP\n")
+				}
+				copier.SrcFs, copier.DstFs = src.fs, dst.fs
+				diff, err = copier.Compare(src.path, copied(src, dst))
+			}
+			if !metadata {
+				diff.Changed = contentOnly(diff.Changed)
+			}
+			if err != nil {
+				fatal("verifying: %v\n", err)
+			}
+			if asJSON {
+				writeDiff(diff)
+			} else {
+				printDiff(diff)
+			}
+			if !diff.Equal() {
+				os.Exit(exitMismatch)
+			}
+		},
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&manifest, "manifest", "", "check the files listed in the manifest `FILE` against their hashes")
+	flags.StringVar(&hash, "hash", "sha256", "the `ALGORITHM` of the manifest: sha256, sha1, xxhash64, blake3 or crc32c")
+	flags.BoolVar(&metadata, "metadata", false, "count files whose mode or modification time differ as changed too")
+	flags.BoolVar(&asJSON, "json", false, "write the differences to stdout as a JSON object")
+	flags.IntVar(&parallel, "parallel", 0, "number of files to compare in parallel")
+	cmd.RegisterFlagCompletionFunc("hash", completeValues("sha256", "sha1", "xxhash64", "blake3", "crc32c"))
+	return cmd
+}
+
+// copied is where cp -r would have copied src to within dst: like rsync,
+// into it under its own name unless it has a trailing slash.
+func copied(src, dst endpoint) string {
+	if strings.HasSuffix(src.path, "/") || strings.HasSuffix(src.path, string(filepath.Separator)) {
+		return dst.path
+	}
+	if fi, err := src.fs.Stat(src.path); err == nil && !fi.IsDir() {
+		if fi, err := dst.fs.Stat(dst.path); err != nil || !fi.IsDir() {
+			return dst.path
+		}
+	}
+	return filepath.Join(dst.path, filepath.Base(src.path))
+}
+
+// contentOnly leaves out the differences in metadata, which copies don't
+// carry over unless asked to.
+func contentOnly(changed []cp.Mismatch) []cp.Mismatch {
+	var content []cp.Mismatch
+	for _, m := range changed {
+		if m.Content {
+			m.Mode, m.ModTime = false, false
+			content = append(content, m)
+		}
+	}
+	return content
+}
+
+// printDiff lists the differences one per line.
+func printDiff(diff cp.Diff) {
+	for _, path := range diff.Missing {
+		fmt.Printf("%s %s\n", color.New(color.FgRed).Sprint("missing"), path)
+	}
+	for _, path := range diff.Extra {
+		fmt.Printf("%s   %s\n", color.New(color.FgYellow).Sprint("extra"), path)
+	}
+	for _, m := range diff.Changed {
+		var how []string
+		if m.Content {
+			how = append(how, "content")
+		}
+		if m.Mode {
+			how = append(how, "mode")
+		}
+		if m.ModTime {
+			how = append(how, "mtime")
+		}
+		fmt.Printf("%s %s (%s)\n", color.New(color.FgBlue).Sprint("changed"), m.To, strings.Join(how, ", "))
+	}
+}
+
+// diffJSON is a Diff as written by --json.
+type diffJSON struct {
+	Missing []string       `json:"missing"`
+	Extra   []string       `json:"extra"`
+	Changed []mismatchJSON `json:"changed"`
+}
+
+type mismatchJSON struct {
+	From    string `json:"from,omitempty"`
+	To      string `json:"to"`
+	Content bool   `json:"content"`
+	Mode    bool   `json:"mode"`
+	ModTime bool   `json:"mtime"`
+}
+
+// writeDiff writes the differences as a single JSON object.
+func writeDiff(diff cp.Diff) {
+	out := diffJSON{Missing: diff.Missing, Extra: diff.Extra, Changed: []mismatchJSON{}}
+	if out.Missing == nil {
+		out.Missing = []string{}
+	}
+	if out.Extra == nil {
+		out.Extra = []string{}
+	}
+	for _, m := range diff.Changed {
+		out.Changed = append(out.Changed, mismatchJSON(m))
+	}
+	json.NewEncoder(os.Stdout).Encode(out)
+}
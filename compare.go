@@ -0,0 +1,234 @@
+package cp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// Diff is how a destination differs from what copying the source to it
+// would produce. Paths are those at the destination.
+type Diff struct {
+	// Missing are the files and directories the copy would create.
+	Missing []string
+	// Extra are the files at the destination that no source file is
+	// copied to.
+	Extra []string
+	// Changed are the files at both that differ.
+	Changed []Mismatch
+}
+
+// Equal reports whether there are no differences.
+func (d Diff) Equal() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Changed) == 0
+}
+
+// Mismatch is a file whose copy differs from its source, and how.
+type Mismatch struct {
+	From, To string
+	// Content is whether the contents differ.
+	Content bool
+	// Mode is whether the permissions or type differ.
+	Mode bool
+	// ModTime is whether the modification times differ. Copies don't
+	// carry them over, unless touched up with MetadataOnly.
+	ModTime bool
+}
+
+// Compare reports how to differs from from without copying anything. The
+// source is walked as Copy would walk it, with the filters, Rename and
+// Flatten applied, and the files at both ends are compared Parallel at a
+// time. Extra files are those in to left out by none of the filters.
+func (c *Copier) Compare(from, to string) (Diff, error) {
+	if c.IncludeRoot {
+		to = filepath.Join(to, filepath.Base(filepath.Clean(from)))
+	}
+	if c.Fs == nil {
+		c.Fs = afero.NewOsFs()
+	}
+	c.init()
+	fromFi, err := c.srcFs().Stat(from)
+	if err != nil {
+		return Diff{}, errors.Wrap(err, "reading file metadata")
+	}
+	if fromFi.IsDir() && c.within(from, fromFi, to) {
+		return Diff{}, ErrRecursiveCopy{From: from, To: to}
+	}
+	p, err := c.planTree(from, to, fromFi)
+	if err != nil {
+		return Diff{}, err
+	}
+	cp := c.copier()
+	diff := Diff{}
+	targets := map[string]bool{}
+	var pairs []Op
+	for _, op := range p.Ops {
+		targets[op.To] = true
+		switch op.Kind {
+		case OpMkdir:
+			if _, err := cp.dst.Stat(op.To); os.IsNotExist(err) {
+				diff.Missing = append(diff.Missing, op.To)
+			}
+		case OpCopy:
+			diff.Missing = append(diff.Missing, op.To)
+		case OpOverwrite:
+			pairs = append(pairs, op)
+		}
+	}
+	changed, errs := cp.compare(pairs)
+	diff.Changed = changed
+	if fromFi.IsDir() {
+		extra, err := cp.extra(to, targets)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		diff.Extra = extra
+	}
+	sort.Strings(diff.Missing)
+	sort.Strings(diff.Extra)
+	sort.Slice(diff.Changed, func(ii, jj int) bool {
+		return diff.Changed[ii].To < diff.Changed[jj].To
+	})
+	if len(errs) > 0 {
+		return diff, Failures{errs}
+	}
+	return diff, nil
+}
+
+// compare compares the pairs of files with a pool of workers, returning
+// those that differ.
+func (c *copier) compare(pairs []Op) ([]Mismatch, []error) {
+	parallel := c.parallel
+	if parallel < 1 {
+		parallel = 10
+	}
+	var (
+		mu      sync.Mutex
+		changed []Mismatch
+		errs    []error
+		wg      sync.WaitGroup
+	)
+	work := make(chan Op)
+	for ii := 0; ii < parallel; ii++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for op := range work {
+				m, err := c.mismatch(op.From, op.To)
+				mu.Lock()
+				switch {
+				case err != nil:
+					errs = append(errs, err)
+				case m.Content || m.Mode || m.ModTime:
+					changed = append(changed, m)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, op := range pairs {
+		work <- op
+	}
+	close(work)
+	wg.Wait()
+	return changed, errs
+}
+
+// mismatch compares a file with its copy.
+func (c *copier) mismatch(from, to string) (Mismatch, error) {
+	m := Mismatch{From: from, To: to}
+	fromFi, err := c.src.Stat(from)
+	if err != nil {
+		return m, errors.Wrap(err, "reading file metadata")
+	}
+	toFi, err := c.dst.Stat(to)
+	if err != nil {
+		return m, errors.Wrap(err, "reading file metadata")
+	}
+	m.Mode = fromFi.Mode() != toFi.Mode()
+	m.ModTime = !fromFi.ModTime().Equal(toFi.ModTime())
+	if fromFi.Size() != toFi.Size() || toFi.IsDir() {
+		m.Content = true
+		return m, nil
+	}
+	same, err := c.sameContent(from, to)
+	m.Content = !same
+	return m, err
+}
+
+// sameContent reports whether the two files hold the same bytes.
+func (c *copier) sameContent(from, to string) (bool, error) {
+	if c.fds != nil {
+		c.fds.acquire(2)
+		defer c.fds.release(2)
+	}
+	a, err := c.src.Open(from)
+	if err != nil {
+		return false, errors.Wrapf(err, "opening %s", from)
+	}
+	defer a.Close()
+	b, err := c.dst.Open(to)
+	if err != nil {
+		return false, errors.Wrapf(err, "opening %s", to)
+	}
+	defer b.Close()
+	bufA, bufB := make([]byte, 32<<10), make([]byte, 32<<10)
+	for {
+		n, errA := io.ReadFull(a, bufA)
+		m, errB := io.ReadFull(b, bufB)
+		if !bytes.Equal(bufA[:n], bufB[:m]) {
+			return false, nil
+		}
+		if errA == io.EOF || errA == io.ErrUnexpectedEOF {
+			return errB == io.EOF || errB == io.ErrUnexpectedEOF, nil
+		}
+		if errA != nil {
+			return false, errors.Wrapf(errA, "reading %s", from)
+		}
+		if errB != nil {
+			return false, errors.Wrapf(errB, "reading %s", to)
+		}
+	}
+}
+
+// extra lists the files under to that aren't among the targets of the
+// copy, leaving out those the filters would.
+func (c *copier) extra(to string, targets map[string]bool) ([]string, error) {
+	if _, err := c.dst.Stat(to); os.IsNotExist(err) {
+		return nil, nil
+	}
+	var extra []string
+	err := afero.Walk(c.dst, to, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(to, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && c.excluded(rel, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() && c.deepest(rel) {
+			return filepath.SkipDir
+		}
+		if info.IsDir() || targets[path] || c.filtered(info) {
+			return nil
+		}
+		extra = append(extra, path)
+		return nil
+	})
+	if err != nil {
+		return extra, errors.Wrapf(err, "walking %s", to)
+	}
+	return extra, nil
+}
@@ -0,0 +1,52 @@
+package cp
+
+import "fmt"
+
+// Conflict is what to do about a file whose destination already exists.
+type Conflict int
+
+const (
+	// ConflictOverwrite copies the file over the existing one.
+	ConflictOverwrite Conflict = iota
+	// ConflictSkip leaves the existing file, reporting the file as skipped.
+	ConflictSkip
+	// ConflictAbort stops the copy, failing the file with ErrAborted and
+	// skipping those not yet copied.
+	ConflictAbort
+)
+
+// ErrAborted means OnConflict stopped the copy at To.
+type ErrAborted struct {
+	To string
+}
+
+func (err ErrAborted) Error() string {
+	return fmt.Sprintf("copy aborted at %q", err.To)
+}
+
+// conflict asks OnConflict what to do if to exists, reporting whether to
+// skip the file. Once aborted, every file is skipped.
+func (c *copier) conflict(from, to string) (bool, error) {
+	if c.onConflict == nil || c.archive != nil || c.metadataOnly {
+		return false, nil
+	}
+	if c.aborted.Load() {
+		return true, nil
+	}
+	if _, err := c.dst.Stat(to); err != nil {
+		return false, nil
+	}
+	c.conflicts.Lock()
+	defer c.conflicts.Unlock()
+	if c.aborted.Load() {
+		return true, nil
+	}
+	switch c.onConflict(from, to) {
+	case ConflictSkip:
+		return true, nil
+	case ConflictAbort:
+		c.aborted.Store(true)
+		return false, ErrAborted{To: to}
+	}
+	return false, nil
+}
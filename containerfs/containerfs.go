@@ -0,0 +1,286 @@
+// Package containerfs copies to and from running containers, as docker cp
+// does, and out of OCI images.
+//
+// A container is reached through the Docker Engine API and presented as an
+// afero.Fs:
+//
+//	copier := cp.Copier{SrcFs: containerfs.New("web")}
+//	err := copier.Copy("/var/log/nginx", "logs")
+//
+// An image is read from an OCI image layout directory, such as one written
+// by "docker save" and unpacked, or "skopeo copy ... oci:dir", and presented
+// as an fs.FS of its layers laid one over another:
+//
+//	image, err := containerfs.OpenImage("image")
+//	defer image.Close()
+//	err = copier.CopyFS(image, "rootfs")
+package containerfs
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/jackmordaunt/cp/objectfs"
+)
+
+// Store is an objectfs.Store backed by the filesystem of a container, by
+// way of the archive endpoints of the Docker Engine API. Keys are paths
+// from the container's root.
+//
+// Listing a directory reads the archive of everything beneath it, which is
+// kept to answer the listings of its subdirectories until something is
+// written. Files can't be removed through the API, so Delete is not
+// supported.
+type Store struct {
+	client *http.Client
+	base   string
+	id     string
+
+	mu     sync.Mutex
+	listed map[string][]objectfs.Object
+}
+
+var _ objectfs.Store = &Store{}
+
+// New presents the container with the given ID or name as a filesystem.
+func New(id string) *objectfs.Fs {
+	return objectfs.New(NewStore(id))
+}
+
+// NewStore creates a Store for the container, reaching the daemon at
+// DOCKER_HOST, or its local socket by default.
+func NewStore(id string) *Store {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = "unix:///var/run/docker.sock"
+	}
+	client, base := &http.Client{}, "http://docker"
+	if socket, ok := strings.CutPrefix(host, "unix://"); ok {
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		}
+	} else {
+		base = "http://" + strings.TrimPrefix(host, "tcp://")
+	}
+	return NewStoreWithClient(client, base, id)
+}
+
+// NewStoreWithClient creates a Store for the container making its requests
+// with client to the daemon at base, such as "http://localhost:2375".
+func NewStoreWithClient(client *http.Client, base, id string) *Store {
+	return &Store{client: client, base: strings.TrimSuffix(base, "/"), id: id}
+}
+
+// pathStat is the description of a path the daemon gives in the
+// X-Docker-Container-Path-Stat header.
+type pathStat struct {
+	Name  string      `json:"name"`
+	Size  int64       `json:"size"`
+	Mode  os.FileMode `json:"mode"`
+	Mtime time.Time   `json:"mtime"`
+}
+
+// Head describes the file or directory at key.
+func (s *Store) Head(ctx context.Context, key string) (objectfs.Object, error) {
+	resp, err := s.archive(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return objectfs.Object{}, err
+	}
+	resp.Body.Close()
+	var stat pathStat
+	b, err := base64.StdEncoding.DecodeString(resp.Header.Get("X-Docker-Container-Path-Stat"))
+	if err == nil {
+		err = json.Unmarshal(b, &stat)
+	}
+	if err != nil {
+		return objectfs.Object{}, errors.Wrapf(err, "reading the stat of %s", key)
+	}
+	return objectfs.Object{Key: key, Size: stat.Size, ModTime: stat.Mtime, Dir: stat.Mode.IsDir()}, nil
+}
+
+// List describes the files and directories directly under prefix.
+func (s *Store) List(ctx context.Context, prefix string) ([]objectfs.Object, error) {
+	dir := strings.TrimSuffix(prefix, "/")
+	s.mu.Lock()
+	objects, ok := s.listed[dir]
+	s.mu.Unlock()
+	if ok {
+		return objects, nil
+	}
+	resp, err := s.archive(ctx, http.MethodGet, dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// Entries are named from the base of the directory archived, and the
+	// listings of every directory beneath it are gathered on the way, with
+	// any the archive leaves implied.
+	listed := map[string][]objectfs.Object{dir: nil}
+	var add func(obj objectfs.Object)
+	add = func(obj objectfs.Object) {
+		if obj.Dir {
+			if _, ok := listed[obj.Key]; ok {
+				return
+			}
+			listed[obj.Key] = nil
+		}
+		parent := path.Dir(obj.Key)
+		if parent == "." {
+			parent = ""
+		}
+		if _, ok := listed[parent]; !ok {
+			add(objectfs.Object{Key: parent, Dir: true})
+		}
+		listed[parent] = append(listed[parent], obj)
+	}
+	tr := tar.NewReader(resp.Body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing %s", dir)
+		}
+		_, rel, _ := strings.Cut(strings.Trim(hdr.Name, "/"), "/")
+		if rel == "" {
+			continue
+		}
+		obj := objectfs.Object{Key: path.Join(dir, rel), ModTime: hdr.ModTime, Dir: hdr.Typeflag == tar.TypeDir}
+		if !obj.Dir {
+			obj.Size = hdr.Size
+		}
+		add(obj)
+	}
+	s.mu.Lock()
+	if s.listed == nil {
+		s.listed = map[string][]objectfs.Object{}
+	}
+	for dir, objects := range listed {
+		s.listed[dir] = objects
+	}
+	s.mu.Unlock()
+	return listed[dir], nil
+}
+
+// Get reads the file at key from offset.
+func (s *Store) Get(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	resp, err := s.archive(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(resp.Body)
+	hdr, err := tr.Next()
+	if err == nil && hdr.Typeflag != tar.TypeReg {
+		err = errors.Errorf("%s is not a regular file", key)
+	}
+	if err == nil {
+		_, err = io.CopyN(io.Discard, tr, offset)
+	}
+	if err != nil {
+		resp.Body.Close()
+		return nil, errors.Wrapf(err, "reading %s", key)
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{tr, resp.Body}, nil
+}
+
+// Put writes the file at key. Archives give the size of each entry before
+// its contents, so r is spooled to a temporary file first; the daemon then
+// extracts it, creating any directories missing above it.
+func (s *Store) Put(ctx context.Context, key string, r io.Reader) error {
+	spool, err := os.CreateTemp("", "containerfs")
+	if err != nil {
+		return errors.Wrap(err, "spooling upload")
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+	size, err := io.Copy(spool, r)
+	if err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "spooling upload")
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     key,
+			Size:     size,
+			Mode:     0644,
+			ModTime:  time.Now(),
+		})
+		if err == nil {
+			_, err = io.Copy(tw, spool)
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	resp, err := s.archive(ctx, http.MethodPut, "", pr)
+	pr.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	s.mu.Lock()
+	s.listed = nil
+	s.mu.Unlock()
+	return nil
+}
+
+// Delete is not supported.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	return objectfs.ErrNotSupported
+}
+
+// archive makes a request of the archive endpoint for the path at key,
+// mapping a missing container or path onto os.ErrNotExist.
+func (s *Store) archive(ctx context.Context, method, key string, body io.Reader) (*http.Response, error) {
+	u := fmt.Sprintf("%s/containers/%s/archive?path=%s", s.base, url.PathEscape(s.id), url.QueryEscape("/"+key))
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-tar")
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reaching container %s", s.id)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, os.ErrNotExist
+	case resp.StatusCode >= 300:
+		defer resp.Body.Close()
+		var msg struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&msg)
+		return nil, errors.Errorf("container %s: %s: %s", s.id, resp.Status, msg.Message)
+	}
+	return resp, nil
+}
@@ -0,0 +1,247 @@
+package containerfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/jackmordaunt/cp"
+	"github.com/jackmordaunt/cp/objectfs"
+)
+
+// layout writes an OCI image layout of the layers, each a list of entries
+// to archive, gzipping the first.
+func layout(t *testing.T, layers ...[]tar.Header) string {
+	t.Helper()
+	dir := t.TempDir()
+	put := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		digest := "sha256:" + hex.EncodeToString(sum[:])
+		if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755); err != nil {
+			t.Fatalf("unexpected error creating blobs: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "blobs", "sha256", hex.EncodeToString(sum[:])), data, 0644); err != nil {
+			t.Fatalf("unexpected error writing blob: %v", err)
+		}
+		return digest
+	}
+	var descriptors []map[string]string
+	for ii, entries := range layers {
+		buf := &bytes.Buffer{}
+		tw := tar.NewWriter(buf)
+		for _, hdr := range entries {
+			content := []byte(hdr.Linkname)
+			if hdr.Typeflag == tar.TypeReg {
+				hdr.Size, hdr.Linkname = int64(len(content)), ""
+			} else {
+				content = nil
+			}
+			hdr.Mode = 0644
+			if err := tw.WriteHeader(&hdr); err != nil {
+				t.Fatalf("unexpected error writing layer: %v", err)
+			}
+			tw.Write(content)
+		}
+		tw.Close()
+		mediaType := "application/vnd.oci.image.layer.v1.tar"
+		data := buf.Bytes()
+		if ii == 0 {
+			gz := &bytes.Buffer{}
+			w := gzip.NewWriter(gz)
+			w.Write(data)
+			w.Close()
+			data, mediaType = gz.Bytes(), mediaType+"+gzip"
+		}
+		descriptors = append(descriptors, map[string]string{"mediaType": mediaType, "digest": put(data)})
+	}
+	manifest, _ := json.Marshal(map[string]any{"layers": descriptors})
+	index, _ := json.Marshal(map[string]any{"manifests": []map[string]string{{"digest": put(manifest)}}})
+	if err := os.WriteFile(filepath.Join(dir, "index.json"), index, 0644); err != nil {
+		t.Fatalf("unexpected error writing index: %v", err)
+	}
+	return dir
+}
+
+// TestImage tests that the layers of an image are laid over one another,
+// honouring whiteouts and links, when the image is copied out.
+func TestImage(t *testing.T) {
+	// Regular files hold their Linkname, as a shorthand.
+	dir := layout(t,
+		[]tar.Header{
+			{Typeflag: tar.TypeDir, Name: "etc/"},
+			{Typeflag: tar.TypeReg, Name: "etc/hostname", Linkname: "base"},
+			{Typeflag: tar.TypeReg, Name: "etc/removed", Linkname: "gone"},
+			{Typeflag: tar.TypeReg, Name: "var/cache/old", Linkname: "gone"},
+			{Typeflag: tar.TypeReg, Name: "usr/lib/libc.so", Linkname: "libc"},
+		},
+		[]tar.Header{
+			{Typeflag: tar.TypeReg, Name: "etc/hostname", Linkname: "top"},
+			{Typeflag: tar.TypeReg, Name: "etc/.wh.removed"},
+			{Typeflag: tar.TypeReg, Name: "var/cache/.wh..wh..opq"},
+			{Typeflag: tar.TypeReg, Name: "var/cache/new", Linkname: "new"},
+			{Typeflag: tar.TypeSymlink, Name: "lib", Linkname: "usr/lib"},
+			{Typeflag: tar.TypeLink, Name: "etc/name", Linkname: "etc/hostname"},
+		},
+	)
+	image, err := OpenImage(dir)
+	if err != nil {
+		t.Fatalf("unexpected error opening image: %v", err)
+	}
+	defer image.Close()
+	fs := afero.NewMemMapFs()
+	copier := cp.Copier{Fs: fs}
+	if err := copier.CopyFS(image, "rootfs"); err != nil {
+		t.Fatalf("unexpected error copying image: %v", err)
+	}
+	want := map[string]string{
+		"etc/hostname":    "top",
+		"etc/name":        "top",
+		"var/cache/new":   "new",
+		"usr/lib/libc.so": "libc",
+		"lib/libc.so":     "libc",
+	}
+	for path, content := range want {
+		got, err := afero.ReadFile(fs, filepath.Join("rootfs", path))
+		if err != nil {
+			t.Errorf("unexpected error reading %s: %v", path, err)
+			continue
+		}
+		if string(got) != content {
+			t.Errorf("%s: want %q, got %q", path, content, got)
+		}
+	}
+	for _, path := range []string{"etc/removed", "var/cache/old", "etc/.wh.removed"} {
+		if _, err := fs.Stat(filepath.Join("rootfs", path)); !os.IsNotExist(err) {
+			t.Errorf("want %s whited out, got %v", path, err)
+		}
+	}
+}
+
+// daemon serves the archive endpoints of the Docker Engine API for one
+// container, whose files are held in memory.
+func daemon(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	files := map[string]string{}
+	isDir := func(dir string) bool {
+		for name := range files {
+			if strings.HasPrefix(name, dir+"/") || dir == "" {
+				return true
+			}
+		}
+		return false
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path != "/containers/app/archive" {
+			http.NotFound(w, r)
+			return
+		}
+		name := strings.Trim(r.URL.Query().Get("path"), "/")
+		content, isFile := files[name]
+		switch r.Method {
+		case http.MethodPut:
+			tr := tar.NewReader(r.Body)
+			for {
+				hdr, err := tr.Next()
+				if err != nil {
+					break
+				}
+				data, _ := io.ReadAll(tr)
+				files[path.Join(name, hdr.Name)] = string(data)
+			}
+			return
+		case http.MethodHead, http.MethodGet:
+			if !isFile && !isDir(name) {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		stat := pathStat{Name: path.Base(name), Size: int64(len(content)), Mode: 0644, Mtime: time.Now()}
+		if !isFile {
+			stat.Mode = os.ModeDir | 0755
+		}
+		b, _ := json.Marshal(stat)
+		w.Header().Set("X-Docker-Container-Path-Stat", base64.StdEncoding.EncodeToString(b))
+		if r.Method == http.MethodHead {
+			return
+		}
+		tw := tar.NewWriter(w)
+		defer tw.Close()
+		if isFile {
+			tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: path.Base(name), Size: int64(len(content)), Mode: 0644})
+			io.WriteString(tw, content)
+			return
+		}
+		var names []string
+		for file := range files {
+			if strings.HasPrefix(file, name+"/") || name == "" {
+				names = append(names, file)
+			}
+		}
+		sort.Strings(names)
+		base := path.Base("/" + name)
+		tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: base + "/", Mode: 0755})
+		for _, file := range names {
+			rel := strings.TrimPrefix(file, name+"/")
+			tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: base + "/" + rel, Size: int64(len(files[file])), Mode: 0644})
+			io.WriteString(tw, files[file])
+		}
+	}))
+}
+
+// TestStore_RoundTrip tests that a tree copied into a container and back
+// out is unchanged.
+func TestStore_RoundTrip(t *testing.T) {
+	srv := daemon(t)
+	defer srv.Close()
+	local := afero.NewMemMapFs()
+	files := map[string]string{
+		"/from/foo.txt":         "foo",
+		"/from/dir/bar.txt":     "bar",
+		"/from/dir/sub/baz.txt": "baz",
+	}
+	for path, data := range files {
+		if err := afero.WriteFile(local, path, []byte(data), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	container := objectfs.New(NewStoreWithClient(srv.Client(), srv.URL, "app"))
+	up := cp.Copier{SrcFs: local, DstFs: container}
+	if err := up.Copy("/from", "/srv"); err != nil {
+		t.Fatalf("unexpected error while uploading: %v", err)
+	}
+	down := cp.Copier{SrcFs: container, DstFs: local}
+	if err := down.Copy("/srv", "/to"); err != nil {
+		t.Fatalf("unexpected error while downloading: %v", err)
+	}
+	for path, want := range files {
+		got, err := afero.ReadFile(local, strings.Replace(path, "/from", "/to", 1))
+		if err != nil {
+			t.Fatalf("unexpected error while reading %s: %v", path, err)
+		}
+		if string(got) != want {
+			t.Errorf("want %s to contain %q, got %q", path, want, got)
+		}
+	}
+	if _, err := container.Stat("/missing"); !os.IsNotExist(err) {
+		t.Errorf("want a missing path not to exist, got %v", err)
+	}
+}
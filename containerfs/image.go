@@ -0,0 +1,337 @@
+package containerfs
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// maxLinks is the most symlinks followed in resolving a path.
+const maxLinks = 40
+
+// Image is the filesystem of an OCI image, its layers laid one over another
+// with the files each whites out removed. Layers are unpacked to temporary
+// files as it is opened, so that files can be read straight from them.
+type Image struct {
+	layers []*os.File
+	files  map[string]*entry
+	// children are the names of the entries in each directory, sorted.
+	children map[string][]string
+}
+
+var _ fs.FS = &Image{}
+
+// entry is a file of the image, found at offset in one of the layers.
+type entry struct {
+	hdr    *tar.Header
+	layer  int
+	offset int64
+}
+
+// descriptor refers to a blob of an image layout.
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Platform  *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+	} `json:"platform"`
+}
+
+// OpenImage opens the image of the OCI image layout in dir, taking the one
+// for this platform if it holds several.
+func OpenImage(dir string) (*Image, error) {
+	var index struct {
+		Manifests []descriptor `json:"manifests"`
+	}
+	if err := readJSON(filepath.Join(dir, "index.json"), &index); err != nil {
+		return nil, err
+	}
+	manifests := index.Manifests
+	for {
+		d, err := pick(manifests)
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening image %s", dir)
+		}
+		var m struct {
+			Manifests []descriptor `json:"manifests"`
+			Layers    []descriptor `json:"layers"`
+		}
+		if err := readJSON(blob(dir, d.Digest), &m); err != nil {
+			return nil, err
+		}
+		if len(m.Manifests) > 0 {
+			manifests = m.Manifests
+			continue
+		}
+		img := &Image{files: map[string]*entry{}}
+		for _, layer := range m.Layers {
+			if err := img.unpack(blob(dir, layer.Digest), layer.MediaType); err != nil {
+				img.Close()
+				return nil, err
+			}
+		}
+		img.index()
+		return img, nil
+	}
+}
+
+// pick chooses the manifest for this platform, or the only one.
+func pick(manifests []descriptor) (descriptor, error) {
+	for _, d := range manifests {
+		if p := d.Platform; p != nil && p.OS == runtime.GOOS && p.Architecture == runtime.GOARCH {
+			return d, nil
+		}
+	}
+	if len(manifests) == 0 {
+		return descriptor{}, errors.New("no manifest")
+	}
+	return manifests[0], nil
+}
+
+func blob(dir, digest string) string {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	return filepath.Join(dir, "blobs", algorithm, hex)
+}
+
+func readJSON(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "reading image")
+	}
+	return errors.Wrapf(json.Unmarshal(b, v), "parsing %s", path)
+}
+
+// unpack adds the layer in the file blob to the image, writing it out
+// uncompressed and noting where each of its files starts.
+func (img *Image) unpack(blob, mediaType string) error {
+	f, err := os.Open(blob)
+	if err != nil {
+		return errors.Wrap(err, "opening layer")
+	}
+	defer f.Close()
+	var r io.Reader = f
+	switch {
+	case strings.HasSuffix(mediaType, "+gzip") || strings.HasSuffix(mediaType, ".gzip"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return errors.Wrapf(err, "decompressing %s", blob)
+		}
+		r = gz
+	case strings.HasSuffix(mediaType, "+zstd"):
+		return errors.Errorf("layer %s: zstd is not supported", blob)
+	}
+	tmp, err := os.CreateTemp("", "containerfs-layer")
+	if err != nil {
+		return errors.Wrap(err, "unpacking layer")
+	}
+	os.Remove(tmp.Name())
+	layer := len(img.layers)
+	img.layers = append(img.layers, tmp)
+	counted := &counter{r: io.TeeReader(r, tmp)}
+	tr := tar.NewReader(counted)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "reading layer %s", blob)
+		}
+		name := clean(hdr.Name)
+		dir, base := path.Dir(name), path.Base(name)
+		switch {
+		case base == ".wh..wh..opq":
+			img.remove(dir, false, layer)
+		case strings.HasPrefix(base, ".wh."):
+			img.remove(path.Join(dir, strings.TrimPrefix(base, ".wh.")), true, layer)
+		default:
+			img.files[name] = &entry{hdr: hdr, layer: layer, offset: counted.n}
+		}
+	}
+	// Read to the end so that the last file is written out in full.
+	_, err = io.Copy(io.Discard, counted)
+	return errors.Wrapf(err, "reading layer %s", blob)
+}
+
+// remove drops what the layers below layer hold beneath name, and name
+// itself if self is set.
+func (img *Image) remove(name string, self bool, layer int) {
+	prefix := name + "/"
+	if name == "." {
+		prefix = ""
+	}
+	for file, e := range img.files {
+		below := e.layer < layer
+		if below && (strings.HasPrefix(file, prefix) && file != name || self && file == name) {
+			delete(img.files, file)
+		}
+	}
+}
+
+// index fills in the directories layers leave implied and lists the
+// contents of each.
+func (img *Image) index() {
+	img.children = map[string][]string{}
+	for name := range img.files {
+		for dir := path.Dir(name); name != "."; name, dir = dir, path.Dir(dir) {
+			if _, ok := img.files[dir]; !ok {
+				img.files[dir] = &entry{hdr: &tar.Header{Typeflag: tar.TypeDir, Name: dir, Mode: 0755}}
+			}
+		}
+	}
+	if _, ok := img.files["."]; !ok {
+		img.files["."] = &entry{hdr: &tar.Header{Typeflag: tar.TypeDir, Name: ".", Mode: 0755}}
+	}
+	for name := range img.files {
+		if name != "." {
+			dir := path.Dir(name)
+			img.children[dir] = append(img.children[dir], path.Base(name))
+		}
+	}
+	for _, names := range img.children {
+		sort.Strings(names)
+	}
+}
+
+func clean(name string) string {
+	return path.Clean(strings.TrimPrefix(path.Clean("/"+name), "/"))
+}
+
+// Close removes the unpacked layers.
+func (img *Image) Close() error {
+	for _, f := range img.layers {
+		f.Close()
+	}
+	return nil
+}
+
+// Open opens the file at name, following symlinks within the image.
+func (img *Image) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	e, resolved, err := img.resolve(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	f := &imageFile{img: img, name: resolved, info: info{e.hdr.FileInfo(), path.Base(name)}}
+	switch e.hdr.Typeflag {
+	case tar.TypeDir:
+	case tar.TypeReg, tar.TypeRegA:
+		f.r = io.NewSectionReader(img.layers[e.layer], e.offset, e.hdr.Size)
+	default:
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("not a regular file or directory")}
+	}
+	return f, nil
+}
+
+// resolve finds the entry at name, following symlinks and hard links.
+func (img *Image) resolve(name string) (*entry, string, error) {
+	for hops := 0; hops < maxLinks; hops++ {
+		e, ok := img.files[name]
+		if !ok {
+			if name, ok = img.through(name); !ok {
+				return nil, "", fs.ErrNotExist
+			}
+			continue
+		}
+		switch e.hdr.Typeflag {
+		case tar.TypeSymlink:
+			name = link(name, e.hdr.Linkname)
+		case tar.TypeLink:
+			name = clean(e.hdr.Linkname)
+		default:
+			return e, name, nil
+		}
+	}
+	return nil, "", errors.New("too many links")
+}
+
+// through is where name leads if a directory above it is a symlink.
+func (img *Image) through(name string) (string, bool) {
+	for dir, rest := path.Dir(name), path.Base(name); dir != "."; dir, rest = path.Dir(dir), path.Join(path.Base(dir), rest) {
+		if e, ok := img.files[dir]; ok && e.hdr.Typeflag == tar.TypeSymlink {
+			return path.Join(link(dir, e.hdr.Linkname), rest), true
+		}
+	}
+	return "", false
+}
+
+// link is where the symlink at name pointing at target leads.
+func link(name, target string) string {
+	if path.IsAbs(target) {
+		return clean(target)
+	}
+	return clean(path.Join(path.Dir(name), target))
+}
+
+// imageFile is an open file or directory of an Image.
+type imageFile struct {
+	img  *Image
+	name string
+	info info
+	r    *io.SectionReader
+	read int
+}
+
+func (f *imageFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *imageFile) Close() error               { return nil }
+
+func (f *imageFile) Read(p []byte) (int, error) {
+	if f.r == nil {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: errors.New("is a directory")}
+	}
+	return f.r.Read(p)
+}
+
+// ReadDir lists the directory, following symlinks in it.
+func (f *imageFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	names := f.img.children[f.name][f.read:]
+	if n > 0 && len(names) > n {
+		names = names[:n]
+	}
+	var entries []fs.DirEntry
+	for _, child := range names {
+		f.read++
+		e, _, err := f.img.resolve(path.Join(f.name, child))
+		if err != nil {
+			continue
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info{e.hdr.FileInfo(), child}))
+	}
+	if n > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	return entries, nil
+}
+
+// info is the description of a file under the name it was reached by.
+type info struct {
+	fs.FileInfo
+	name string
+}
+
+func (i info) Name() string { return i.name }
+
+// counter counts the bytes read through it.
+type counter struct {
+	r io.Reader
+	n int64
+}
+
+func (c *counter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
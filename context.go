@@ -0,0 +1,43 @@
+package cp
+
+import (
+	"context"
+	"io"
+
+	"github.com/spf13/afero"
+)
+
+// cancellable makes reads from r fail once the copy is cancelled, so that
+// a large file stops part way rather than running to the end.
+func (c *copier) cancellable(r io.Reader) io.Reader {
+	if c.ctx.Done() == nil {
+		return r
+	}
+	return contextReader{ctx: c.ctx, r: r}
+}
+
+// contextReader reads from r until ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// discard removes the partly written file at to if the copy was cancelled
+// while creating it. Files being overwritten are left for Rollback to
+// restore, since what they held is already gone.
+func (c *copier) discard(f afero.File, to string, overwrote bool) {
+	if overwrote || c.ctx.Err() == nil {
+		return
+	}
+	if f != nil {
+		f.Close()
+	}
+	c.dst.Remove(to)
+}
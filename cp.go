@@ -1,176 +1,1696 @@
 package cp
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/afero"
 )
 
 // Copier copies files concurrently.
-// Safe to use as-is with sane defaults.
+// Safe to use as-is with sane defaults, and to reuse for any number of
+// copies, one after another or at once. Each call starts afresh, sharing
+// only Stats, the MaxTotalBytes quota and the limits on rate and open files
+// with the others, unless SkipCopied is set.
 type Copier struct {
 	// Fs is the filesystem object to operate on. Defaults to `afero.OsFs`.
 	Fs afero.Fs
+	// SrcFs, when set, is the filesystem copied from instead of Fs.
+	SrcFs afero.Fs
+	// DstFs, when set, is the filesystem copied to instead of Fs.
+	DstFs afero.Fs
 	// Clobber is whether or not to copy into a directory that already
 	// exists, potentially clobbering any files.
 	Clobber bool
+	// IncludeRoot is whether a copied directory is placed inside the
+	// destination, rather than its contents merged into it.
+	// With IncludeRoot, Copy("photos", "backup") produces "backup/photos/..."
+	// instead of "backup/...".
+	IncludeRoot bool
+	// Parents makes CopyAll place each source at its whole path beneath the
+	// destination rather than by name, so "a/b/c.txt" is copied to
+	// "dest/a/b/c.txt", as with cp --parents.
+	Parents bool
 	// Parallel is the number of parallel workers to use.
 	// Higher means better throughput. You will need to respect your OS's
-	// open file descriptor maximum.
+	// open file descriptor maximum. Zero picks a number to suit the
+	// storage at either end, judged by GOMAXPROCS and whether it looks to
+	// be an SSD, a spinning disk or on the network, and tunes it while
+	// copying by the throughput each change brings.
 	Parallel int
+	// MinParallel, when set below Parallel, is the number of workers kept
+	// running; more are added, up to Parallel, while files wait in the
+	// queue, and leave once it empties.
+	MinParallel int
+	// Batch, when more than one, has each worker take up to that many
+	// small files of 64 KiB or less from the queue at once, reading all of
+	// them before writing any, which cuts the overhead of each file that
+	// dominates trees of tiny ones. It doesn't apply when deduplicating,
+	// preserving links or cloning.
+	Batch int
+	// Pipeline, when two or more, reads each file larger than 256 KiB in a
+	// goroutine of its own, a ring of that many buffers ahead of writing
+	// it, so that reads from the source overlap writes to the destination.
+	// On network filesystems, where each waits on a round trip, this can
+	// come close to doubling the speed of a single file.
+	Pipeline int
+	// BufferSize is the size in bytes of the buffer each file is read and
+	// written through, 32 KiB by default. Larger buffers make for fewer
+	// calls, which pays off where each one is slow, as on network
+	// filesystems.
+	BufferSize int
+	// Walkers is the number of directories read at once while walking a
+	// tree, which helps where listing millions of small files is slower
+	// than copying them. Zero or one walks a directory at a time, in order;
+	// with more, files are queued in no particular order, so with Flatten
+	// which of two files of the same name comes first varies.
+	Walkers int
+	// Order is the order files found are copied in, largest first by
+	// default.
+	Order Order
+	// QueueSize caps the number of files found but not yet being copied,
+	// zero meaning no limit, so that walking a huge tree doesn't hold all
+	// of it in memory. Orders other than OrderFIFO only choose among the
+	// files queued, so a small queue weakens them.
+	QueueSize int
+	// ChunkThreshold is the size at which a file is split into ranges that
+	// are copied concurrently, which suits storage that sustains several
+	// streams at once. The ranges are checked once copied by hashing the
+	// file and its source with Hasher. Zero disables chunking.
+	ChunkThreshold int64
+	// Chunks is the number of ranges a file over ChunkThreshold is split
+	// into, defaulting to Parallel.
+	Chunks int
+	// Preallocate reserves the space for each file on disk before writing
+	// it, where the filesystem supports it, which reduces fragmentation
+	// and fails a file that won't fit before any of it is written rather
+	// than part way through.
+	Preallocate bool
+	// NiceIO lowers the IO priority of the workers, to the idle class on
+	// Linux and to background on macOS and Windows, so that a big copy
+	// gives way to other work on the same disks. Elsewhere it does nothing.
+	NiceIO bool
+	// Reflink is whether files are cloned rather than copied, never by
+	// default. Cloned files aren't chunked or memory mapped, and files
+	// being transformed are never cloned.
+	Reflink Reflink
+	// Sparse is whether runs of zeros are left as holes in the files
+	// written, never by default. Files split into chunks are written in
+	// full.
+	Sparse Sparse
+	// MetadataOnly leaves file contents alone, instead giving each file and
+	// directory already at the destination the mode, modification time and
+	// owner of its source, along with anything else set to be preserved;
+	// such as to finish off a copy made by a tool that dropped them. Files
+	// updated are reported as copied and those missing as skipped. The
+	// changes are not rolled back.
+	MetadataOnly bool
+	// Strategy is how file contents are copied, buffered by default.
+	Strategy Strategy
+	// MmapThreshold is the size from which StrategyMmap maps files,
+	// defaulting to 64MiB.
+	MmapThreshold int64
+	// MaxOpenFiles caps the number of files held open at once across all
+	// workers, independently of Parallel. Zero detects the limit from the OS
+	// where possible, leaving some headroom; negative means no limit.
+	MaxOpenFiles int
+	// MaxBytesPerSecond caps the combined rate at which all workers read,
+	// zero meaning no limit. With a limit, more workers do not mean more
+	// throughput; they share the same budget.
+	MaxBytesPerSecond int64
+	// MaxTotalBytes caps the bytes copied across all copies, zero meaning
+	// no limit. Once a file would exceed it, that file and every one after
+	// it fail with ErrQuotaExceeded instead of being queued.
+	MaxTotalBytes int64
+	// Progress, when set, is called each time a file has been dealt with.
+	// Calls are never made concurrently.
+	Progress func(Progress)
+	// Expected, when set, such as from Measure, is the size of what is to
+	// be copied, which the ETA in Stats is estimated against until the walk
+	// has found more.
+	Expected Totals
+	// PreserveAttributes copies file attributes that have no equivalent in
+	// the file mode: on Windows, the read-only, hidden and system
+	// attributes; on macOS, the Finder information and quarantine; on
+	// macOS and the BSDs, the file flags owners may set, such as hidden,
+	// nodump and uchg, the Finder's lock. Only copies between OS
+	// filesystems carry them.
+	PreserveAttributes bool
+	// PreserveStreams copies the secondary streams of files and
+	// directories: on NTFS, alternate data streams such as the
+	// Zone.Identifier recording where a download came from; on macOS, the
+	// resource fork. Only copies between OS filesystems carry them.
+	PreserveStreams bool
+	// PreserveSELinux copies the SELinux security context of files and
+	// directories on Linux. Without it, files created by the copy are
+	// labelled by the policy for where they land, as with any new file, and
+	// files overwritten keep their label. Only copies between OS
+	// filesystems carry it.
+	PreserveSELinux bool
+	// PreserveMode, PreserveTimes and PreserveOwner give each file and
+	// directory copied the exact permissions, modification time and owner
+	// of its source; otherwise files are created with the source's
+	// permissions less the umask, at the time they are copied, owned by
+	// whoever copies them. Without the privilege to give files away, as is
+	// usual without root, files keep their owner and only the group is
+	// set, where the user is in it.
+	PreserveMode, PreserveTimes, PreserveOwner bool
+	// PreserveXattrs copies the extended attributes of files and
+	// directories on Linux, macOS, FreeBSD and NetBSD, apart from those in
+	// the security namespace, which PreserveSELinux covers. Only copies
+	// between OS filesystems carry them.
+	PreserveXattrs bool
+	// CopySymlinks recreates the symlinks found in a tree as symlinks to
+	// the same target, rather than copying what they point to. With
+	// FollowSymlinks, symlinked directories are still descended into.
+	CopySymlinks bool
+	// PreserveLinks keeps files that are hard linked together in the
+	// source hard linked together in the copy, rather than copying each.
+	// Only copies between OS filesystems keep them.
+	PreserveLinks bool
+	// LinkRoots, with CopySymlinks, copies a source that is itself a
+	// symlink as a symlink too, as cp -P does. Otherwise sources are
+	// always followed.
+	LinkRoots bool
+	// Journal, when set, is the path of a file on the OS filesystem that
+	// records the progress of each copy, from which Resume can continue
+	// one interrupted by a crash. Each file copied is synced to disk before
+	// it is recorded as done.
+	Journal string
+	// StateFile, when set, is the path of a file on the OS filesystem that
+	// a State is written to as JSON every StateInterval, one second by
+	// default, while copying and once more at the end, so that a monitor
+	// can follow a copy made by a process with no other way to report.
+	StateFile     string
+	StateInterval time.Duration
+	// Dedupe hard links each file whose content matches a file already
+	// copied in the same run to that copy, rather than writing it again,
+	// which saves space in trees with a lot of duplication. Linked files
+	// share their permissions and times. Only applies when copying to the
+	// OS filesystem; each file is read twice to hash it first.
+	Dedupe bool
+	// Transform, when set, is applied to each file's contents as it is
+	// copied, given the path being copied from; it may template config
+	// files, strip byte order marks, convert line endings and the like. A
+	// returned reader that is also an io.Closer is closed once read.
+	// Files are neither chunked nor deduplicated while transforming, and
+	// archives are written untransformed.
+	Transform func(path string, r io.Reader) (io.Reader, error)
+	// Rename, when set, maps the path of each file and directory, relative
+	// to the root being copied, to its path relative to the destination;
+	// it may strip prefixes, change extensions or restructure the tree.
+	// Returning "" leaves the file, or the whole directory, out. Paths
+	// that would escape the destination fail. Single files copied by name
+	// are not renamed.
+	Rename func(relPath string) string
+	// Flatten copies every file into the top of the destination, leaving
+	// behind the directories it was in, such as to collect "**/*.log"
+	// into one place. Collisions decides what happens when two files have
+	// the same name.
+	Flatten bool
+	// Collisions is what to do when flattening gives two files the same
+	// name, failing all but the first by default.
+	Collisions Collision
+	// SpecialFiles is what to do with named pipes, sockets and device
+	// nodes, which are skipped by default.
+	SpecialFiles SpecialFiles
+	// FollowSymlinks descends into symlinked directories, copying what
+	// they link to. A link back to a directory that contains it fails with
+	// ErrSymlinkCycle instead of being followed.
+	FollowSymlinks bool
+	// MaxDepth limits how many levels below the source directory are
+	// copied, zero meaning no limit. With 1, only the files directly inside
+	// it are copied, along with its subdirectories but not their contents.
+	MaxDepth int
+	// MinSize and MaxSize leave out files smaller or larger than them, in
+	// bytes, zero meaning no limit. MinSize 1 leaves out empty files.
+	MinSize, MaxSize int64
+	// ModifiedAfter and ModifiedBefore, when set, leave out files not
+	// modified in between them, so an incremental copy can take just the
+	// files changed since the last. Files left out are never overwritten.
+	ModifiedAfter, ModifiedBefore time.Time
+	// IncludeRegexp, when set, limits the files copied from a directory to
+	// those whose slash separated path relative to it matches one of the
+	// expressions, and ExcludeRegexp leaves out those that match one. An
+	// excluded directory is not descended into, nor is one that nothing
+	// beneath could match an inclusion anchored with ^.
+	IncludeRegexp, ExcludeRegexp []*regexp.Regexp
+	// SkipHidden leaves out hidden files and directories, those whose
+	// names start with a dot or, on Windows, with the hidden attribute.
+	// Hidden directories are not descended into.
+	SkipHidden bool
+	// IgnoreFile, when set, is the name of a file such as ".cpignore" read
+	// from the root of each directory copied, listing patterns of what to
+	// leave out in the syntax of .gitignore. With NestedIgnoreFiles, it is
+	// read from every directory beneath too, its patterns applying to what
+	// that directory contains.
+	IgnoreFile        string
+	NestedIgnoreFiles bool
+	// OnConflict, when set, is asked what to do with each file whose
+	// destination already exists, in place of the Clobber check. It is
+	// called one file at a time, so it may prompt the user.
+	OnConflict func(from, to string) Conflict
+	// Rollback, when set, undoes a copy that fails: the files and
+	// directories it created are removed and those it overwrote restored,
+	// leaving the destination as it was. Overwritten files are moved aside
+	// until the copy is done so they can be put back.
+	Rollback bool
+	// Retries is the number of times a file that fails with a retryable
+	// error is tried again before it counts as failed, waiting
+	// RetryBackoff (100ms by default) before the first retry and twice as
+	// long before each after. Passing faults of NFS and SMB, such as stale
+	// file handles and dropped connections, are retried at least three
+	// times regardless.
+	Retries      int
+	RetryBackoff time.Duration
+	// IsRetryable decides which errors are retried, defaulting to
+	// interrupted calls, timeouts and other temporary errors.
+	IsRetryable func(error) bool
+	// FileTimeout, when set, fails a file with ErrFileTimeout if it takes
+	// longer than this to copy, so that one stuck file doesn't hold up a
+	// worker, and with it the copy, forever.
+	FileTimeout time.Duration
+	// Logger, when set, receives an event as each walk starts and each file
+	// is queued, copied, skipped or fails, at debug level for the chatty
+	// ones and error level for failures.
+	Logger *slog.Logger
+	// Observers are each told of every stage of every copy, so that a
+	// display, metrics and the like can all follow along.
+	Observers []Observer
+	// Manifest, when set, receives a line in the format of sha256sum for
+	// each file copied by CopyReport and the functions built on it, giving
+	// its hash and destination path. Hashes are of the destination as read
+	// back once written, so they vouch for what arrived.
+	Manifest io.Writer
+	// ManifestFile, when set, is the name of a file written into the
+	// destination directory, or the directory a single file is copied to,
+	// listing each file copied as Manifest does but with paths relative to
+	// it, so that "sha256sum -c" run there checks the copy, given the
+	// default Hasher.
+	ManifestFile string
+	// HashWorkers, when set, hashes the files copied for the manifest on
+	// a pool of that many workers of its own, rather than in the workers
+	// copying them, so that those move straight on to the next file and
+	// keep the disks busy. Durations then leave out the time hashing.
+	HashWorkers int
+	// SkipCopied skips files whose destination an earlier call on the
+	// Copier copied to, as within a single call, so that copies fed
+	// overlapping sources add only what is new. By default each call
+	// copies everything it is given.
+	SkipCopied bool
+	// DeleteExcluded has Mirror remove the files at the destination that
+	// the filters leave out too, rather than leave them be.
+	DeleteExcluded bool
+	// Hasher is the hash algorithm of manifests, VerifyResume and the check
+	// of chunked copies, defaulting to SHA256. Dedupe always uses SHA256.
+	Hasher Hasher
+	// VerifyResume has CopyFileFrom check that what the destination already
+	// holds matches the source, by hash, before carrying on after it.
+	VerifyResume bool
 
-	// seen tracks the file paths already copied to.
+	// seen tracks the file paths copied to across calls, for SkipCopied.
 	seen *sync.Map
+	// stats accumulates progress across copies.
+	stats *counters
+	// limit is shared by all workers to enforce MaxBytesPerSecond.
+	limit *limiter
+	// fds is shared by all workers to enforce MaxOpenFiles.
+	fds *semaphore
+	// quota is shared by all copies to enforce MaxTotalBytes.
+	quota *quota
+	// events is the channel returned by Events, if it has been called.
+	events atomic.Pointer[chan Event]
+	// once guards the initialisation of state shared between copies.
+	once sync.Once
+}
+
+// init prepares the state shared between copies on first use.
+func (c *Copier) init() {
+	c.once.Do(func() {
+		c.seen = &sync.Map{}
+		c.stats = &counters{}
+		if c.MaxBytesPerSecond > 0 {
+			c.limit = newLimiter(c.MaxBytesPerSecond)
+		}
+		c.fds = c.openFiles()
+		if c.MaxTotalBytes > 0 && !c.MetadataOnly {
+			c.quota = &quota{limit: c.MaxTotalBytes}
+		}
+	})
+}
+
+// start prepares for a copy, returning a func to call once it is done.
+func (c *Copier) start() (done func()) {
+	if c.Fs == nil {
+		c.Fs = afero.NewOsFs()
+	}
+	stats := c.counters()
+	stop := func() {}
+	if stats.begin() {
+		stop = c.snapshots()
+	}
+	return func() {
+		stats.done()
+		stop()
+		c.emit(Event{Kind: Done, Stats: c.Stats()})
+	}
+}
+
+// srcFs is the filesystem copied from.
+func (c *Copier) srcFs() afero.Fs {
+	if c.SrcFs != nil {
+		return osPaths(c.SrcFs)
+	}
+	return osPaths(c.Fs)
+}
+
+// dstFs is the filesystem copied to.
+func (c *Copier) dstFs() afero.Fs {
+	if c.DstFs != nil {
+		return osPaths(c.DstFs)
+	}
+	return osPaths(c.Fs)
 }
 
 // Copy executes the copy.
 // Safe for conccurent use.
 func (c *Copier) Copy(from, to string) error {
+	_, err := c.CopyReport(from, to)
+	return err
+}
+
+// CopyReport executes the copy and reports what happened to each file.
+// The report is populated even when an error is returned, describing how far
+// the copy got.
+func (c *Copier) CopyReport(from, to string) (Report, error) {
+	return c.CopyContext(context.Background(), from, to)
+}
+
+// CopyContext is CopyReport, stopping early if ctx is cancelled. The file in
+// progress fails with the context's error, and is removed if the copy had
+// created it; those not yet started are skipped.
+func (c *Copier) CopyContext(ctx context.Context, from, to string) (Report, error) {
+	if c.IncludeRoot {
+		to = filepath.Join(to, filepath.Base(filepath.Clean(from)))
+	}
+	return c.copyReport(ctx, c.session(), from, to)
+}
+
+// session is the record of destinations copied to for a call, shared
+// between calls with SkipCopied.
+func (c *Copier) session() *sync.Map {
+	c.init()
+	if c.SkipCopied {
+		return c.seen
+	}
+	return &sync.Map{}
+}
+
+// copyReport copies from to to, skipping the destinations in seen.
+func (c *Copier) copyReport(ctx context.Context, seen *sync.Map, from, to string) (report Report, err error) {
 	if from == to {
-		return nil
+		return Report{}, nil
 	}
-	if c.Fs == nil {
-		c.Fs = afero.NewOsFs()
+	defer c.start()()
+	var j *journal
+	if c.Journal != "" {
+		if j, err = openJournal(c.Journal); err != nil {
+			return Report{}, err
+		}
+		defer func() {
+			if jerr := j.close(); jerr != nil && err == nil {
+				err = jerr
+			}
+		}()
+		j.record("copy", from, to)
 	}
-	fromFi, err := c.Fs.Stat(from)
+	if c.CopySymlinks && c.LinkRoots {
+		if fi, err := lstat(c.srcFs(), from); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return c.copyLink(ctx, seen, from, to, fi)
+		}
+	}
+	fromFi, err := c.check(from, to)
 	if err != nil {
-		return errors.Wrap(err, "reading file metadata")
+		return Report{}, err
 	}
-	_, err = c.Fs.Stat(to)
-	if !os.IsNotExist(err) && !c.Clobber {
-		return ErrClobberAvoided{to}
+	if err := ctx.Err(); err != nil {
+		return Report{}, err
 	}
+	cp := c.copier()
+	cp.ctx = ctx
+	cp.seen = seen
+	cp.journal = j
+	cp.root = from
 	if !fromFi.IsDir() {
-		return copyFile(c.Fs, from, to)
-	}
-	if err := c.Fs.MkdirAll(to, fromFi.Mode()); err != nil {
-		return err
+		r, ok := cp.special(from, to, fromFi)
+		if !ok {
+			r = result{FileReport: FileReport{From: from, To: to}}
+			if r.Err = cp.quota.reserve(from, fromFi.Size()); r.Err == nil {
+				cp.stats.found(fromFi.Size())
+				j.record("queued", from, from, to)
+				r = cp.copyJob(job{From: from, To: to, Size: fromFi.Size()})
+			}
+			r.Err = cp.settle(r.Err)
+		}
+		report.add(r)
+		c.finished(r)
+		return report, c.manifest(report, filepath.Dir(to), r.Err)
 	}
-	if c.seen == nil {
-		c.seen = &sync.Map{}
+	if c.within(from, fromFi, to) {
+		return Report{}, ErrRecursiveCopy{From: from, To: to}
 	}
-	return c.copy(from, to)
+	report, err = cp.copy(from, to)
+	return report, c.manifest(report, to, err)
 }
 
-func copyFile(fs afero.Fs, from, to string) error {
-	fromFile, err := fs.Open(from)
+// check stats from, refusing to copy it onto itself or over an existing
+// destination without Clobber.
+func (c *Copier) check(from, to string) (os.FileInfo, error) {
+	fromFi, err := c.srcFs().Stat(from)
 	if err != nil {
-		return errors.Wrapf(err, "opening %s", from)
+		return nil, errors.Wrap(err, "reading file metadata")
 	}
-	defer fromFile.Close()
-	fromFi, err := fromFile.Stat()
+	toFi, err := c.dstFs().Stat(to)
+	if err == nil && os.SameFile(fromFi, toFi) {
+		return nil, ErrSameFile{From: from, To: to}
+	}
+	if !os.IsNotExist(err) && !c.Clobber && c.OnConflict == nil && !c.MetadataOnly {
+		return nil, ErrClobberAvoided{to}
+	}
+	return fromFi, nil
+}
+
+// within reports whether to is inside the directory from, which would have
+// the copy walk into its own output. Besides comparing paths with symlinks
+// resolved, each existing ancestor of to is compared with from by identity,
+// catching other ways of reaching the same directory such as bind mounts.
+func (c *Copier) within(from string, fromFi os.FileInfo, to string) bool {
+	if !sameFs(c.srcFs(), c.dstFs()) {
+		return false
+	}
+	if rel, err := filepath.Rel(resolve(c.srcFs(), from), resolve(c.dstFs(), to)); err == nil {
+		if rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	for dir := filepath.Clean(to); ; dir = filepath.Dir(dir) {
+		if fi, err := c.dstFs().Stat(dir); err == nil && os.SameFile(fromFi, fi) {
+			return true
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return false
+		}
+	}
+}
+
+// resolve cleans path and, on the OS filesystem, makes it absolute and
+// follows any symlinks in the part of it that exists.
+func resolve(fs afero.Fs, path string) string {
+	path = filepath.Clean(path)
+	if !isOs(fs) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
 	if err != nil {
-		return errors.Wrap(err, "reading file metadata")
+		return path
+	}
+	for dir, rest := abs, ""; ; {
+		if real, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(real, rest)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return abs
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+}
+
+// sameFs reports whether a and b are the same filesystem, without
+// panicking on implementations that can't be compared.
+func sameFs(a, b afero.Fs) bool {
+	if isOs(a) && isOs(b) {
+		return true
+	}
+	if reflect.TypeOf(a) != reflect.TypeOf(b) || !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+// CopyAll copies each of the sources into the dest directory, which is created
+// as need be. Each source keeps its name, so "a/b" is copied to "dest/b".
+// As with rsync, a source with a trailing separator has its contents copied
+// instead, so "a/b/" is merged into "dest". The sources share one pool of
+// workers, so a small source doesn't leave them idle while waiting on the
+// next, and the report covers them all.
+func (c *Copier) CopyAll(sources []string, dest string) (Report, error) {
+	return c.CopyAllContext(context.Background(), sources, dest)
+}
+
+// CopyInto is CopyAll taking the sources as separate arguments after dest,
+// returning the error alone as Copy does. Use CopyAll for the report.
+func (c *Copier) CopyInto(dest string, sources ...string) error {
+	_, err := c.CopyAll(sources, dest)
+	return err
+}
+
+// CopyAllContext is CopyAll, stopping early if ctx is cancelled.
+func (c *Copier) CopyAllContext(ctx context.Context, sources []string, dest string) (Report, error) {
+	if c.Fs == nil {
+		c.Fs = afero.NewOsFs()
+	}
+	fi, err := c.dstFs().Stat(dest)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return Report{}, errors.Wrap(err, "reading file metadata")
+	case !fi.IsDir():
+		return Report{}, ErrNotDirectory{dest}
+	}
+	// Sources are copied as one, so that a file reached through two of
+	// them is copied once.
+	seen := c.session()
+	var (
+		pairs [][2]string
+		errs  []error
+	)
+	for _, src := range sources {
+		to := target(src, dest)
+		if c.Parents {
+			rel, err := relative(src)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			to = filepath.Join(dest, rel)
+		}
+		if src != to {
+			pairs = append(pairs, [2]string{src, to})
+		}
+	}
+	var report Report
+	if c.Journal != "" {
+		// The journal records one root per copy, so each source is copied
+		// in turn.
+		report, err = c.copyEach(ctx, seen, pairs)
+	} else {
+		report, err = c.copyShared(ctx, seen, pairs, dest)
+	}
+	if f, ok := err.(Failures); ok {
+		errs = append(errs, f.list...)
+	} else if err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return report, Failures{errs}
+	}
+	return report, nil
+}
+
+// copyShared copies each pair of source and destination through a single
+// pool of workers.
+func (c *Copier) copyShared(ctx context.Context, seen *sync.Map, pairs [][2]string, dest string) (Report, error) {
+	if len(pairs) == 0 {
+		return Report{}, nil
+	}
+	defer c.start()()
+	cp := c.copier()
+	cp.ctx = ctx
+	cp.seen = seen
+	cp.autoParallel(pairs[0][0], dest)
+	report, err := cp.run(func() {
+		for _, pair := range pairs {
+			if ctx.Err() != nil {
+				return
+			}
+			from, to := pair[0], pair[1]
+			fail := func(err error) {
+				cp.results <- result{FileReport: FileReport{From: from, To: to, Err: err}}
+			}
+			if c.CopySymlinks && c.LinkRoots {
+				if fi, err := lstat(cp.src, from); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+					if _, err := lstat(cp.dst, to); err == nil && !c.Clobber && c.OnConflict == nil {
+						fail(ErrClobberAvoided{to})
+					} else {
+						r, _ := cp.relink(from, to, fi)
+						cp.results <- r
+					}
+					continue
+				}
+			}
+			fromFi, err := c.check(from, to)
+			switch {
+			case err != nil:
+				fail(err)
+			case !fromFi.IsDir():
+				cp.enqueue(from, to, fromFi)
+			case c.within(from, fromFi, to):
+				fail(ErrRecursiveCopy{From: from, To: to})
+			default:
+				cp.walk(from, to)
+			}
+		}
+	})
+	return report, c.manifest(report, dest, err)
+}
+
+// copyEach copies each pair of source and destination in turn.
+func (c *Copier) copyEach(ctx context.Context, seen *sync.Map, pairs [][2]string) (Report, error) {
+	report := Report{}
+	var errs []error
+	for _, pair := range pairs {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		r, err := c.copyReport(ctx, seen, pair[0], pair[1])
+		report.merge(r)
+		if f, ok := err.(Failures); ok {
+			errs = append(errs, f.list...)
+		} else if err != nil {
+			errs = append(errs, err)
+		}
 	}
-	if err := fs.MkdirAll(filepath.Dir(to), fromFi.Mode()); err != nil {
-		return errors.Wrapf(err, "preparing directories for %s", to)
+	if len(errs) > 0 {
+		return report, Failures{errs}
 	}
-	toFile, err := fs.OpenFile(to, os.O_CREATE|os.O_RDWR, fromFi.Mode())
+	return report, nil
+}
+
+// CopyPaths copies an explicit list of paths into the dest directory, each
+// keeping its relative path, so "a/b/c.txt" is copied to "dest/a/b/c.txt".
+// Listed directories are created but their contents are only copied if they
+// are listed too, which suits the output of tools like find. A listed file
+// whose destination exists fails with ErrClobberAvoided unless Clobber or
+// OnConflict is set.
+func (c *Copier) CopyPaths(paths []string, dest string) (Report, error) {
+	return c.CopyPathsContext(context.Background(), paths, dest)
+}
+
+// CopyPathsContext is CopyPaths, stopping early if ctx is cancelled.
+func (c *Copier) CopyPathsContext(ctx context.Context, paths []string, dest string) (Report, error) {
+	defer c.start()()
+	if err := c.dstFs().MkdirAll(dest, 0755); err != nil {
+		return Report{}, errors.Wrapf(err, "creating %s", dest)
+	}
+	cp := c.copier()
+	cp.ctx = ctx
+	return cp.copyList(paths, dest)
+}
+
+// CopyGlob copies each path matching the shell pattern into the dest
+// directory under its own name, creating the directory if need be.
+// Matching directories are copied recursively. See filepath.Match for the
+// pattern syntax, to which Glob adds "**" for any number of directories.
+// Each match is checked as Copy checks its source, so one whose destination
+// exists fails with ErrClobberAvoided unless Clobber or OnConflict is set.
+func (c *Copier) CopyGlob(pattern, dest string) (Report, error) {
+	defer c.start()()
+	matches, err := Glob(c.srcFs(), pattern)
 	if err != nil {
-		return errors.Wrapf(err, "creating %s", to)
+		return Report{}, errors.Wrapf(err, "matching %s", pattern)
 	}
-	defer toFile.Close()
-	if _, err := io.Copy(toFile, fromFile); err != nil {
-		return errors.Wrapf(err, "copying file from %s to %s", from, to)
+	if len(matches) == 0 {
+		return Report{}, ErrNoMatch{pattern}
 	}
-	return nil
+	if err := c.dstFs().MkdirAll(dest, 0755); err != nil {
+		return Report{}, errors.Wrapf(err, "creating %s", dest)
+	}
+	cp := c.copier()
+	cp.autoParallel(matches[0], dest)
+	return cp.run(func() {
+		for _, match := range matches {
+			toPath := filepath.Join(dest, filepath.Base(match))
+			info, err := c.check(match, toPath)
+			if err != nil {
+				cp.results <- result{FileReport: FileReport{From: match, To: toPath, Err: err}}
+				continue
+			}
+			if info.IsDir() {
+				if c.within(match, info, toPath) {
+					cp.results <- result{FileReport: FileReport{
+						From: match,
+						To:   toPath,
+						Err:  ErrRecursiveCopy{From: match, To: toPath},
+					}}
+					continue
+				}
+				if c.Flatten {
+					toPath = dest
+				}
+				cp.walk(match, toPath)
+				continue
+			}
+			cp.enqueue(match, toPath, info)
+		}
+	})
+}
+
+// target is where src is copied to within the dest directory.
+func target(src, dest string) string {
+	if strings.HasSuffix(src, "/") || strings.HasSuffix(src, string(filepath.Separator)) {
+		return dest
+	}
+	return filepath.Join(dest, filepath.Base(src))
 }
 
 // copy copies an entire directory concurrently.
-func (c *Copier) copy(from, to string) error {
-	cp := &copier{
-		fs:       c.Fs,
+func (c *Copier) copier() *copier {
+	return &copier{
+		ctx:      context.Background(),
+		src:      c.srcFs(),
+		dst:      c.dstFs(),
 		parallel: c.Parallel,
-		seen:     c.seen,
-		work:     make(chan job),
-		failures: make(chan error),
+		walkers:  c.Walkers,
+		seen:     c.session(),
+		stats:    c.stats,
+		limit:    c.limit,
+		fds:      c.fds,
+		quota:    c.quota,
+		log:      c.log,
+		emit:     c.emit,
+		finished: c.finished,
+		check:    c.check,
+		work:     newQueue(c.Order, c.QueueSize),
+		results:  make(chan result),
+
+		transform:      c.Transform,
+		rename:         c.Rename,
+		flatten:        c.Flatten,
+		collisions:     c.Collisions,
+		claimed:        map[string]string{},
+		specialFiles:   c.SpecialFiles,
+		followSymlinks: c.FollowSymlinks,
+		maxDepth:       c.MaxDepth,
+		minSize:        c.MinSize,
+		maxSize:        c.MaxSize,
+		modifiedAfter:  c.ModifiedAfter,
+		modifiedBefore: c.ModifiedBefore,
+		include:        inclusions(c.IncludeRegexp),
+		exclude:        c.ExcludeRegexp,
+		skipHidden:     c.SkipHidden,
+		ignoreFile:     c.IgnoreFile,
+		nestedIgnores:  c.NestedIgnoreFiles,
+		onConflict:     c.OnConflict,
+		conflicts:      &sync.Mutex{},
+		aborted:        &atomic.Bool{},
+		chunkThreshold: c.chunkThreshold(),
+		chunks:         c.chunks(),
+		minParallel:    c.MinParallel,
+		batch:          c.Batch,
+		pipeline:       c.Pipeline,
+		bufferSize:     c.BufferSize,
+		hashWorkers:    c.HashWorkers,
+		hasher:         c.hasher(),
+		mmapThreshold:  c.mmapThreshold(),
+		allocate:       c.Preallocate && c.Transform == nil,
+		reflink:        c.reflink(),
+		sparse:         c.Sparse,
+		niceIO:         c.NiceIO,
+		metadataOnly:   c.MetadataOnly,
+		hashing:        c.manifests(),
+		attributes:     c.PreserveAttributes,
+		streams:        c.PreserveStreams,
+		selinux:        c.PreserveSELinux,
+		xattrs:         c.PreserveXattrs,
+		mode:           c.PreserveMode || c.MetadataOnly,
+		times:          c.PreserveTimes || c.MetadataOnly,
+		owner:          c.PreserveOwner || c.MetadataOnly,
+		symlinks:       c.CopySymlinks,
+		dirs:           &[]dir{},
+		walkMu:         &sync.Mutex{},
+		digests:        c.digests(),
+		inodes:         c.inodes(),
+		undo:           c.undo(),
+		retries:        c.Retries,
+		retryBackoff:   c.RetryBackoff,
+		isRetryable:    c.IsRetryable,
+		fileTimeout:    c.FileTimeout,
+	}
+}
+
+// chunkThreshold is the size files are chunked at, if they can be.
+func (c *Copier) chunkThreshold() int64 {
+	if c.Transform != nil || c.reflink() != ReflinkNever {
+		return 0
+	}
+	return c.ChunkThreshold
+}
+
+// undo tracks the changes made in a run, if they may be rolled back.
+func (c *Copier) undo() *undo {
+	if c.Rollback {
+		return newUndo()
+	}
+	return nil
+}
+
+// digests tracks the content copied in a run, if deduplicating.
+func (c *Copier) digests() *sync.Map {
+	if c.Dedupe && c.Transform == nil && isOs(c.dstFs()) {
+		return &sync.Map{}
+	}
+	return nil
+}
+
+func (c *Copier) chunks() int {
+	switch {
+	case c.Chunks > 0:
+		return c.Chunks
+	case c.Parallel > 0:
+		return c.Parallel
+	default:
+		return 10
 	}
-	return cp.copy(from, to)
 }
 
 // copier private type which implements the concurrency.
 type copier struct {
-	fs       afero.Fs
+	// ctx stops the copy early when cancelled.
+	ctx      context.Context
+	src      afero.Fs
+	dst      afero.Fs
 	parallel int
+	// tuning is whether parallel was chosen by autoParallel, to be tuned.
+	tuning   bool
+	walkers  int
 	seen     *sync.Map
-	work     chan job
-	failures chan error
+	stats    *counters
+	limit    *limiter
+	fds      *semaphore
+	quota    *quota
+	log      func(slog.Level, string, ...any)
+	emit     func(Event)
+	finished func(result)
+	check    func(from, to string) (os.FileInfo, error)
+	work     *queue
+	results  chan result
+
+	transform  func(string, io.Reader) (io.Reader, error)
+	rename     func(string) string
+	flatten    bool
+	collisions Collision
+	// claimed maps flattened destinations to the file copied there.
+	claimed        map[string]string
+	specialFiles   SpecialFiles
+	followSymlinks bool
+	maxDepth       int
+	minSize        int64
+	maxSize        int64
+	modifiedAfter  time.Time
+	modifiedBefore time.Time
+	include        []inclusion
+	exclude        []*regexp.Regexp
+	skipHidden     bool
+	ignoreFile     string
+	nestedIgnores  bool
+	onConflict     func(string, string) Conflict
+	// conflicts serialises calls to onConflict, and aborted is set once it
+	// has stopped the copy.
+	conflicts      *sync.Mutex
+	aborted        *atomic.Bool
+	chunkThreshold int64
+	chunks         int
+	// minParallel is the number of workers kept running when the pool
+	// grows and shrinks with the queue.
+	minParallel   int
+	mmapThreshold int64
+	// batch is the most small files a worker copies at once.
+	batch int
+	// pipeline is the number of buffers a file is read ahead through.
+	pipeline int
+	// bufferSize is the size of the buffer files are copied through.
+	bufferSize int
+	// allocate is whether to reserve space for files before writing them.
+	allocate bool
+	// reflink is whether to clone files.
+	reflink Reflink
+	// sparse is whether to leave holes in the files written.
+	sparse Sparse
+	// niceIO is whether workers lower their IO priority.
+	niceIO bool
+	// metadataOnly is whether to update metadata in place of copying.
+	metadataOnly bool
+	// hashing is whether to hash each file copied for the manifest.
+	hashing bool
+	// hashWorkers is the size of the pool hashing files, if they aren't
+	// hashed by the workers copying them, and hashers feeds it.
+	hashWorkers int
+	hashers     chan result
+	// hasher makes the hashes of manifests, VerifyResume and chunked copies.
+	hasher Hasher
+
+	// planned, when set, records what would be done instead of doing it.
+	planned *planner
+	// undo, when set, records the changes made so they can be rolled back.
+	undo *undo
+
+	retries      int
+	retryBackoff time.Duration
+	isRetryable  func(error) bool
+	fileTimeout  time.Duration
+
+	// attributes is whether to copy file attributes.
+	attributes bool
+	// streams is whether to copy alternate data streams.
+	streams bool
+	// selinux is whether to copy security contexts.
+	selinux bool
+	// xattrs is whether to copy extended attributes.
+	xattrs bool
+	// mode, times and owner are whether to copy those exactly.
+	mode, times, owner bool
+	// symlinks is whether to recreate symlinks rather than copy through
+	// them.
+	symlinks bool
+	// dirs collects the directories walked when preserving metadata.
+	dirs *[]dir
+	// walkMu guards claimed and dirs, which walkers add to concurrently.
+	walkMu *sync.Mutex
+	// digests maps the content copied so far to the first copy of it,
+	// when deduplicating.
+	digests *sync.Map
+	// inodes maps the hard linked files copied so far to where the first
+	// link was copied to, when preserving hard links.
+	inodes *sync.Map
+
+	// journal, when set, records the files queued and done beneath root.
+	journal *journal
+	root    string
+	// made are the directories created by the copy being resumed.
+	made map[string]bool
+
+	// archive, when set, receives the files instead of dst.
+	archive   ArchiveWriter
+	archiveMu *sync.Mutex
+}
+
+func (c copier) copy(from, to string) (Report, error) {
+	c.autoParallel(from, to)
+	return c.run(func() {
+		c.walk(from, to)
+	})
 }
 
-func (c copier) copy(from, to string) error {
-	go c.walk(from, to)
+func (c copier) copyList(paths []string, to string) (Report, error) {
+	if len(paths) > 0 {
+		c.autoParallel(paths[0], to)
+	}
+	return c.run(func() {
+		c.list(paths, to)
+	})
+}
+
+// run feeds the workers with the jobs queued by produce and collects the
+// results.
+func (c copier) run(produce func()) (Report, error) {
+	go func() {
+		produce()
+		c.work.close()
+	}()
 	go c.copyFiles()
-	return c.collectErrors()
+	report, err := c.collect()
+	if cerr := c.ctx.Err(); cerr != nil {
+		if f, ok := err.(Failures); ok {
+			err = Failures{append(f.list, cerr)}
+		} else {
+			err = Failures{[]error{cerr}}
+		}
+	}
+	if errs := c.preserveDirs(); len(errs) > 0 {
+		if f, ok := err.(Failures); ok {
+			errs = append(f.list, errs...)
+		}
+		err = Failures{errs}
+	}
+	return report, c.settle(err)
 }
 
+// copyFile copies a single file, returning the number of bytes written and
+// whether an existing file was overwritten.
+func (c *copier) copyFile(from, to string) (int64, bool, error) {
+	if c.chunkThreshold > 0 {
+		if info, err := c.src.Stat(from); err == nil && c.chunked(info) {
+			return c.copyChunked(from, to, info)
+		}
+	}
+	if c.fds != nil {
+		c.fds.acquire(2)
+		defer c.fds.release(2)
+	}
+	fromFile, err := c.src.Open(from)
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "opening %s", from)
+	}
+	defer fromFile.Close()
+	return c.copyFrom(fromFile, from, to)
+}
+
+// copyFrom copies the open source file fromFile to to.
+func (c *copier) copyFrom(fromFile afero.File, from, to string) (int64, bool, error) {
+	fromFi, err := fromFile.Stat()
+	if err != nil {
+		return 0, false, errors.Wrap(err, "reading file metadata")
+	}
+	if err := c.dst.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return 0, false, errors.Wrapf(err, "preparing directories for %s", to)
+	}
+	toFi, err := c.dst.Stat(to)
+	overwrote := err == nil
+	if overwrote && os.SameFile(fromFi, toFi) {
+		return 0, false, ErrSameFile{From: from, To: to}
+	}
+	if overwrote {
+		if err := c.prepare(to); err != nil {
+			return 0, overwrote, err
+		}
+	} else {
+		c.create(to)
+	}
+	toFile, err := c.dst.OpenFile(to, os.O_CREATE|os.O_RDWR|os.O_TRUNC, fromFi.Mode())
+	if err != nil {
+		return 0, overwrote, errors.Wrapf(err, "creating %s", to)
+	}
+	// Closing again would stamp memory filesystems with a new modification
+	// time after preserve has set it.
+	closed := false
+	defer func() {
+		if !closed {
+			toFile.Close()
+		}
+	}()
+	cloned, err := c.clone(fromFile, toFile, from, to, fromFi.Size())
+	if err != nil {
+		if !overwrote {
+			toFile.Close()
+			c.dst.Remove(to)
+		}
+		return 0, overwrote, err
+	}
+	var w io.Writer = toFile
+	holes := c.holes(toFile, fromFi)
+	if holes != nil {
+		w = holes
+	} else if !cloned {
+		if err := c.preallocate(toFile, to, fromFi.Size()); err != nil {
+			return 0, overwrote, err
+		}
+	}
+	var n int64
+	if cloned {
+		n = fromFi.Size()
+	} else if data, unmap, ok := c.mapped(fromFile, fromFi); ok {
+		defer unmap()
+		n, err = c.writeMapped(countingWriter{w, c.stats}, data)
+	} else {
+		var r io.Reader = c.cancellable(fromFile)
+		if c.limit != nil {
+			r = throttledReader{r, c.limit}
+		}
+		if c.transform != nil {
+			t, err := c.transform(from, r)
+			if err != nil {
+				return 0, overwrote, errors.Wrapf(err, "transforming %s", from)
+			}
+			if closer, ok := t.(io.Closer); ok {
+				defer closer.Close()
+			}
+			r = t
+		}
+		if c.piped(fromFi.Size()) {
+			n, err = c.pipe(countingWriter{w, c.stats}, r)
+		} else {
+			n, err = c.buffered(countingWriter{w, c.stats}, r)
+		}
+	}
+	if err == nil && holes != nil && !cloned {
+		err = holes.close()
+	}
+	if err != nil {
+		c.discard(toFile, to, overwrote)
+		return n, overwrote, errors.Wrapf(err, "copying file from %s to %s", from, to)
+	}
+	if c.journal != nil {
+		if err := toFile.Sync(); err != nil {
+			return n, overwrote, errors.Wrapf(err, "syncing %s", to)
+		}
+	}
+	closed = true
+	if err := toFile.Close(); err != nil {
+		return n, overwrote, errors.Wrapf(err, "closing %s", to)
+	}
+	if err := c.preserve(from, to, fromFi); err != nil {
+		return n, overwrote, err
+	}
+	atomic.AddInt64(&c.stats.files, 1)
+	return n, overwrote, nil
+}
+
+// copyFiles runs the workers until the queue is drained, then closes the
+// results.
 func (c *copier) copyFiles() {
-	if c.parallel < 1 {
-		c.parallel = 10
+	c.autoParallel("", "")
+	core := c.parallel
+	if c.minParallel > 0 && c.minParallel < c.parallel {
+		core = c.minParallel
+	}
+	// When tuning, every worker there could be is started but only as many
+	// as the tuner allows copy at once, rather than growing the pool.
+	var tuned *tuner
+	if c.tuning {
+		tuned = newTuner(c.parallel, max(c.minParallel, 1), c.parallel*4)
+		core = tuned.max
+	}
+	hashing := &sync.WaitGroup{}
+	if c.hashing && c.hashWorkers > 0 {
+		c.hashers = make(chan result)
+		for ii := 0; ii < c.hashWorkers; ii++ {
+			hashing.Add(1)
+			go func() {
+				defer hashing.Done()
+				c.background()
+				for r := range c.hashers {
+					r.Digest, r.Err = c.hash(r.To)
+					c.results <- r
+				}
+			}()
+		}
 	}
 	jobs := &sync.WaitGroup{}
-	for ii := 0; ii < c.parallel-1; ii++ {
+	for ii := 0; ii < core; ii++ {
 		jobs.Add(1)
 		go func() {
-			for job := range c.work {
-				if err := copyFile(
-					c.fs,
-					job.From,
-					job.To,
-				); err != nil {
-					c.failures <- err
+			defer jobs.Done()
+			c.background()
+			b := &batch{}
+			for {
+				tuned.acquire()
+				job, ok := c.work.pop()
+				if !ok {
+					tuned.release()
+					return
 				}
+				atomic.AddInt64(&c.stats.queued, -1)
+				c.copyBatch(job, b)
+				tuned.release()
 			}
-			jobs.Done()
 		}()
 	}
+	extras := &sync.WaitGroup{}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		switch {
+		case tuned != nil:
+			c.tune(tuned, stop)
+		case core < c.parallel:
+			c.grow(c.parallel-core, extras, stop)
+		}
+	}()
 	jobs.Wait()
-	close(c.failures)
+	close(stop)
+	<-stopped
+	extras.Wait()
+	if c.hashers != nil {
+		close(c.hashers)
+		hashing.Wait()
+	}
+	close(c.results)
+}
+
+// send passes on the result of a file, by way of the hashing pool if there
+// is one and the file needs hashing.
+func (c *copier) send(r result) {
+	if c.hashers != nil && !r.skipped && r.Err == nil {
+		c.hashers <- r
+		return
+	}
+	c.results <- r
+}
+
+// growInterval is how often the queue is checked for a backlog to add
+// workers for.
+var growInterval = 20 * time.Millisecond
+
+// grow adds up to max workers beside the core ones while files back up in
+// the queue, until stop is closed. Each leaves again once the queue runs
+// dry.
+func (c *copier) grow(max int, extras *sync.WaitGroup, stop chan struct{}) {
+	var running atomic.Int64
+	tick := time.NewTicker(growInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+		for n := c.work.len(); n > 0 && running.Load() < int64(max); n-- {
+			running.Add(1)
+			extras.Add(1)
+			go func() {
+				defer extras.Done()
+				defer running.Add(-1)
+				c.background()
+				b := &batch{}
+				for {
+					job, ok := c.work.tryPop()
+					if !ok {
+						return
+					}
+					atomic.AddInt64(&c.stats.queued, -1)
+					c.copyBatch(job, b)
+				}
+			}()
+		}
+	}
+}
+
+// copyJob copies a queued file by whichever means applies.
+func (c *copier) copyJob(j job) result {
+	r := result{FileReport: FileReport{From: j.From, To: j.To}}
+	if c.ctx.Err() != nil {
+		r.skipped = true
+		return r
+	}
+	start := time.Now()
+	r.skipped, r.Err = c.conflict(j.From, j.To)
+	if !r.skipped && r.Err == nil {
+		c.emit(Event{Kind: FileStarted, File: FileReport{From: j.From, To: j.To, Bytes: j.Size}})
+		r = c.timed(j, r, c.transfer)
+	}
+	// With a pool of its own, hashing is left to send.
+	if c.hashing && c.hashers == nil && !r.skipped && r.Err == nil {
+		r.Digest, r.Err = c.hash(j.To)
+	}
+	if !r.skipped && r.Err == nil {
+		c.journal.record("done", j.To)
+	}
+	r.Duration = time.Since(start)
+	return r
+}
+
+// transfer copies the file's contents, retrying if need be.
+func (c *copier) transfer(j job, r result) result {
+	switch {
+	case c.metadataOnly:
+		r.skipped, r.Err = c.touchUp(j.From, j.To)
+	case c.archive != nil:
+		// A partly written entry can't be taken back, so isn't retried.
+		r.Bytes, r.Err = c.archiveFile(j.From, j.To)
+	case c.digests != nil:
+		c.retry(&r, func() (int64, bool, bool, error) {
+			return c.copyDeduped(j.From, j.To)
+		})
+	case c.inodes != nil:
+		c.retry(&r, func() (int64, bool, bool, error) {
+			return c.copyLinked(j.From, j.To)
+		})
+	default:
+		c.retry(&r, func() (int64, bool, bool, error) {
+			// Retries read the source afresh.
+			if ahead := j.ahead; ahead != nil {
+				j.ahead = nil
+				if c.fds != nil {
+					c.fds.acquire(1)
+					defer c.fds.release(1)
+				}
+				n, overwrote, err := c.copyFrom(ahead, j.From, j.To)
+				return n, overwrote, false, err
+			}
+			n, overwrote, err := c.copyFile(j.From, j.To)
+			return n, overwrote, false, err
+		})
+	}
+	return r
 }
 
-func (c *copier) collectErrors() error {
+// collect builds the report from the results of the walk and copy.
+func (c *copier) collect() (Report, error) {
+	report := Report{}
 	var errs []error
-	for err := range c.failures {
-		errs = append(errs, err)
+	for r := range c.results {
+		report.add(r)
+		c.finished(r)
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+		}
 	}
 	if len(errs) > 0 {
-		return Failures{errs}
+		return report, Failures{errs}
 	}
-	return nil
+	return report, nil
 }
 
 func (c *copier) walk(from, to string) {
+	c.log(slog.LevelDebug, "walk started", "from", from, "to", to)
+	c.emit(Event{Kind: WalkStarted, File: FileReport{From: from, To: to}})
+	ignores := c.ignores()
 	walker := func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
+		if err := c.ctx.Err(); err != nil {
+			return err
+		}
+		rel := strings.Replace(path, from, "", 1)
+		target, err := c.target(to, rel)
+		if err != nil {
+			c.results <- result{FileReport: FileReport{From: path, Err: err}}
 			return nil
 		}
-		toPath := filepath.Join(to, strings.Replace(path, from, "", 1))
-		if _, ok := c.seen.Load(toPath); ok {
+		if target == "" || c.excluded(rel, info) || ignores.ignored(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if err := ignores.read(path, rel); err != nil {
+				return err
+			}
+			if !c.flatten {
+				c.mkdir(path, target, info)
+			}
+			if c.deepest(rel) {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-		c.seen.Store(toPath, struct{}{})
-		c.work <- job{
-			From: path,
-			To:   toPath,
+		if c.flatten {
+			target = flat(to, target)
 		}
+		c.enqueue(path, target, info)
 		return nil
 	}
-	if err := afero.Walk(c.fs, from, walker); err != nil {
-		c.failures <- errors.Wrap(err, "walking file system")
+	root := from
+	if real, ok := c.resolve(from); ok {
+		// A symlinked root is walked through its target, the paths found
+		// given back under the link so that they're placed alike.
+		root = real
+		inner := walker
+		walker = func(path string, info os.FileInfo, err error) error {
+			return inner(from+strings.TrimPrefix(path, real), info, err)
+		}
+	}
+	walk := func() error { return afero.Walk(c.src, root, walker) }
+	switch {
+	case c.walkers > 1:
+		walk = func() error { return c.walkParallel(root, walker) }
+	case c.followSymlinks:
+		walk = func() error { return c.walkFollowing(root, walker) }
+	}
+	// Cancellation is reported once the copy is done, not as a failed walk.
+	if err := walk(); err != nil && c.ctx.Err() == nil {
+		c.results <- result{
+			FileReport: FileReport{From: from, To: to, Err: errors.Wrap(err, "walking file system")},
+		}
+	} else if err == nil {
+		c.journal.record("walked", c.root)
 	}
-	close(c.work)
+}
+
+// list queues an explicit list of paths, each copied to the same relative
+// path under to. Directories are created but not descended into.
+func (c *copier) list(paths []string, to string) {
+	c.log(slog.LevelDebug, "walk started", "paths", len(paths), "to", to)
+	c.emit(Event{Kind: WalkStarted, File: FileReport{To: to}})
+	for _, path := range paths {
+		if c.ctx.Err() != nil {
+			return
+		}
+		rel, err := relative(path)
+		if err != nil {
+			c.results <- result{FileReport: FileReport{From: path, Err: err}}
+			continue
+		}
+		toPath, err := c.target(to, rel)
+		if err != nil {
+			c.results <- result{FileReport: FileReport{From: path, Err: err}}
+			continue
+		}
+		if toPath == "" {
+			continue
+		}
+		info, err := c.src.Stat(path)
+		if err != nil {
+			c.results <- result{FileReport: FileReport{
+				From: path,
+				To:   toPath,
+				Err:  errors.Wrap(err, "reading file metadata"),
+			}}
+			continue
+		}
+		if info.IsDir() && c.flatten {
+			continue
+		}
+		if c.flatten {
+			toPath = flat(to, toPath)
+		}
+		if info.IsDir() {
+			c.mkdir(path, toPath, info)
+			continue
+		}
+		if _, err := c.check(path, toPath); err != nil {
+			c.results <- result{FileReport: FileReport{From: path, To: toPath, Err: err}}
+			continue
+		}
+		c.enqueue(path, toPath, info)
+	}
+}
+
+// enqueue queues a file for the workers unless it is filtered out or its
+// destination has already been copied to.
+func (c *copier) enqueue(from, to string, info os.FileInfo) {
+	if c.filtered(info) {
+		return
+	}
+	if c.flatten {
+		placed, ok, err := c.place(from, to)
+		if err != nil {
+			c.results <- result{FileReport: FileReport{From: from, To: to, Err: err}}
+			return
+		}
+		if !ok {
+			c.results <- result{FileReport: FileReport{From: from, To: to}, skipped: true}
+			return
+		}
+		to = placed
+	}
+	if _, ok := c.seen.LoadOrStore(to, struct{}{}); ok {
+		c.results <- result{
+			FileReport: FileReport{From: from, To: to},
+			skipped:    true,
+		}
+		return
+	}
+	if c.planned != nil {
+		c.plan(from, to, info)
+		return
+	}
+	if r, ok := c.special(from, to, info); ok {
+		c.results <- r
+		return
+	}
+	if r, ok := c.relink(from, to, info); ok {
+		c.results <- r
+		return
+	}
+	if err := c.quota.reserve(from, info.Size()); err != nil {
+		c.results <- result{FileReport: FileReport{From: from, To: to, Err: err}}
+		return
+	}
+	c.log(slog.LevelDebug, "file queued", "from", from, "to", to, "bytes", info.Size())
+	c.emit(Event{Kind: FileQueued, File: FileReport{From: from, To: to, Bytes: info.Size()}})
+	c.stats.found(info.Size())
+	c.journal.record("queued", c.root, from, to)
+	atomic.AddInt64(&c.stats.queued, 1)
+	c.work.push(job{
+		From: from,
+		To:   to,
+		Size: info.Size(),
+	})
+}
+
+// deepest reports whether the directory rel, relative to the root of the
+// walk, is as deep as MaxDepth allows, so its contents are left out.
+func (c *copier) deepest(rel string) bool {
+	if c.maxDepth <= 0 {
+		return false
+	}
+	rel = strings.Trim(filepath.ToSlash(rel), "/")
+	if rel == "" || rel == "." {
+		return false
+	}
+	return strings.Count(rel, "/")+1 >= c.maxDepth
+}
+
+// mkdir creates the directory at to as it is walked, so that empty
+// directories are copied too. It is left writable by its owner until the
+// copy is done, when it is given the mode of the source; a read-only
+// directory could not have its contents written otherwise.
+func (c *copier) mkdir(from, to string, info os.FileInfo) {
+	if c.planned != nil {
+		c.planned.add(Op{Kind: OpMkdir, From: from, To: to})
+		return
+	}
+	if c.metadataOnly {
+		// Only directories already there are touched up.
+		if fi, err := c.dst.Stat(to); err == nil && fi.IsDir() {
+			c.addDir(dir{From: from, To: to, info: info})
+		}
+		return
+	}
+	_, err := c.dst.Stat(to)
+	created := os.IsNotExist(err)
+	if err := c.dst.MkdirAll(to, info.Mode().Perm()|0700); err != nil {
+		c.results <- result{FileReport: FileReport{
+			From: from,
+			To:   to,
+			Err:  errors.Wrapf(err, "creating %s", to),
+		}}
+		return
+	}
+	if created {
+		c.journal.record("mkdir", c.root, from, to)
+	}
+	// A directory made by the copy being resumed counts as created.
+	c.addDir(dir{From: from, To: to, info: info, created: created || c.made[to]})
+}
+
+// target is where the path rel, relative to the root being copied, goes
+// under to once renamed, or "" if Rename leaves it out.
+func (c *copier) target(to, rel string) (string, error) {
+	rel = strings.TrimLeft(rel, string(filepath.Separator))
+	if c.rename == nil || rel == "" {
+		return filepath.Join(to, rel), nil
+	}
+	renamed := c.rename(rel)
+	if renamed == "" {
+		return "", nil
+	}
+	renamed, err := relative(renamed)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(to, renamed), nil
+}
+
+// relative makes path relative so that it can be placed under a destination,
+// refusing paths that would climb out of it.
+func relative(path string) (string, error) {
+	rel := filepath.Clean(path)
+	rel = strings.TrimPrefix(rel, filepath.VolumeName(rel))
+	rel = strings.TrimLeft(rel, string(filepath.Separator))
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("path %q escapes the destination", path)
+	}
+	return rel, nil
 }
 
 type job struct {
 	From, To string
+	Size     int64
+	// seq is the order the job was queued in.
+	seq int64
+	// ahead, when set, is the source already read as part of a batch.
+	ahead *readAhead
+}
+
+// result is the outcome of a single job.
+type result struct {
+	FileReport
+	overwrote bool
+	skipped   bool
+	linked    bool
+	removed   bool
+	renamed   bool
+}
+
+// Report describes what a copy did, file by file.
+type Report struct {
+	// Copied lists files written to a destination that did not exist.
+	Copied []FileReport
+	// Overwritten lists files written over an existing destination file.
+	Overwritten []FileReport
+	// Skipped lists files that were not copied because their destination
+	// had already been copied to, or because they were special files.
+	Skipped []FileReport
+	// Linked lists files hard linked to an identical file copied earlier,
+	// when deduplicating, or to the copy of a file they were linked to,
+	// when preserving links.
+	Linked []FileReport
+	// Failed lists files that could not be copied.
+	Failed []FileReport
+	// Removed lists the files and directories Mirror removed from the
+	// destination, with just To set.
+	Removed []FileReport
+	// Renamed lists the files and directories Move renamed into place
+	// whole rather than copying.
+	Renamed []FileReport
+}
+
+// FileReport describes the outcome of copying a single file.
+type FileReport struct {
+	From, To string
+	// Bytes is the number of bytes written to the destination.
+	Bytes int64
+	// Duration is how long the file took to copy.
+	Duration time.Duration
+	// Digest is the hex hash of the file as copied, by Hasher, when
+	// writing a manifest.
+	Digest string
+	// Err is why the file failed to copy, nil otherwise.
+	Err error
+}
+
+// empty reports whether r lists nothing at all.
+func (r *Report) empty() bool {
+	return len(r.Copied)+len(r.Overwritten)+len(r.Skipped)+len(r.Linked)+
+		len(r.Failed)+len(r.Removed)+len(r.Renamed) == 0
+}
+
+// merge appends the contents of other to the report.
+func (r *Report) merge(other Report) {
+	r.Copied = append(r.Copied, other.Copied...)
+	r.Overwritten = append(r.Overwritten, other.Overwritten...)
+	r.Skipped = append(r.Skipped, other.Skipped...)
+	r.Linked = append(r.Linked, other.Linked...)
+	r.Failed = append(r.Failed, other.Failed...)
+	r.Removed = append(r.Removed, other.Removed...)
+	r.Renamed = append(r.Renamed, other.Renamed...)
+}
+
+func (r *Report) add(res result) {
+	switch {
+	case res.Err != nil:
+		r.Failed = append(r.Failed, res.FileReport)
+	case res.skipped:
+		r.Skipped = append(r.Skipped, res.FileReport)
+	case res.linked:
+		r.Linked = append(r.Linked, res.FileReport)
+	case res.removed:
+		r.Removed = append(r.Removed, res.FileReport)
+	case res.renamed:
+		r.Renamed = append(r.Renamed, res.FileReport)
+	case res.overwrote:
+		r.Overwritten = append(r.Overwritten, res.FileReport)
+	default:
+		r.Copied = append(r.Copied, res.FileReport)
+	}
 }
 
 // Failures wraps a list of errors.
@@ -191,6 +1711,12 @@ func (err Failures) Error() string {
 	return b.String()
 }
 
+// Unwrap returns the individual failures, so that errors.Is and errors.As
+// can look through them.
+func (err Failures) Unwrap() []error {
+	return err.list
+}
+
 // ErrClobberAvoided describes an attempt to overwrite an existing file.
 type ErrClobberAvoided struct {
 	Path string
@@ -200,3 +1726,42 @@ func (err ErrClobberAvoided) Error() string {
 	return fmt.Sprintf("avoided attempt to clobber existing file or directory %q",
 		err.Path)
 }
+
+// ErrNotDirectory describes a path that must be a directory but isn't, such
+// as the destination of CopyAll or the source of Mirror.
+type ErrNotDirectory struct {
+	Path string
+}
+
+func (err ErrNotDirectory) Error() string {
+	return fmt.Sprintf("%q is not a directory", err.Path)
+}
+
+// ErrSameFile describes a copy between two paths to the same file, such as
+// through a symlink or hard link, which would truncate the file as it is read.
+type ErrSameFile struct {
+	From, To string
+}
+
+func (err ErrSameFile) Error() string {
+	return fmt.Sprintf("%q and %q are the same file", err.From, err.To)
+}
+
+// ErrRecursiveCopy describes copying a directory into itself, which would
+// never finish.
+type ErrRecursiveCopy struct {
+	From, To string
+}
+
+func (err ErrRecursiveCopy) Error() string {
+	return fmt.Sprintf("cannot copy %q into itself, %q", err.From, err.To)
+}
+
+// ErrNoMatch describes a pattern that matched nothing.
+type ErrNoMatch struct {
+	Pattern string
+}
+
+func (err ErrNoMatch) Error() string {
+	return fmt.Sprintf("no matches for pattern %q", err.Pattern)
+}
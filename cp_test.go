@@ -1,7 +1,38 @@
 package cp
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	iofs "io/fs"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"github.com/spf13/afero"
 
@@ -132,10 +163,11 @@ func TestCopy_LateralCopy(t *testing.T) {
 }
 
 // TestCopy_VerticalCopy tests that copying vertically does not end in infinite
-// recursion. That is we should should be able to copy into a child directory
-// or a parent directory without issue. Maybe, see TODO.
+// recursion. That is we should should be able to copy into a parent directory
+// without issue.
 // "cp -r parent/child parent" copies the contents of child into parent.
-// "cp -r parent parent/child" causes infinite recursion.
+// "cp -r parent parent/child" causes infinite recursion, so it is rejected;
+// see TestCopy_IntoChild.
 func TestCopy_VerticalCopy(t *testing.T) {
 	tests := []struct {
 		desc     string
@@ -144,37 +176,6 @@ func TestCopy_VerticalCopy(t *testing.T) {
 		original fb.Entry
 		expected fb.Entry
 	}{
-		// This test, "copy into child", will give you infinite recursion
-		// using cp -r.
-		// For this library however it gives inconsistent results:
-		// sometimes the test passes and sometimes it fails.
-		//
-		// TODO(jfm): Should this usecase throw an error or should we
-		// handle it?
-		// Perhaps one is just asking for trouble by attempting such a
-		// command.
-		//
-		// {
-		// 	"copy into child",
-		// 	// cp from from/to
-		// 	"/from",
-		// 	"/from/to",
-		// 	fb.Entries([]fb.Entry{
-		// 		fb.File{Path: "/dir/foo.exe"},
-		// 		fb.File{Path: "/dir/bar.exe"},
-		// 		fb.File{Path: "/dir/foobar.exe"},
-		// 	}),
-		// 	fb.Entries([]fb.Entry{
-		// 		fb.File{Path: "/dir/foo.exe"},
-		// 		fb.File{Path: "/dir/bar.exe"},
-		// 		fb.File{Path: "/dir/foobar.exe"},
-		// 		// New directory "to" with the original contents
-		// 		// of "from".
-		// 		fb.File{Path: "/to/dir/foo.exe"},
-		// 		fb.File{Path: "/to/dir/bar.exe"},
-		// 		fb.File{Path: "/to/dir/foobar.exe"},
-		// 	}),
-		// },
 		{
 			"copy into parent",
 			"/from/child",
@@ -227,3 +228,3014 @@ func TestCopy_VerticalCopy(t *testing.T) {
 		}
 	}
 }
+
+// TestCopy_IntoChild tests that copying a directory into its own child is
+// rejected, whether the child is named directly or through a symlink.
+func TestCopy_IntoChild(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if _, err := fb.Build(fs, "/from", fb.Entries([]fb.Entry{
+		fb.File{Path: "/dir/foo.exe"},
+		fb.File{Path: "/dir/bar.exe"},
+	})); err != nil {
+		t.Fatalf("unexpected error while building filesystem: %v", err)
+	}
+	copier := Copier{Fs: fs, Clobber: true}
+	for _, to := range []string{"/from/to", "/from/dir/to", "/from/../from/to"} {
+		if err := copier.Copy("/from", to); !errors.As(err, &ErrRecursiveCopy{}) {
+			t.Errorf("[%s] want ErrRecursiveCopy, got %v", to, err)
+		}
+	}
+	if err := copier.Copy("/from", "/fromage"); err != nil {
+		t.Fatalf("unexpected error copying to a sibling: %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "from", "dir"), 0755); err != nil {
+		t.Fatalf("unexpected error creating directory: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "from", "dir"), filepath.Join(dir, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	copier = Copier{Clobber: true}
+	if err := copier.Copy(filepath.Join(dir, "from"), filepath.Join(dir, "link", "to")); !errors.As(err, &ErrRecursiveCopy{}) {
+		t.Errorf("[symlink] want ErrRecursiveCopy, got %v", err)
+	}
+}
+
+// TestCopyReport tests that the report accounts for each file copied.
+func TestCopyReport(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if _, err := fb.Build(fs, "from", fb.Entries([]fb.Entry{
+		fb.File{Path: "foo.exe"},
+		fb.File{Path: "bar.exe"},
+	})); err != nil {
+		t.Fatalf("unexpected error while building filesystem: %v", err)
+	}
+	if _, err := fb.Build(fs, "to", fb.Entries([]fb.Entry{
+		fb.File{Path: "foo.exe"},
+	})); err != nil {
+		t.Fatalf("unexpected error while building clobber files: %v", err)
+	}
+	if err := afero.WriteFile(fs, "from/bar.exe", []byte("bar"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	copier := Copier{
+		Fs:      fs,
+		Clobber: true,
+	}
+	report, err := copier.CopyReport("from", "to")
+	if err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if len(report.Copied) != 1 || report.Copied[0].To != filepath.Join("to", "bar.exe") {
+		t.Fatalf("want bar.exe copied, got %+v", report.Copied)
+	}
+	if report.Copied[0].Bytes != 3 {
+		t.Fatalf("want 3 bytes copied, got %d", report.Copied[0].Bytes)
+	}
+	if len(report.Overwritten) != 1 || report.Overwritten[0].To != filepath.Join("to", "foo.exe") {
+		t.Fatalf("want foo.exe overwritten, got %+v", report.Overwritten)
+	}
+	if len(report.Skipped) != 0 || len(report.Failed) != 0 {
+		t.Fatalf("want nothing skipped or failed, got %+v", report)
+	}
+}
+
+// TestCopier_Stats tests that stats accumulate the files and bytes copied.
+func TestCopier_Stats(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/foo.exe", "from/dir/bar.exe"} {
+		if err := afero.WriteFile(fs, path, []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs}
+	if stats := copier.Stats(); stats != (Stats{}) {
+		t.Fatalf("want zero stats before copying, got %+v", stats)
+	}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	stats := copier.Stats()
+	if stats.Files != 2 || stats.Bytes != 8 {
+		t.Fatalf("want 2 files and 8 bytes, got %+v", stats)
+	}
+	if stats.Elapsed <= 0 {
+		t.Fatalf("want positive elapsed time, got %v", stats.Elapsed)
+	}
+}
+
+// TestCopier_ETA tests that the rate and ETA are estimated while copying
+// and the files found are totalled.
+func TestCopier_ETA(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for ii := 0; ii < 10; ii++ {
+		if err := afero.WriteFile(fs, fmt.Sprintf("from/%d.bin", ii), make([]byte, 4<<10), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	var estimated bool
+	copier := Copier{
+		Fs:       fs,
+		Parallel: 1,
+		Expected: Totals{Files: 10, Bytes: 40 << 10},
+		Transform: func(path string, r io.Reader) (io.Reader, error) {
+			time.Sleep(10 * time.Millisecond)
+			return r, nil
+		},
+	}
+	copier.Progress = func(p Progress) {
+		if p.Stats.Files < 10 && p.Stats.Rate > 0 && p.Stats.ETA > 0 {
+			estimated = true
+		}
+	}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if !estimated {
+		t.Errorf("want a rate and ETA while copying")
+	}
+	stats := copier.Stats()
+	if stats.Found != (Totals{Files: 10, Bytes: 40 << 10}) {
+		t.Errorf("want 10 files of 40KiB found, got %+v", stats.Found)
+	}
+	if stats.ETA != 0 || stats.Rate != 0 {
+		t.Errorf("want no rate or ETA once done, got %v and %v", stats.Rate, stats.ETA)
+	}
+}
+
+// TestCopier_Progress tests that progress is reported for every file and
+// that Measure agrees with what was copied.
+func TestCopier_Progress(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/foo.exe", "from/dir/bar.exe", "from/dir/baz.exe"} {
+		if err := afero.WriteFile(fs, path, []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	var calls int
+	copier := Copier{
+		Fs: fs,
+		Progress: func(p Progress) {
+			calls++
+		},
+	}
+	total, err := copier.Measure("from")
+	if err != nil {
+		t.Fatalf("unexpected error while measuring: %v", err)
+	}
+	if total != (Totals{Files: 3, Bytes: 12}) {
+		t.Fatalf("want 3 files and 12 bytes, got %+v", total)
+	}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("want 3 progress calls, got %d", calls)
+	}
+}
+
+// TestCopier_CopyAll tests that each source is copied into the destination
+// directory under its own name.
+func TestCopier_CopyAll(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"a/foo.exe", "b/dir/bar.exe", "c.exe", "file"} {
+		if err := afero.WriteFile(fs, path, []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs}
+	if _, err := copier.CopyAll([]string{"a", "b", "c.exe"}, "dest"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	for _, path := range []string{"dest/a/foo.exe", "dest/b/dir/bar.exe", "dest/c.exe"} {
+		if ok, _ := afero.Exists(fs, path); !ok {
+			t.Errorf("want %s to exist", path)
+		}
+	}
+	if _, err := copier.CopyAll([]string{"a"}, "file"); err == nil {
+		t.Fatalf("want error copying into a file, got nil")
+	}
+}
+
+// TestCopier_CopyInto tests that the variadic form copies each source into
+// the destination as CopyAll does.
+func TestCopier_CopyInto(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"a/foo.exe", "c.exe", "file"} {
+		if err := afero.WriteFile(fs, path, []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs}
+	if err := copier.CopyInto("dest", "a", "c.exe"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	for _, path := range []string{"dest/a/foo.exe", "dest/c.exe"} {
+		if ok, _ := afero.Exists(fs, path); !ok {
+			t.Errorf("want %s to exist", path)
+		}
+	}
+	if err := copier.CopyInto("file", "a"); err == nil {
+		t.Fatalf("want error copying into a file, got nil")
+	}
+}
+
+// TestCopier_CopyAll_Shared tests that the sources are copied by one pool
+// of workers, so that single files are copied at once rather than in turn.
+func TestCopier_CopyAll_Shared(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	var sources []string
+	for ii := 0; ii < 4; ii++ {
+		path := fmt.Sprintf("%d.txt", ii)
+		if err := afero.WriteFile(fs, path, []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+		sources = append(sources, path)
+	}
+	var active, most atomic.Int64
+	copier := Copier{
+		Fs:       fs,
+		Parallel: 4,
+		Transform: func(path string, r io.Reader) (io.Reader, error) {
+			n := active.Add(1)
+			defer active.Add(-1)
+			for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+			}
+			time.Sleep(20 * time.Millisecond)
+			return r, nil
+		},
+	}
+	report, err := copier.CopyAll(sources, "dest")
+	if err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if len(report.Copied) != 4 {
+		t.Fatalf("want 4 files copied, got %d", len(report.Copied))
+	}
+	if most.Load() < 2 {
+		t.Errorf("want sources copied at once, got %d at most", most.Load())
+	}
+}
+
+// TestCopier_SkipHidden tests that dotfiles are left out, and hidden
+// directories not descended into.
+func TestCopier_SkipHidden(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/a.txt", "from/.b.txt", "from/.git/config", "from/c/.d", "from/c/e.txt"} {
+		if err := afero.WriteFile(fs, path, []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs, SkipHidden: true}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	for path, want := range map[string]bool{
+		"to/a.txt":   true,
+		"to/c/e.txt": true,
+		"to/.b.txt":  false,
+		"to/.git":    false,
+		"to/c/.d":    false,
+	} {
+		if ok, _ := afero.Exists(fs, path); ok != want {
+			t.Errorf("%s: want exists %t, got %t", path, want, ok)
+		}
+	}
+}
+
+// TestCopier_IgnoreFile tests that the patterns of the ignore file at the
+// root leave out what they match, at any depth unless anchored, and that
+// nested ignore files apply beneath their directories once enabled.
+func TestCopier_IgnoreFile(t *testing.T) {
+	files := map[string]string{
+		"from/.cpignore":      "# build output\n*.o\n/tmp/\nlogs/\n!keep.o\n",
+		"from/a.c":            "",
+		"from/a.o":            "",
+		"from/keep.o":         "",
+		"from/tmp/x":          "",
+		"from/sub/tmp/y":      "",
+		"from/sub/b.o":        "",
+		"from/sub/logs/z":     "",
+		"from/sub/.cpignore":  "*.txt\n",
+		"from/sub/c.txt":      "",
+		"from/sub/deep/d.txt": "",
+	}
+	for _, tt := range []struct {
+		nested bool
+		want   map[string]bool
+	}{
+		{false, map[string]bool{
+			"to/a.c": true, "to/keep.o": true, "to/sub/tmp/y": true, "to/sub/c.txt": true,
+			"to/a.o": false, "to/tmp": false, "to/sub/b.o": false, "to/sub/logs": false,
+		}},
+		{true, map[string]bool{
+			"to/a.c": true, "to/sub/tmp/y": true,
+			"to/sub/c.txt": false, "to/sub/deep/d.txt": false,
+		}},
+	} {
+		fs := afero.NewMemMapFs()
+		for path, data := range files {
+			if err := afero.WriteFile(fs, path, []byte(data), 0644); err != nil {
+				t.Fatalf("unexpected error while writing file: %v", err)
+			}
+		}
+		copier := Copier{Fs: fs, IgnoreFile: ".cpignore", NestedIgnoreFiles: tt.nested}
+		if err := copier.Copy("from", "to"); err != nil {
+			t.Fatalf("unexpected error while copying: %v", err)
+		}
+		for path, want := range tt.want {
+			if ok, _ := afero.Exists(fs, path); ok != want {
+				t.Errorf("nested %t: %s: want exists %t, got %t", tt.nested, path, want, ok)
+			}
+		}
+	}
+}
+
+// TestCopier_Mirror tests that mirroring copies new and changed files,
+// leaves unchanged ones, and removes what the source doesn't have, apart
+// from excluded files unless DeleteExcluded is set.
+func TestCopier_Mirror(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/a.txt", "from/b.txt", "from/dir/c.txt"} {
+		if err := afero.WriteFile(fs, path, []byte(path), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs, ExcludeRegexp: []*regexp.Regexp{regexp.MustCompile(`\.log$`)}}
+	report, err := copier.Mirror("from", "to")
+	if err != nil {
+		t.Fatalf("unexpected error while mirroring: %v", err)
+	}
+	if len(report.Copied) != 3 {
+		t.Fatalf("want 3 files copied, got %+v", report.Copied)
+	}
+	afero.WriteFile(fs, "from/b.txt", []byte("changed"), 0644)
+	fs.Chtimes("from/b.txt", time.Now(), time.Now().Add(time.Hour))
+	for _, path := range []string{"to/stale.txt", "to/old/d.txt", "to/keep.log"} {
+		afero.WriteFile(fs, path, []byte(path), 0644)
+	}
+	report, err = copier.Mirror("from", "to")
+	if err != nil {
+		t.Fatalf("unexpected error while mirroring: %v", err)
+	}
+	if len(report.Copied)+len(report.Overwritten) != 1 || len(report.Skipped) != 2 {
+		t.Errorf("want only b.txt copied, got %+v", report)
+	}
+	if got, _ := afero.ReadFile(fs, "to/b.txt"); string(got) != "changed" {
+		t.Errorf("want b.txt updated, got %q", got)
+	}
+	var removed []string
+	for _, f := range report.Removed {
+		removed = append(removed, f.To)
+	}
+	sort.Strings(removed)
+	want := []string{filepath.Join("to", "old"), filepath.Join("to", "stale.txt")}
+	if !reflect.DeepEqual(removed, want) {
+		t.Errorf("want %v removed, got %v", want, removed)
+	}
+	if ok, _ := afero.Exists(fs, "to/keep.log"); !ok {
+		t.Errorf("want excluded file left alone")
+	}
+	copier.DeleteExcluded = true
+	if _, err := copier.Mirror("from", "to"); err != nil {
+		t.Fatalf("unexpected error while mirroring: %v", err)
+	}
+	if ok, _ := afero.Exists(fs, "to/keep.log"); ok {
+		t.Errorf("want excluded file removed with DeleteExcluded")
+	}
+}
+
+// TestCopier_Move tests that a move renames in place on one filesystem, and
+// between two copies and then removes what it copied, leaving behind what
+// was left out.
+func TestCopier_Move(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/a.txt", "from/dir/b.txt", "from/skip/c.log"} {
+		if err := afero.WriteFile(fs, path, []byte(path), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs}
+	report, err := copier.Move("from", "moved")
+	if err != nil {
+		t.Fatalf("unexpected error while moving: %v", err)
+	}
+	if len(report.Renamed) != 1 || len(report.Copied) != 0 {
+		t.Fatalf("want from renamed whole, got %+v", report)
+	}
+	if ok, _ := afero.Exists(fs, "from"); ok {
+		t.Errorf("want from gone after renaming")
+	}
+	dst := afero.NewMemMapFs()
+	copier = Copier{SrcFs: fs, DstFs: dst, ExcludeRegexp: []*regexp.Regexp{regexp.MustCompile(`\.log$`)}}
+	report, err = copier.Move("moved", "to")
+	if err != nil {
+		t.Fatalf("unexpected error while moving: %v", err)
+	}
+	if len(report.Copied) != 2 || len(report.Renamed) != 0 {
+		t.Fatalf("want 2 files copied across, got %+v", report)
+	}
+	if got, _ := afero.ReadFile(dst, "to/dir/b.txt"); string(got) != "from/dir/b.txt" {
+		t.Errorf("want b.txt moved, got %q", got)
+	}
+	for path, want := range map[string]bool{"moved/a.txt": false, "moved/dir": false, "moved/skip/c.log": true} {
+		if ok, _ := afero.Exists(fs, path); ok != want {
+			t.Errorf("want %s left behind %v, got %v", path, want, ok)
+		}
+	}
+}
+
+// TestCopier_Move_MetadataOnly tests that a move refuses to run when it
+// would remove sources whose contents weren't copied.
+func TestCopier_Move_MetadataOnly(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/a.txt", "to/a.txt"} {
+		if err := afero.WriteFile(fs, path, []byte(path), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs, MetadataOnly: true}
+	if _, err := copier.Move("from", "to"); err == nil {
+		t.Fatalf("want an error moving with MetadataOnly")
+	}
+	if got, _ := afero.ReadFile(fs, "from/a.txt"); string(got) != "from/a.txt" {
+		t.Errorf("want the source left alone, got %q", got)
+	}
+}
+
+// TestCopier_Move_Refused tests that a move refused before copying anything
+// leaves the source alone, empty directories included.
+func TestCopier_Move_Refused(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := fs.MkdirAll("from/empty", 0755); err != nil {
+		t.Fatalf("unexpected error while making directory: %v", err)
+	}
+	if err := afero.WriteFile(fs, "to", []byte("to"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	copier := Copier{Fs: fs}
+	if _, err := copier.Move("from", "to"); !errors.As(err, &ErrClobberAvoided{}) {
+		t.Fatalf("want ErrClobberAvoided, got %v", err)
+	}
+	if ok, _ := afero.DirExists(fs, "from/empty"); !ok {
+		t.Errorf("want from/empty left after a refused move")
+	}
+}
+
+// TestCopier_Move_Excluded tests that a move keeps the source directories
+// it left out, even those that are empty.
+func TestCopier_Move_Excluded(t *testing.T) {
+	src, dst := afero.NewMemMapFs(), afero.NewMemMapFs()
+	if err := afero.WriteFile(src, "from/a.txt", []byte("a"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	for _, dir := range []string{"from/cache", "from/empty"} {
+		if err := src.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("unexpected error while making directory: %v", err)
+		}
+	}
+	copier := Copier{SrcFs: src, DstFs: dst, ExcludeRegexp: []*regexp.Regexp{regexp.MustCompile(`^cache$`)}}
+	if _, err := copier.Move("from", "to"); err != nil {
+		t.Fatalf("unexpected error while moving: %v", err)
+	}
+	for path, want := range map[string]bool{"from/a.txt": false, "from/empty": false, "from/cache": true} {
+		if ok, _ := afero.Exists(src, path); ok != want {
+			t.Errorf("want %s left behind %v, got %v", path, want, ok)
+		}
+	}
+}
+
+// TestCopier_Parents tests that sources keep their whole path beneath the
+// destination.
+func TestCopier_Parents(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"a/b/c.txt", "a/d/e.txt", "../up.txt"} {
+		if err := afero.WriteFile(fs, path, []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs, Parents: true}
+	if _, err := copier.CopyAll([]string{"a/b/c.txt", "a/d"}, "dest"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	for _, path := range []string{"dest/a/b/c.txt", "dest/a/d/e.txt"} {
+		if ok, _ := afero.Exists(fs, path); !ok {
+			t.Errorf("want %s to exist", path)
+		}
+	}
+	if _, err := copier.CopyAll([]string{"../up.txt"}, "dest"); err == nil {
+		t.Errorf("want error copying a path that escapes the destination")
+	}
+}
+
+// TestCopier_CopyPaths tests that listed paths keep their relative structure
+// and that paths escaping the destination are refused.
+func TestCopier_CopyPaths(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"a/foo.exe", "a/b/bar.exe", "a/b/baz.exe"} {
+		if err := afero.WriteFile(fs, path, []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs}
+	report, err := copier.CopyPaths([]string{"a/foo.exe", "a/b", "a/b/bar.exe"}, "dest")
+	if err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if len(report.Copied) != 2 {
+		t.Fatalf("want 2 files copied, got %+v", report.Copied)
+	}
+	for _, path := range []string{"dest/a/foo.exe", "dest/a/b/bar.exe"} {
+		if ok, _ := afero.Exists(fs, path); !ok {
+			t.Errorf("want %s to exist", path)
+		}
+	}
+	if ok, _ := afero.Exists(fs, "dest/a/b/baz.exe"); ok {
+		t.Errorf("want unlisted file to be left alone")
+	}
+	if _, err := copier.CopyPaths([]string{"../a/foo.exe"}, "dest"); err == nil {
+		t.Fatalf("want error for path escaping the destination, got nil")
+	}
+}
+
+// TestCopier_CopyPaths_Clobber tests that a listed file isn't copied over an
+// existing file without Clobber, while listed directories are merged into.
+func TestCopier_CopyPaths_Clobber(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for path, data := range map[string]string{"a/b.txt": "new", "a/c.txt": "new", "dest/a/b.txt": "old"} {
+		if err := afero.WriteFile(fs, path, []byte(data), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs}
+	report, err := copier.CopyPaths([]string{"a", "a/b.txt", "a/c.txt"}, "dest")
+	if !errors.As(err, &ErrClobberAvoided{}) {
+		t.Fatalf("want ErrClobberAvoided, got %v", err)
+	}
+	if len(report.Copied) != 1 || len(report.Failed) != 1 {
+		t.Errorf("want a/c.txt copied and a/b.txt refused, got %+v", report)
+	}
+	for path, want := range map[string]string{"dest/a/b.txt": "old", "dest/a/c.txt": "new"} {
+		if got, _ := afero.ReadFile(fs, path); string(got) != want {
+			t.Errorf("want %s to hold %q, got %q", path, want, got)
+		}
+	}
+}
+
+// TestCopier_CopyGlob tests that only matching paths are copied.
+func TestCopier_CopyGlob(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"build/a.tar.gz", "build/b.tar.gz", "build/c.txt", "build/d.tar.gz/e"} {
+		if err := afero.WriteFile(fs, path, []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs}
+	if _, err := copier.CopyGlob("build/*.tar.gz", "dist"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	for _, path := range []string{"dist/a.tar.gz", "dist/b.tar.gz", "dist/d.tar.gz/e"} {
+		if ok, _ := afero.Exists(fs, path); !ok {
+			t.Errorf("want %s to exist", path)
+		}
+	}
+	if ok, _ := afero.Exists(fs, "dist/c.txt"); ok {
+		t.Errorf("want non-matching file to be left alone")
+	}
+	if _, err := copier.CopyGlob("build/*.zip", "dist"); err == nil {
+		t.Fatalf("want error for pattern without matches, got nil")
+	}
+}
+
+// TestCopier_CopyGlob_Clobber tests that a match isn't copied over an
+// existing file without Clobber.
+func TestCopier_CopyGlob_Clobber(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for path, data := range map[string]string{"build/a.tar.gz": "new", "dist/a.tar.gz": "old"} {
+		if err := afero.WriteFile(fs, path, []byte(data), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs}
+	if _, err := copier.CopyGlob("build/*.tar.gz", "dist"); !errors.As(err, &ErrClobberAvoided{}) {
+		t.Fatalf("want ErrClobberAvoided, got %v", err)
+	}
+	if got, _ := afero.ReadFile(fs, "dist/a.tar.gz"); string(got) != "old" {
+		t.Errorf("want the existing file left alone, got %q", got)
+	}
+}
+
+// TestCopier_CopyGlob_Conflict tests that "**" matches meeting existing
+// files are refused without Clobber, the others still copied, and that
+// OnConflict is asked about each of them when set.
+func TestCopier_CopyGlob_Conflict(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for path, data := range map[string]string{"src/a.go": "new", "src/b/c.go": "new", "dist/c.go": "old"} {
+		if err := afero.WriteFile(fs, path, []byte(data), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs}
+	if _, err := copier.CopyGlob("src/**/*.go", "dist"); !errors.As(err, &ErrClobberAvoided{}) {
+		t.Fatalf("want ErrClobberAvoided, got %v", err)
+	}
+	for path, want := range map[string]string{"dist/a.go": "new", "dist/c.go": "old"} {
+		if got, _ := afero.ReadFile(fs, path); string(got) != want {
+			t.Errorf("want %s to hold %q, got %q", path, want, got)
+		}
+	}
+	var asked []string
+	copier = Copier{Fs: fs, OnConflict: func(from, to string) Conflict {
+		asked = append(asked, from)
+		return ConflictSkip
+	}}
+	report, err := copier.CopyGlob("src/**/*.go", "dist")
+	if err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	sort.Strings(asked)
+	if !reflect.DeepEqual(asked, []string{"src/a.go", "src/b/c.go"}) || len(report.Skipped) != 2 {
+		t.Errorf("want both matches asked about and skipped, got %v and %+v", asked, report)
+	}
+	if got, _ := afero.ReadFile(fs, "dist/c.go"); string(got) != "old" {
+		t.Errorf("want the existing file skipped, got %q", got)
+	}
+}
+
+// TestGlob tests that "**" matches any number of directories, none
+// included, and that nothing is matched beneath a matching directory.
+func TestGlob(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"src/a.go", "src/b/c.go", "src/b/d/e.go", "src/b/f.txt", "src/g.go/h.go"} {
+		if err := afero.WriteFile(fs, path, []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	for _, tt := range []struct {
+		pattern string
+		want    []string
+	}{
+		{"src/**/*.go", []string{"src/a.go", "src/b/c.go", "src/b/d/e.go", "src/g.go"}},
+		{"src/b/**", []string{"src/b/c.go", "src/b/d", "src/b/f.txt"}},
+		{"**/d/*.go", []string{"src/b/d/e.go"}},
+		{"src/*/*.txt", []string{"src/b/f.txt"}},
+	} {
+		got, err := Glob(fs, tt.pattern)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.pattern, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: want %v, got %v", tt.pattern, tt.want, got)
+		}
+	}
+	if _, err := Glob(fs, "src/**/[.go"); err == nil {
+		t.Errorf("want error for bad pattern, got nil")
+	}
+}
+
+// TestCopier_CopyAll_TrailingSlash tests that a source with a trailing slash
+// has its contents copied rather than itself.
+func TestCopier_CopyAll_TrailingSlash(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "src/foo.exe", []byte("data"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	copier := Copier{Fs: fs, Clobber: true}
+	if _, err := copier.CopyAll([]string{"src"}, "dest"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if ok, _ := afero.Exists(fs, "dest/src/foo.exe"); !ok {
+		t.Errorf("want directory copied into destination")
+	}
+	if _, err := copier.CopyAll([]string{"src/"}, "contents"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if ok, _ := afero.Exists(fs, "contents/foo.exe"); !ok {
+		t.Errorf("want directory contents copied into destination")
+	}
+}
+
+// TestCopier_IncludeRoot tests that the source directory itself is copied
+// into the destination when asked.
+func TestCopier_IncludeRoot(t *testing.T) {
+	tests := []struct {
+		desc        string
+		from        string
+		includeRoot bool
+		want        string
+	}{
+		{"contents", "photos", false, "backup/foo.jpg"},
+		{"root", "photos", true, "backup/photos/foo.jpg"},
+		{"root with trailing slash", "photos/", true, "backup/photos/foo.jpg"},
+	}
+	for _, tt := range tests {
+		fs := afero.NewMemMapFs()
+		if err := afero.WriteFile(fs, "photos/foo.jpg", []byte("data"), 0644); err != nil {
+			t.Fatalf("[%s] unexpected error while writing file: %v", tt.desc, err)
+		}
+		copier := Copier{
+			Fs:          fs,
+			IncludeRoot: tt.includeRoot,
+		}
+		if err := copier.Copy(tt.from, "backup"); err != nil {
+			t.Fatalf("[%s] unexpected error while copying: %v", tt.desc, err)
+		}
+		if ok, _ := afero.Exists(fs, tt.want); !ok {
+			t.Errorf("[%s] want %s to exist", tt.desc, tt.want)
+		}
+	}
+}
+
+// TestCopier_MaxBytesPerSecond tests that the workers together respect the
+// rate limit.
+func TestCopier_MaxBytesPerSecond(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/foo.exe", "from/bar.exe", "from/baz.exe"} {
+		if err := afero.WriteFile(fs, path, make([]byte, 50<<10), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{
+		Fs:                fs,
+		MaxBytesPerSecond: 100 << 10,
+	}
+	start := time.Now()
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	// The first second's worth is allowed as a burst, the remaining 50KiB
+	// takes half a second.
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("want copy to be throttled, took %v", elapsed)
+	}
+}
+
+// TestCopier_MaxOpenFiles tests that a small open file budget doesn't starve
+// the workers.
+func TestCopier_MaxOpenFiles(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for ii := 0; ii < 50; ii++ {
+		if err := afero.WriteFile(fs, fmt.Sprintf("from/%d.exe", ii), []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{
+		Fs:           fs,
+		Parallel:     20,
+		MaxOpenFiles: 3,
+	}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if stats := copier.Stats(); stats.Files != 50 {
+		t.Fatalf("want 50 files copied, got %d", stats.Files)
+	}
+}
+
+// TestQueue_LargestFirst tests that queued jobs are handed out largest first.
+func TestQueue_LargestFirst(t *testing.T) {
+	q := newQueue(OrderLargestFirst, 0)
+	for _, size := range []int64{3, 100, 0, 42, 7} {
+		q.push(job{Size: size})
+	}
+	q.close()
+	var got []int64
+	for {
+		j, ok := q.pop()
+		if !ok {
+			break
+		}
+		got = append(got, j.Size)
+	}
+	want := []int64{100, 42, 7, 3, 0}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("want jobs in order %v, got %v", want, got)
+	}
+}
+
+// TestQueue_Order tests the other orders, and that a full queue holds up
+// pushes until a job is taken.
+func TestQueue_Order(t *testing.T) {
+	paths := []string{"b/1", "a/1", "b/2", "c/1", "a/2"}
+	for order, want := range map[Order][]string{
+		OrderFIFO:     paths,
+		OrderLocality: {"a/1", "a/2", "b/1", "b/2", "c/1"},
+	} {
+		q := newQueue(order, 0)
+		for _, path := range paths {
+			q.push(job{From: path})
+		}
+		q.close()
+		var got []string
+		for {
+			j, ok := q.pop()
+			if !ok {
+				break
+			}
+			got = append(got, j.From)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("order %d: want jobs in order %v, got %v", order, want, got)
+		}
+	}
+	q := newQueue(OrderFIFO, 1)
+	q.push(job{From: "first"})
+	pushed := make(chan struct{})
+	go func() {
+		q.push(job{From: "second"})
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+		t.Fatalf("want push to wait for room in a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if j, _ := q.pop(); j.From != "first" {
+		t.Fatalf("want first job, got %q", j.From)
+	}
+	<-pushed
+}
+
+// TestCopier_ChunkThreshold tests that a file split into ranges is copied
+// intact.
+func TestCopier_ChunkThreshold(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	data := make([]byte, 1<<20+7)
+	for ii := range data {
+		data[ii] = byte(ii % 251)
+	}
+	if err := afero.WriteFile(fs, "big.img", data, 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	copier := Copier{
+		Fs:             fs,
+		ChunkThreshold: 1 << 10,
+		Chunks:         7,
+	}
+	report, err := copier.CopyReport("big.img", "copy.img")
+	if err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if report.Copied[0].Bytes != int64(len(data)) {
+		t.Fatalf("want %d bytes copied, got %d", len(data), report.Copied[0].Bytes)
+	}
+	got, err := afero.ReadFile(fs, "copy.img")
+	if err != nil {
+		t.Fatalf("unexpected error while reading copy: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("copy does not match the original")
+	}
+}
+
+// corruptFs is an afero.Fs whose files have the first byte of each write
+// past the start flipped, spoiling every range but the first.
+type corruptFs struct{ afero.Fs }
+
+func (fs corruptFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	f, err := fs.Fs.OpenFile(name, flag, perm)
+	return corruptFile{f}, err
+}
+
+type corruptFile struct{ afero.File }
+
+func (f corruptFile) WriteAt(p []byte, off int64) (int, error) {
+	if off > 0 && len(p) > 0 {
+		p = append([]byte{^p[0]}, p[1:]...)
+	}
+	return f.File.WriteAt(p, off)
+}
+
+// TestCopier_ChunkThreshold_Corrupt tests that a chunked copy whose ranges
+// arrive the right size but wrong fails.
+func TestCopier_ChunkThreshold_Corrupt(t *testing.T) {
+	src := afero.NewMemMapFs()
+	data := make([]byte, 1<<20+7)
+	for ii := range data {
+		data[ii] = byte(ii % 251)
+	}
+	if err := afero.WriteFile(src, "big.img", data, 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	dst := afero.NewMemMapFs()
+	copier := Copier{
+		SrcFs:          src,
+		DstFs:          corruptFs{dst},
+		ChunkThreshold: 1 << 10,
+		Chunks:         4,
+	}
+	if err := copier.Copy("big.img", "copy.img"); err == nil || !strings.Contains(err.Error(), "doesn't match") {
+		t.Fatalf("want an error copying corrupted ranges, got %v", err)
+	}
+}
+
+// TestCopier_Mmap tests that files copied from memory maps arrive intact,
+// both above and below the threshold.
+func TestCopier_Mmap(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 9<<20+7)
+	for ii := range data {
+		data[ii] = byte(ii % 251)
+	}
+	files := map[string][]byte{"big.img": data, "small.txt": []byte("small")}
+	if err := os.MkdirAll(filepath.Join(dir, "from"), 0755); err != nil {
+		t.Fatalf("unexpected error while creating directory: %v", err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, "from", name), content, 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{
+		Strategy:      StrategyMmap,
+		MmapThreshold: 1 << 10,
+	}
+	if err := copier.Copy(filepath.Join(dir, "from"), filepath.Join(dir, "to")); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	for name, content := range files {
+		got, err := os.ReadFile(filepath.Join(dir, "to", name))
+		if err != nil {
+			t.Fatalf("unexpected error while reading copy: %v", err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("copy of %s does not match the original", name)
+		}
+	}
+}
+
+// TestCopier_MetadataOnly tests that the destination is given the modes and
+// times of the source without its contents being rewritten or missing files
+// created.
+func TestCopier_MetadataOnly(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	stamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	for path, mode := range map[string]os.FileMode{"from/a.txt": 0600, "from/dir/b.txt": 0640, "from/dir/new.txt": 0644} {
+		if err := afero.WriteFile(fs, path, []byte("source"), mode); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+		if err := fs.Chtimes(path, stamp, stamp); err != nil {
+			t.Fatalf("unexpected error while setting times: %v", err)
+		}
+	}
+	if err := fs.Chmod("from/dir", 0700); err != nil {
+		t.Fatalf("unexpected error while setting mode: %v", err)
+	}
+	for _, path := range []string{"to/a.txt", "to/dir/b.txt"} {
+		if err := afero.WriteFile(fs, path, []byte("copied"), 0666); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs, MetadataOnly: true}
+	report, err := copier.CopyReport("from", "to")
+	if err != nil {
+		t.Fatalf("unexpected error while touching up: %v", err)
+	}
+	if len(report.Copied) != 2 || len(report.Skipped) != 1 {
+		t.Fatalf("want 2 files touched up and 1 skipped, got %+v", report)
+	}
+	if _, err := fs.Stat("to/dir/new.txt"); !os.IsNotExist(err) {
+		t.Fatalf("want missing file left missing, got %v", err)
+	}
+	for path, mode := range map[string]os.FileMode{"to/a.txt": 0600, "to/dir/b.txt": 0640, "to/dir": os.ModeDir | 0700} {
+		fi, err := fs.Stat(path)
+		if err != nil {
+			t.Fatalf("unexpected error while reading file metadata: %v", err)
+		}
+		if fi.Mode() != mode {
+			t.Errorf("want %s to have mode %v, got %v", path, mode, fi.Mode())
+		}
+		if !fi.IsDir() && !fi.ModTime().Equal(stamp) {
+			t.Errorf("want %s modified at %v, got %v", path, stamp, fi.ModTime())
+		}
+		if got, _ := afero.ReadFile(fs, path); !fi.IsDir() && string(got) != "copied" {
+			t.Errorf("want %s left as copied, got %q", path, got)
+		}
+	}
+}
+
+// TestCopier_Compare tests that missing, extra and changed files are found
+// without anything being copied.
+func TestCopier_Compare(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	stamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := []struct {
+		path    string
+		content string
+		mode    os.FileMode
+	}{
+		{"from/same.txt", "same", 0644},
+		{"to/same.txt", "same", 0644},
+		{"from/content.txt", "before", 0644},
+		{"to/content.txt", "after!", 0644},
+		{"from/mode.txt", "mode", 0644},
+		{"to/mode.txt", "mode", 0600},
+		{"from/dir/missing.txt", "missing", 0644},
+		{"to/extra.txt", "extra", 0644},
+		{"to/skip.log", "excluded", 0644},
+	}
+	for _, f := range files {
+		if err := afero.WriteFile(fs, f.path, []byte(f.content), f.mode); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+		if err := fs.Chtimes(f.path, stamp, stamp); err != nil {
+			t.Fatalf("unexpected error while setting times: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs, ExcludeRegexp: []*regexp.Regexp{regexp.MustCompile(`\.log$`)}}
+	diff, err := copier.Compare("from", "to")
+	if err != nil {
+		t.Fatalf("unexpected error while comparing: %v", err)
+	}
+	want := Diff{
+		Missing: []string{filepath.Join("to", "dir"), filepath.Join("to", "dir", "missing.txt")},
+		Extra:   []string{filepath.Join("to", "extra.txt")},
+		Changed: []Mismatch{
+			{From: filepath.Join("from", "content.txt"), To: filepath.Join("to", "content.txt"), Content: true},
+			{From: filepath.Join("from", "mode.txt"), To: filepath.Join("to", "mode.txt"), Mode: true},
+		},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Fatalf("want %+v, got %+v", want, diff)
+	}
+	if _, err := fs.Stat("to/dir"); !os.IsNotExist(err) {
+		t.Fatalf("want nothing copied, got %v", err)
+	}
+}
+
+// TestCopier_Manifest tests that the files copied are listed with their
+// hashes, relative to the destination in the manifest file.
+func TestCopier_Manifest(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	files := map[string]string{"from/a.txt": "alpha", "from/dir/b.txt": "beta"}
+	for path, content := range files {
+		if err := afero.WriteFile(fs, path, []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	sum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	manifest := &bytes.Buffer{}
+	copier := Copier{Fs: fs, Manifest: manifest, ManifestFile: "SHA256SUMS"}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	want := fmt.Sprintf("%s  %s\n%s  %s\n",
+		sum("alpha"), filepath.Join("to", "a.txt"),
+		sum("beta"), filepath.Join("to", "dir", "b.txt"))
+	if manifest.String() != want {
+		t.Errorf("want manifest %q, got %q", want, manifest.String())
+	}
+	got, err := afero.ReadFile(fs, filepath.Join("to", "SHA256SUMS"))
+	if err != nil {
+		t.Fatalf("unexpected error while reading manifest: %v", err)
+	}
+	want = fmt.Sprintf("%s  a.txt\n%s  dir/b.txt\n", sum("alpha"), sum("beta"))
+	if string(got) != want {
+		t.Errorf("want manifest file %q, got %q", want, got)
+	}
+}
+
+// TestCopier_VerifyManifest tests that files listed in a manifest are
+// reported missing or changed once removed or modified.
+func TestCopier_VerifyManifest(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/a.txt", "from/b.txt", "from/dir/c.txt"} {
+		if err := afero.WriteFile(fs, path, []byte(path), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs, ManifestFile: "SHA256SUMS"}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	diff, err := copier.VerifyManifest("to/SHA256SUMS", "to")
+	if err != nil {
+		t.Fatalf("unexpected error while verifying: %v", err)
+	}
+	if !diff.Equal() {
+		t.Errorf("want no differences, got %+v", diff)
+	}
+	fs.Remove("to/a.txt")
+	afero.WriteFile(fs, "to/dir/c.txt", []byte("changed"), 0644)
+	diff, err = copier.VerifyManifest("to/SHA256SUMS", "to")
+	if err != nil {
+		t.Fatalf("unexpected error while verifying: %v", err)
+	}
+	want := Diff{
+		Missing: []string{filepath.Join("to", "a.txt")},
+		Changed: []Mismatch{{To: filepath.Join("to", "dir", "c.txt"), Content: true}},
+	}
+	if !reflect.DeepEqual(diff, want) {
+		t.Errorf("want %+v, got %+v", want, diff)
+	}
+}
+
+// TestCopier_Hasher tests that the manifest is hashed with the Hasher
+// chosen.
+func TestCopier_Hasher(t *testing.T) {
+	for name, hasher := range map[string]Hasher{
+		"sha1":     SHA1,
+		"xxhash64": XXHash64,
+		"blake3":   BLAKE3,
+		"crc32c":   CRC32C,
+	} {
+		fs := afero.NewMemMapFs()
+		if err := afero.WriteFile(fs, "from/a.txt", []byte("alpha"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+		manifest := &bytes.Buffer{}
+		copier := Copier{Fs: fs, Manifest: manifest, Hasher: hasher}
+		if err := copier.Copy("from", "to"); err != nil {
+			t.Fatalf("%s: unexpected error while copying: %v", name, err)
+		}
+		h := hasher()
+		h.Write([]byte("alpha"))
+		want := fmt.Sprintf("%x  %s\n", h.Sum(nil), filepath.Join("to", "a.txt"))
+		if manifest.String() != want {
+			t.Errorf("%s: want manifest %q, got %q", name, want, manifest.String())
+		}
+	}
+}
+
+// TestCopier_HashWorkers tests that hashing on a pool of its own gives the
+// same manifest as hashing in the copying workers.
+func TestCopier_HashWorkers(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	files := map[string]string{}
+	for ii := 0; ii < 20; ii++ {
+		files[filepath.Join("from", fmt.Sprintf("file%02d.txt", ii))] = strings.Repeat("x", ii)
+	}
+	for path, content := range files {
+		if err := afero.WriteFile(fs, path, []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	want := &bytes.Buffer{}
+	if err := (&Copier{Fs: fs, Manifest: want}).Copy("from", "want"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	got := &bytes.Buffer{}
+	copier := Copier{Fs: fs, Manifest: got, Clobber: true, HashWorkers: 3}
+	report, err := copier.CopyReport("from", "want")
+	if err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if len(report.Overwritten) != len(files) {
+		t.Errorf("want %d files copied, got %d", len(files), len(report.Overwritten))
+	}
+	if got.String() != want.String() {
+		t.Errorf("want manifest %q, got %q", want.String(), got.String())
+	}
+}
+
+// TestCopier_Walkers tests that walking many directories at once copies the
+// whole tree, and every directory in it.
+func TestCopier_Walkers(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	var want []string
+	for ii := 0; ii < 20; ii++ {
+		for jj := 0; jj < 5; jj++ {
+			path := filepath.Join(fmt.Sprintf("dir%d", ii), fmt.Sprintf("sub%d", jj%2), fmt.Sprintf("file%d.txt", jj))
+			if err := afero.WriteFile(fs, filepath.Join("from", path), []byte(path), 0644); err != nil {
+				t.Fatalf("unexpected error while writing file: %v", err)
+			}
+			want = append(want, path)
+		}
+	}
+	if err := fs.MkdirAll(filepath.Join("from", "empty", "dir"), 0755); err != nil {
+		t.Fatalf("unexpected error while creating directory: %v", err)
+	}
+	for _, follow := range []bool{false, true} {
+		to := fmt.Sprintf("to-%v", follow)
+		copier := Copier{Fs: fs, Walkers: 8, FollowSymlinks: follow}
+		report, err := copier.CopyReport("from", to)
+		if err != nil {
+			t.Fatalf("unexpected error while copying: %v", err)
+		}
+		if len(report.Copied) != len(want) {
+			t.Fatalf("want %d files copied, got %d", len(want), len(report.Copied))
+		}
+		for _, path := range want {
+			got, err := afero.ReadFile(fs, filepath.Join(to, path))
+			if err != nil || string(got) != path {
+				t.Fatalf("want %s copied, got %q, %v", path, got, err)
+			}
+		}
+		if fi, err := fs.Stat(filepath.Join(to, "empty", "dir")); err != nil || !fi.IsDir() {
+			t.Fatalf("want empty directory copied, got %v", err)
+		}
+	}
+}
+
+// TestCopier_Reuse tests that a second copy with the same Copier copies
+// everything again, unless SkipCopied is set.
+func TestCopier_Reuse(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		fs := afero.NewMemMapFs()
+		if err := afero.WriteFile(fs, "from/file.txt", []byte("first"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+		copier := Copier{Fs: fs, Clobber: true, SkipCopied: skip}
+		if err := copier.Copy("from", "to"); err != nil {
+			t.Fatalf("unexpected error while copying: %v", err)
+		}
+		if err := afero.WriteFile(fs, "from/file.txt", []byte("second"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+		report, err := copier.CopyReport("from", "to")
+		if err != nil {
+			t.Fatalf("unexpected error while copying again: %v", err)
+		}
+		want := "second"
+		if skip {
+			want = "first"
+		}
+		if got, _ := afero.ReadFile(fs, "to/file.txt"); string(got) != want {
+			t.Errorf("SkipCopied %v: want %q, got %q (report %+v)", skip, want, got, report)
+		}
+	}
+}
+
+// TestCopier_Batch tests that small files copied in batches arrive whole,
+// beside a large one that isn't batched.
+func TestCopier_Batch(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	files := map[string]string{"big.bin": strings.Repeat("b", batchFileSize+1)}
+	for ii := 0; ii < 50; ii++ {
+		files[fmt.Sprintf("dir%d/%d.txt", ii%3, ii)] = strings.Repeat(fmt.Sprint(ii), ii)
+	}
+	for path, content := range files {
+		if err := afero.WriteFile(fs, filepath.Join("from", path), []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs, Parallel: 2, Batch: 8}
+	report, err := copier.CopyReport("from", "to")
+	if err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if len(report.Copied) != len(files) {
+		t.Errorf("want %d files copied, got %d", len(files), len(report.Copied))
+	}
+	for path, want := range files {
+		got, err := afero.ReadFile(fs, filepath.Join("to", path))
+		if err != nil {
+			t.Fatalf("unexpected error while reading %s: %v", path, err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: want %d bytes, got %q", path, len(want), got)
+		}
+	}
+}
+
+// TestCopier_Pipeline tests that a file read ahead through a pipeline is
+// written whole, and that a failed read fails the copy.
+func TestCopier_Pipeline(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	content := make([]byte, 5*pipeBufferSize/2)
+	for ii := range content {
+		content[ii] = byte(ii * 7)
+	}
+	if err := afero.WriteFile(fs, "from/big.bin", content, 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	copier := Copier{Fs: fs, Pipeline: 2}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if got, _ := afero.ReadFile(fs, "to/big.bin"); !bytes.Equal(got, content) {
+		t.Errorf("want %d bytes copied intact, got %d", len(content), len(got))
+	}
+	broken := errors.New("broken")
+	copier = Copier{
+		Fs:       fs,
+		Pipeline: 2,
+		Transform: func(path string, r io.Reader) (io.Reader, error) {
+			return io.MultiReader(io.LimitReader(r, pipeBufferSize+1), iotest.ErrReader(broken)), nil
+		},
+	}
+	if err := copier.Copy("from", "failed"); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("want the read error, got %v", err)
+	}
+}
+
+// readFunc is a function standing in for a Reader.
+type readFunc func(p []byte) (int, error)
+
+func (f readFunc) Read(p []byte) (int, error) { return f(p) }
+
+// TestCopier_BufferSize tests that files are read through a buffer of
+// BufferSize and copied intact.
+func TestCopier_BufferSize(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	content := make([]byte, 10000)
+	for ii := range content {
+		content[ii] = byte(ii * 7)
+	}
+	if err := afero.WriteFile(fs, "from/file.bin", content, 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	var largest int
+	copier := Copier{
+		Fs:         fs,
+		BufferSize: 1000,
+		Transform: func(path string, r io.Reader) (io.Reader, error) {
+			return readFunc(func(p []byte) (int, error) {
+				largest = max(largest, len(p))
+				return r.Read(p)
+			}), nil
+		},
+	}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if largest != 1000 {
+		t.Errorf("want reads of 1000 bytes, got %d", largest)
+	}
+	if got, _ := afero.ReadFile(fs, "to/file.bin"); !bytes.Equal(got, content) {
+		t.Errorf("want %d bytes copied intact, got %d", len(content), len(got))
+	}
+}
+
+// TestCopier_CopyRange tests that ranges are written at their offset, and
+// that one starting at the end of the destination appends to it.
+func TestCopier_CopyRange(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "from.txt", []byte("0123456789"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	copier := Copier{Fs: fs}
+	for _, tt := range []struct {
+		offset, length, n int64
+		want              string
+	}{
+		{0, 3, 3, "012"},
+		{6, 2, 2, "012\x00\x00\x0067"},
+		{3, 3, 3, "01234567"},
+		{8, -1, 2, "0123456789"},
+		{5, 100, 5, "0123456789"},
+	} {
+		n, err := copier.CopyRange("from.txt", "to/to.txt", tt.offset, tt.length)
+		if err != nil {
+			t.Fatalf("unexpected error while copying range: %v", err)
+		}
+		if n != tt.n {
+			t.Errorf("range %d+%d: want %d bytes copied, got %d", tt.offset, tt.length, tt.n, n)
+		}
+		if got, _ := afero.ReadFile(fs, "to/to.txt"); string(got) != tt.want {
+			t.Errorf("range %d+%d: want %q, got %q", tt.offset, tt.length, tt.want, got)
+		}
+	}
+}
+
+// TestCopier_CopyFileFrom tests that a copy resumes after the offset given,
+// cutting off what lies past the end of the source, and starts again when
+// VerifyResume finds the part already copied differs.
+func TestCopier_CopyFileFrom(t *testing.T) {
+	for _, tt := range []struct {
+		to     string
+		offset int64
+		verify bool
+		n      int64
+	}{
+		{to: "", offset: 0, n: 10},
+		{to: "01234", offset: 5, n: 5},
+		{to: "0123456789abc", offset: 4, n: 6},
+		{to: "01234", offset: 5, verify: true, n: 5},
+		{to: "01x34", offset: 5, verify: true, n: 10},
+	} {
+		fs := afero.NewMemMapFs()
+		if err := afero.WriteFile(fs, "from.txt", []byte("0123456789"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+		if tt.to != "" {
+			if err := afero.WriteFile(fs, "to/to.txt", []byte(tt.to), 0644); err != nil {
+				t.Fatalf("unexpected error while writing file: %v", err)
+			}
+		}
+		copier := Copier{Fs: fs, VerifyResume: tt.verify}
+		n, err := copier.CopyFileFrom("from.txt", "to/to.txt", tt.offset)
+		if err != nil {
+			t.Fatalf("resuming %q at %d: unexpected error: %v", tt.to, tt.offset, err)
+		}
+		if n != tt.n {
+			t.Errorf("resuming %q at %d: want %d bytes copied, got %d", tt.to, tt.offset, tt.n, n)
+		}
+		if got, _ := afero.ReadFile(fs, "to/to.txt"); string(got) != "0123456789" {
+			t.Errorf("resuming %q at %d: want %q, got %q", tt.to, tt.offset, "0123456789", got)
+		}
+	}
+	copier := Copier{Fs: afero.NewMemMapFs()}
+	afero.WriteFile(copier.Fs, "from.txt", []byte("0123456789"), 0644)
+	afero.WriteFile(copier.Fs, "to.txt", []byte("01"), 0644)
+	if _, err := copier.CopyFileFrom("from.txt", "to.txt", 5); err == nil {
+		t.Errorf("resuming past the end of the destination: want error, got nil")
+	}
+}
+
+// TestCopier_Workers tests that the pool runs exactly Parallel workers, one
+// included, and grows from MinParallel up to Parallel while files back up.
+func TestCopier_Workers(t *testing.T) {
+	for _, tt := range []struct {
+		parallel, min int
+		want          func(int64) bool
+	}{
+		{parallel: 1, want: func(n int64) bool { return n == 1 }},
+		{parallel: 4, want: func(n int64) bool { return n == 4 }},
+		{parallel: 4, min: 1, want: func(n int64) bool { return n > 1 && n <= 4 }},
+	} {
+		fs := afero.NewMemMapFs()
+		for ii := 0; ii < 40; ii++ {
+			if err := afero.WriteFile(fs, fmt.Sprintf("from/%d.txt", ii), []byte("data"), 0644); err != nil {
+				t.Fatalf("unexpected error while writing file: %v", err)
+			}
+		}
+		var active, most atomic.Int64
+		copier := Copier{
+			Fs:          fs,
+			Parallel:    tt.parallel,
+			MinParallel: tt.min,
+			Transform: func(path string, r io.Reader) (io.Reader, error) {
+				n := active.Add(1)
+				defer active.Add(-1)
+				for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+				}
+				time.Sleep(5 * time.Millisecond)
+				return r, nil
+			},
+		}
+		report, err := copier.CopyReport("from", "to")
+		if err != nil {
+			t.Fatalf("unexpected error while copying: %v", err)
+		}
+		if len(report.Copied) != 40 {
+			t.Fatalf("want 40 files copied, got %d", len(report.Copied))
+		}
+		if !tt.want(most.Load()) {
+			t.Errorf("Parallel %d, MinParallel %d: unexpected %d workers at once", tt.parallel, tt.min, most.Load())
+		}
+	}
+}
+
+// TestCopier_AutoParallel tests that with Parallel left at zero the pool
+// starts at the number of workers chosen for the storage and is tuned
+// within its bounds while copying.
+func TestCopier_AutoParallel(t *testing.T) {
+	tuneInterval = time.Millisecond
+	defer func() { tuneInterval = time.Second }()
+	if n := workers(storageHDD, storageSSD); n != 2 {
+		t.Errorf("want 2 workers for a spinning disk, got %d", n)
+	}
+	if n, m := workers(storageNetwork, storageSSD), workers(storageSSD, storageSSD); n <= m {
+		t.Errorf("want more workers for the network than an SSD, got %d and %d", n, m)
+	}
+	fs := afero.NewMemMapFs()
+	for ii := 0; ii < 200; ii++ {
+		if err := afero.WriteFile(fs, fmt.Sprintf("from/%d.txt", ii), []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	var active, most atomic.Int64
+	copier := Copier{
+		Fs: fs,
+		Transform: func(path string, r io.Reader) (io.Reader, error) {
+			n := active.Add(1)
+			defer active.Add(-1)
+			for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+			}
+			time.Sleep(time.Millisecond)
+			return r, nil
+		},
+	}
+	report, err := copier.CopyReport("from", "to")
+	if err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if len(report.Copied) != 200 {
+		t.Fatalf("want 200 files copied, got %d", len(report.Copied))
+	}
+	if n := int64(workers(storageSSD, storageSSD)) * 4; most.Load() < 2 || most.Load() > n {
+		t.Errorf("want between 2 and %d workers at once, got %d", n, most.Load())
+	}
+}
+
+// TestCopier_Resume tests that resuming from a journal copies only the
+// files not done, walking the tree again only if its walk didn't finish.
+func TestCopier_Resume(t *testing.T) {
+	for _, walked := range []bool{true, false} {
+		fs := afero.NewMemMapFs()
+		for _, path := range []string{"from/a", "from/dir/b"} {
+			if err := afero.WriteFile(fs, path, []byte("data"), 0644); err != nil {
+				t.Fatalf("unexpected error while writing file: %v", err)
+			}
+		}
+		path := filepath.Join(t.TempDir(), "journal")
+		copier := Copier{Fs: fs, Journal: path}
+		if err := copier.Copy("from", "to"); err != nil {
+			t.Fatalf("unexpected error while copying: %v", err)
+		}
+		// Make out the copy was cut short after a, and maybe before the
+		// walk finished.
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error while reading journal: %v", err)
+		}
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			if line == `done "to/dir/b"` || (!walked && strings.HasPrefix(line, "walked")) {
+				continue
+			}
+			lines = append(lines, line)
+		}
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing journal: %v", err)
+		}
+		for path, content := range map[string]string{"to/a": "stale", "from/c": "new"} {
+			if err := afero.WriteFile(fs, path, []byte(content), 0644); err != nil {
+				t.Fatalf("unexpected error while writing file: %v", err)
+			}
+		}
+		fs.Remove("to/dir/b")
+		want := 2
+		if walked {
+			want = 1
+		}
+		report, err := (&Copier{Fs: fs}).Resume(path)
+		if err != nil {
+			t.Fatalf("unexpected error while resuming: %v", err)
+		}
+		if len(report.Copied) != want {
+			t.Errorf("walked %v: want %d files copied, got %+v", walked, want, report.Copied)
+		}
+		if got, _ := afero.ReadFile(fs, "to/a"); string(got) != "stale" {
+			t.Errorf("walked %v: want a left as it was, got %q", walked, got)
+		}
+		if got, _ := afero.ReadFile(fs, "to/dir/b"); string(got) != "data" {
+			t.Errorf("walked %v: want b copied, got %q", walked, got)
+		}
+		if ok, _ := afero.Exists(fs, "to/c"); ok == walked {
+			t.Errorf("walked %v: want c copied only if walked again", walked)
+		}
+	}
+}
+
+// TestCopier_Resume_DirMode tests that directories created by the copy
+// being resumed are given the mode of their source in the end, whether or
+// not its walk finished.
+func TestCopier_Resume_DirMode(t *testing.T) {
+	for _, walked := range []bool{true, false} {
+		fs := afero.NewMemMapFs()
+		if err := afero.WriteFile(fs, "from/dir/b", []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+		if err := fs.Chmod("from/dir", os.ModeDir|0555); err != nil {
+			t.Fatalf("unexpected error while setting mode: %v", err)
+		}
+		path := filepath.Join(t.TempDir(), "journal")
+		copier := Copier{Fs: fs, Journal: path}
+		if err := copier.Copy("from", "to"); err != nil {
+			t.Fatalf("unexpected error while copying: %v", err)
+		}
+		// Make out the copy was cut short before b was done, so the
+		// directory kept the mode it was made with.
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("unexpected error while reading journal: %v", err)
+		}
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			if line == `done "to/dir/b"` || (!walked && strings.HasPrefix(line, "walked")) {
+				continue
+			}
+			lines = append(lines, line)
+		}
+		if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing journal: %v", err)
+		}
+		fs.Remove("to/dir/b")
+		fs.Chmod("to/dir", os.ModeDir|0755)
+		if _, err := (&Copier{Fs: fs}).Resume(path); err != nil {
+			t.Fatalf("walked %v: unexpected error while resuming: %v", walked, err)
+		}
+		fi, err := fs.Stat("to/dir")
+		if err != nil {
+			t.Fatalf("walked %v: unexpected error while reading file metadata: %v", walked, err)
+		}
+		if fi.Mode().Perm() != 0555 {
+			t.Errorf("walked %v: want the directory given mode 0555, got %v", walked, fi.Mode().Perm())
+		}
+	}
+}
+
+// TestCopier_StateFile tests that the state left once a copy is done
+// records where it finished.
+func TestCopier_StateFile(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/a", "from/dir/b"} {
+		if err := afero.WriteFile(fs, path, []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "state.json")
+	copier := Copier{Fs: fs, StateFile: path, StateInterval: time.Millisecond}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error while reading state: %v", err)
+	}
+	var state State
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("unexpected error while decoding state: %v", err)
+	}
+	if state.Running || state.Files != 2 || state.Bytes != 8 || state.Queued != 0 {
+		t.Errorf("want 2 files and 8 bytes done with none queued, got %+v", state)
+	}
+}
+
+// TestCopier_Preallocate tests that preallocated files come out the size of
+// the original, whether or not the space was reserved.
+func TestCopier_Preallocate(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("preallocated"), 1<<16)
+	if err := os.WriteFile(filepath.Join(dir, "from.txt"), data, 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	for _, chunk := range []int64{0, 1 << 10} {
+		to := filepath.Join(dir, fmt.Sprintf("to-%d.txt", chunk))
+		copier := Copier{Preallocate: true, ChunkThreshold: chunk}
+		if err := copier.Copy(filepath.Join(dir, "from.txt"), to); err != nil {
+			t.Fatalf("unexpected error while copying: %v", err)
+		}
+		got, err := os.ReadFile(to)
+		if err != nil {
+			t.Fatalf("unexpected error while reading copy: %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("chunk threshold %d: want %d bytes matching the original, got %d", chunk, len(data), len(got))
+		}
+	}
+}
+
+// TestCopier_Reflink tests that files are copied where they can't be cloned
+// with ReflinkAuto, and fail with ReflinkAlways.
+func TestCopier_Reflink(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "from.txt", []byte("data"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	copier := Copier{Fs: fs, Reflink: ReflinkAuto}
+	if err := copier.Copy("from.txt", "auto.txt"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if got, _ := afero.ReadFile(fs, "auto.txt"); string(got) != "data" {
+		t.Errorf("want the file copied, got %q", got)
+	}
+	copier.Reflink = ReflinkAlways
+	err := copier.Copy("from.txt", "always.txt")
+	if _, ok := errors.Cause(err).(ErrCloneUnsupported); !ok {
+		t.Fatalf("want ErrCloneUnsupported, got %v", err)
+	}
+	if ok, _ := afero.Exists(fs, "always.txt"); ok {
+		t.Errorf("want nothing left behind by a failed clone")
+	}
+}
+
+// TestCopier_Sparse tests that runs of zeros are left as holes with
+// SparseAlways, the copy reading back the same.
+func TestCopier_Sparse(t *testing.T) {
+	dir := t.TempDir()
+	data := make([]byte, 1<<20)
+	copy(data[100:], "start")
+	copy(data[600<<10:], "middle")
+	from := filepath.Join(dir, "from.img")
+	if err := os.WriteFile(from, data, 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	to := filepath.Join(dir, "to.img")
+	copier := Copier{Sparse: SparseAlways}
+	if err := copier.Copy(from, to); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	got, err := os.ReadFile(to)
+	if err != nil {
+		t.Fatalf("unexpected error while reading copy: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("want %d bytes matching the original, got %d", len(data), len(got))
+	}
+	fi, err := os.Stat(to)
+	if err != nil {
+		t.Fatalf("unexpected error while reading file metadata: %v", err)
+	}
+	if runtime.GOOS != "windows" && !sparse(fi) {
+		t.Errorf("want the copy to have holes")
+	}
+}
+
+// TestCopier_CopyFS tests that an fs.FS is extracted onto the filesystem.
+func TestCopier_CopyFS(t *testing.T) {
+	src := fstest.MapFS{
+		"foo.exe":         {Data: []byte("foo")},
+		"dir/bar.exe":     {Data: []byte("bar")},
+		"dir/sub/baz.exe": {Data: []byte("baz")},
+	}
+	fs := afero.NewMemMapFs()
+	copier := Copier{Fs: fs}
+	if err := copier.CopyFS(src, "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	for path, file := range src {
+		got, err := afero.ReadFile(fs, filepath.Join("to", filepath.FromSlash(path)))
+		if err != nil {
+			t.Fatalf("unexpected error while reading %s: %v", path, err)
+		}
+		if !bytes.Equal(got, file.Data) {
+			t.Errorf("want %s to contain %q, got %q", path, file.Data, got)
+		}
+	}
+	if err := copier.CopyFS(src, "to"); err == nil {
+		t.Fatalf("want error copying over existing directory, got nil")
+	}
+}
+
+// TestCopier_SrcFsDstFs tests copying from one filesystem to another.
+func TestCopier_SrcFsDstFs(t *testing.T) {
+	src := afero.NewMemMapFs()
+	dst := afero.NewMemMapFs()
+	for _, path := range []string{"from/foo.exe", "from/dir/bar.exe"} {
+		if err := afero.WriteFile(src, path, []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{
+		SrcFs: afero.NewReadOnlyFs(src),
+		DstFs: dst,
+	}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	for _, path := range []string{"to/foo.exe", "to/dir/bar.exe"} {
+		if ok, _ := afero.Exists(dst, path); !ok {
+			t.Errorf("want %s to exist on the destination", path)
+		}
+	}
+	if ok, _ := afero.Exists(src, "to"); ok {
+		t.Errorf("want source filesystem untouched")
+	}
+}
+
+// TestCopier_CopyToArchive tests that a tree is packed into a tarball with
+// its directories and modes intact.
+func TestCopier_CopyToArchive(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	files := map[string]string{
+		"from/foo.exe":         "foo",
+		"from/dir/bar.exe":     "bar",
+		"from/dir/sub/baz.exe": "baz",
+	}
+	for path, data := range files {
+		if err := afero.WriteFile(fs, path, []byte(data), 0750); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	buf := &bytes.Buffer{}
+	archive := NewTarGzWriter(buf)
+	copier := Copier{Fs: fs}
+	if _, err := copier.CopyToArchive("from", archive); err != nil {
+		t.Fatalf("unexpected error while archiving: %v", err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("unexpected error closing archive: %v", err)
+	}
+	gz, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatalf("unexpected error reading archive: %v", err)
+	}
+	seen := map[string]bool{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error reading archive: %v", err)
+		}
+		seen[hdr.Name] = true
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		if dir := path.Dir(hdr.Name); dir != "." && !seen[dir+"/"] {
+			t.Errorf("want directory %s before %s", dir, hdr.Name)
+		}
+		if hdr.FileInfo().Mode().Perm() != 0750 {
+			t.Errorf("want %s mode 0750, got %v", hdr.Name, hdr.FileInfo().Mode())
+		}
+		data, _ := io.ReadAll(tr)
+		if want := files["from/"+hdr.Name]; string(data) != want {
+			t.Errorf("want %s to contain %q, got %q", hdr.Name, want, data)
+		}
+	}
+	for path := range files {
+		if !seen[strings.TrimPrefix(path, "from/")] {
+			t.Errorf("want %s in the archive", path)
+		}
+	}
+}
+
+// TestCopier_Zip tests that a tree round trips through a zip archive.
+func TestCopier_Zip(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	files := map[string]string{
+		"from/foo.exe":         "foo",
+		"from/dir/bar.exe":     "bar",
+		"from/dir/sub/baz.exe": "baz",
+	}
+	for path, data := range files {
+		if err := afero.WriteFile(fs, path, []byte(data), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	f, err := fs.Create("archive.zip")
+	if err != nil {
+		t.Fatalf("unexpected error creating archive: %v", err)
+	}
+	archive := NewZipWriter(f)
+	copier := Copier{Fs: fs}
+	if _, err := copier.CopyToArchive("from", archive); err != nil {
+		t.Fatalf("unexpected error while archiving: %v", err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("unexpected error closing archive: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("unexpected error closing archive: %v", err)
+	}
+	if err := copier.ExtractZip("archive.zip", "to"); err != nil {
+		t.Fatalf("unexpected error while extracting: %v", err)
+	}
+	diff, ok, err := fb.CompareDirectories(fs, "from", "to")
+	if err != nil {
+		t.Fatalf("unexpected error comparing directories: %v", err)
+	}
+	if !ok {
+		t.Fatalf("extraction not exact: \n%v", diff)
+	}
+}
+
+// TestCopier_Download tests that downloads are retried, resumed and verified.
+func TestCopier_Download(t *testing.T) {
+	retryBackoff = time.Millisecond
+	content := strings.Repeat("download", 1024)
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case 2:
+			// Cut the body short part way through.
+			w.Header().Set("Content-Length", fmt.Sprint(len(content)))
+			io.WriteString(w, content[:100])
+		default:
+			http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader(content))
+		}
+	}))
+	defer srv.Close()
+	fs := afero.NewMemMapFs()
+	if err := fs.MkdirAll("to", 0755); err != nil {
+		t.Fatalf("unexpected error creating directory: %v", err)
+	}
+	copier := Copier{Fs: fs, Clobber: true}
+	report, err := copier.Download(Download{
+		URL:      srv.URL + "/file.txt",
+		Checksum: fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(content))),
+		Retries:  3,
+	}, "to")
+	if err != nil {
+		t.Fatalf("unexpected error downloading: %v", err)
+	}
+	if len(report.Copied) != 1 || report.Copied[0].To != filepath.Join("to", "file.txt") {
+		t.Fatalf("want to/file.txt copied, got %+v", report)
+	}
+	data, err := afero.ReadFile(fs, filepath.Join("to", "file.txt"))
+	if err != nil {
+		t.Fatalf("unexpected error reading file: %v", err)
+	}
+	if string(data) != content {
+		t.Fatalf("want %d bytes of content, got %d", len(content), len(data))
+	}
+	_, err = copier.Download(Download{
+		URL:      srv.URL + "/file.txt",
+		Checksum: "sha256:" + strings.Repeat("0", 64),
+	}, "bad.txt")
+	if _, ok := errors.Cause(err).(ErrChecksum); !ok {
+		t.Fatalf("want ErrChecksum, got %v", err)
+	}
+	if _, err := fs.Stat("bad.txt"); err == nil {
+		t.Fatalf("want mismatched download removed")
+	}
+}
+
+// TestWatcher tests that changes to the source are mirrored.
+func TestWatcher(t *testing.T) {
+	from, to := t.TempDir(), filepath.Join(t.TempDir(), "mirror")
+	if err := os.WriteFile(filepath.Join(from, "foo.txt"), []byte("foo"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	synced := make(chan error, 10)
+	w := &Watcher{
+		Copier:   &Copier{},
+		From:     from,
+		To:       to,
+		Debounce: 10 * time.Millisecond,
+		Synced:   func(_ Report, err error) { synced <- err },
+	}
+	done := make(chan error)
+	go func() { done <- w.Run() }()
+	defer func() {
+		w.Close()
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error from watch: %v", err)
+		}
+	}()
+	// Wait for the initial copy before making changes.
+	wait := func(check func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !check() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for the mirror")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	exists := func(path string) func() bool {
+		return func() bool {
+			_, err := os.Stat(filepath.Join(to, path))
+			return err == nil
+		}
+	}
+	wait(exists("foo.txt"))
+	time.Sleep(50 * time.Millisecond)
+	if err := os.MkdirAll(filepath.Join(from, "dir"), 0755); err != nil {
+		t.Fatalf("unexpected error creating directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(from, "dir", "bar.txt"), []byte("bar"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	if err := os.Remove(filepath.Join(from, "foo.txt")); err != nil {
+		t.Fatalf("unexpected error removing file: %v", err)
+	}
+	wait(exists(filepath.Join("dir", "bar.txt")))
+	wait(func() bool { return !exists("foo.txt")() })
+	if err := <-synced; err != nil {
+		t.Fatalf("unexpected error syncing: %v", err)
+	}
+}
+
+// TestCopier_Logger tests that each file's outcome is logged.
+func TestCopier_Logger(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/foo.exe", "from/dir/bar.exe"} {
+		if err := afero.WriteFile(fs, path, []byte(path), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	var buf bytes.Buffer
+	copier := Copier{
+		Fs:     fs,
+		Logger: slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	for _, want := range []string{"walk started", "file queued", "file copied"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("want %q logged, got:\n%s", want, buf.String())
+		}
+	}
+	if n := strings.Count(buf.String(), "file copied"); n != 2 {
+		t.Errorf("want 2 files copied, got %d", n)
+	}
+}
+
+// TestCopier_Events tests that a copy's lifecycle is streamed as events.
+func TestCopier_Events(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/foo.exe", "from/dir/bar.exe"} {
+		if err := afero.WriteFile(fs, path, []byte(path), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs}
+	events := copier.Events()
+	if copier.Events() != events {
+		t.Fatalf("want the same channel from each call")
+	}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	kinds := map[EventKind]int{}
+	for ev := range events {
+		kinds[ev.Kind]++
+		if ev.Kind == Done {
+			if ev.Stats.Files != 2 {
+				t.Errorf("want 2 files in the totals, got %d", ev.Stats.Files)
+			}
+			break
+		}
+	}
+	want := map[EventKind]int{WalkStarted: 1, FileQueued: 2, FileCopied: 2, Done: 1}
+	for kind, n := range want {
+		if kinds[kind] != n {
+			t.Errorf("want %d %v events, got %d", n, kind, kinds[kind])
+		}
+	}
+}
+
+// countingObserver counts the calls to the Observer methods it implements.
+type countingObserver struct {
+	NopObserver
+	mu                  sync.Mutex
+	walks, starts, done int
+}
+
+func (o *countingObserver) OnWalk(from, to string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.walks++
+}
+
+func (o *countingObserver) OnCopyStart(from, to string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.starts++
+}
+
+func (o *countingObserver) OnCopyDone(f FileReport) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.done++
+}
+
+// TestCopier_Observers tests that every Observer is told of each stage.
+func TestCopier_Observers(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/foo.exe", "from/dir/bar.exe"} {
+		if err := afero.WriteFile(fs, path, []byte(path), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	a, b := &countingObserver{}, &countingObserver{}
+	copier := Copier{Fs: fs, Observers: []Observer{a, b}}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	for _, o := range []*countingObserver{a, b} {
+		if o.walks != 1 || o.starts != 2 || o.done != 2 {
+			t.Errorf("want 1 walk and 2 files started and done, got %d, %d and %d", o.walks, o.starts, o.done)
+		}
+	}
+}
+
+// TestCopyFunctions tests the package level functions and their options.
+func TestCopyFunctions(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "from/foo.exe", []byte("foo"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	if err := CopyDir("from", "to", WithFs(fs), WithParallel(2)); err != nil {
+		t.Fatalf("unexpected error copying directory: %v", err)
+	}
+	if err := CopyFile("from/foo.exe", "to/foo.exe", WithFs(fs)); err == nil {
+		t.Fatalf("want clobber avoided without WithClobber")
+	}
+	if err := CopyFile("from/foo.exe", "to/foo.exe", WithFs(fs), WithClobber()); err != nil {
+		t.Fatalf("unexpected error copying file: %v", err)
+	}
+	if err := CopyFile("from", "elsewhere", WithFs(fs)); err == nil {
+		t.Fatalf("want error copying a directory as a file")
+	}
+	if err := CopyDir("from/foo.exe", "elsewhere", WithFs(fs)); err == nil {
+		t.Fatalf("want error copying a file as a directory")
+	}
+	if err := Copy("from", "again", WithFs(fs), WithIncludeRoot()); err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	if _, err := fs.Stat("again/from/foo.exe"); err != nil {
+		t.Fatalf("want again/from/foo.exe, got %v", err)
+	}
+}
+
+// TestCopy_SameFile tests that copying a file onto itself by another path is
+// rejected rather than truncating it.
+func TestCopy_SameFile(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(a, []byte("contents"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	others := []string{dir + string(filepath.Separator) + "." + string(filepath.Separator) + "a.txt"}
+	if err := os.Link(a, filepath.Join(dir, "hard.txt")); err == nil {
+		others = append(others, filepath.Join(dir, "hard.txt"))
+	}
+	if err := os.Symlink(a, filepath.Join(dir, "soft.txt")); err == nil {
+		others = append(others, filepath.Join(dir, "soft.txt"))
+	}
+	copier := Copier{Clobber: true}
+	for _, to := range others {
+		if err := copier.Copy(a, to); !errors.As(err, &ErrSameFile{}) {
+			t.Errorf("[%s] want ErrSameFile, got %v", to, err)
+		}
+	}
+	if data, err := os.ReadFile(a); err != nil || string(data) != "contents" {
+		t.Fatalf("want source intact, got %q, %v", data, err)
+	}
+}
+
+// TestCopier_Dedupe tests that files with identical content are linked to
+// the first copy.
+func TestCopier_Dedupe(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "from"), filepath.Join(dir, "to")
+	files := map[string]string{
+		"a.txt":          "duplicate",
+		"sub/b.txt":      "duplicate",
+		"sub/deep/c.txt": "duplicate",
+		"unique.txt":     "unique",
+	}
+	for path, data := range files {
+		path = filepath.Join(from, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("unexpected error creating directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Dedupe: true, Parallel: 1}
+	report, err := copier.CopyReport(from, to)
+	if err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	if len(report.Copied) != 2 || len(report.Linked) != 2 {
+		t.Fatalf("want 2 copied and 2 linked, got %d and %d", len(report.Copied), len(report.Linked))
+	}
+	var infos []os.FileInfo
+	for path, data := range files {
+		path = filepath.Join(to, filepath.FromSlash(path))
+		got, err := os.ReadFile(path)
+		if err != nil || string(got) != data {
+			t.Fatalf("want %s to contain %q, got %q, %v", path, data, got, err)
+		}
+		if data == "duplicate" {
+			fi, _ := os.Stat(path)
+			infos = append(infos, fi)
+		}
+	}
+	for _, fi := range infos[1:] {
+		if !os.SameFile(infos[0], fi) {
+			t.Errorf("want duplicates linked together")
+		}
+	}
+}
+
+// blindHash is a hash that ignores what it is given, so every file collides.
+type blindHash struct{ hash.Hash }
+
+func (blindHash) Write(p []byte) (int, error) { return len(p), nil }
+
+// TestCopier_Dedupe_Hasher tests that deduplication doesn't trust the Hasher,
+// which needn't resist collisions, to say files are the same.
+func TestCopier_Dedupe_Hasher(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "from"), filepath.Join(dir, "to")
+	for name, data := range map[string]string{"a.txt": "aaaa", "b.txt": "bbbb"} {
+		if err := os.MkdirAll(from, 0755); err != nil {
+			t.Fatalf("unexpected error creating directory: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(from, name), []byte(data), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Dedupe: true, Parallel: 1, Hasher: func() hash.Hash { return blindHash{crc32.NewIEEE()} }}
+	report, err := copier.CopyReport(from, to)
+	if err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	if len(report.Linked) != 0 {
+		t.Errorf("want different files copied, not linked, got %+v", report.Linked)
+	}
+	if got, _ := os.ReadFile(filepath.Join(to, "b.txt")); string(got) != "bbbb" {
+		t.Errorf("want b.txt copied intact, got %q", got)
+	}
+}
+
+// TestCopier_Dedupe_Changed tests that a file isn't linked to an earlier
+// copy that has been changed since it was written.
+func TestCopier_Dedupe_Changed(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("duplicate"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: afero.NewOsFs(), Dedupe: true}
+	defer copier.start()()
+	cp := copier.copier()
+	if _, _, _, err := cp.copyDeduped(filepath.Join(dir, "a.txt"), filepath.Join(dir, "a.copy")); err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.copy"), []byte("rewritten"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	_, _, linked, err := cp.copyDeduped(filepath.Join(dir, "b.txt"), filepath.Join(dir, "b.copy"))
+	if err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	if linked {
+		t.Errorf("want b.txt copied rather than linked to the changed copy")
+	}
+	if got, _ := os.ReadFile(filepath.Join(dir, "b.copy")); string(got) != "duplicate" {
+		t.Errorf("want b.txt copied intact, got %q", got)
+	}
+}
+
+// TestCopier_Transform tests that file contents are transformed as they are
+// copied.
+func TestCopier_Transform(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	files := map[string]string{
+		"from/config.ini": "\ufeffname=app\r\n",
+		"from/data.bin":   "\ufeffraw\r\n",
+	}
+	for path, data := range files {
+		if err := afero.WriteFile(fs, path, []byte(data), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{
+		Fs: fs,
+		Transform: func(path string, r io.Reader) (io.Reader, error) {
+			if filepath.Ext(path) != ".ini" {
+				return r, nil
+			}
+			data, err := io.ReadAll(r)
+			if err != nil {
+				return nil, err
+			}
+			data = bytes.TrimPrefix(data, []byte("\ufeff"))
+			return bytes.NewReader(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))), nil
+		},
+	}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	for path, want := range map[string]string{
+		"to/config.ini": "name=app\n",
+		"to/data.bin":   "\ufeffraw\r\n",
+	} {
+		got, err := afero.ReadFile(fs, path)
+		if err != nil {
+			t.Fatalf("unexpected error reading %s: %v", path, err)
+		}
+		if string(got) != want {
+			t.Errorf("want %s to contain %q, got %q", path, want, got)
+		}
+	}
+}
+
+// TestCopier_Rename tests that destination paths are rewritten.
+func TestCopier_Rename(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/src/main.ts", "from/src/util/util.ts", "from/test/main_test.ts"} {
+		if err := afero.WriteFile(fs, path, []byte(path), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{
+		Fs: fs,
+		Rename: func(rel string) string {
+			if rel == "test" {
+				return ""
+			}
+			rel = strings.TrimPrefix(rel, "src"+string(filepath.Separator))
+			return strings.TrimSuffix(rel, ".ts") + ".js"
+		},
+	}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	for _, path := range []string{"to/main.js", "to/util/util.js"} {
+		got, err := afero.ReadFile(fs, path)
+		if err != nil {
+			t.Fatalf("want %s, got %v", path, err)
+		}
+		if !strings.HasSuffix(string(got), ".ts") {
+			t.Errorf("want %s to hold the original contents, got %q", path, got)
+		}
+	}
+	if _, err := fs.Stat("to/test"); err == nil {
+		t.Errorf("want the test directory left out")
+	}
+	escape := Copier{Fs: fs, Clobber: true, Rename: func(string) string { return "../outside" }}
+	if err := escape.Copy("from", "escape"); err == nil {
+		t.Errorf("want an error renaming outside the destination")
+	}
+}
+
+// TestCopier_Flatten tests that files are collected into one directory with
+// each way of resolving collisions.
+func TestCopier_Flatten(t *testing.T) {
+	tests := []struct {
+		desc       string
+		collisions Collision
+		want       []string
+		failed     int
+		skipped    int
+	}{
+		{"fail", CollisionFail, []string{"app.log"}, 1, 0},
+		{"number", CollisionNumber, []string{"app.log", "app-1.log"}, 0, 0},
+		{"keep first", CollisionKeepFirst, []string{"app.log"}, 0, 1},
+	}
+	for _, tt := range tests {
+		fs := afero.NewMemMapFs()
+		for _, path := range []string{"from/a/app.log", "from/b/c/app.log"} {
+			if err := afero.WriteFile(fs, path, []byte(path), 0644); err != nil {
+				t.Fatalf("[%s] unexpected error while writing file: %v", tt.desc, err)
+			}
+		}
+		copier := Copier{Fs: fs, Flatten: true, Collisions: tt.collisions}
+		report, _ := copier.CopyReport("from", "to")
+		if len(report.Failed) != tt.failed || len(report.Skipped) != tt.skipped {
+			t.Errorf("[%s] want %d failed and %d skipped, got %+v", tt.desc, tt.failed, tt.skipped, report)
+		}
+		for _, name := range tt.want {
+			if _, err := fs.Stat(filepath.Join("to", name)); err != nil {
+				t.Errorf("[%s] want %s, got %v", tt.desc, name, err)
+			}
+		}
+		if _, err := fs.Stat(filepath.Join("to", "a")); err == nil {
+			t.Errorf("[%s] want no directories at the destination", tt.desc)
+		}
+	}
+}
+
+// TestCopy_EmptyDirectories tests that empty directories are copied.
+func TestCopy_EmptyDirectories(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, dir := range []string{"from/empty", "from/nested/empty"} {
+		if err := fs.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("unexpected error creating directory: %v", err)
+		}
+	}
+	if err := afero.WriteFile(fs, "from/nested/foo.exe", []byte("foo"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	copier := Copier{Fs: fs}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	if err := copier.CopyFS(fstest.MapFS{"empty": &fstest.MapFile{Mode: iofs.ModeDir | 0755}}, "fromfs"); err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	for _, dir := range []string{"to/empty", "to/nested/empty", "fromfs/empty"} {
+		fi, err := fs.Stat(dir)
+		if err != nil || !fi.IsDir() {
+			t.Errorf("want directory %s, got %v", dir, err)
+		}
+	}
+}
+
+// TestCopy_DirectoryModes tests that directories get the mode of their
+// source rather than of the files in them, even when read-only.
+func TestCopy_DirectoryModes(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	modes := map[string]os.FileMode{
+		"from":               0755,
+		"from/private":       0700,
+		"from/readonly":      0555,
+		"from/readonly/deep": 0750,
+	}
+	for dir := range modes {
+		if err := fs.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("unexpected error creating directory: %v", err)
+		}
+	}
+	for _, path := range []string{"from/private/key", "from/readonly/deep/file"} {
+		if err := afero.WriteFile(fs, path, []byte(path), 0600); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	for dir, mode := range modes {
+		if err := fs.Chmod(dir, mode); err != nil {
+			t.Fatalf("unexpected error setting mode: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs}
+	if err := copier.Copy("from", "to"); err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	for dir, mode := range modes {
+		to := "to" + strings.TrimPrefix(dir, "from")
+		fi, err := fs.Stat(to)
+		if err != nil {
+			t.Fatalf("want %s, got %v", to, err)
+		}
+		if fi.Mode().Perm() != mode {
+			t.Errorf("want %s mode %v, got %v", to, mode, fi.Mode().Perm())
+		}
+	}
+}
+
+func TestCopier_FollowSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "from")
+	if err := os.MkdirAll(filepath.Join(from, "a"), 0755); err != nil {
+		t.Fatalf("unexpected error creating directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(from, "a", "file"), []byte("file"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	if err := os.Symlink("a", filepath.Join(from, "b")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := os.Symlink("..", filepath.Join(from, "a", "loop")); err != nil {
+		t.Fatalf("unexpected error creating symlink: %v", err)
+	}
+	to := filepath.Join(dir, "to")
+	copier := Copier{FollowSymlinks: true}
+	report, err := copier.CopyReport(from, to)
+	if err == nil {
+		t.Fatalf("want cycle error")
+	}
+	var cycles int
+	for _, f := range report.Failed {
+		if _, ok := errors.Cause(f.Err).(ErrSymlinkCycle); ok {
+			cycles++
+		}
+	}
+	if cycles != 2 || len(report.Failed) != 2 {
+		t.Errorf("want a cycle through a and through b, got %v", report.Failed)
+	}
+	for _, path := range []string{"a/file", "b/file"} {
+		if data, err := os.ReadFile(filepath.Join(to, path)); err != nil || string(data) != "file" {
+			t.Errorf("want %s copied, got %q, %v", path, data, err)
+		}
+	}
+}
+
+// TestCopier_Preserve tests that modes and times are kept exactly and
+// symlinks recreated, as with cp -a.
+func TestCopier_Preserve(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "from")
+	if err := os.MkdirAll(filepath.Join(from, "dir"), 0755); err != nil {
+		t.Fatalf("unexpected error creating directory: %v", err)
+	}
+	stamp := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	file := filepath.Join(from, "dir", "file")
+	if err := os.WriteFile(file, []byte("file"), 0600); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	// Set after writing, so the umask doesn't apply.
+	if err := os.Chmod(file, 0604); err != nil {
+		t.Fatalf("unexpected error while setting mode: %v", err)
+	}
+	if err := os.Chtimes(file, stamp, stamp); err != nil {
+		t.Fatalf("unexpected error while setting times: %v", err)
+	}
+	if err := os.Symlink("dir/file", filepath.Join(from, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	to := filepath.Join(dir, "to")
+	copier := Copier{PreserveMode: true, PreserveTimes: true, PreserveOwner: true, CopySymlinks: true}
+	if err := copier.Copy(from, to); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	fi, err := os.Stat(filepath.Join(to, "dir", "file"))
+	if err != nil {
+		t.Fatalf("unexpected error while reading file metadata: %v", err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode() != 0604 {
+		t.Errorf("want mode %v, got %v", os.FileMode(0604), fi.Mode())
+	}
+	if !fi.ModTime().Equal(stamp) {
+		t.Errorf("want modified at %v, got %v", stamp, fi.ModTime())
+	}
+	if target, err := os.Readlink(filepath.Join(to, "link")); err != nil || target != "dir/file" {
+		t.Errorf("want link to dir/file, got %q, %v", target, err)
+	}
+}
+
+// TestCopier_PreserveLinks tests that files hard linked in the source are
+// hard linked in the copy.
+func TestCopier_PreserveLinks(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "from")
+	if err := os.MkdirAll(from, 0755); err != nil {
+		t.Fatalf("unexpected error creating directory: %v", err)
+	}
+	for _, name := range []string{"a", "c"} {
+		if err := os.WriteFile(filepath.Join(from, name), []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	if err := os.Link(filepath.Join(from, "a"), filepath.Join(from, "b")); err != nil {
+		t.Skipf("hard links not supported: %v", err)
+	}
+	to := filepath.Join(dir, "to")
+	copier := Copier{PreserveLinks: true, Parallel: 1}
+	report, err := copier.CopyReport(from, to)
+	if err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if len(report.Copied) != 2 || len(report.Linked) != 1 {
+		t.Fatalf("want 2 files copied and 1 linked, got %+v", report)
+	}
+	a, _ := os.Stat(filepath.Join(to, "a"))
+	b, _ := os.Stat(filepath.Join(to, "b"))
+	c, _ := os.Stat(filepath.Join(to, "c"))
+	if !os.SameFile(a, b) || os.SameFile(a, c) {
+		t.Errorf("want a and b linked and c apart")
+	}
+}
+
+// TestCopier_LinkRoots tests that a symlinked source is walked through by
+// default and copied as a symlink with LinkRoots.
+func TestCopier_LinkRoots(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "real"), 0755); err != nil {
+		t.Fatalf("unexpected error creating directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "real", "file"), []byte("file"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink("real", link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	copier := Copier{CopySymlinks: true}
+	if err := copier.Copy(link, filepath.Join(dir, "followed")); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "followed", "file")); err != nil || string(data) != "file" {
+		t.Errorf("want the linked directory copied, got %q, %v", data, err)
+	}
+	copier.LinkRoots = true
+	if err := copier.Copy(link, filepath.Join(dir, "linked")); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(dir, "linked")); err != nil || target != "real" {
+		t.Errorf("want a link to real, got %q, %v", target, err)
+	}
+}
+
+func TestCopier_MaxTotalBytes(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for path, size := range map[string]int{"from/a": 40, "from/b": 40, "from/c": 10} {
+		if err := afero.WriteFile(fs, path, make([]byte, size), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs, MaxTotalBytes: 50}
+	report, err := copier.CopyReport("from", "to")
+	if err == nil {
+		t.Fatalf("want quota error")
+	}
+	if len(report.Copied) != 1 || report.Copied[0].From != "from/a" {
+		t.Errorf("want only from/a copied, got %v", report.Copied)
+	}
+	if len(report.Failed) != 2 {
+		t.Fatalf("want the rest failed, got %v", report.Failed)
+	}
+	for _, f := range report.Failed {
+		quota, ok := errors.Cause(f.Err).(ErrQuotaExceeded)
+		if !ok {
+			t.Fatalf("want ErrQuotaExceeded, got %v", f.Err)
+		}
+		if quota.Used != 40 {
+			t.Errorf("want 40 bytes used, got %d", quota.Used)
+		}
+	}
+}
+
+func TestCopier_MaxDepth(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/top", "from/a/middle", "from/a/b/bottom"} {
+		if err := afero.WriteFile(fs, path, []byte(path), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	tests := []struct {
+		depth   int
+		present []string
+		absent  []string
+	}{
+		{1, []string{"top", "a"}, []string{"a/middle", "a/b"}},
+		{2, []string{"top", "a/middle", "a/b"}, []string{"a/b/bottom"}},
+		{0, []string{"top", "a/middle", "a/b/bottom"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.depth), func(t *testing.T) {
+			to := fmt.Sprintf("to%d", tt.depth)
+			copier := Copier{Fs: fs, MaxDepth: tt.depth}
+			if err := copier.Copy("from", to); err != nil {
+				t.Fatalf("unexpected error copying: %v", err)
+			}
+			for _, path := range tt.present {
+				if _, err := fs.Stat(filepath.Join(to, path)); err != nil {
+					t.Errorf("want %s copied, got %v", path, err)
+				}
+			}
+			for _, path := range tt.absent {
+				if _, err := fs.Stat(filepath.Join(to, path)); !os.IsNotExist(err) {
+					t.Errorf("want %s left out, got %v", path, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCopier_SizeFilters(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for path, size := range map[string]int{"from/empty": 0, "from/small": 10, "from/large": 100} {
+		if err := afero.WriteFile(fs, path, make([]byte, size), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{Fs: fs, MinSize: 1, MaxSize: 50}
+	report, err := copier.CopyReport("from", "to")
+	if err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	if len(report.Copied) != 1 || report.Copied[0].From != "from/small" {
+		t.Errorf("want only from/small copied, got %v", report.Copied)
+	}
+	for _, path := range []string{"to/empty", "to/large"} {
+		if _, err := fs.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("want %s left out, got %v", path, err)
+		}
+	}
+}
+
+func TestCopier_ModifiedFilters(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	now := time.Now()
+	times := map[string]time.Time{
+		"from/old":    now.Add(-48 * time.Hour),
+		"from/recent": now.Add(-time.Hour),
+		"from/future": now.Add(time.Hour),
+	}
+	for path, mtime := range times {
+		if err := afero.WriteFile(fs, path, []byte(path), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+		if err := fs.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("unexpected error setting times: %v", err)
+		}
+	}
+	if err := afero.WriteFile(fs, "to/old", []byte("kept"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	copier := Copier{
+		Fs:             fs,
+		Clobber:        true,
+		ModifiedAfter:  now.Add(-24 * time.Hour),
+		ModifiedBefore: now,
+	}
+	report, err := copier.CopyReport("from", "to")
+	if err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	if len(report.Copied) != 1 || report.Copied[0].From != "from/recent" {
+		t.Errorf("want only from/recent copied, got %v", report.Copied)
+	}
+	if data, _ := afero.ReadFile(fs, "to/old"); string(data) != "kept" {
+		t.Errorf("want to/old left alone, got %q", data)
+	}
+	if _, err := fs.Stat("to/future"); !os.IsNotExist(err) {
+		t.Errorf("want to/future left out, got %v", err)
+	}
+}
+
+func TestCopier_Regexp(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{
+		"from/logs/2024-01-01/app.log",
+		"from/logs/2024-01-02/app.tmp",
+		"from/logs/2023-12-31/app.log",
+		"from/other/app.log",
+	} {
+		if err := afero.WriteFile(fs, path, []byte(path), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	copier := Copier{
+		Fs:            fs,
+		IncludeRegexp: []*regexp.Regexp{regexp.MustCompile(`^logs/2024-\d{2}-\d{2}/`)},
+		ExcludeRegexp: []*regexp.Regexp{regexp.MustCompile(`\.tmp$`)},
+	}
+	report, err := copier.CopyReport("from", "to")
+	if err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	if len(report.Copied) != 1 || report.Copied[0].From != filepath.Join("from", "logs", "2024-01-01", "app.log") {
+		t.Errorf("want only the 2024 log copied, got %v", report.Copied)
+	}
+	// Directories that can't hold a match are pruned rather than created.
+	for _, path := range []string{"to/other", "to/logs/2023-12-31"} {
+		if _, err := fs.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("want %s pruned, got %v", path, err)
+		}
+	}
+}
+
+func TestCopier_OnConflict(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/a", "from/b", "from/c"} {
+		if err := afero.WriteFile(fs, path, []byte("new"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	for _, path := range []string{"to/a", "to/b"} {
+		if err := afero.WriteFile(fs, path, []byte("old"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	var (
+		mu    sync.Mutex
+		asked []string
+	)
+	answers := map[string]Conflict{"to/a": ConflictOverwrite, "to/b": ConflictSkip}
+	copier := Copier{
+		Fs: fs,
+		OnConflict: func(from, to string) Conflict {
+			mu.Lock()
+			defer mu.Unlock()
+			asked = append(asked, to)
+			return answers[to]
+		},
+	}
+	report, err := copier.CopyReport("from", "to")
+	if err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	if len(asked) != 2 {
+		t.Errorf("want asked about the 2 existing files, got %v", asked)
+	}
+	if len(report.Skipped) != 1 {
+		t.Errorf("want 1 skipped, got %v", report.Skipped)
+	}
+	for path, want := range map[string]string{"to/a": "new", "to/b": "old", "to/c": "new"} {
+		if data, _ := afero.ReadFile(fs, path); string(data) != want {
+			t.Errorf("want %s %q, got %q", path, want, data)
+		}
+	}
+	t.Run("abort", func(t *testing.T) {
+		copier := Copier{
+			Fs:         fs,
+			OnConflict: func(string, string) Conflict { return ConflictAbort },
+		}
+		report, err := copier.CopyReport("from", "to")
+		if err == nil {
+			t.Fatalf("want abort error")
+		}
+		if len(report.Failed) != 1 {
+			t.Fatalf("want the first file aborted, got %v", report.Failed)
+		}
+		if _, ok := errors.Cause(report.Failed[0].Err).(ErrAborted); !ok {
+			t.Errorf("want ErrAborted, got %v", report.Failed[0].Err)
+		}
+		if len(report.Skipped) != 2 {
+			t.Errorf("want the rest skipped, got %v", report.Skipped)
+		}
+	})
+}
+
+func TestCopier_Plan(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/a", "from/sub/b"} {
+		if err := afero.WriteFile(fs, path, []byte("new"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	if err := afero.WriteFile(fs, "to/a", []byte("old"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	copier := Copier{Fs: fs, Clobber: true}
+	plan, err := copier.Plan("from", "to")
+	if err != nil {
+		t.Fatalf("unexpected error planning: %v", err)
+	}
+	want := []Op{
+		{Kind: OpMkdir, From: "from", To: "to"},
+		{Kind: OpOverwrite, From: filepath.Join("from", "a"), To: filepath.Join("to", "a"), Size: 3},
+		{Kind: OpMkdir, From: filepath.Join("from", "sub"), To: filepath.Join("to", "sub")},
+		{Kind: OpCopy, From: filepath.Join("from", "sub", "b"), To: filepath.Join("to", "sub", "b"), Size: 3},
+	}
+	if !reflect.DeepEqual(plan.Ops, want) {
+		t.Fatalf("want plan %v, got %v", want, plan.Ops)
+	}
+	if _, err := fs.Stat("to/sub"); !os.IsNotExist(err) {
+		t.Fatalf("want nothing done while planning, got %v", err)
+	}
+	// Drop the overwrite and round trip the plan as a review tool might.
+	plan.Ops = append(plan.Ops[:1], plan.Ops[2:]...)
+	data, err := json.Marshal(plan)
+	if err != nil {
+		t.Fatalf("unexpected error encoding plan: %v", err)
+	}
+	var decoded Plan
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unexpected error decoding plan: %v", err)
+	}
+	report, err := copier.Apply(decoded)
+	if err != nil {
+		t.Fatalf("unexpected error applying: %v", err)
+	}
+	if len(report.Copied) != 1 {
+		t.Errorf("want 1 file copied, got %v", report.Copied)
+	}
+	for path, want := range map[string]string{"to/a": "old", "to/sub/b": "new"} {
+		if data, _ := afero.ReadFile(fs, path); string(data) != want {
+			t.Errorf("want %s %q, got %q", path, want, data)
+		}
+	}
+}
+
+func TestCopier_Rollback(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/a", "from/sub/b"} {
+		if err := afero.WriteFile(fs, path, []byte("new"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	if err := afero.WriteFile(fs, "to/a", []byte("old"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	copier := Copier{
+		Fs:       fs,
+		Clobber:  true,
+		Rollback: true,
+		Transform: func(path string, r io.Reader) (io.Reader, error) {
+			if filepath.Base(path) == "b" {
+				return nil, fmt.Errorf("failing %s", path)
+			}
+			return r, nil
+		},
+	}
+	if err := copier.Copy("from", "to"); err == nil {
+		t.Fatalf("want error from the failed file")
+	}
+	if data, _ := afero.ReadFile(fs, "to/a"); string(data) != "old" {
+		t.Errorf("want to/a restored, got %q", data)
+	}
+	if names := dirNames(t, fs, "to"); !reflect.DeepEqual(names, []string{"a"}) {
+		t.Errorf("want only the original file left, got %v", names)
+	}
+	t.Run("success", func(t *testing.T) {
+		copier := Copier{Fs: fs, Clobber: true, Rollback: true}
+		if err := copier.Copy("from", "to"); err != nil {
+			t.Fatalf("unexpected error copying: %v", err)
+		}
+		if data, _ := afero.ReadFile(fs, "to/a"); string(data) != "new" {
+			t.Errorf("want to/a overwritten, got %q", data)
+		}
+		if names := dirNames(t, fs, "to"); !reflect.DeepEqual(names, []string{"a", "sub"}) {
+			t.Errorf("want backups removed, got %v", names)
+		}
+	})
+}
+
+// dirNames lists the entries of dir.
+func dirNames(t *testing.T, fs afero.Fs, dir string) []string {
+	t.Helper()
+	infos, err := afero.ReadDir(fs, dir)
+	if err != nil {
+		t.Fatalf("unexpected error listing %s: %v", dir, err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	return names
+}
+
+func TestCopier_Retries(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "from/a", []byte("a"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	tests := []struct {
+		desc     string
+		err      error
+		failures int
+		calls    int
+		fails    bool
+	}{
+		{"transient", syscall.EINTR, 2, 3, false},
+		{"exhausted", syscall.ETIMEDOUT, 5, 3, true},
+		{"permanent", iofs.ErrPermission, 1, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			calls := 0
+			copier := Copier{
+				Fs:           fs,
+				Clobber:      true,
+				Retries:      2,
+				RetryBackoff: time.Millisecond,
+				Transform: func(path string, r io.Reader) (io.Reader, error) {
+					calls++
+					if calls <= tt.failures {
+						return nil, tt.err
+					}
+					return r, nil
+				},
+			}
+			err := copier.Copy("from/a", "to/"+tt.desc)
+			if calls != tt.calls {
+				t.Errorf("want %d attempts, got %d", tt.calls, calls)
+			}
+			if (err != nil) != tt.fails {
+				t.Errorf("want failure %v, got %v", tt.fails, err)
+			}
+		})
+	}
+}
+
+func TestCopier_FileTimeout(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/stuck", "from/fine"} {
+		if err := afero.WriteFile(fs, path, []byte(path), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	stuck := make(chan struct{})
+	defer close(stuck)
+	copier := Copier{
+		Fs:          fs,
+		FileTimeout: 50 * time.Millisecond,
+		Transform: func(path string, r io.Reader) (io.Reader, error) {
+			if filepath.Base(path) == "stuck" {
+				<-stuck
+			}
+			return r, nil
+		},
+	}
+	report, err := copier.CopyReport("from", "to")
+	if err == nil {
+		t.Fatalf("want timeout error")
+	}
+	if len(report.Failed) != 1 {
+		t.Fatalf("want the stuck file failed, got %v", report.Failed)
+	}
+	if _, ok := errors.Cause(report.Failed[0].Err).(ErrFileTimeout); !ok {
+		t.Errorf("want ErrFileTimeout, got %v", report.Failed[0].Err)
+	}
+	if len(report.Copied) != 1 {
+		t.Errorf("want the other file copied, got %v", report.Copied)
+	}
+}
+
+func TestCopier_CopyContext(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"from/a", "from/b", "from/c"} {
+		if err := afero.WriteFile(fs, path, []byte(path), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	copier := Copier{
+		Fs:       fs,
+		Parallel: 1,
+		// Cancel as the first file starts, so that it is cut off part way.
+		Transform: func(path string, r io.Reader) (io.Reader, error) {
+			cancel()
+			return r, nil
+		},
+	}
+	report, err := copier.CopyContext(ctx, "from", "to")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want context.Canceled, got %v", err)
+	}
+	if len(report.Copied) != 0 {
+		t.Errorf("want nothing copied, got %v", report.Copied)
+	}
+	for _, path := range []string{"to/a", "to/b", "to/c"} {
+		if _, err := fs.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("want %s not left behind, got %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+// Package cptest provides a filesystem that fails on demand, for testing how
+// code built on package cp copes with errors without relying on real disks
+// to misbehave.
+//
+//	fs := cptest.New(afero.NewMemMapFs(), cptest.Faults{FailOpenEvery: 3})
+//	copier := cp.Copier{SrcFs: src, DstFs: fs}
+//
+// The failures are *os.PathError values wrapping ErrInjected, or Faults.Err,
+// so errors.Is tells them apart from real ones.
+package cptest
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// ErrInjected is the error failures carry unless Faults.Err is set.
+var ErrInjected = errors.New("injected failure")
+
+// Faults describes the failures an Fs injects. The zero value injects none.
+type Faults struct {
+	// FailOpenEvery, when set, fails every Nth call to Open, OpenFile or
+	// Create, directories included.
+	FailOpenEvery int
+	// FailWritesOver, when set, fails writes that would take a file past
+	// that many bytes, after writing as much as fits.
+	FailWritesOver int64
+	// Latency is slept before every operation on the filesystem and its
+	// files, to stand in for a slow disk or network.
+	Latency time.Duration
+	// Match, when set, limits the failures to the paths it accepts.
+	// Latency applies regardless.
+	Match func(name string) bool
+	// Err is the error failures carry, ErrInjected by default.
+	Err error
+}
+
+// Fs wraps an afero.Fs, injecting its Faults.
+type Fs struct {
+	afero.Fs
+	faults Faults
+	opens  atomic.Int64
+}
+
+var (
+	_ afero.Fs      = &Fs{}
+	_ afero.Lstater = &Fs{}
+)
+
+// New wraps fs to fail as faults describes.
+func New(fs afero.Fs, faults Faults) *Fs {
+	if faults.Err == nil {
+		faults.Err = ErrInjected
+	}
+	return &Fs{Fs: fs, faults: faults}
+}
+
+// Opens is the number of files opened so far, counting those that failed.
+func (fs *Fs) Opens() int64 {
+	return fs.opens.Load()
+}
+
+func (fs *Fs) wait() {
+	if fs.faults.Latency > 0 {
+		time.Sleep(fs.faults.Latency)
+	}
+}
+
+func (fs *Fs) matches(name string) bool {
+	return fs.faults.Match == nil || fs.faults.Match(name)
+}
+
+// open counts an open of name, failing it if its turn has come.
+func (fs *Fs) open(op, name string, open func() (afero.File, error)) (afero.File, error) {
+	fs.wait()
+	if fs.matches(name) {
+		n := fs.opens.Add(1)
+		if every := int64(fs.faults.FailOpenEvery); every > 0 && n%every == 0 {
+			return nil, &os.PathError{Op: op, Path: name, Err: fs.faults.Err}
+		}
+	}
+	f, err := open()
+	if err != nil {
+		return nil, err
+	}
+	return &File{File: f, fs: fs, limited: fs.matches(name)}, nil
+}
+
+func (fs *Fs) Create(name string) (afero.File, error) {
+	return fs.open("create", name, func() (afero.File, error) { return fs.Fs.Create(name) })
+}
+
+func (fs *Fs) Open(name string) (afero.File, error) {
+	return fs.open("open", name, func() (afero.File, error) { return fs.Fs.Open(name) })
+}
+
+func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	return fs.open("open", name, func() (afero.File, error) { return fs.Fs.OpenFile(name, flag, perm) })
+}
+
+func (fs *Fs) Mkdir(name string, perm os.FileMode) error {
+	fs.wait()
+	return fs.Fs.Mkdir(name, perm)
+}
+
+func (fs *Fs) MkdirAll(path string, perm os.FileMode) error {
+	fs.wait()
+	return fs.Fs.MkdirAll(path, perm)
+}
+
+func (fs *Fs) Remove(name string) error {
+	fs.wait()
+	return fs.Fs.Remove(name)
+}
+
+func (fs *Fs) RemoveAll(path string) error {
+	fs.wait()
+	return fs.Fs.RemoveAll(path)
+}
+
+func (fs *Fs) Rename(oldname, newname string) error {
+	fs.wait()
+	return fs.Fs.Rename(oldname, newname)
+}
+
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	fs.wait()
+	return fs.Fs.Stat(name)
+}
+
+// LstatIfPossible lstats name if the wrapped filesystem can.
+func (fs *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if l, ok := fs.Fs.(afero.Lstater); ok {
+		fs.wait()
+		return l.LstatIfPossible(name)
+	}
+	fi, err := fs.Stat(name)
+	return fi, false, err
+}
+
+func (fs *Fs) Chmod(name string, mode os.FileMode) error {
+	fs.wait()
+	return fs.Fs.Chmod(name, mode)
+}
+
+func (fs *Fs) Chown(name string, uid, gid int) error {
+	fs.wait()
+	return fs.Fs.Chown(name, uid, gid)
+}
+
+func (fs *Fs) Chtimes(name string, atime, mtime time.Time) error {
+	fs.wait()
+	return fs.Fs.Chtimes(name, atime, mtime)
+}
+
+func (fs *Fs) Name() string {
+	return "cptest(" + fs.Fs.Name() + ")"
+}
+
+// File is a file opened through an Fs.
+type File struct {
+	afero.File
+	fs *Fs
+	// limited is whether FailWritesOver applies to the file.
+	limited bool
+
+	mu sync.Mutex
+	// pos is the offset the next Read or Write is made at.
+	pos int64
+}
+
+// room is how much of a write of n bytes at off fits beneath the limit,
+// and whether that is all of it.
+func (f *File) room(off int64, n int) (int, bool) {
+	limit := f.fs.faults.FailWritesOver
+	if !f.limited || limit <= 0 || off+int64(n) <= limit {
+		return n, true
+	}
+	return int(max(0, limit-off)), false
+}
+
+// fail is the error for a write cut short by FailWritesOver.
+func (f *File) fail() error {
+	return &os.PathError{Op: "write", Path: f.Name(), Err: f.fs.faults.Err}
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	f.fs.wait()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.File.Read(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	f.fs.wait()
+	return f.File.ReadAt(p, off)
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.fs.wait()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, ok := f.room(f.pos, len(p))
+	n, err := f.File.Write(p[:n])
+	f.pos += int64(n)
+	if err == nil && !ok {
+		err = f.fail()
+	}
+	return n, err
+}
+
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	f.fs.wait()
+	n, ok := f.room(off, len(p))
+	n, err := f.File.WriteAt(p[:n], off)
+	if err == nil && !ok {
+		err = f.fail()
+	}
+	return n, err
+}
+
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	pos, err := f.File.Seek(offset, whence)
+	if err == nil {
+		f.pos = pos
+	}
+	return pos, err
+}
@@ -0,0 +1,78 @@
+package cptest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/jackmordaunt/cp"
+)
+
+// tree writes n small files beneath from.
+func tree(t *testing.T, fs afero.Fs, n int) {
+	for ii := 0; ii < n; ii++ {
+		if err := afero.WriteFile(fs, fmt.Sprintf("from/%d.txt", ii), []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+}
+
+// TestFs_FailOpenEvery tests that every Nth open fails, and that the copy
+// reports those files as failed with the injected error.
+func TestFs_FailOpenEvery(t *testing.T) {
+	src := afero.NewMemMapFs()
+	tree(t, src, 6)
+	dst := New(afero.NewMemMapFs(), Faults{FailOpenEvery: 2})
+	copier := cp.Copier{SrcFs: src, DstFs: dst, Parallel: 1}
+	report, err := copier.CopyReport("from", "to")
+	if !errors.Is(err, ErrInjected) {
+		t.Fatalf("want the injected error, got %v", err)
+	}
+	if len(report.Failed) != 3 || len(report.Copied) != 3 {
+		t.Errorf("want 3 files failed and 3 copied, got %+v", report)
+	}
+	if dst.Opens() != 6 {
+		t.Errorf("want 6 opens counted, got %d", dst.Opens())
+	}
+}
+
+// TestFs_FailWritesOver tests that a write past the limit is cut short and
+// fails, while files within it are written whole.
+func TestFs_FailWritesOver(t *testing.T) {
+	fs := New(afero.NewMemMapFs(), Faults{FailWritesOver: 4})
+	if err := afero.WriteFile(fs, "small.txt", []byte("1234"), 0644); err != nil {
+		t.Fatalf("unexpected error within the limit: %v", err)
+	}
+	err := afero.WriteFile(fs, "large.txt", []byte("123456"), 0644)
+	if !errors.Is(err, ErrInjected) {
+		t.Fatalf("want the injected error, got %v", err)
+	}
+	if got, _ := afero.ReadFile(fs.Fs, "large.txt"); string(got) != "1234" {
+		t.Errorf("want the write cut short at 4 bytes, got %q", got)
+	}
+}
+
+// TestFs_Match tests that failures are limited to the paths Match accepts,
+// and that Latency slows every operation.
+func TestFs_Match(t *testing.T) {
+	injected := errors.New("disk on fire")
+	fs := New(afero.NewMemMapFs(), Faults{
+		FailOpenEvery: 1,
+		Latency:       time.Millisecond,
+		Match:         func(name string) bool { return name == "bad.txt" },
+		Err:           injected,
+	})
+	start := time.Now()
+	if err := afero.WriteFile(fs, "good.txt", []byte("data"), 0644); err != nil {
+		t.Fatalf("unexpected error for an unmatched path: %v", err)
+	}
+	if took := time.Since(start); took < 2*time.Millisecond {
+		t.Errorf("want the open and write delayed, took %v", took)
+	}
+	if err := afero.WriteFile(fs, "bad.txt", []byte("data"), 0644); !errors.Is(err, injected) {
+		t.Errorf("want the configured error, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package cp
+
+import (
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// copyDeduped links to to a file with the same content copied earlier in
+// the run if there is one, and copies it otherwise. It reports whether the
+// file was linked.
+func (c *copier) copyDeduped(from, to string) (int64, bool, bool, error) {
+	key, err := c.digest(from)
+	if err != nil {
+		return 0, false, false, err
+	}
+	if first, ok := c.digests.Load(key); ok && c.intact(first.(deduped)) {
+		overwrote, err := c.link(first.(deduped).path, to)
+		if err == nil {
+			atomic.AddInt64(&c.stats.files, 1)
+			return 0, overwrote, true, nil
+		}
+		// Links can't cross devices, among other things, in which case the
+		// file is copied after all.
+	}
+	n, overwrote, err := c.copyFile(from, to)
+	if err == nil {
+		if fi, err := c.dst.Stat(to); err == nil {
+			c.digests.LoadOrStore(key, deduped{path: to, size: fi.Size(), modTime: fi.ModTime()})
+		}
+	}
+	return n, overwrote, false, err
+}
+
+// deduped is the first copy of some content, as it was once written.
+type deduped struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// intact reports whether the first copy looks as it did once written, so
+// that linking to it still gives the content hashed. One changed since,
+// by another writer, is copied past instead.
+func (c *copier) intact(first deduped) bool {
+	fi, err := c.dst.Stat(first.path)
+	return err == nil && fi.Mode().IsRegular() && fi.Size() == first.size && fi.ModTime().Equal(first.modTime)
+}
+
+// digest identifies the content of the file by its size and SHA256 hash.
+// A match is taken as proof that two files are the same, so the hash is
+// cryptographic whatever Hasher is.
+func (c *copier) digest(path string) (string, error) {
+	if c.fds != nil {
+		c.fds.acquire(1)
+		defer c.fds.release(1)
+	}
+	f, err := c.src.Open(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "opening %s", path)
+	}
+	defer f.Close()
+	h := SHA256()
+	var r io.Reader = f
+	if c.limit != nil {
+		r = throttledReader{r, c.limit}
+	}
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", errors.Wrapf(err, "hashing %s", path)
+	}
+	return strconv.FormatInt(n, 10) + ":" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// link hard links to to target, replacing any file already at to.
+func (c *copier) link(target, to string) (bool, error) {
+	if err := c.dst.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return false, err
+	}
+	_, err := c.dst.Stat(to)
+	overwrote := err == nil
+	if overwrote {
+		if err := c.prepare(to); err != nil {
+			return overwrote, err
+		}
+		if err := c.dst.Remove(to); err != nil && !os.IsNotExist(err) {
+			return overwrote, err
+		}
+	} else {
+		c.create(to)
+	}
+	if err := os.Link(longPath(target), longPath(to)); err != nil {
+		return overwrote, err
+	}
+	return overwrote, nil
+}
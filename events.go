@@ -0,0 +1,105 @@
+package cp
+
+// EventKind is the stage of a copy an Event describes.
+type EventKind int
+
+const (
+	// WalkStarted is sent as a tree starts being walked for files.
+	WalkStarted EventKind = iota
+	// FileQueued is sent as a file is queued for the workers.
+	FileQueued
+	// FileCopied is sent once a file has been copied.
+	FileCopied
+	// FileSkipped is sent for a file that had already been copied.
+	FileSkipped
+	// FileFailed is sent for a file that could not be copied.
+	FileFailed
+	// Done is sent once a copy has finished, with the totals so far.
+	Done
+	// FileRemoved is sent for a file or directory Mirror removed.
+	FileRemoved
+	// FileRenamed is sent for a file or directory Move renamed into place.
+	FileRenamed
+	// FileStarted is sent as a worker starts copying a file.
+	FileStarted
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case WalkStarted:
+		return "walk started"
+	case FileQueued:
+		return "file queued"
+	case FileCopied:
+		return "file copied"
+	case FileSkipped:
+		return "file skipped"
+	case FileFailed:
+		return "file failed"
+	case Done:
+		return "done"
+	case FileRemoved:
+		return "file removed"
+	case FileRenamed:
+		return "file renamed"
+	case FileStarted:
+		return "file started"
+	}
+	return "unknown"
+}
+
+// Event describes the progress of a copy.
+type Event struct {
+	Kind EventKind
+	// File is the file concerned, or for WalkStarted the roots of the walk.
+	File FileReport
+	// Stats is a snapshot of the totals, for Done.
+	Stats Stats
+}
+
+// eventBuffer is the number of events held for a slow consumer before
+// further ones are dropped.
+const eventBuffer = 1024
+
+// Events returns a channel of events for every copy made by the Copier from
+// then on. The channel is buffered, and events are dropped rather than
+// stalling the workers when the consumer falls behind, so it suits updating
+// a display rather than keeping an exact account; the Report is that.
+// The channel is never closed, since the Copier can be reused.
+func (c *Copier) Events() <-chan Event {
+	ch := make(chan Event, eventBuffer)
+	if !c.events.CompareAndSwap(nil, &ch) {
+		return *c.events.Load()
+	}
+	return ch
+}
+
+// emit passes the event to the Observers, and sends it if anything is
+// listening and has room for it.
+func (c *Copier) emit(ev Event) {
+	c.observe(ev)
+	ch := c.events.Load()
+	if ch == nil {
+		return
+	}
+	select {
+	case *ch <- ev:
+	default:
+	}
+}
+
+// eventFor describes the outcome of a file.
+func eventFor(r result) Event {
+	switch {
+	case r.Err != nil:
+		return Event{Kind: FileFailed, File: r.FileReport}
+	case r.skipped:
+		return Event{Kind: FileSkipped, File: r.FileReport}
+	case r.removed:
+		return Event{Kind: FileRemoved, File: r.FileReport}
+	case r.renamed:
+		return Event{Kind: FileRenamed, File: r.FileReport}
+	default:
+		return Event{Kind: FileCopied, File: r.FileReport}
+	}
+}
@@ -0,0 +1,56 @@
+package cp
+
+import "sync"
+
+// fdReserve is how many descriptors are left for the rest of the process
+// when the budget is detected from the OS limit.
+const fdReserve = 32
+
+// openFiles returns the budget of simultaneously open files, or nil for none.
+func (c *Copier) openFiles() *semaphore {
+	n := c.MaxOpenFiles
+	if n == 0 {
+		if limit := maxOpenFiles(); limit > 0 {
+			n = limit - fdReserve
+		}
+	}
+	if n <= 0 {
+		return nil
+	}
+	// A file copy holds two descriptors at once.
+	if n < 2 {
+		n = 2
+	}
+	return newSemaphore(n)
+}
+
+// semaphore hands out a fixed number of tokens, several at a time.
+// Tokens are taken all at once so that workers holding some while waiting on
+// more cannot deadlock each other.
+type semaphore struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	free int
+}
+
+func newSemaphore(n int) *semaphore {
+	s := &semaphore{free: n}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+func (s *semaphore) acquire(n int) {
+	s.mu.Lock()
+	for s.free < n {
+		s.cond.Wait()
+	}
+	s.free -= n
+	s.mu.Unlock()
+}
+
+func (s *semaphore) release(n int) {
+	s.mu.Lock()
+	s.free += n
+	s.mu.Unlock()
+	s.cond.Broadcast()
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package cp
+
+import (
+	"os"
+	"syscall"
+)
+
+// inode identifies a file by the device it is on and its inode there.
+type inode struct {
+	dev, ino uint64
+}
+
+func fileID(info os.FileInfo) (inode, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return inode{}, false
+	}
+	return inode{dev: uint64(st.Dev), ino: uint64(st.Ino)}, true
+}
+
+// linkCount is the number of hard links to the file.
+func linkCount(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Nlink)
+	}
+	return 1
+}
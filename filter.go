@@ -0,0 +1,103 @@
+package cp
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"time"
+)
+
+// filtered reports whether the file is left out of the copy by the
+// filters. Filtered files are not queued, so never occupy a worker.
+func (c *copier) filtered(info os.FileInfo) bool {
+	size := info.Size()
+	if size < c.minSize {
+		return true
+	}
+	if c.maxSize > 0 && size > c.maxSize {
+		return true
+	}
+	if !after(info.ModTime(), c.modifiedAfter) || !before(info.ModTime(), c.modifiedBefore) {
+		return true
+	}
+	return false
+}
+
+// after reports whether t is after since, if since is set.
+func after(t, since time.Time) bool {
+	return since.IsZero() || t.After(since)
+}
+
+// before reports whether t is before until, if until is set.
+func before(t, until time.Time) bool {
+	return until.IsZero() || t.Before(until)
+}
+
+// excluded reports whether the path expressions or SkipHidden leave out
+// rel, relative to the root of the walk. A directory is left out if it
+// matches an exclusion or if nothing beneath it could match an inclusion,
+// so the walk need not descend into it.
+func (c *copier) excluded(rel string, info os.FileInfo) bool {
+	rel = strings.TrimPrefix(filepath.ToSlash(rel), "/")
+	if rel == "" || rel == "." {
+		return false
+	}
+	if c.skipHidden && hidden(info) {
+		return true
+	}
+	dir := info.IsDir()
+	for _, re := range c.exclude {
+		if re.MatchString(rel) {
+			return true
+		}
+	}
+	if len(c.include) == 0 {
+		return false
+	}
+	for _, in := range c.include {
+		if dir && in.under(rel) || !dir && in.MatchString(rel) {
+			return false
+		}
+	}
+	return true
+}
+
+// inclusion is an IncludeRegexp with the literal text, if any, that every
+// match must start with.
+type inclusion struct {
+	*regexp.Regexp
+	prefix string
+}
+
+func inclusions(res []*regexp.Regexp) []inclusion {
+	var in []inclusion
+	for _, re := range res {
+		in = append(in, inclusion{Regexp: re, prefix: anchoredPrefix(re)})
+	}
+	return in
+}
+
+// under reports whether a path beneath the directory could match.
+func (in inclusion) under(dir string) bool {
+	dir += "/"
+	return strings.HasPrefix(dir, in.prefix) || strings.HasPrefix(in.prefix, dir)
+}
+
+// anchoredPrefix is the literal text an expression anchored with ^ must
+// start with. Unanchored expressions can match anywhere, so have none.
+func anchoredPrefix(re *regexp.Regexp) string {
+	s, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	s = s.Simplify()
+	if s.Op != syntax.OpConcat || len(s.Sub) < 2 || s.Sub[0].Op != syntax.OpBeginText {
+		return ""
+	}
+	if lit := s.Sub[1]; lit.Op == syntax.OpLiteral && lit.Flags&syntax.FoldCase == 0 {
+		return string(lit.Rune)
+	}
+	return ""
+}
@@ -0,0 +1,47 @@
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly
+
+package cp
+
+import "golang.org/x/sys/unix"
+
+// The file flags owners may set, as in <sys/stat.h>; the system flags need
+// root, so aren't copied.
+const (
+	// userFlags is UF_SETTABLE, covering nodump, uchg, uappnd and hidden.
+	userFlags = 0x0000ffff
+	// userLocks is UF_IMMUTABLE and UF_APPEND, which prevent overwriting.
+	userLocks = 0x00000002 | 0x00000004
+)
+
+// copyFlags copies the user settable chflags flags, such as those behind
+// the Finder's hidden and locked, from one file onto the other. It goes
+// last, since a locked file can't be changed.
+func copyFlags(from, to string) error {
+	var src, dst unix.Stat_t
+	if err := unix.Stat(from, &src); err != nil {
+		return err
+	}
+	if err := unix.Stat(to, &dst); err != nil {
+		return err
+	}
+	have := uint32(dst.Flags)
+	want := have&^userFlags | uint32(src.Flags)&userFlags
+	if want == have {
+		return nil
+	}
+	return unix.Chflags(to, int(want))
+}
+
+// unlockFlags clears the immutable and append only flags so that the file
+// can be overwritten.
+func unlockFlags(path string) error {
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return err
+	}
+	flags := uint32(st.Flags)
+	if flags&userLocks == 0 {
+		return nil
+	}
+	return unix.Chflags(path, int(flags&^userLocks))
+}
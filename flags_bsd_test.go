@@ -0,0 +1,42 @@
+//go:build darwin || freebsd || netbsd || openbsd || dragonfly
+
+package cp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestCopier_FileFlags tests that the nodump and uchg flags are copied, and
+// that a locked copy can be overwritten.
+func TestCopier_FileFlags(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "from"), filepath.Join(dir, "to")
+	if err := os.WriteFile(from, []byte("data"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	const flags = 0x1 | 0x2 // UF_NODUMP | UF_IMMUTABLE
+	if err := unix.Chflags(from, flags); err != nil {
+		t.Skipf("file flags not supported: %v", err)
+	}
+	t.Cleanup(func() {
+		unix.Chflags(from, 0)
+		unix.Chflags(to, 0)
+	})
+	copier := Copier{PreserveAttributes: true, Clobber: true}
+	for ii := 0; ii < 2; ii++ {
+		if err := copier.Copy(from, to); err != nil {
+			t.Fatalf("unexpected error copying: %v", err)
+		}
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(to, &st); err != nil {
+		t.Fatalf("unexpected error while reading file metadata: %v", err)
+	}
+	if got := uint32(st.Flags) & userFlags; got != flags {
+		t.Fatalf("want flags %#x, got %#x", flags, got)
+	}
+}
@@ -0,0 +1,62 @@
+package cp
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Collision is what to do when flattening gives two files the same name.
+type Collision int
+
+const (
+	// CollisionFail fails every file after the first to claim a name.
+	CollisionFail Collision = iota
+	// CollisionNumber numbers the files after the first, copying "a.log"
+	// to "a-1.log", "a-2.log" and so on.
+	CollisionNumber
+	// CollisionKeepFirst skips the files after the first.
+	CollisionKeepFirst
+)
+
+// ErrCollision describes a file that flattening gave the same name as
+// another.
+type ErrCollision struct {
+	From, To string
+	// Other is the file that claimed To first.
+	Other string
+}
+
+func (err ErrCollision) Error() string {
+	return fmt.Sprintf("%q and %q both flatten to %q", err.Other, err.From, err.To)
+}
+
+// flat is where a file goes when flattening into to.
+func flat(to, target string) string {
+	return filepath.Join(to, filepath.Base(target))
+}
+
+// place claims the flattened destination for a file, resolving collisions,
+// and reports false if the file should be skipped.
+func (c *copier) place(from, to string) (string, bool, error) {
+	c.walkMu.Lock()
+	defer c.walkMu.Unlock()
+	other, taken := c.claimed[to]
+	if taken {
+		switch c.collisions {
+		case CollisionKeepFirst:
+			return to, false, nil
+		case CollisionNumber:
+			ext := filepath.Ext(to)
+			stem := strings.TrimSuffix(to, ext)
+			for n := 1; taken; n++ {
+				to = fmt.Sprintf("%s-%d%s", stem, n, ext)
+				_, taken = c.claimed[to]
+			}
+		default:
+			return to, false, ErrCollision{From: from, To: to, Other: other}
+		}
+	}
+	c.claimed[to] = from
+	return to, true, nil
+}
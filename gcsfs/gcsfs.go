@@ -0,0 +1,147 @@
+// Package gcsfs copies to and from Google Cloud Storage by presenting a
+// bucket as an afero.Fs.
+//
+//	client, err := storage.NewClient(ctx)
+//	copier := cp.Copier{DstFs: gcsfs.New(client, "bucket"), PreserveTimes: true}
+//	err = copier.Copy("/data", "/prefix")
+//
+// Objects are uploaded with resumable uploads, sent and retried in chunks,
+// so that large ones survive a dropped connection. Modification times are
+// kept in the goog-reserved-file-mtime metadata that gsutil uses, so each
+// tool sees the times the other set.
+package gcsfs
+
+import (
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+
+	"github.com/jackmordaunt/cp/objectfs"
+)
+
+// mtimeKey is the metadata holding an object's modification time, in
+// seconds since the epoch.
+const mtimeKey = "goog-reserved-file-mtime"
+
+// defaultChunkSize is the chunk size of resumable uploads by default.
+const defaultChunkSize = 16 << 20
+
+// Store is an objectfs.Store backed by a GCS bucket.
+type Store struct {
+	bucket *storage.BucketHandle
+	// ChunkSize is the size of each chunk of a resumable upload, 16 MiB
+	// by default. Objects smaller than one chunk are sent in a single
+	// request, and zero sends every object in one, without retrying.
+	ChunkSize int
+}
+
+var (
+	_ objectfs.Store   = &Store{}
+	_ objectfs.Toucher = &Store{}
+)
+
+// New presents the bucket as a filesystem.
+func New(client *storage.Client, bucket string) *objectfs.Fs {
+	return objectfs.New(NewStore(client, bucket))
+}
+
+// NewStore creates a Store for the bucket.
+func NewStore(client *storage.Client, bucket string) *Store {
+	return &Store{bucket: client.Bucket(bucket), ChunkSize: defaultChunkSize}
+}
+
+// Head describes the object at key.
+func (s *Store) Head(ctx context.Context, key string) (objectfs.Object, error) {
+	attrs, err := s.bucket.Object(key).Attrs(ctx)
+	if err != nil {
+		return objectfs.Object{}, translate(err)
+	}
+	return object(attrs), nil
+}
+
+// List describes the objects and common prefixes directly under prefix.
+func (s *Store) List(ctx context.Context, prefix string) ([]objectfs.Object, error) {
+	var objects []objectfs.Object
+	it := s.bucket.Objects(ctx, &storage.Query{Prefix: prefix, Delimiter: "/"})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, translate(err)
+		}
+		switch {
+		case attrs.Prefix != "":
+			objects = append(objects, objectfs.Object{Key: strings.TrimSuffix(attrs.Prefix, "/"), Dir: true})
+		case attrs.Name != prefix:
+			objects = append(objects, object(attrs))
+		}
+	}
+	return objects, nil
+}
+
+// Get reads the object at key from offset.
+func (s *Store) Get(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	r, err := s.bucket.Object(key).NewRangeReader(ctx, offset, -1)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return r, nil
+}
+
+// Put uploads the object at key. A failed upload is abandoned rather than
+// leaving a partial object behind.
+func (s *Store) Put(ctx context.Context, key string, r io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w := s.bucket.Object(key).NewWriter(ctx)
+	w.ChunkSize = s.ChunkSize
+	if _, err := io.Copy(w, r); err != nil {
+		cancel()
+		w.Close()
+		return err
+	}
+	return translate(w.Close())
+}
+
+// Delete removes the object at key.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	return translate(s.bucket.Object(key).Delete(ctx))
+}
+
+// Touch records mtime as the object's modification time.
+func (s *Store) Touch(ctx context.Context, key string, mtime time.Time) error {
+	_, err := s.bucket.Object(key).Update(ctx, storage.ObjectAttrsToUpdate{
+		Metadata: map[string]string{mtimeKey: strconv.FormatInt(mtime.Unix(), 10)},
+	})
+	return translate(err)
+}
+
+// object describes an object by its attributes, taking its modification
+// time from the metadata if recorded there.
+func object(attrs *storage.ObjectAttrs) objectfs.Object {
+	obj := objectfs.Object{Key: attrs.Name, Size: attrs.Size, ModTime: attrs.Updated}
+	if sec, err := strconv.ParseInt(attrs.Metadata[mtimeKey], 10, 64); err == nil {
+		obj.ModTime = time.Unix(sec, 0)
+	}
+	return obj
+}
+
+// translate maps missing objects onto os.ErrNotExist.
+func translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, storage.ErrBucketNotExist) {
+		return os.ErrNotExist
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+package cp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// Glob returns the paths on fs matching pattern, as afero.Glob does, but
+// with "**" as a whole path element matching any number of directories,
+// none included, so "src/**/*.go" matches both "src/a.go" and
+// "src/a/b/c.go". Nothing beneath a matching directory is matched too,
+// since copying the directory copies it. As with afero.Glob, errors
+// reading directories are ignored.
+func Glob(fs afero.Fs, pattern string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		return afero.Glob(fs, pattern)
+	}
+	sep := string(filepath.Separator)
+	elems := strings.Split(filepath.Clean(pattern), sep)
+	for _, elem := range elems {
+		if _, err := filepath.Match(elem, ""); err != nil {
+			return nil, err
+		}
+	}
+	// The walk starts from the last directory named outright.
+	n := 0
+	for n < len(elems) && !strings.ContainsAny(elems[n], `*?[\`) {
+		n++
+	}
+	root := strings.Join(elems[:n], sep)
+	switch {
+	case n == 0:
+		root = "."
+	case root == "":
+		root = sep
+	}
+	var matches []string
+	err := afero.Walk(fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return nil
+		}
+		ok, deeper := match(elems[n:], strings.Split(rel, sep))
+		switch {
+		case ok:
+			matches = append(matches, path)
+		case deeper:
+			return nil
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// match reports whether the path elements name match the pattern elements
+// pat, and whether a path beneath name could.
+func match(pat, name []string) (matched, deeper bool) {
+	if len(name) == 0 {
+		for _, p := range pat {
+			if p != "**" {
+				return false, true
+			}
+		}
+		return true, len(pat) > 0
+	}
+	if len(pat) == 0 {
+		return false, false
+	}
+	if pat[0] == "**" {
+		none, noneDeeper := match(pat[1:], name)
+		more, moreDeeper := match(pat, name[1:])
+		return none || more, noneDeeper || moreDeeper
+	}
+	if ok, _ := filepath.Match(pat[0], name[0]); !ok {
+		return false, false
+	}
+	return match(pat[1:], name[1:])
+}
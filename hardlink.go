@@ -0,0 +1,44 @@
+package cp
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// inodes tracks the files with several hard links copied in a run, if they
+// are being preserved.
+func (c *Copier) inodes() *sync.Map {
+	if c.PreserveLinks && isOs(c.srcFs()) && isOs(c.dstFs()) {
+		return &sync.Map{}
+	}
+	return nil
+}
+
+// copyLinked links to to the copy of a file hard linked to from if one has
+// been made in the run, and copies it otherwise. It reports whether the
+// file was linked.
+func (c *copier) copyLinked(from, to string) (int64, bool, bool, error) {
+	info, err := c.src.Stat(from)
+	if err != nil {
+		return 0, false, false, errors.Wrap(err, "reading file metadata")
+	}
+	id, ok := fileID(info)
+	if !ok || linkCount(info) < 2 {
+		n, overwrote, err := c.copyFile(from, to)
+		return n, overwrote, false, err
+	}
+	if first, ok := c.inodes.Load(id); ok {
+		overwrote, err := c.link(first.(string), to)
+		if err == nil {
+			atomic.AddInt64(&c.stats.files, 1)
+			return 0, overwrote, true, nil
+		}
+	}
+	n, overwrote, err := c.copyFile(from, to)
+	if err == nil {
+		c.inodes.LoadOrStore(id, to)
+	}
+	return n, overwrote, false, err
+}
@@ -0,0 +1,35 @@
+package cp
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"hash"
+	"hash/crc32"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+)
+
+// Hasher makes the hashes of file contents used for manifests, VerifyResume
+// and checking chunked copies.
+type Hasher func() hash.Hash
+
+// The hashers provided. SHA256 is the default. The non-cryptographic
+// XXHash64 and CRC32C are much faster, and enough where only accidental
+// corruption matters; BLAKE3 is both fast and cryptographic. Dedupe always
+// uses SHA256, since it links files whose hashes match.
+var (
+	SHA256   Hasher = sha256.New
+	SHA1     Hasher = sha1.New
+	XXHash64 Hasher = func() hash.Hash { return xxhash.New() }
+	BLAKE3   Hasher = func() hash.Hash { return blake3.New() }
+	CRC32C   Hasher = func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) }
+)
+
+// hasher is Hasher, defaulting to SHA256.
+func (c *Copier) hasher() Hasher {
+	if c.Hasher == nil {
+		return SHA256
+	}
+	return c.Hasher
+}
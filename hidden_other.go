@@ -0,0 +1,13 @@
+//go:build !windows
+
+package cp
+
+import (
+	"os"
+	"strings"
+)
+
+// hidden reports whether the file's name starts with a dot.
+func hidden(info os.FileInfo) bool {
+	return strings.HasPrefix(info.Name(), ".")
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package cp
+
+import (
+	"os"
+	"strings"
+	"syscall"
+)
+
+// hidden reports whether the file has the hidden attribute, or on file
+// systems that don't report attributes, whether its name starts with a dot.
+func hidden(info os.FileInfo) bool {
+	if data, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return data.FileAttributes&syscall.FILE_ATTRIBUTE_HIDDEN != 0
+	}
+	return strings.HasPrefix(info.Name(), ".")
+}
@@ -0,0 +1,299 @@
+package cp
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// retryBackoff is how much longer to wait before each successive retry.
+var retryBackoff = time.Second
+
+// Download is a file to fetch over HTTP or HTTPS.
+type Download struct {
+	// URL of the file.
+	URL string
+	// Checksum, when set, is verified as the file streams in, in the form
+	// "sha256:<hex>". The md5, sha1, sha256 and sha512 algorithms are
+	// supported. A file that doesn't match is removed.
+	Checksum string
+	// Retries is the number of times a failed request is retried, resuming
+	// from where it left off.
+	Retries int
+	// Client makes the requests, defaulting to http.DefaultClient.
+	Client *http.Client
+}
+
+// Download fetches the file into to, or into the directory to under the
+// name at the end of the URL if to is an existing directory. The body is
+// streamed through the same path as Copy, so Progress, MaxBytesPerSecond and
+// the clobber rules apply.
+func (c *Copier) Download(d Download, to string) (Report, error) {
+	defer c.start()()
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return Report{}, errors.Wrapf(err, "parsing %s", d.URL)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return Report{}, errors.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	sum, err := parseChecksum(d.Checksum)
+	if err != nil {
+		return Report{}, err
+	}
+	if fi, err := c.dstFs().Stat(to); err == nil && fi.IsDir() {
+		name := path.Base(u.Path)
+		if name == "/" || name == "." {
+			return Report{}, errors.Errorf("no file name in %s to copy into %s", d.URL, to)
+		}
+		to = filepath.Join(to, name)
+	}
+	_, err = c.dstFs().Stat(to)
+	if !os.IsNotExist(err) && !c.Clobber {
+		return Report{}, ErrClobberAvoided{to}
+	}
+	cp := c.copier()
+	cp.src = afero.FromIOFS{FS: httpFS{d: d, sum: sum}}
+	cp.chunkThreshold = 0
+	r := result{FileReport: FileReport{From: d.URL, To: to}}
+	start := time.Now()
+	r.Bytes, r.overwrote, r.Err = cp.copyFile(d.URL, to)
+	r.Duration = time.Since(start)
+	r.Err = cp.settle(r.Err)
+	if _, ok := errors.Cause(r.Err).(ErrChecksum); ok && cp.undo == nil {
+		c.dstFs().Remove(to)
+	}
+	report := Report{}
+	report.add(r)
+	c.finished(r)
+	return report, r.Err
+}
+
+// ErrChecksum means a downloaded file didn't match its expected checksum.
+type ErrChecksum struct {
+	URL  string
+	Want string
+	Got  string
+}
+
+func (err ErrChecksum) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: want %s, got %s", err.URL, err.Want, err.Got)
+}
+
+// checksum is an expected digest and the hash that produces it.
+type checksum struct {
+	algorithm string
+	hash      func() hash.Hash
+	want      string
+}
+
+func parseChecksum(s string) (*checksum, error) {
+	if s == "" {
+		return nil, nil
+	}
+	algorithm, want, ok := strings.Cut(s, ":")
+	if !ok {
+		return nil, errors.Errorf("checksum %q is not of the form algorithm:hex", s)
+	}
+	hashes := map[string]func() hash.Hash{
+		"md5":    md5.New,
+		"sha1":   sha1.New,
+		"sha256": sha256.New,
+		"sha512": sha512.New,
+	}
+	h, ok := hashes[strings.ToLower(algorithm)]
+	if !ok {
+		return nil, errors.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+	if _, err := hex.DecodeString(want); err != nil {
+		return nil, errors.Errorf("checksum %q is not hex", want)
+	}
+	return &checksum{algorithm: strings.ToLower(algorithm), hash: h, want: strings.ToLower(want)}, nil
+}
+
+// httpFS presents a single download as a file system, whatever name is
+// opened, so that it can be the source of copyFile.
+type httpFS struct {
+	d   Download
+	sum *checksum
+}
+
+func (h httpFS) Open(string) (fs.File, error) {
+	f := &httpFile{d: h.d, size: -1}
+	if f.d.Client == nil {
+		f.d.Client = http.DefaultClient
+	}
+	if h.sum != nil {
+		f.sum, f.hash = h.sum, h.sum.hash()
+	}
+	if err := f.request(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// httpFile streams a response body, re-requesting the remainder when the
+// transfer fails part way.
+type httpFile struct {
+	d       Download
+	body    io.ReadCloser
+	size    int64
+	modTime time.Time
+	read    int64
+	tries   int
+	sum     *checksum
+	hash    hash.Hash
+}
+
+// request fetches the file from where the last attempt left off, retrying
+// failed attempts.
+func (f *httpFile) request() error {
+	for {
+		err := f.get()
+		if err == nil || f.tries >= f.d.Retries || !retryable(err) {
+			return err
+		}
+		f.tries++
+		time.Sleep(time.Duration(f.tries) * retryBackoff)
+	}
+}
+
+func (f *httpFile) get() error {
+	req, err := http.NewRequest(http.MethodGet, f.d.URL, nil)
+	if err != nil {
+		return errors.Wrapf(err, "requesting %s", f.d.URL)
+	}
+	if f.read > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", f.read))
+	}
+	resp, err := f.d.Client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "requesting %s", f.d.URL)
+	}
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && f.read > 0:
+	case resp.StatusCode == http.StatusOK:
+		// Servers that ignore ranges send the whole file again, so skip
+		// what has already been read.
+		if _, err := io.CopyN(io.Discard, resp.Body, f.read); err != nil {
+			resp.Body.Close()
+			return errors.Wrapf(err, "resuming %s", f.d.URL)
+		}
+		if resp.ContentLength >= 0 {
+			f.size = resp.ContentLength
+		}
+		if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+			f.modTime = t
+		}
+	default:
+		resp.Body.Close()
+		return errStatus{url: f.d.URL, code: resp.StatusCode}
+	}
+	f.body = resp.Body
+	return nil
+}
+
+func (f *httpFile) Read(p []byte) (int, error) {
+	for {
+		n, err := f.body.Read(p)
+		f.read += int64(n)
+		if f.hash != nil {
+			f.hash.Write(p[:n])
+		}
+		if err == io.EOF {
+			return n, f.verify()
+		}
+		if err == nil || n > 0 {
+			return n, nil
+		}
+		if f.tries >= f.d.Retries {
+			return 0, errors.Wrapf(err, "reading %s", f.d.URL)
+		}
+		f.body.Close()
+		f.tries++
+		time.Sleep(time.Duration(f.tries) * retryBackoff)
+		if err := f.request(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// verify checks the file once all of it has been read.
+func (f *httpFile) verify() error {
+	if f.size >= 0 && f.read != f.size {
+		return errors.Errorf("%s: read %d bytes, expected %d", f.d.URL, f.read, f.size)
+	}
+	if f.sum != nil {
+		got := hex.EncodeToString(f.hash.Sum(nil))
+		if got != f.sum.want {
+			return ErrChecksum{
+				URL:  f.d.URL,
+				Want: f.sum.algorithm + ":" + f.sum.want,
+				Got:  f.sum.algorithm + ":" + got,
+			}
+		}
+	}
+	return io.EOF
+}
+
+func (f *httpFile) Stat() (fs.FileInfo, error) {
+	return httpInfo{f}, nil
+}
+
+func (f *httpFile) Close() error {
+	return f.body.Close()
+}
+
+// httpInfo describes a download from its response headers.
+type httpInfo struct {
+	f *httpFile
+}
+
+func (i httpInfo) Name() string {
+	u, err := url.Parse(i.f.d.URL)
+	if err != nil {
+		return ""
+	}
+	return path.Base(u.Path)
+}
+
+func (i httpInfo) Size() int64        { return i.f.size }
+func (i httpInfo) Mode() fs.FileMode  { return 0644 }
+func (i httpInfo) ModTime() time.Time { return i.f.modTime }
+func (i httpInfo) IsDir() bool        { return false }
+func (i httpInfo) Sys() interface{}   { return nil }
+
+// errStatus is an unsuccessful HTTP response.
+type errStatus struct {
+	url  string
+	code int
+}
+
+func (err errStatus) Error() string {
+	return fmt.Sprintf("requesting %s: %s", err.url, http.StatusText(err.code))
+}
+
+// retryable reports whether a failed request may succeed if tried again.
+// Client errors other than rate limiting won't.
+func retryable(err error) bool {
+	if err, ok := err.(errStatus); ok {
+		return err.code >= 500 || err.code == http.StatusTooManyRequests
+	}
+	return true
+}
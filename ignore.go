@@ -0,0 +1,149 @@
+package cp
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// ignoreRule is a line of an ignore file.
+type ignoreRule struct {
+	// elems is the pattern split at slashes, starting with "**" unless it
+	// is anchored to the directory of the file.
+	elems []string
+	// negate re-includes what an earlier rule left out.
+	negate bool
+	// dirs is whether only directories match.
+	dirs bool
+}
+
+// ignores holds the rules read from the ignore files of a walk, by the
+// slash separated path of their directory relative to its root.
+type ignores struct {
+	fs     afero.Fs
+	name   string
+	nested bool
+	rules  sync.Map
+}
+
+// ignores is the set of ignore files for a walk, nil unless IgnoreFile is
+// set.
+func (c *copier) ignores() *ignores {
+	if c.ignoreFile == "" {
+		return nil
+	}
+	return &ignores{fs: c.src, name: c.ignoreFile, nested: c.nestedIgnores}
+}
+
+// read reads the rules of the ignore file in the directory dir, at rel
+// relative to the root of the walk. Beneath the root, they are only read
+// if nested.
+func (ig *ignores) read(dir, rel string) error {
+	if ig == nil {
+		return nil
+	}
+	rel = clean(rel)
+	if rel != "" && !ig.nested {
+		return nil
+	}
+	name := filepath.Join(dir, ig.name)
+	f, err := ig.fs.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "opening %s", name)
+	}
+	defer f.Close()
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseIgnore(line)
+		if err != nil {
+			return errors.Wrapf(err, "parsing %s", name)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "reading %s", name)
+	}
+	if len(rules) > 0 {
+		ig.rules.Store(rel, rules)
+	}
+	return nil
+}
+
+// parseIgnore parses a line of an ignore file, in the syntax of gitignore:
+// a leading ! negates it, a trailing / matches only directories, and a
+// pattern with a slash other than at its end is anchored to the directory
+// of the file, while one without matches at any depth.
+func parseIgnore(line string) (ignoreRule, error) {
+	var rule ignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate, line = true, line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirs, line = true, strings.TrimRight(line, "/")
+	}
+	anchored := strings.Contains(line, "/")
+	rule.elems = strings.Split(strings.TrimPrefix(line, "/"), "/")
+	for _, elem := range rule.elems {
+		if _, err := path.Match(elem, ""); err != nil {
+			return ignoreRule{}, errors.Wrapf(err, "pattern %q", line)
+		}
+	}
+	if !anchored {
+		rule.elems = append([]string{"**"}, rule.elems...)
+	}
+	return rule, nil
+}
+
+// ignored reports whether the ignore files of the directories above rel,
+// relative to the root of the walk, leave it out. The last rule matching
+// wins, those of deeper files coming after those of shallower ones.
+func (ig *ignores) ignored(rel string, dir bool) bool {
+	if ig == nil {
+		return false
+	}
+	rel = clean(rel)
+	if rel == "" {
+		return false
+	}
+	name := strings.Split(rel, "/")
+	ignored := false
+	for depth := 0; depth < len(name); depth++ {
+		rules, ok := ig.rules.Load(strings.Join(name[:depth], "/"))
+		if !ok {
+			continue
+		}
+		for _, rule := range rules.([]ignoreRule) {
+			if rule.dirs && !dir {
+				continue
+			}
+			if ok, _ := match(rule.elems, name[depth:]); ok {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// clean is rel slash separated, without leading slashes, and "" for the
+// root.
+func clean(rel string) string {
+	rel = strings.Trim(filepath.ToSlash(rel), "/")
+	if rel == "." {
+		return ""
+	}
+	return rel
+}
@@ -0,0 +1,95 @@
+package cp
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// CopyFS copies the entire contents of src into the directory to, on the
+// Copier's filesystem. This extracts embedded assets (embed.FS) or any other
+// fs.FS through the same workers, clobber rules and error reporting as Copy.
+func (c *Copier) CopyFS(src fs.FS, to string) error {
+	defer c.start()()
+	_, err := c.dstFs().Stat(to)
+	if !os.IsNotExist(err) && !c.Clobber && c.OnConflict == nil {
+		return ErrClobberAvoided{to}
+	}
+	if err := c.dstFs().MkdirAll(to, 0755); err != nil {
+		return errors.Wrapf(err, "creating %s", to)
+	}
+	cp := c.copier()
+	cp.src = afero.FromIOFS{FS: src}
+	_, err = cp.run(func() {
+		cp.walkFS(src, to)
+	})
+	return err
+}
+
+// walkFS queues every file in src, which uses slash separated paths
+// regardless of platform.
+func (c *copier) walkFS(src fs.FS, to string) {
+	c.log(slog.LevelDebug, "walk started", "from", ".", "to", to)
+	c.emit(Event{Kind: WalkStarted, File: FileReport{From: ".", To: to}})
+	walker := func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if c.excluded(path, info) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if c.flatten {
+				if c.deepest(path) {
+					return fs.SkipDir
+				}
+				return nil
+			}
+			rel := filepath.FromSlash(path)
+			if rel == "." {
+				rel = ""
+			}
+			target, err := c.target(to, rel)
+			if err != nil {
+				c.results <- result{FileReport: FileReport{From: path, Err: err}}
+				return fs.SkipDir
+			}
+			if target == "" {
+				return fs.SkipDir
+			}
+			c.mkdir(path, target, info)
+			if c.deepest(rel) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		target, err := c.target(to, filepath.FromSlash(path))
+		if err != nil {
+			c.results <- result{FileReport: FileReport{From: path, Err: err}}
+			return nil
+		}
+		if c.flatten && target != "" {
+			target = flat(to, target)
+		}
+		if target != "" {
+			c.enqueue(path, target, info)
+		}
+		return nil
+	}
+	if err := fs.WalkDir(src, ".", walker); err != nil {
+		c.results <- result{
+			FileReport: FileReport{From: ".", To: to, Err: errors.Wrap(err, "walking file system")},
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package cp
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// journal is an append-only log of the progress of copies, from which one
+// that was interrupted can be resumed. Each line is a kind followed by
+// quoted fields:
+//
+//	copy "from" "to"            a root being copied
+//	queued "root" "from" "to"   a file found beneath the root to copy
+//	mkdir "root" "from" "to"    a directory the copy created
+//	walked "root"               the root's walk finished, every file queued
+//	done "to"                   a file copied, its contents synced to disk
+//
+// A line cut short by a crash is ignored.
+type journal struct {
+	mu  sync.Mutex
+	f   *os.File
+	err error
+}
+
+func openJournal(path string) (*journal, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening journal %s", path)
+	}
+	return &journal{f: f}, nil
+}
+
+// record appends a line, keeping the first error for close to report. A nil
+// journal records nothing.
+func (j *journal) record(kind string, fields ...string) {
+	if j == nil {
+		return
+	}
+	line := []string{kind}
+	for _, field := range fields {
+		line = append(line, strconv.Quote(field))
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, err := j.f.WriteString(strings.Join(line, " ") + "\n"); err != nil && j.err == nil {
+		j.err = errors.Wrap(err, "writing journal")
+	}
+}
+
+func (j *journal) close() error {
+	if j == nil {
+		return nil
+	}
+	err := j.f.Close()
+	if j.err != nil {
+		return j.err
+	}
+	return errors.Wrap(err, "closing journal")
+}
+
+// journalRoot is a root copied, as recorded in a journal.
+type journalRoot struct {
+	from, to string
+	walked   bool
+	queued   []job
+	// made are the directories the copy created, owed the metadata of
+	// their source once resumed.
+	made []job
+}
+
+// readJournal reads back the roots a journal records, in the order they
+// were copied, and the destinations of the files done.
+func readJournal(path string) ([]*journalRoot, map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "opening journal %s", path)
+	}
+	defer f.Close()
+	var roots []*journalRoot
+	byFrom := map[string]*journalRoot{}
+	done := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kind, fields, ok := parseJournalLine(scanner.Text())
+		if !ok {
+			continue
+		}
+		switch {
+		case kind == "copy" && len(fields) == 2:
+			if _, ok := byFrom[fields[0]]; !ok {
+				root := &journalRoot{from: fields[0], to: fields[1]}
+				byFrom[root.from] = root
+				roots = append(roots, root)
+			}
+		case kind == "queued" && len(fields) == 3:
+			if root, ok := byFrom[fields[0]]; ok {
+				root.queued = append(root.queued, job{From: fields[1], To: fields[2]})
+			}
+		case kind == "mkdir" && len(fields) == 3:
+			if root, ok := byFrom[fields[0]]; ok {
+				root.made = append(root.made, job{From: fields[1], To: fields[2]})
+			}
+		case kind == "walked" && len(fields) == 1:
+			if root, ok := byFrom[fields[0]]; ok {
+				root.walked = true
+			}
+		case kind == "done" && len(fields) == 1:
+			done[fields[0]] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, errors.Wrapf(err, "reading journal %s", path)
+	}
+	return roots, done, nil
+}
+
+// parseJournalLine splits a line into its kind and unquoted fields,
+// reporting false if it is malformed.
+func parseJournalLine(line string) (string, []string, bool) {
+	kind, rest, _ := strings.Cut(line, " ")
+	var fields []string
+	for rest != "" {
+		quoted, err := strconv.QuotedPrefix(rest)
+		if err != nil {
+			return "", nil, false
+		}
+		field, err := strconv.Unquote(quoted)
+		if err != nil {
+			return "", nil, false
+		}
+		fields = append(fields, field)
+		rest = strings.TrimPrefix(rest[len(quoted):], " ")
+	}
+	return kind, fields, true
+}
+
+// Resume continues the copies recorded in the journal at path, as kept
+// with Journal, leaving out the files they finished. Roots whose walk
+// finished aren't walked again; the files they queued are copied from the
+// list. The Copier should be set up as it was for the original copies, and
+// the journal goes on recording so that an interrupted Resume can itself
+// be resumed.
+func (c *Copier) Resume(path string) (Report, error) {
+	return c.ResumeContext(context.Background(), path)
+}
+
+// ResumeContext is Resume, stopping early if ctx is cancelled.
+func (c *Copier) ResumeContext(ctx context.Context, path string) (Report, error) {
+	roots, done, err := readJournal(path)
+	if err != nil {
+		return Report{}, err
+	}
+	j, err := openJournal(path)
+	if err != nil {
+		return Report{}, err
+	}
+	seen := c.session()
+	for to := range done {
+		seen.Store(to, struct{}{})
+	}
+	report := Report{}
+	var errs []error
+	for _, root := range roots {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		r, err := c.resume(ctx, j, seen, root)
+		report.merge(r)
+		if f, ok := err.(Failures); ok {
+			errs = append(errs, f.list...)
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := j.close(); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) > 0 {
+		return report, Failures{errs}
+	}
+	return report, nil
+}
+
+// resume continues copying one root, from the files it queued if its walk
+// finished and by walking it again otherwise. Either way, the directories
+// the interrupted copy created are given their metadata once done, as
+// they would have been.
+func (c *Copier) resume(ctx context.Context, j *journal, seen *sync.Map, root *journalRoot) (Report, error) {
+	defer c.start()()
+	fromFi, err := c.srcFs().Stat(root.from)
+	if err != nil {
+		return Report{}, errors.Wrap(err, "reading file metadata")
+	}
+	cp := c.copier()
+	cp.ctx = ctx
+	cp.seen = seen
+	cp.journal = j
+	cp.root = root.from
+	cp.made = map[string]bool{}
+	for _, d := range root.made {
+		cp.made[d.To] = true
+	}
+	if fromFi.IsDir() && !root.walked {
+		return cp.copy(root.from, root.to)
+	}
+	queued := root.queued
+	if !fromFi.IsDir() {
+		queued = []job{{From: root.from, To: root.to}}
+	}
+	cp.autoParallel(root.from, root.to)
+	return cp.run(func() {
+		for _, d := range root.made {
+			if info, err := cp.src.Stat(d.From); err == nil {
+				cp.addDir(dir{From: d.From, To: d.To, info: info, created: true})
+			}
+		}
+		for _, q := range queued {
+			if ctx.Err() != nil {
+				return
+			}
+			info, err := cp.src.Stat(q.From)
+			if err != nil {
+				cp.results <- result{FileReport: FileReport{
+					From: q.From,
+					To:   q.To,
+					Err:  errors.Wrap(err, "reading file metadata"),
+				}}
+				continue
+			}
+			cp.enqueue(q.From, q.To, info)
+		}
+	})
+}
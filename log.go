@@ -0,0 +1,34 @@
+package cp
+
+import (
+	"context"
+	"log/slog"
+)
+
+// log records an event with the Logger, if there is one.
+func (c *Copier) log(level slog.Level, msg string, args ...any) {
+	if c.Logger == nil {
+		return
+	}
+	c.Logger.Log(context.Background(), level, msg, args...)
+}
+
+// finished logs the outcome of a file and reports its progress and event.
+func (c *Copier) finished(r result) {
+	switch {
+	case r.Err != nil:
+		c.log(slog.LevelError, "file failed", "from", r.From, "to", r.To, "error", r.Err)
+	case r.skipped:
+		c.log(slog.LevelDebug, "file skipped", "from", r.From, "to", r.To, "reason", "already copied")
+	case r.linked:
+		c.log(slog.LevelInfo, "file linked", "from", r.From, "to", r.To, "overwrote", r.overwrote)
+	case r.removed:
+		c.log(slog.LevelInfo, "file removed", "to", r.To)
+	case r.renamed:
+		c.log(slog.LevelInfo, "file renamed", "from", r.From, "to", r.To)
+	default:
+		c.log(slog.LevelInfo, "file copied", "from", r.From, "to", r.To, "bytes", r.Bytes, "duration", r.Duration, "overwrote", r.overwrote)
+	}
+	c.emit(eventFor(r))
+	c.progress(r)
+}
@@ -0,0 +1,100 @@
+package cp
+
+import (
+	"os"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// osPaths wraps the OS filesystem so that every path given to it is made
+// safe for the platform; on Windows, paths too long for the legacy API get
+// the \\?\ prefix. Reports still carry the paths as the caller gave them.
+func osPaths(fs afero.Fs) afero.Fs {
+	if _, ok := fs.(*afero.OsFs); ok && longPaths {
+		return longPathFs{fs}
+	}
+	return fs
+}
+
+// isOs reports whether fs is the OS filesystem.
+func isOs(fs afero.Fs) bool {
+	switch fs := fs.(type) {
+	case *afero.OsFs:
+		return true
+	case longPathFs:
+		return isOs(fs.Fs)
+	}
+	return false
+}
+
+// longPathFs applies longPath to every path given to the filesystem.
+type longPathFs struct {
+	afero.Fs
+}
+
+var (
+	_ afero.Lstater    = longPathFs{}
+	_ afero.Symlinker  = longPathFs{}
+	_ afero.LinkReader = longPathFs{}
+)
+
+func (fs longPathFs) Create(name string) (afero.File, error) {
+	return fs.Fs.Create(longPath(name))
+}
+
+func (fs longPathFs) Mkdir(name string, perm os.FileMode) error {
+	return fs.Fs.Mkdir(longPath(name), perm)
+}
+
+func (fs longPathFs) MkdirAll(path string, perm os.FileMode) error {
+	return fs.Fs.MkdirAll(longPath(path), perm)
+}
+
+func (fs longPathFs) Open(name string) (afero.File, error) {
+	return fs.Fs.Open(longPath(name))
+}
+
+func (fs longPathFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	return fs.Fs.OpenFile(longPath(name), flag, perm)
+}
+
+func (fs longPathFs) Remove(name string) error {
+	return fs.Fs.Remove(longPath(name))
+}
+
+func (fs longPathFs) RemoveAll(path string) error {
+	return fs.Fs.RemoveAll(longPath(path))
+}
+
+func (fs longPathFs) Rename(oldname, newname string) error {
+	return fs.Fs.Rename(longPath(oldname), longPath(newname))
+}
+
+func (fs longPathFs) Stat(name string) (os.FileInfo, error) {
+	return fs.Fs.Stat(longPath(name))
+}
+
+func (fs longPathFs) Chmod(name string, mode os.FileMode) error {
+	return fs.Fs.Chmod(longPath(name), mode)
+}
+
+func (fs longPathFs) Chown(name string, uid, gid int) error {
+	return fs.Fs.Chown(longPath(name), uid, gid)
+}
+
+func (fs longPathFs) Chtimes(name string, atime, mtime time.Time) error {
+	return fs.Fs.Chtimes(longPath(name), atime, mtime)
+}
+
+func (fs longPathFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	return fs.Fs.(afero.Lstater).LstatIfPossible(longPath(name))
+}
+
+func (fs longPathFs) SymlinkIfPossible(oldname, newname string) error {
+	return fs.Fs.(afero.Symlinker).SymlinkIfPossible(oldname, longPath(newname))
+}
+
+func (fs longPathFs) ReadlinkIfPossible(name string) (string, error) {
+	return fs.Fs.(afero.LinkReader).ReadlinkIfPossible(longPath(name))
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package cp
+
+// longPaths is whether paths need rewriting for length.
+const longPaths = false
+
+// longPath leaves paths as they are where they have no length limit.
+func longPath(path string) string {
+	return path
+}
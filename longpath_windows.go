@@ -0,0 +1,33 @@
+//go:build windows
+
+package cp
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// longPaths is whether paths need rewriting for length.
+const longPaths = true
+
+// maxPath is the length beyond which the Windows API needs extended length
+// paths. Directories are limited to 12 characters less, leaving room for an
+// 8.3 file name.
+const maxPath = 260 - 12
+
+// longPath makes path an extended length path if it is long enough to need
+// one. Such paths are passed to the filesystem as they are, so they must be
+// absolute, clean and use backslashes.
+func longPath(path string) string {
+	if len(path) < maxPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
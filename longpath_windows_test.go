@@ -0,0 +1,39 @@
+//go:build windows
+
+package cp
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// TestLongPath tests that trees deeper than MAX_PATH copy.
+func TestLongPath(t *testing.T) {
+	dir := t.TempDir()
+	deep := filepath.Join(dir, "from", strings.Repeat(strings.Repeat("d", 50)+string(filepath.Separator), 8), "file.txt")
+	fs := osPaths(afero.NewOsFs())
+	if err := afero.WriteFile(fs, deep, []byte("deep"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	copier := Copier{}
+	if err := copier.Copy(filepath.Join(dir, "from"), filepath.Join(dir, "to")); err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	rel, _ := filepath.Rel(filepath.Join(dir, "from"), deep)
+	data, err := afero.ReadFile(fs, filepath.Join(dir, "to", rel))
+	if err != nil || string(data) != "deep" {
+		t.Fatalf("want deep file copied, got %q, %v", data, err)
+	}
+	for in, want := range map[string]string{
+		`C:\short`:                                   `C:\short`,
+		`\\?\C:\` + strings.Repeat("x", 300):         `\\?\C:\` + strings.Repeat("x", 300),
+		`\\server\share\` + strings.Repeat("x", 300): `\\?\UNC\server\share\` + strings.Repeat("x", 300),
+	} {
+		if got := longPath(in); got != want {
+			t.Errorf("longPath(%.20q...) = %.30q..., want %.30q...", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package cp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// manifests reports whether the copy records a manifest at all.
+func (c *Copier) manifests() bool {
+	return c.Manifest != nil || c.ManifestFile != ""
+}
+
+// manifest writes the digests of the files in the report to Manifest and
+// ManifestFile, alongside err from the copy. A copy rolled back has nothing
+// to list.
+func (c *Copier) manifest(report Report, root string, err error) error {
+	if !c.manifests() || (err != nil && c.Rollback) {
+		return err
+	}
+	var files []FileReport
+	for _, list := range [][]FileReport{report.Copied, report.Overwritten, report.Linked} {
+		for _, f := range list {
+			if f.Digest != "" {
+				files = append(files, f)
+			}
+		}
+	}
+	sort.Slice(files, func(ii, jj int) bool { return files[ii].To < files[jj].To })
+	var errs []error
+	if c.Manifest != nil {
+		for _, f := range files {
+			if _, err := fmt.Fprintf(c.Manifest, "%s  %s\n", f.Digest, f.To); err != nil {
+				errs = append(errs, errors.Wrap(err, "writing manifest"))
+				break
+			}
+		}
+	}
+	if c.ManifestFile != "" {
+		buf := &bytes.Buffer{}
+		for _, f := range files {
+			rel, err := filepath.Rel(root, f.To)
+			if err != nil {
+				rel = f.To
+			}
+			fmt.Fprintf(buf, "%s  %s\n", f.Digest, filepath.ToSlash(rel))
+		}
+		path := filepath.Join(root, c.ManifestFile)
+		if err := afero.WriteFile(c.dstFs(), path, buf.Bytes(), 0644); err != nil {
+			errs = append(errs, errors.Wrapf(err, "writing manifest %s", path))
+		}
+	}
+	if len(errs) == 0 {
+		return err
+	}
+	if f, ok := err.(Failures); ok {
+		errs = append(f.list, errs...)
+	} else if err != nil {
+		errs = append([]error{err}, errs...)
+	}
+	return Failures{errs}
+}
+
+// hash reads back the file copied to to, giving the hash of what landed
+// rather than of what was meant to.
+func (c *copier) hash(to string) (string, error) {
+	if c.fds != nil {
+		c.fds.acquire(1)
+		defer c.fds.release(1)
+	}
+	f, err := c.dst.Open(to)
+	if err != nil {
+		return "", errors.Wrapf(err, "opening %s", to)
+	}
+	defer f.Close()
+	h := c.hasher()
+	if _, err := io.Copy(h, c.cancellable(f)); err != nil {
+		return "", errors.Wrapf(err, "hashing %s", to)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// VerifyManifest checks the files listed in a manifest on DstFs, in the
+// format of sha256sum as written by Manifest and ManifestFile, against
+// their hashes by Hasher, Parallel at a time. Listed paths that are
+// relative are taken as relative to root. Files missing are reported as
+// Missing and those whose contents differ as Changed, with just To set.
+func (c *Copier) VerifyManifest(manifest, root string) (Diff, error) {
+	if c.Fs == nil {
+		c.Fs = afero.NewOsFs()
+	}
+	c.init()
+	data, err := afero.ReadFile(c.dstFs(), manifest)
+	if err != nil {
+		return Diff{}, errors.Wrapf(err, "reading manifest %s", manifest)
+	}
+	want := map[string]string{}
+	for ii, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		digest, path, ok := strings.Cut(line, " ")
+		if _, err := hex.DecodeString(digest); !ok || err != nil || len(path) < 2 {
+			return Diff{}, errors.Errorf("%s:%d: not a line of a manifest", manifest, ii+1)
+		}
+		// A space or an asterisk, for text or binary mode, comes between.
+		path = filepath.FromSlash(path[1:])
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		want[path] = strings.ToLower(digest)
+	}
+	cp := c.copier()
+	parallel := cp.parallel
+	if parallel < 1 {
+		parallel = 10
+	}
+	var (
+		mu   sync.Mutex
+		diff Diff
+		errs []error
+		wg   sync.WaitGroup
+	)
+	work := make(chan string)
+	for ii := 0; ii < parallel; ii++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range work {
+				got, err := cp.hash(path)
+				mu.Lock()
+				switch {
+				case os.IsNotExist(errors.Cause(err)):
+					diff.Missing = append(diff.Missing, path)
+				case err != nil:
+					errs = append(errs, err)
+				case got != want[path]:
+					diff.Changed = append(diff.Changed, Mismatch{To: path, Content: true})
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for path := range want {
+		work <- path
+	}
+	close(work)
+	wg.Wait()
+	sort.Strings(diff.Missing)
+	sort.Slice(diff.Changed, func(ii, jj int) bool {
+		return diff.Changed[ii].To < diff.Changed[jj].To
+	})
+	if len(errs) > 0 {
+		return diff, Failures{errs}
+	}
+	return diff, nil
+}
@@ -0,0 +1,63 @@
+package cp
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// touchUp gives the existing file at to the metadata of the one at from,
+// leaving its contents alone. It reports true if there is no file at to.
+func (c *copier) touchUp(from, to string) (bool, error) {
+	fromFi, err := c.src.Stat(from)
+	if err != nil {
+		return false, errors.Wrap(err, "reading file metadata")
+	}
+	toFi, err := c.dst.Stat(to)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.Wrap(err, "reading file metadata")
+	}
+	if fromFi.IsDir() != toFi.IsDir() {
+		return false, errors.Errorf("%s and %s are not both files or both directories", from, to)
+	}
+	if err := c.preserve(from, to, fromFi); err != nil {
+		return false, err
+	}
+	atomic.AddInt64(&c.stats.files, 1)
+	return false, nil
+}
+
+// setMetadata gives to the mode, modification time and owner in want, as
+// far as they are preserved, changing only those that differ from have.
+func (c *copier) setMetadata(to string, want, have os.FileInfo) error {
+	if uid, gid, ok := owner(want); ok && c.owner {
+		if huid, hgid, _ := owner(have); uid != huid || gid != hgid {
+			if err := c.dst.Chown(to, uid, gid); err != nil {
+				if !errors.Is(err, os.ErrPermission) {
+					return errors.Wrapf(err, "setting the owner of %s", to)
+				}
+				// Without the privilege to give files away, the group
+				// may still be set if the user is in it.
+				c.dst.Chown(to, -1, gid)
+			}
+		}
+	}
+	// Changing the owner can clear the setuid and setgid bits, so the mode
+	// follows it.
+	if c.mode && want.Mode() != have.Mode() {
+		if err := c.dst.Chmod(to, want.Mode()); err != nil {
+			return errors.Wrapf(err, "setting the mode of %s", to)
+		}
+	}
+	if c.times && !want.ModTime().Equal(have.ModTime()) {
+		if err := c.dst.Chtimes(to, time.Time{}, want.ModTime()); err != nil {
+			return errors.Wrapf(err, "setting the times of %s", to)
+		}
+	}
+	return nil
+}
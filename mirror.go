@@ -0,0 +1,121 @@
+package cp
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// Mirror makes the directory to match the directory from, as rsync -a
+// --delete does: files new or changed, by size or modification time, are
+// copied over whatever is there, and everything at to that nothing is
+// copied to is removed and listed in the report's Removed. Modification
+// times are kept, so that later mirrors tell what changed. What the filters
+// leave out is left alone at to, unless DeleteExcluded is set. Nothing is
+// copied or removed if the source can't be walked in full.
+func (c *Copier) Mirror(from, to string) (Report, error) {
+	p, err := c.PlanMirror(from, to)
+	if err != nil {
+		return Report{}, err
+	}
+	defer c.start()()
+	cp := c.copier()
+	cp.times = true
+	return cp.applyAll(p)
+}
+
+// PlanMirror works out what Mirror would do without changing anything.
+// Unchanged files are planned as OpSkip, and what Mirror would remove as
+// OpRemove.
+func (c *Copier) PlanMirror(from, to string) (Plan, error) {
+	if c.IncludeRoot {
+		to = filepath.Join(to, filepath.Base(filepath.Clean(from)))
+	}
+	if c.Fs == nil {
+		c.Fs = afero.NewOsFs()
+	}
+	fromFi, err := c.srcFs().Stat(from)
+	if err != nil {
+		return Plan{}, errors.Wrap(err, "reading file metadata")
+	}
+	if !fromFi.IsDir() {
+		return Plan{}, ErrNotDirectory{from}
+	}
+	if from == to {
+		return Plan{}, nil
+	}
+	p, err := c.planTree(from, to, fromFi)
+	if err != nil {
+		return Plan{}, err
+	}
+	cp := c.copier()
+	targets := map[string]bool{to: true}
+	for ii, op := range p.Ops {
+		targets[op.To] = true
+		if op.Kind == OpOverwrite && cp.unchanged(op.From, op.To) {
+			p.Ops[ii].Kind = OpSkip
+		}
+	}
+	stale, err := cp.stale(to, targets, c.DeleteExcluded)
+	if err != nil {
+		return Plan{}, err
+	}
+	for _, path := range stale {
+		p.Ops = append(p.Ops, Op{Kind: OpRemove, To: path})
+	}
+	return p, nil
+}
+
+// unchanged reports whether to looks like a copy of from already, being a
+// file of the same size and modification time.
+func (c *copier) unchanged(from, to string) bool {
+	fromFi, err := c.src.Stat(from)
+	if err != nil {
+		return false
+	}
+	toFi, err := c.dst.Stat(to)
+	if err != nil {
+		return false
+	}
+	return fromFi.Mode().IsRegular() && toFi.Mode().IsRegular() &&
+		fromFi.Size() == toFi.Size() && fromFi.ModTime().Equal(toFi.ModTime())
+}
+
+// stale lists what lies beneath to that isn't among the targets of the
+// copy, a directory standing for everything in it. What the filters leave
+// out is left out unless all, and directories as deep as MaxDepth allows
+// aren't looked in.
+func (c *copier) stale(to string, targets map[string]bool, all bool) ([]string, error) {
+	if _, err := c.dst.Stat(to); os.IsNotExist(err) {
+		return nil, nil
+	}
+	var stale []string
+	err := afero.Walk(c.dst, to, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(to, path)
+		if err != nil {
+			return err
+		}
+		switch {
+		case rel == ".":
+			return nil
+		case !all && (c.excluded(rel, info) || !info.IsDir() && c.filtered(info)):
+		case !targets[path]:
+			stale = append(stale, path)
+		case !info.IsDir() || !c.deepest(rel):
+			return nil
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return stale, errors.Wrapf(err, "walking %s", to)
+	}
+	return stale, nil
+}
@@ -0,0 +1,80 @@
+package cp
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Strategy is how a file's contents are moved from source to destination.
+type Strategy int
+
+const (
+	// StrategyBuffered reads the source into a buffer and writes it out,
+	// which works on every filesystem.
+	StrategyBuffered Strategy = iota
+	// StrategyMmap maps source files of at least MmapThreshold into memory
+	// and writes them straight from there, saving a copy through a buffer.
+	// It applies only to files opened from the OS filesystem on Unix, and
+	// not while throttling or transforming; other files are buffered.
+	StrategyMmap
+)
+
+// defaultMmapThreshold is the size from which files are mapped when no
+// MmapThreshold is set. Mapping costs more to set up than a few buffered
+// reads, so only pays off for large files.
+const defaultMmapThreshold = 64 << 20
+
+// mmapWindow is how much of a mapped file is written at once, so that
+// cancellation and progress are noticed part way through.
+const mmapWindow = 4 << 20
+
+// mmapThreshold is the size files are mapped from, or zero if they aren't.
+func (c *Copier) mmapThreshold() int64 {
+	switch {
+	case c.Strategy != StrategyMmap || c.Transform != nil || c.MaxBytesPerSecond > 0:
+		return 0
+	case c.MmapThreshold > 0:
+		return c.MmapThreshold
+	default:
+		return defaultMmapThreshold
+	}
+}
+
+// mapped maps the opened source file into memory if it should be, returning
+// its contents and a func to unmap them.
+func (c *copier) mapped(f io.Reader, info os.FileInfo) ([]byte, func(), bool) {
+	if c.mmapThreshold <= 0 || !info.Mode().IsRegular() || info.Size() < c.mmapThreshold {
+		return nil, nil, false
+	}
+	file, ok := f.(*os.File)
+	if !ok {
+		return nil, nil, false
+	}
+	data, err := mmap(file, info.Size())
+	if err != nil {
+		// The file is copied the ordinary way instead.
+		c.log(slog.LevelDebug, "mapping failed", "from", file.Name(), "err", err)
+		return nil, nil, false
+	}
+	return data, func() { munmap(data) }, true
+}
+
+// writeMapped writes the mapped contents to w a window at a time, stopping
+// if the copy is cancelled.
+func (c *copier) writeMapped(w io.Writer, data []byte) (int64, error) {
+	var written int64
+	for len(data) > 0 {
+		if err := c.ctx.Err(); err != nil {
+			return written, err
+		}
+		n := min(len(data), mmapWindow)
+		m, err := w.Write(data[:n])
+		written += int64(m)
+		if err != nil {
+			return written, err
+		}
+		data = data[n:]
+	}
+	return written, nil
+}
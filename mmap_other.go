@@ -0,0 +1,16 @@
+//go:build !unix
+
+package cp
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// mmap fails, so files are buffered instead.
+func mmap(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.Errorf("mapping %s: not supported", f.Name())
+}
+
+func munmap([]byte) {}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package cp
+
+import (
+	"math"
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// mmap maps size bytes of f into memory, read only.
+func mmap(f *os.File, size int64) ([]byte, error) {
+	if size > math.MaxInt {
+		return nil, errors.Errorf("%s is too large to map", f.Name())
+	}
+	data, err := unix.Mmap(int(f.Fd()), 0, int(size), unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return nil, errors.Wrapf(err, "mapping %s", f.Name())
+	}
+	// The file is read once from start to end.
+	unix.Madvise(data, unix.MADV_SEQUENTIAL)
+	return data, nil
+}
+
+func munmap(data []byte) {
+	unix.Munmap(data)
+}
@@ -0,0 +1,142 @@
+package cp
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// Move moves from to to, as mv does. Where both are on the same filesystem
+// and to doesn't exist, from is renamed into place whole and listed in the
+// report's Renamed. Otherwise, or if the rename fails as it does across
+// devices, from is copied as CopyContext would and each file removed once
+// its copy is in place, followed by the directories left empty. A file that
+// fails to copy stays where it was, listed in Failed, so a partial move can
+// be finished by moving again. With Rollback, a failed copy removes nothing,
+// as does a copy refused before anything was copied, such as over an
+// existing to without Clobber.
+// MetadataOnly would have sources removed whose contents were never copied,
+// so Move refuses it.
+func (c *Copier) Move(from, to string) (Report, error) {
+	return c.MoveContext(context.Background(), from, to)
+}
+
+// MoveContext is Move, stopping early if ctx is cancelled. Files copied
+// before then are removed from the source as usual.
+func (c *Copier) MoveContext(ctx context.Context, from, to string) (Report, error) {
+	if c.IncludeRoot {
+		to = filepath.Join(to, filepath.Base(filepath.Clean(from)))
+	}
+	if from == to {
+		return Report{}, nil
+	}
+	if c.MetadataOnly {
+		return Report{}, errors.New("moving with MetadataOnly would remove files whose contents weren't copied")
+	}
+	if r, ok := c.rename(from, to); ok {
+		report := Report{}
+		report.add(r)
+		c.finished(r)
+		return report, r.Err
+	}
+	report, err := c.copyReport(ctx, c.session(), from, to)
+	if err != nil && (c.Rollback || report.empty()) {
+		return report, err
+	}
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, list := range [][]FileReport{report.Copied, report.Overwritten, report.Linked} {
+		for _, f := range list {
+			if err := c.srcFs().Remove(f.From); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, errors.Wrapf(err, "removing %s", f.From))
+			}
+		}
+	}
+	if err := c.prune(from, to); err != nil {
+		errs = append(errs, err)
+	}
+	if len(errs) == 1 {
+		return report, errs[0]
+	}
+	if len(errs) > 0 {
+		return report, Failures{errs}
+	}
+	return report, nil
+}
+
+// rename moves from to to in one step if it can, reporting whether it did.
+// It only tries when the copy would reproduce from exactly and would
+// neither overwrite nor merge into anything at to.
+func (c *Copier) rename(from, to string) (result, bool) {
+	if !sameFs(c.srcFs(), c.dstFs()) || !c.verbatim() {
+		return result{}, false
+	}
+	fromFi, err := lstat(c.srcFs(), from)
+	if err != nil {
+		return result{}, false
+	}
+	if _, err := lstat(c.dstFs(), to); !os.IsNotExist(err) {
+		return result{}, false
+	}
+	if fromFi.IsDir() && c.within(from, fromFi, to) {
+		return result{}, false
+	}
+	if err := c.srcFs().Rename(from, to); err != nil {
+		return result{}, false
+	}
+	r := result{FileReport: FileReport{From: from, To: to}, renamed: true}
+	if fromFi.Mode().IsRegular() {
+		r.Bytes = fromFi.Size()
+	}
+	return r, true
+}
+
+// verbatim reports whether a copy would reproduce the tree as it is, with
+// nothing left out, renamed, rewritten, limited or recorded along the way,
+// so that a rename can stand in for it.
+func (c *Copier) verbatim() bool {
+	return c.Transform == nil && c.MaxTotalBytes == 0 && c.Rename == nil && !c.Flatten && c.MaxDepth == 0 &&
+		c.MinSize == 0 && c.MaxSize == 0 && c.ModifiedAfter.IsZero() && c.ModifiedBefore.IsZero() &&
+		len(c.IncludeRegexp) == 0 && len(c.ExcludeRegexp) == 0 && !c.SkipHidden && c.IgnoreFile == "" &&
+		!c.FollowSymlinks && !c.manifests() && c.Journal == ""
+}
+
+// prune removes the directories beneath from, and from itself, that moving
+// has left empty, so long as each has its counterpart beneath to. Those
+// still holding files that weren't moved are kept, as are those the copy
+// left out or put elsewhere.
+func (c *Copier) prune(from, to string) error {
+	fi, err := c.srcFs().Stat(from)
+	if err != nil || !fi.IsDir() {
+		return nil
+	}
+	var dirs []string
+	err = afero.Walk(c.srcFs(), from, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "walking %s", from)
+	}
+	// Deepest first, so that parents are empty by the time they're reached.
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		rel, err := filepath.Rel(from, dir)
+		if err != nil {
+			continue
+		}
+		if fi, err := c.dstFs().Stat(filepath.Join(to, rel)); err != nil || !fi.IsDir() {
+			continue
+		}
+		c.srcFs().Remove(dir)
+	}
+	return nil
+}
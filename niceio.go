@@ -0,0 +1,20 @@
+package cp
+
+import (
+	"log/slog"
+	"runtime"
+)
+
+// background lowers the IO priority of the goroutine's thread, if asked to.
+// Priority belongs to the thread, so the goroutine keeps it to itself: the
+// thread is locked to it and never unlocked, which has the runtime end the
+// thread along with the goroutine rather than hand it on.
+func (c *copier) background() {
+	if !c.niceIO {
+		return
+	}
+	runtime.LockOSThread()
+	if err := lowerIOPriority(); err != nil {
+		c.log(slog.LevelWarn, "io priority not lowered", "error", err)
+	}
+}
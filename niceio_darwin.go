@@ -0,0 +1,16 @@
+//go:build darwin
+
+package cp
+
+import "golang.org/x/sys/unix"
+
+const (
+	prioDarwinThread = 3
+	prioDarwinBG     = 0x1000
+)
+
+// lowerIOPriority marks the calling thread as background, which throttles
+// its IO behind that of other work.
+func lowerIOPriority() error {
+	return unix.Setpriority(prioDarwinThread, 0, prioDarwinBG)
+}
@@ -0,0 +1,21 @@
+//go:build linux
+
+package cp
+
+import "golang.org/x/sys/unix"
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+// lowerIOPriority puts the calling thread in the idle IO class, so it is
+// only served when the disk has nothing else to do.
+func lowerIOPriority() error {
+	_, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, 0, ioprioClassIdle<<ioprioClassShift)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package cp
+
+import (
+	"runtime"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestLowerIOPriority tests that the thread is put in the idle IO class.
+func TestLowerIOPriority(t *testing.T) {
+	done := make(chan uintptr)
+	go func() {
+		// The thread keeps its lowered priority, so dies with the goroutine.
+		runtime.LockOSThread()
+		if err := lowerIOPriority(); err != nil {
+			t.Errorf("unexpected error lowering io priority: %v", err)
+		}
+		prio, _, _ := unix.Syscall(unix.SYS_IOPRIO_GET, ioprioWhoProcess, 0, 0)
+		done <- prio
+	}()
+	if class := <-done >> ioprioClassShift; class != ioprioClassIdle {
+		t.Errorf("want the idle class, got %d", class)
+	}
+}
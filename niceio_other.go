@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !windows
+
+package cp
+
+// lowerIOPriority does nothing, there being no IO priority to lower.
+func lowerIOPriority() error {
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package cp
+
+import "golang.org/x/sys/windows"
+
+const threadModeBackgroundBegin = 0x00010000
+
+var setThreadPriority = windows.NewLazySystemDLL("kernel32.dll").NewProc("SetThreadPriority")
+
+// lowerIOPriority puts the calling thread in background mode, lowering its
+// IO and memory priority.
+func lowerIOPriority() error {
+	thread, err := windows.GetCurrentThread()
+	if err != nil {
+		return err
+	}
+	if ok, _, err := setThreadPriority.Call(uintptr(thread), threadModeBackgroundBegin); ok == 0 {
+		return err
+	}
+	return nil
+}
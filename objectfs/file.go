@@ -0,0 +1,198 @@
+package objectfs
+
+import (
+	"io"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/spf13/afero"
+)
+
+// File is an open object or directory.
+type File struct {
+	fs   *Fs
+	name string
+	key  string
+	info fileInfo
+
+	// Reading.
+	r   io.ReadCloser
+	off int64
+	dir []os.FileInfo
+
+	// Writing, through a pipe into Store.Put.
+	pw      *io.PipeWriter
+	done    chan error
+	written int64
+}
+
+var _ afero.File = &File{}
+
+// Name of the file as opened.
+func (f *File) Name() string { return f.name }
+
+// Read reads from the current offset.
+func (f *File) Read(p []byte) (int, error) {
+	if f.pw != nil || f.info.Dir {
+		return 0, f.err("read", ErrNotSupported)
+	}
+	if f.r == nil {
+		r, err := f.fs.store.Get(f.fs.ctx, f.key, f.off)
+		if err != nil {
+			return 0, f.err("read", err)
+		}
+		f.r = r
+	}
+	n, err := f.r.Read(p)
+	f.off += int64(n)
+	return n, err
+}
+
+// ReadAt reads len(p) bytes from off with a request of its own.
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if f.pw != nil || f.info.Dir {
+		return 0, f.err("read", ErrNotSupported)
+	}
+	r, err := f.fs.store.Get(f.fs.ctx, f.key, off)
+	if err != nil {
+		return 0, f.err("read", err)
+	}
+	defer r.Close()
+	n, err := io.ReadFull(r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Seek moves the read offset, reopening the object there on the next read.
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if f.pw != nil {
+		return 0, f.err("seek", ErrNotSupported)
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.off
+	case io.SeekEnd:
+		offset += f.info.Size()
+	}
+	if offset < 0 {
+		return 0, f.err("seek", os.ErrInvalid)
+	}
+	if offset != f.off && f.r != nil {
+		f.r.Close()
+		f.r = nil
+	}
+	f.off = offset
+	return offset, nil
+}
+
+// Write appends to the object being written.
+func (f *File) Write(p []byte) (int, error) {
+	if f.pw == nil {
+		return 0, f.err("write", ErrNotSupported)
+	}
+	n, err := f.pw.Write(p)
+	f.written += int64(n)
+	return n, err
+}
+
+// WriteAt is not supported.
+func (f *File) WriteAt(p []byte, off int64) (int, error) {
+	return 0, f.err("write", ErrNotSupported)
+}
+
+// WriteString writes s.
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// Truncate is not supported.
+func (f *File) Truncate(size int64) error {
+	return f.err("truncate", ErrNotSupported)
+}
+
+// Sync does nothing; writes are committed on Close.
+func (f *File) Sync() error { return nil }
+
+// Close finishes reading, or commits the written object to the store.
+func (f *File) Close() error {
+	if f.pw != nil {
+		f.pw.Close()
+		err := <-f.done
+		f.pw = nil
+		if err != nil {
+			return f.err("close", err)
+		}
+		return nil
+	}
+	if f.r != nil {
+		err := f.r.Close()
+		f.r = nil
+		return err
+	}
+	return nil
+}
+
+// Stat describes the file.
+func (f *File) Stat() (os.FileInfo, error) {
+	if f.pw != nil {
+		info := f.info
+		info.Object.Size = f.written
+		return info, nil
+	}
+	return f.info, nil
+}
+
+// Readdir lists the directory, in name order.
+func (f *File) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.info.Dir {
+		return nil, f.err("readdir", ErrNotSupported)
+	}
+	if f.dir == nil {
+		prefix := f.key
+		if prefix != "" {
+			prefix += "/"
+		}
+		objects, err := f.fs.store.List(f.fs.ctx, prefix)
+		if err != nil {
+			return nil, f.err("readdir", err)
+		}
+		f.dir = make([]os.FileInfo, 0, len(objects))
+		for _, obj := range objects {
+			obj.Key = path.Clean(obj.Key)
+			f.dir = append(f.dir, fileInfo{obj})
+		}
+		sort.Slice(f.dir, func(i, j int) bool { return f.dir[i].Name() < f.dir[j].Name() })
+	}
+	if count <= 0 {
+		entries := f.dir
+		f.dir = f.dir[len(f.dir):]
+		return entries, nil
+	}
+	if len(f.dir) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.dir) {
+		count = len(f.dir)
+	}
+	entries := f.dir[:count]
+	f.dir = f.dir[count:]
+	return entries, nil
+}
+
+// Readdirnames lists the names in the directory.
+func (f *File) Readdirnames(n int) ([]string, error) {
+	entries, err := f.Readdir(n)
+	names := make([]string, len(entries))
+	for ii, e := range entries {
+		names[ii] = e.Name()
+	}
+	return names, err
+}
+
+func (f *File) err(op string, err error) error {
+	return &os.PathError{Op: op, Path: f.name, Err: err}
+}
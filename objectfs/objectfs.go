@@ -0,0 +1,247 @@
+// Package objectfs presents an object store, such as S3, as an afero.Fs so
+// that it can be copied to and from like any other filesystem.
+//
+// Object stores have no real directories: a directory exists while objects
+// exist beneath its prefix, and creating one is a no-op. Files can be read
+// with seeking, but are written in a single pass from start to finish.
+package objectfs
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// ErrNotSupported is returned for operations object stores cannot perform,
+// such as writing at an offset.
+var ErrNotSupported = errors.New("not supported by object storage")
+
+// Store is the handful of operations an object store must provide.
+// Keys are slash separated without a leading slash.
+type Store interface {
+	// Head describes the object at key, returning an error satisfying
+	// os.IsNotExist if there is none.
+	Head(ctx context.Context, key string) (Object, error)
+	// List describes the objects directly under prefix, and the common
+	// prefixes beneath it as directories. The prefix is empty or ends in
+	// a slash.
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// Get reads the object at key starting from offset.
+	Get(ctx context.Context, key string, offset int64) (io.ReadCloser, error)
+	// Put writes the object at key with the contents of r.
+	Put(ctx context.Context, key string, r io.Reader) error
+	// Delete removes the object at key.
+	Delete(ctx context.Context, key string) error
+}
+
+// Toucher is implemented by stores that can keep a modification time of
+// an object's own, such as in its metadata, which Chtimes then sets. Other
+// stores keep the time each object was written.
+type Toucher interface {
+	Touch(ctx context.Context, key string, mtime time.Time) error
+}
+
+// Object describes an object, or a common prefix when Dir is set.
+type Object struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+	Dir     bool
+}
+
+// Fs is an afero.Fs backed by a Store.
+type Fs struct {
+	store Store
+	ctx   context.Context
+}
+
+var _ afero.Fs = &Fs{}
+
+// New presents the store as a filesystem.
+func New(store Store) *Fs {
+	return NewWithContext(context.Background(), store)
+}
+
+// NewWithContext presents the store as a filesystem whose requests are made
+// with ctx.
+func NewWithContext(ctx context.Context, store Store) *Fs {
+	return &Fs{store: store, ctx: ctx}
+}
+
+// key converts a filesystem path to an object key.
+func key(name string) string {
+	k := path.Clean(filepath.ToSlash(name))
+	k = strings.TrimPrefix(k, "/")
+	if k == "." {
+		return ""
+	}
+	return k
+}
+
+// Name of the filesystem.
+func (fs *Fs) Name() string { return "objectfs" }
+
+// Create creates the object, truncating any that exists.
+func (fs *Fs) Create(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+}
+
+// Mkdir does nothing as directories exist implicitly.
+func (fs *Fs) Mkdir(name string, perm os.FileMode) error { return nil }
+
+// MkdirAll does nothing as directories exist implicitly.
+func (fs *Fs) MkdirAll(path string, perm os.FileMode) error { return nil }
+
+// Open opens the object, or directory, for reading.
+func (fs *Fs) Open(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the object for reading or, given a write flag, for writing
+// from the start. Appending is not supported.
+func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&os.O_APPEND != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrNotSupported}
+	}
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		return fs.create(name), nil
+	}
+	info, err := fs.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return &File{fs: fs, name: name, key: key(name), info: info.(fileInfo)}, nil
+}
+
+func (fs *Fs) create(name string) *File {
+	pr, pw := io.Pipe()
+	f := &File{
+		fs:   fs,
+		name: name,
+		key:  key(name),
+		info: fileInfo{Object{Key: key(name), ModTime: time.Now()}},
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+	go func() {
+		err := fs.store.Put(fs.ctx, f.key, pr)
+		pr.CloseWithError(err)
+		f.done <- err
+	}()
+	return f
+}
+
+// Remove deletes the object.
+func (fs *Fs) Remove(name string) error {
+	if err := fs.store.Delete(fs.ctx, key(name)); err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+// RemoveAll deletes the object, or every object beneath the directory.
+func (fs *Fs) RemoveAll(name string) error {
+	info, err := fs.Stat(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fs.Remove(name)
+	}
+	prefix := key(name)
+	if prefix != "" {
+		prefix += "/"
+	}
+	objects, err := fs.store.List(fs.ctx, prefix)
+	if err != nil {
+		return &os.PathError{Op: "removeall", Path: name, Err: err}
+	}
+	for _, obj := range objects {
+		if err := fs.RemoveAll("/" + obj.Key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename copies the object to its new key and deletes the old one.
+func (fs *Fs) Rename(oldname, newname string) error {
+	r, err := fs.store.Get(fs.ctx, key(oldname), 0)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	defer r.Close()
+	if err := fs.store.Put(fs.ctx, key(newname), r); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return fs.Remove(oldname)
+}
+
+// Stat describes the object, or the directory if objects exist beneath it.
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	k := key(name)
+	if k == "" {
+		return fileInfo{Object{Dir: true}}, nil
+	}
+	obj, err := fs.store.Head(fs.ctx, k)
+	if err == nil {
+		return fileInfo{obj}, nil
+	}
+	if !os.IsNotExist(errors.Cause(err)) {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	children, err := fs.store.List(fs.ctx, k+"/")
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if len(children) == 0 {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
+	return fileInfo{Object{Key: k, Dir: true}}, nil
+}
+
+// Chmod is ignored; objects have no modes.
+func (fs *Fs) Chmod(name string, mode os.FileMode) error { return nil }
+
+// Chown is ignored; objects have no owners.
+func (fs *Fs) Chown(name string, uid, gid int) error { return nil }
+
+// Chtimes sets the modification time of the object if the store is a
+// Toucher, and is ignored otherwise.
+func (fs *Fs) Chtimes(name string, atime, mtime time.Time) error {
+	t, ok := fs.store.(Toucher)
+	if !ok {
+		return nil
+	}
+	if err := t.Touch(fs.ctx, key(name), mtime); err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	return nil
+}
+
+// fileInfo describes an object.
+type fileInfo struct {
+	Object
+}
+
+func (fi fileInfo) Name() string       { return path.Base("/" + fi.Key) }
+func (fi fileInfo) Size() int64        { return fi.Object.Size }
+func (fi fileInfo) ModTime() time.Time { return fi.Object.ModTime }
+func (fi fileInfo) IsDir() bool        { return fi.Dir }
+func (fi fileInfo) Sys() interface{}   { return fi.Object }
+func (fi fileInfo) Mode() os.FileMode {
+	if fi.Dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
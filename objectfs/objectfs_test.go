@@ -0,0 +1,158 @@
+package objectfs
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+
+	"github.com/jackmordaunt/cp"
+)
+
+// memStore is a Store held in memory.
+type memStore struct {
+	sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *memStore) Head(ctx context.Context, key string) (Object, error) {
+	s.Lock()
+	defer s.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return Object{}, os.ErrNotExist
+	}
+	return Object{Key: key, Size: int64(len(data)), ModTime: time.Now()}, nil
+}
+
+func (s *memStore) List(ctx context.Context, prefix string) ([]Object, error) {
+	s.Lock()
+	defer s.Unlock()
+	dirs := map[string]bool{}
+	var objects []Object
+	for key, data := range s.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(key, prefix)
+		if ii := strings.Index(rest, "/"); ii >= 0 {
+			dirs[prefix+rest[:ii]] = true
+			continue
+		}
+		objects = append(objects, Object{Key: key, Size: int64(len(data))})
+	}
+	for dir := range dirs {
+		objects = append(objects, Object{Key: dir, Dir: true})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+func (s *memStore) Get(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	s.Lock()
+	defer s.Unlock()
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(data[offset:])), nil
+}
+
+func (s *memStore) Put(ctx context.Context, key string, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.objects[key] = data
+	return nil
+}
+
+func (s *memStore) Delete(ctx context.Context, key string) error {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+// TestFs_RoundTrip tests that a tree copied into the store and back out
+// is unchanged.
+func TestFs_RoundTrip(t *testing.T) {
+	local := afero.NewMemMapFs()
+	files := map[string]string{
+		"/from/foo.exe":         "foo",
+		"/from/dir/bar.exe":     "bar",
+		"/from/dir/sub/baz.exe": "baz",
+	}
+	for path, data := range files {
+		if err := afero.WriteFile(local, path, []byte(data), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	store := New(&memStore{objects: map[string][]byte{}})
+	up := cp.Copier{SrcFs: local, DstFs: store}
+	if err := up.Copy("/from", "/prefix"); err != nil {
+		t.Fatalf("unexpected error while uploading: %v", err)
+	}
+	down := cp.Copier{SrcFs: store, DstFs: local}
+	if err := down.Copy("/prefix", "/to"); err != nil {
+		t.Fatalf("unexpected error while downloading: %v", err)
+	}
+	for path, want := range files {
+		got, err := afero.ReadFile(local, strings.Replace(path, "/from", "/to", 1))
+		if err != nil {
+			t.Fatalf("unexpected error while reading %s: %v", path, err)
+		}
+		if string(got) != want {
+			t.Errorf("want %s to contain %q, got %q", path, want, got)
+		}
+	}
+	if err := store.RemoveAll("/prefix"); err != nil {
+		t.Fatalf("unexpected error while removing: %v", err)
+	}
+	if _, err := store.Stat("/prefix"); !os.IsNotExist(err) {
+		t.Fatalf("want prefix removed, got %v", err)
+	}
+}
+
+// touchStore is a memStore that keeps modification times.
+type touchStore struct {
+	memStore
+	times map[string]time.Time
+}
+
+func (s *touchStore) Touch(ctx context.Context, key string, mtime time.Time) error {
+	s.Lock()
+	defer s.Unlock()
+	s.times[key] = mtime
+	return nil
+}
+
+// TestFs_Touch tests that preserving times records them with a store that
+// keeps them.
+func TestFs_Touch(t *testing.T) {
+	local := afero.NewMemMapFs()
+	if err := afero.WriteFile(local, "/from/foo.exe", []byte("foo"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := local.Chtimes("/from/foo.exe", mtime, mtime); err != nil {
+		t.Fatalf("unexpected error while setting times: %v", err)
+	}
+	store := &touchStore{memStore: memStore{objects: map[string][]byte{}}, times: map[string]time.Time{}}
+	copier := cp.Copier{SrcFs: local, DstFs: New(store), PreserveTimes: true}
+	if err := copier.Copy("/from", "/prefix"); err != nil {
+		t.Fatalf("unexpected error while uploading: %v", err)
+	}
+	if got := store.times["prefix/foo.exe"]; !got.Equal(mtime) {
+		t.Errorf("want modification time %v, got %v", mtime, got)
+	}
+}
@@ -0,0 +1,52 @@
+package cp
+
+// Observer is told of each stage of the copies a Copier makes, through its
+// Observers. Unlike Progress, its methods are called from the workers as
+// things happen, concurrently, so they must be safe for that and should
+// return quickly. Embed NopObserver to implement only some of them.
+type Observer interface {
+	// OnWalk is called as a tree starts being walked for files.
+	OnWalk(from, to string)
+	// OnQueue is called as a file of size bytes is queued for the workers.
+	OnQueue(from, to string, size int64)
+	// OnCopyStart is called as a worker starts copying a file.
+	OnCopyStart(from, to string)
+	// OnCopyDone is called once a file has been copied or linked, or
+	// renamed or removed by Move or Mirror.
+	OnCopyDone(f FileReport)
+	// OnSkip is called for a file that wasn't copied, having been copied
+	// already or being left alone.
+	OnSkip(f FileReport)
+	// OnError is called for a file that failed, with the reason in f.Err.
+	OnError(f FileReport)
+}
+
+// NopObserver is an Observer that ignores everything.
+type NopObserver struct{}
+
+func (NopObserver) OnWalk(from, to string)              {}
+func (NopObserver) OnQueue(from, to string, size int64) {}
+func (NopObserver) OnCopyStart(from, to string)         {}
+func (NopObserver) OnCopyDone(f FileReport)             {}
+func (NopObserver) OnSkip(f FileReport)                 {}
+func (NopObserver) OnError(f FileReport)                {}
+
+// observe passes the event to each of the Observers.
+func (c *Copier) observe(ev Event) {
+	for _, o := range c.Observers {
+		switch ev.Kind {
+		case WalkStarted:
+			o.OnWalk(ev.File.From, ev.File.To)
+		case FileQueued:
+			o.OnQueue(ev.File.From, ev.File.To, ev.File.Bytes)
+		case FileStarted:
+			o.OnCopyStart(ev.File.From, ev.File.To)
+		case FileCopied, FileRemoved, FileRenamed:
+			o.OnCopyDone(ev.File)
+		case FileSkipped:
+			o.OnSkip(ev.File)
+		case FileFailed:
+			o.OnError(ev.File)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package cp
+
+import (
+	"log/slog"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// Option configures the Copier behind the package level functions.
+type Option func(*Copier)
+
+// WithClobber allows existing files to be overwritten.
+func WithClobber() Option {
+	return func(c *Copier) { c.Clobber = true }
+}
+
+// WithParallel sets the number of parallel workers.
+func WithParallel(n int) Option {
+	return func(c *Copier) { c.Parallel = n }
+}
+
+// WithFs sets the filesystem copied from and to.
+func WithFs(fs afero.Fs) Option {
+	return func(c *Copier) { c.Fs = fs }
+}
+
+// WithSrcFs sets the filesystem copied from.
+func WithSrcFs(fs afero.Fs) Option {
+	return func(c *Copier) { c.SrcFs = fs }
+}
+
+// WithDstFs sets the filesystem copied to.
+func WithDstFs(fs afero.Fs) Option {
+	return func(c *Copier) { c.DstFs = fs }
+}
+
+// WithIncludeRoot places a copied directory inside the destination.
+func WithIncludeRoot() Option {
+	return func(c *Copier) { c.IncludeRoot = true }
+}
+
+// WithProgress calls fn each time a file has been dealt with.
+func WithProgress(fn func(Progress)) Option {
+	return func(c *Copier) { c.Progress = fn }
+}
+
+// WithLogger logs copy events to logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Copier) { c.Logger = logger }
+}
+
+// WithObserver adds o to the Observers.
+func WithObserver(o Observer) Option {
+	return func(c *Copier) { c.Observers = append(c.Observers, o) }
+}
+
+// newCopier configures a Copier with the options.
+func newCopier(opts []Option) *Copier {
+	c := &Copier{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.Fs == nil {
+		c.Fs = afero.NewOsFs()
+	}
+	return c
+}
+
+// Copy copies the file or directory from to to.
+//
+//	err := cp.Copy("src", "dst", cp.WithClobber(), cp.WithParallel(4))
+func Copy(from, to string, opts ...Option) error {
+	return newCopier(opts).Copy(from, to)
+}
+
+// CopyFile copies the file from to to, failing if from is a directory.
+func CopyFile(from, to string, opts ...Option) error {
+	c := newCopier(opts)
+	fi, err := c.srcFs().Stat(from)
+	if err != nil {
+		return errors.Wrap(err, "reading file metadata")
+	}
+	if fi.IsDir() {
+		return errors.Errorf("%q is a directory", from)
+	}
+	return c.Copy(from, to)
+}
+
+// CopyDir copies the directory from to to, failing if from is not a
+// directory.
+func CopyDir(from, to string, opts ...Option) error {
+	c := newCopier(opts)
+	fi, err := c.srcFs().Stat(from)
+	if err != nil {
+		return errors.Wrap(err, "reading file metadata")
+	}
+	if !fi.IsDir() {
+		return ErrNotDirectory{Path: from}
+	}
+	return c.Copy(from, to)
+}
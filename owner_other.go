@@ -0,0 +1,10 @@
+//go:build !unix
+
+package cp
+
+import "os"
+
+// owner reports false, files having no numeric owner to copy.
+func owner(os.FileInfo) (int, int, bool) {
+	return 0, 0, false
+}
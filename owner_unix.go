@@ -0,0 +1,17 @@
+//go:build unix
+
+package cp
+
+import (
+	"os"
+	"syscall"
+)
+
+// owner is the user and group that own the file, if its metadata says.
+func owner(info os.FileInfo) (int, int, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
@@ -0,0 +1,86 @@
+package cp
+
+import (
+	"io"
+	"sync"
+)
+
+// pipeBufferSize is the size of each buffer passed along a pipeline.
+const pipeBufferSize = 256 << 10
+
+var pipeBuffers = sync.Pool{New: func() any {
+	b := make([]byte, pipeBufferSize)
+	return &b
+}}
+
+// piped reports whether a file of size bytes is copied through a pipeline,
+// which is only worth it for files of more than one buffer.
+func (c *copier) piped(size int64) bool {
+	return c.pipeline > 1 && size > pipeBufferSize
+}
+
+// pipe copies r to w with the reads in a goroutine of their own, handing
+// full buffers from a ring of c.pipeline to the writes and taking them back
+// empty, so that reading the next part of a file overlaps writing the last.
+func (c *copier) pipe(w io.Writer, r io.Reader) (int64, error) {
+	free := make(chan *[]byte, c.pipeline)
+	full := make(chan []byte, c.pipeline)
+	for ii := 0; ii < c.pipeline; ii++ {
+		free <- pipeBuffers.Get().(*[]byte)
+	}
+	stop := make(chan struct{})
+	var rerr error
+	go func() {
+		defer close(full)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			var buf *[]byte
+			select {
+			case buf = <-free:
+			case <-stop:
+				return
+			}
+			n, err := io.ReadFull(r, *buf)
+			if n > 0 {
+				full <- (*buf)[:n]
+			} else {
+				free <- buf
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if err != nil {
+				rerr = err
+				return
+			}
+		}
+	}()
+	var written int64
+	var werr error
+	for data := range full {
+		if werr == nil {
+			var n int
+			n, werr = w.Write(data)
+			written += int64(n)
+			if werr == nil && n < len(data) {
+				werr = io.ErrShortWrite
+			}
+			if werr != nil {
+				close(stop)
+			}
+		}
+		buf := data[:cap(data)]
+		free <- &buf
+	}
+	for ii := 0; ii < c.pipeline; ii++ {
+		pipeBuffers.Put(<-free)
+	}
+	if werr != nil {
+		return written, werr
+	}
+	return written, rerr
+}
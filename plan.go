@@ -0,0 +1,166 @@
+package cp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// OpKind is the kind of operation in a Plan.
+type OpKind string
+
+const (
+	// OpMkdir creates the directory To.
+	OpMkdir OpKind = "mkdir"
+	// OpCopy copies From to To, which doesn't exist yet.
+	OpCopy OpKind = "copy"
+	// OpOverwrite copies From over the existing To.
+	OpOverwrite OpKind = "overwrite"
+	// OpSkip leaves From uncopied.
+	OpSkip OpKind = "skip"
+	// OpRemove removes To, and everything beneath it, from the
+	// destination.
+	OpRemove OpKind = "remove"
+)
+
+// Op is a single step of a Plan.
+type Op struct {
+	Kind     OpKind
+	From, To string
+	// Size is the size of the file copied, in bytes.
+	Size int64
+}
+
+// Plan is the operations a copy would perform, in order. It can be
+// inspected, edited or stored before being carried out with Apply.
+type Plan struct {
+	Ops []Op
+}
+
+// Plan works out what CopyReport would do without changing anything. The
+// filters, Rename and Flatten are applied while planning, while OnConflict,
+// the quota and the handling of special files are left to Apply.
+func (c *Copier) Plan(from, to string) (Plan, error) {
+	if c.IncludeRoot {
+		to = filepath.Join(to, filepath.Base(filepath.Clean(from)))
+	}
+	if from == to {
+		return Plan{}, nil
+	}
+	if c.Fs == nil {
+		c.Fs = afero.NewOsFs()
+	}
+	fromFi, err := c.check(from, to)
+	if err != nil {
+		return Plan{}, err
+	}
+	return c.planTree(from, to, fromFi)
+}
+
+// planTree plans the copy of from, described by fromFi, to to.
+func (c *Copier) planTree(from, to string, fromFi os.FileInfo) (Plan, error) {
+	cp := c.copier()
+	cp.seen = &sync.Map{}
+	cp.planned = &planner{}
+	if !fromFi.IsDir() {
+		cp.plan(from, to, fromFi)
+		return Plan{Ops: cp.planned.ops}, nil
+	}
+	if c.within(from, fromFi, to) {
+		return Plan{}, ErrRecursiveCopy{From: from, To: to}
+	}
+	go func() {
+		cp.walk(from, to)
+		close(cp.results)
+	}()
+	var errs []error
+	for r := range cp.results {
+		switch {
+		case r.Err != nil:
+			errs = append(errs, r.Err)
+		case r.skipped:
+			cp.planned.add(Op{Kind: OpSkip, From: r.From, To: r.To})
+		}
+	}
+	p := Plan{Ops: cp.planned.ops}
+	if len(errs) > 0 {
+		return p, Failures{errs}
+	}
+	return p, nil
+}
+
+// Apply carries out a plan, copying its files through the workers as Copy
+// would.
+func (c *Copier) Apply(p Plan) (Report, error) {
+	defer c.start()()
+	return c.copier().applyAll(p)
+}
+
+func (c copier) applyAll(p Plan) (Report, error) {
+	// Destinations were placed when planning.
+	c.flatten = false
+	return c.run(func() {
+		for _, op := range p.Ops {
+			c.apply(op)
+		}
+	})
+}
+
+func (c *copier) apply(op Op) {
+	switch op.Kind {
+	case OpMkdir:
+		info, err := c.src.Stat(op.From)
+		if err != nil {
+			c.results <- result{FileReport: FileReport{From: op.From, To: op.To, Err: errors.Wrap(err, "reading file metadata")}}
+			return
+		}
+		c.mkdir(op.From, op.To, info)
+	case OpCopy, OpOverwrite:
+		info, err := lstat(c.src, op.From)
+		if err != nil {
+			c.results <- result{FileReport: FileReport{From: op.From, To: op.To, Err: errors.Wrap(err, "reading file metadata")}}
+			return
+		}
+		c.enqueue(op.From, op.To, info)
+	case OpSkip:
+		c.results <- result{FileReport: FileReport{From: op.From, To: op.To}, skipped: true}
+	case OpRemove:
+		r := result{FileReport: FileReport{To: op.To}, removed: true}
+		if c.undo != nil {
+			// Moved aside, to be put back if the copy is rolled back.
+			r.Err = c.backup(op.To)
+		} else if err := c.dst.RemoveAll(op.To); err != nil {
+			r.Err = errors.Wrapf(err, "removing %s", op.To)
+		}
+		c.results <- r
+	default:
+		c.results <- result{FileReport: FileReport{From: op.From, To: op.To, Err: fmt.Errorf("unknown operation %q", op.Kind)}}
+	}
+}
+
+// planner collects the operations of a plan in place of performing them.
+type planner struct {
+	mu  sync.Mutex
+	ops []Op
+}
+
+func (p *planner) add(op Op) {
+	p.mu.Lock()
+	p.ops = append(p.ops, op)
+	p.mu.Unlock()
+}
+
+// plan records the file that would be queued.
+func (c *copier) plan(from, to string, info os.FileInfo) {
+	op := Op{Kind: OpCopy, From: from, To: to, Size: info.Size()}
+	if info.Mode()&specialModes != 0 && c.specialFiles == SpecialSkip {
+		op = Op{Kind: OpSkip, From: from, To: to}
+	} else if _, err := c.dst.Stat(to); err == nil {
+		op.Kind = OpOverwrite
+	}
+	c.planned.add(op)
+}
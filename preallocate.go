@@ -0,0 +1,26 @@
+package cp
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// preallocate reserves size bytes of disk for the destination file before
+// it is written, so that a copy that won't fit fails at once rather than
+// part way. Files not on the OS filesystem, and filesystems that can't
+// reserve space, are written as usual.
+func (c *copier) preallocate(f afero.File, to string, size int64) error {
+	if !c.allocate || size <= 0 {
+		return nil
+	}
+	file, ok := f.(*os.File)
+	if !ok {
+		return nil
+	}
+	if err := allocate(file, size); err != nil {
+		return errors.Wrapf(err, "allocating %d bytes for %s", size, to)
+	}
+	return nil
+}
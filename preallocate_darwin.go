@@ -0,0 +1,28 @@
+//go:build darwin
+
+package cp
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// allocate reserves size bytes for f with F_PREALLOCATE, contiguously if
+// the disk has room to.
+func allocate(f *os.File, size int64) error {
+	store := unix.Fstore_t{
+		Flags:   unix.F_ALLOCATECONTIG | unix.F_ALLOCATEALL,
+		Posmode: unix.F_PEOFPOSMODE,
+		Length:  size,
+	}
+	if err := unix.FcntlFstore(f.Fd(), unix.F_PREALLOCATE, &store); err == nil {
+		return nil
+	}
+	store.Flags = unix.F_ALLOCATEALL
+	err := unix.FcntlFstore(f.Fd(), unix.F_PREALLOCATE, &store)
+	if err == unix.ENOTSUP {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !windows
+
+package cp
+
+import "os"
+
+// allocate does nothing, there being no portable way to reserve space.
+func allocate(*os.File, int64) error {
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package cp
+
+import (
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// allocate reserves size bytes for f by setting its allocation size.
+func allocate(f *os.File, size int64) error {
+	info := struct{ AllocationSize int64 }{size}
+	return windows.SetFileInformationByHandle(windows.Handle(f.Fd()), windows.FileAllocationInfo,
+		(*byte)(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)))
+}
@@ -0,0 +1,98 @@
+package cp
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// preserve copies the metadata the Copier is configured to keep onto to,
+// once its contents are in place.
+func (c *copier) preserve(from, to string, info os.FileInfo) error {
+	// Streams go first, since a read-only attribute would prevent writing
+	// them.
+	if c.streams && isOs(c.src) && isOs(c.dst) {
+		if err := copyStreams(longPath(from), longPath(to)); err != nil {
+			return errors.Wrapf(err, "copying data streams to %s", to)
+		}
+	}
+	if c.selinux && isOs(c.src) && isOs(c.dst) {
+		if err := copySELinux(longPath(from), longPath(to)); err != nil {
+			return errors.Wrapf(err, "copying the security context to %s", to)
+		}
+	}
+	if c.xattrs && isOs(c.src) && isOs(c.dst) {
+		if err := copyAllXattrs(longPath(from), longPath(to)); err != nil {
+			return errors.Wrapf(err, "copying extended attributes to %s", to)
+		}
+	}
+	// Times go after anything that could write to the file, and before
+	// attributes that could lock it.
+	if c.owner || c.mode || c.times {
+		have, err := c.dst.Stat(to)
+		if err != nil {
+			return errors.Wrap(err, "reading file metadata")
+		}
+		if err := c.setMetadata(to, info, have); err != nil {
+			return err
+		}
+	}
+	if c.attributes && isOs(c.src) && isOs(c.dst) {
+		if err := copyAttributes(longPath(from), longPath(to)); err != nil {
+			return errors.Wrapf(err, "copying attributes to %s", to)
+		}
+	}
+	return nil
+}
+
+// prepare readies an existing file at to for being overwritten, undoing
+// anything preserved from a previous copy that would prevent it, and moving
+// it aside if the copy may be rolled back.
+func (c *copier) prepare(to string) error {
+	if c.attributes && isOs(c.dst) {
+		if err := unlock(longPath(to)); err != nil {
+			return errors.Wrapf(err, "unlocking %s", to)
+		}
+	}
+	return c.backup(to)
+}
+
+// preserveDirs gives the directories created the mode of their source and
+// copies the metadata of all those walked, deepest first, so that nothing is
+// written into a directory after its own is set.
+func (c *copier) preserveDirs() []error {
+	if c.dirs == nil {
+		return nil
+	}
+	var errs []error
+	dirs := *c.dirs
+	for ii := len(dirs) - 1; ii >= 0; ii-- {
+		d := dirs[ii]
+		if d.created {
+			if err := c.dst.Chmod(d.To, d.info.Mode().Perm()); err != nil {
+				errs = append(errs, errors.Wrapf(err, "setting the mode of %s", d.To))
+			}
+		}
+		if err := c.preserve(d.From, d.To, d.info); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// addDir records a directory walked.
+func (c *copier) addDir(d dir) {
+	c.walkMu.Lock()
+	*c.dirs = append(*c.dirs, d)
+	c.walkMu.Unlock()
+}
+
+// dir is a directory walked, whose metadata is copied once its contents
+// have been.
+type dir struct {
+	From, To string
+	info     os.FileInfo
+	// created is whether the copy created the directory, rather than
+	// merging into one that was there.
+	created bool
+}
@@ -0,0 +1,63 @@
+package cp
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// Progress describes a file that has just been dealt with.
+type Progress struct {
+	// File is the outcome of the file.
+	File FileReport
+	// Skipped is whether the file was left as it was rather than copied.
+	Skipped bool
+	// Removed is whether the file was removed from the destination by
+	// Mirror.
+	Removed bool
+	// Stats is a snapshot of the totals so far.
+	Stats Stats
+}
+
+func (c *Copier) progress(r result) {
+	if c.Progress == nil {
+		return
+	}
+	c.Progress(Progress{
+		File:    r.FileReport,
+		Skipped: r.skipped,
+		Removed: r.removed,
+		Stats:   c.Stats(),
+	})
+}
+
+// Totals is the size of a file tree.
+type Totals struct {
+	Files int64
+	Bytes int64
+}
+
+// Measure walks the tree at path and totals up its files, which is useful for
+// reporting progress against.
+func (c *Copier) Measure(path string) (Totals, error) {
+	if c.Fs == nil {
+		c.Fs = afero.NewOsFs()
+	}
+	t := Totals{}
+	err := afero.Walk(c.srcFs(), path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		t.Files++
+		t.Bytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return t, errors.Wrap(err, "walking file system")
+	}
+	return t, nil
+}
@@ -0,0 +1,151 @@
+package cp
+
+import (
+	"container/heap"
+	"path/filepath"
+	"sync"
+)
+
+// Order is the order queued files are handed to the workers in.
+type Order int
+
+const (
+	// OrderLargestFirst hands out the largest file queued first, so that
+	// big files don't end up copying alone at the tail of a copy while the
+	// other workers sit idle.
+	OrderLargestFirst Order = iota
+	// OrderFIFO hands files out in the order they were found.
+	OrderFIFO
+	// OrderLocality hands out the files of one directory together, which
+	// suits storage where moving between directories is slow, such as
+	// spinning disks.
+	OrderLocality
+)
+
+// queue holds the jobs waiting for a worker, handing them out in order.
+// With a capacity, pushing waits while it is full, so that a walk running
+// ahead of the workers doesn't hold a whole tree in memory.
+type queue struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	jobs     jobHeap
+	capacity int
+	// seq numbers the jobs as they are pushed, breaking ties.
+	seq    int64
+	closed bool
+}
+
+func newQueue(order Order, capacity int) *queue {
+	q := &queue{capacity: capacity}
+	q.cond = sync.NewCond(&q.mu)
+	switch order {
+	case OrderFIFO:
+		q.jobs.less = func(a, b job) bool { return a.seq < b.seq }
+	case OrderLocality:
+		q.jobs.less = func(a, b job) bool {
+			if da, db := filepath.Dir(a.From), filepath.Dir(b.From); da != db {
+				return da < db
+			}
+			return a.seq < b.seq
+		}
+	default:
+		q.jobs.less = func(a, b job) bool {
+			if a.Size != b.Size {
+				return a.Size > b.Size
+			}
+			return a.seq < b.seq
+		}
+	}
+	return q
+}
+
+// push adds a job to the queue, waiting for room if it is full.
+func (q *queue) push(j job) {
+	q.mu.Lock()
+	for q.capacity > 0 && len(q.jobs.jobs) >= q.capacity {
+		q.cond.Wait()
+	}
+	q.seq++
+	j.seq = q.seq
+	heap.Push(&q.jobs, j)
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// pop takes the next job from the queue, waiting for one if need be.
+// Reports false once the queue is closed and drained.
+func (q *queue) pop() (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.jobs.jobs) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.jobs.jobs) == 0 {
+		return job{}, false
+	}
+	j := heap.Pop(&q.jobs).(job)
+	if q.capacity > 0 {
+		q.cond.Broadcast()
+	}
+	return j, true
+}
+
+// tryPop takes the next job from the queue if there is one waiting.
+func (q *queue) tryPop() (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.jobs.jobs) == 0 {
+		return job{}, false
+	}
+	j := heap.Pop(&q.jobs).(job)
+	if q.capacity > 0 {
+		q.cond.Broadcast()
+	}
+	return j, true
+}
+
+// popSmall appends up to n jobs of at most size bytes to jobs, for as long
+// as the next job to be handed out is that small.
+func (q *queue) popSmall(jobs []job, n int, size int64) []job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for ; n > 0 && len(q.jobs.jobs) > 0 && q.jobs.jobs[0].Size <= size; n-- {
+		jobs = append(jobs, heap.Pop(&q.jobs).(job))
+	}
+	if q.capacity > 0 {
+		q.cond.Broadcast()
+	}
+	return jobs
+}
+
+// len is the number of jobs waiting.
+func (q *queue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.jobs.jobs)
+}
+
+// close signals that no more jobs will be pushed.
+func (q *queue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// jobHeap is a heap of jobs, the one that less puts first on top.
+type jobHeap struct {
+	jobs []job
+	less func(a, b job) bool
+}
+
+func (h jobHeap) Len() int            { return len(h.jobs) }
+func (h jobHeap) Less(i, j int) bool  { return h.less(h.jobs[i], h.jobs[j]) }
+func (h jobHeap) Swap(i, j int)       { h.jobs[i], h.jobs[j] = h.jobs[j], h.jobs[i] }
+func (h *jobHeap) Push(x interface{}) { h.jobs = append(h.jobs, x.(job)) }
+func (h *jobHeap) Pop() interface{} {
+	old := h.jobs
+	j := old[len(old)-1]
+	h.jobs = old[:len(old)-1]
+	return j
+}
@@ -0,0 +1,47 @@
+package cp
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ErrQuotaExceeded means a file was not copied because it would take the
+// bytes copied past MaxTotalBytes.
+type ErrQuotaExceeded struct {
+	// Path is the file that was not copied, and Size its size.
+	Path string
+	Size int64
+	// Limit is the quota and Used the bytes already copied against it.
+	Limit int64
+	Used  int64
+}
+
+func (err ErrQuotaExceeded) Error() string {
+	return fmt.Sprintf("copying %q (%d bytes) would exceed the quota of %d bytes, %d of which are used",
+		err.Path, err.Size, err.Limit, err.Used)
+}
+
+// quota hands out bytes up to a limit. Once a file is refused, every file
+// after it is too, so that a copy stops at the first file that doesn't fit
+// rather than filling the space left with whatever happens to be smaller.
+type quota struct {
+	mu       sync.Mutex
+	limit    int64
+	used     int64
+	exceeded bool
+}
+
+// reserve takes size bytes from the quota, if there's a quota.
+func (q *quota) reserve(path string, size int64) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.exceeded || q.used+size > q.limit {
+		q.exceeded = true
+		return ErrQuotaExceeded{Path: path, Size: size, Limit: q.limit, Used: q.used}
+	}
+	q.used += size
+	return nil
+}
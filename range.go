@@ -0,0 +1,137 @@
+package cp
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// CopyRange copies length bytes of from, starting at offset, to the same
+// offset of to, creating to if need be and leaving the rest of it as it
+// is, so copying the range that starts where to ends appends to it. A
+// negative length copies to the end of from, and a range running past its
+// end is cut short there. It returns the number of bytes copied.
+//
+// Since it writes into to rather than replacing it, Clobber doesn't apply.
+func (c *Copier) CopyRange(from, to string, offset, length int64) (int64, error) {
+	defer c.start()()
+	if offset < 0 {
+		return 0, errors.Errorf("negative offset %d", offset)
+	}
+	info, err := c.srcFs().Stat(from)
+	if err != nil {
+		return 0, errors.Wrap(err, "reading file metadata")
+	}
+	if info.IsDir() {
+		return 0, errors.Errorf("%s is a directory", from)
+	}
+	if rest := info.Size() - offset; length < 0 || length > rest {
+		length = max(rest, 0)
+	}
+	if err := c.dstFs().MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return 0, errors.Wrapf(err, "preparing directories for %s", to)
+	}
+	n, err := c.copier().copyRange(from, to, offset, length)
+	return n, errors.Wrapf(err, "copying range of %s to %s", from, to)
+}
+
+// CopyFileFrom resumes a copy of from to to that stopped after startOffset
+// bytes, copying the rest of from after what to already holds and cutting
+// off anything to holds beyond the end of from. Metadata is then preserved
+// as Copy would. It returns the number of bytes copied.
+//
+// With VerifyResume set, the first startOffset bytes of to are hashed
+// against those of from with Hasher first, and if they differ the whole file is copied
+// again instead.
+func (c *Copier) CopyFileFrom(from, to string, startOffset int64) (int64, error) {
+	defer c.start()()
+	if startOffset < 0 {
+		return 0, errors.Errorf("negative offset %d", startOffset)
+	}
+	info, err := c.srcFs().Stat(from)
+	if err != nil {
+		return 0, errors.Wrap(err, "reading file metadata")
+	}
+	if info.IsDir() {
+		return 0, errors.Errorf("%s is a directory", from)
+	}
+	if startOffset > info.Size() {
+		return 0, errors.Errorf("offset %d is past the end of %s", startOffset, from)
+	}
+	if startOffset > 0 {
+		toFi, err := c.dstFs().Stat(to)
+		if err != nil {
+			return 0, errors.Wrap(err, "reading file metadata")
+		}
+		if toFi.Size() < startOffset {
+			return 0, errors.Errorf("%s holds %d bytes, short of offset %d", to, toFi.Size(), startOffset)
+		}
+	} else if err := c.dstFs().MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return 0, errors.Wrapf(err, "preparing directories for %s", to)
+	}
+	cp := c.copier()
+	if c.VerifyResume && startOffset > 0 {
+		same, err := cp.samePrefix(from, to, startOffset)
+		if err != nil {
+			return 0, err
+		}
+		if !same {
+			startOffset = 0
+		}
+	}
+	n, err := cp.copyRange(from, to, startOffset, info.Size()-startOffset)
+	if err != nil {
+		return n, errors.Wrapf(err, "copying file from %s to %s", from, to)
+	}
+	if err := cp.truncate(to, info.Size()); err != nil {
+		return n, err
+	}
+	if err := cp.preserve(from, to, info); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// truncate cuts to off at size.
+func (c *copier) truncate(to string, size int64) error {
+	f, err := c.dst.OpenFile(to, os.O_WRONLY, 0)
+	if err != nil {
+		return errors.Wrapf(err, "opening %s", to)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return errors.Wrapf(err, "truncating %s", to)
+	}
+	return f.Close()
+}
+
+// samePrefix reports whether the first n bytes of from and to hash the same.
+func (c *copier) samePrefix(from, to string, n int64) (bool, error) {
+	want, err := c.prefixSum(c.src, from, n)
+	if err != nil {
+		return false, err
+	}
+	got, err := c.prefixSum(c.dst, to, n)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(want, got), nil
+}
+
+// prefixSum is the hash of the first n bytes of name.
+func (c *copier) prefixSum(fs afero.Fs, name string, n int64) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening %s", name)
+	}
+	defer f.Close()
+	h := c.hasher()
+	if _, err := io.CopyN(h, c.cancellable(f), n); err != nil {
+		return nil, errors.Wrapf(err, "hashing %s", name)
+	}
+	return h.Sum(nil), nil
+}
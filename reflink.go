@@ -0,0 +1,69 @@
+package cp
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/spf13/afero"
+)
+
+// Reflink is whether files are cloned rather than copied, the clone sharing
+// its blocks with the source until either is changed, which is near instant
+// and takes no extra space. Btrfs, XFS and APFS support it.
+type Reflink int
+
+const (
+	// ReflinkNever always copies the contents.
+	ReflinkNever Reflink = iota
+	// ReflinkAuto clones files where the filesystem can and copies them
+	// where it can't.
+	ReflinkAuto
+	// ReflinkAlways clones every file, failing those that can't be cloned
+	// with ErrCloneUnsupported.
+	ReflinkAlways
+)
+
+// ErrCloneUnsupported means a file couldn't be cloned with ReflinkAlways,
+// such as because the filesystem doesn't support it or the source and
+// destination are on different ones.
+type ErrCloneUnsupported struct {
+	From, To string
+	Err      error
+}
+
+func (err ErrCloneUnsupported) Error() string {
+	return fmt.Sprintf("cloning %s to %s: %v", err.From, err.To, err.Err)
+}
+
+// reflink is how the copier clones files, never when transforming them.
+func (c *Copier) reflink() Reflink {
+	if c.Transform != nil {
+		return ReflinkNever
+	}
+	return c.Reflink
+}
+
+// clone clones the opened source file onto the opened destination if it
+// should, reporting whether it did. Files not on the OS filesystem can't
+// be cloned.
+func (c *copier) clone(fromFile, toFile afero.File, from, to string, size int64) (bool, error) {
+	if c.reflink == ReflinkNever {
+		return false, nil
+	}
+	src, ok := fromFile.(*os.File)
+	dst, ok2 := toFile.(*os.File)
+	err := errNoClone
+	if ok && ok2 {
+		err = cloneFile(src, dst, longPath(to))
+	}
+	switch {
+	case err == nil:
+		atomic.AddInt64(&c.stats.bytes, size)
+		return true, nil
+	case c.reflink == ReflinkAlways:
+		return false, ErrCloneUnsupported{From: from, To: to, Err: err}
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,28 @@
+//go:build darwin
+
+package cp
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+var errNoClone = errors.New("files can only be cloned between OS filesystems")
+
+// cloneFile clones from beside the destination at path with clonefile,
+// which can only create a new file, and renames the clone over it. The
+// destination already opened is left empty and unlinked.
+func cloneFile(from, _ *os.File, path string) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".clone")
+	if err := unix.Fclonefileat(int(from.Fd()), unix.AT_FDCWD, tmp, 0); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build linux
+
+package cp
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+var errNoClone = errors.New("files can only be cloned between OS filesystems")
+
+// cloneFile clones from onto to with the FICLONE ioctl.
+func cloneFile(from, to *os.File, _ string) error {
+	return unix.IoctlFileClone(int(to.Fd()), int(from.Fd()))
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !darwin
+
+package cp
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+var errNoClone = errors.New("cloning is not supported on this platform")
+
+// cloneFile always fails, there being no clone support here.
+func cloneFile(*os.File, *os.File, string) error {
+	return errNoClone
+}
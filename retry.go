@@ -0,0 +1,83 @@
+package cp
+
+import (
+	"log/slog"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultRetryBackoff is the wait before the first retry when RetryBackoff
+// is unset.
+const defaultRetryBackoff = 100 * time.Millisecond
+
+// networkRetries is the least number of times a file failing with an error
+// of a network filesystem is retried.
+const networkRetries = 3
+
+// retry calls copy until it succeeds, fails with an error that isn't
+// retryable, or runs out of retries, doubling the wait each time. Each
+// attempt opens the files afresh, which is what clears a stale handle. The
+// overwrote result of the first attempt stands, since a retry finds the
+// remains of the one before.
+func (c *copier) retry(r *result, copy func() (int64, bool, bool, error)) {
+	backoff := c.retryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	isRetryable := c.isRetryable
+	if isRetryable == nil {
+		isRetryable = transient
+	}
+	var overwrote bool
+	r.Bytes, overwrote, r.linked, r.Err = copy()
+	for try := 0; r.Err != nil && c.again(try, r.Err, isRetryable); try++ {
+		c.log(slog.LevelWarn, "file retried", "from", r.From, "to", r.To, "error", r.Err, "attempt", try+1)
+		select {
+		case <-time.After(backoff << try):
+		case <-c.ctx.Done():
+			return
+		}
+		r.Bytes, _, r.linked, r.Err = copy()
+	}
+	r.overwrote = overwrote
+}
+
+// again reports whether to try again after try retries, the last failing
+// with err. Errors of network filesystems are retried networkRetries times
+// even if Retries is less.
+func (c *copier) again(try int, err error, isRetryable func(error) bool) bool {
+	if network(err) {
+		return try < max(c.retries, networkRetries)
+	}
+	return try < c.retries && isRetryable(err)
+}
+
+// network reports whether err is one of the passing faults of a network
+// filesystem.
+func network(err error) bool {
+	for _, errno := range networkErrors {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// transient reports whether err is the kind of failure that may clear up if
+// the file is tried again, such as an interrupted call or a timeout.
+func transient(err error) bool {
+	for _, errno := range []error{syscall.EINTR, syscall.EAGAIN, syscall.ETIMEDOUT, os.ErrDeadlineExceeded} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return true
+	}
+	var temporary interface{ Temporary() bool }
+	return errors.As(err, &temporary) && temporary.Temporary()
+}
@@ -0,0 +1,6 @@
+//go:build !unix && !windows
+
+package cp
+
+// networkErrors are none here.
+var networkErrors []error
@@ -0,0 +1,18 @@
+//go:build unix
+
+package cp
+
+import "syscall"
+
+// networkErrors are those NFS and SMB mounts fail with when the server or
+// the connection to it has a passing fault: a file handle gone stale once
+// the server restarts or the file is replaced, IO errors when a soft mount
+// times out, and dropped connections.
+var networkErrors = []error{
+	syscall.ESTALE,
+	syscall.EIO,
+	syscall.ECONNRESET,
+	syscall.ECONNABORTED,
+	syscall.ENETRESET,
+	syscall.EHOSTDOWN,
+}
@@ -0,0 +1,40 @@
+//go:build unix
+
+package cp
+
+import (
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// TestCopier_NetworkRetries tests that a stale NFS file handle is retried
+// without Retries being set, reopening the file each time.
+func TestCopier_NetworkRetries(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	if err := afero.WriteFile(fs, "from/a", []byte("a"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	opens := 0
+	copier := Copier{
+		Fs:           fs,
+		RetryBackoff: time.Millisecond,
+		Transform: func(path string, r io.Reader) (io.Reader, error) {
+			opens++
+			if opens <= 2 {
+				return nil, errors.Wrap(syscall.ESTALE, "reading")
+			}
+			return r, nil
+		},
+	}
+	if err := copier.Copy("from/a", "to/a"); err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if opens != 3 {
+		t.Errorf("want 3 attempts, got %d", opens)
+	}
+}
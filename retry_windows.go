@@ -0,0 +1,16 @@
+//go:build windows
+
+package cp
+
+import "golang.org/x/sys/windows"
+
+// networkErrors are those SMB shares fail with when the server or the
+// connection to it has a passing fault.
+var networkErrors = []error{
+	windows.ERROR_NETNAME_DELETED,
+	windows.ERROR_UNEXP_NET_ERR,
+	windows.ERROR_NETWORK_BUSY,
+	windows.ERROR_SEM_TIMEOUT,
+	windows.ERROR_VC_DISCONNECTED,
+	windows.ERROR_CONNECTION_ABORTED,
+}
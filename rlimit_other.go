@@ -0,0 +1,8 @@
+//go:build !unix
+
+package cp
+
+// maxOpenFiles reports no limit where one cannot be detected.
+func maxOpenFiles() int {
+	return 0
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package cp
+
+import "syscall"
+
+// maxOpenFiles reports the soft limit on open file descriptors.
+func maxOpenFiles() int {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		return 0
+	}
+	if uint64(rlim.Cur) > 1<<20 {
+		return 1 << 20
+	}
+	return int(rlim.Cur)
+}
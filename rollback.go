@@ -0,0 +1,114 @@
+package cp
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// undo records what a copy changed at the destination, so that it can be
+// put back as it was if the copy fails. Files about to be overwritten are
+// moved aside rather than truncated, and restored or removed at the end.
+type undo struct {
+	mu sync.Mutex
+	// created is the set of files the copy created.
+	created map[string]bool
+	// backups maps overwritten files to where their contents were moved.
+	backups map[string]string
+}
+
+func newUndo() *undo {
+	return &undo{created: map[string]bool{}, backups: map[string]string{}}
+}
+
+// create records that to was created by the copy.
+func (c *copier) create(to string) {
+	if c.undo == nil {
+		return
+	}
+	c.undo.mu.Lock()
+	c.undo.created[to] = true
+	c.undo.mu.Unlock()
+}
+
+// backup moves the existing file at to aside before it is overwritten.
+func (c *copier) backup(to string) error {
+	if c.undo == nil {
+		return nil
+	}
+	c.undo.mu.Lock()
+	_, ok := c.undo.backups[to]
+	ok = ok || c.undo.created[to]
+	c.undo.mu.Unlock()
+	if ok {
+		// What's being overwritten is what's left of an earlier attempt,
+		// the original either moved aside already or not there at all.
+		return nil
+	}
+	backup := filepath.Join(filepath.Dir(to), ".~"+filepath.Base(to)+".cp-rollback")
+	if err := c.dst.Rename(to, backup); err != nil {
+		return errors.Wrapf(err, "backing up %s", to)
+	}
+	c.undo.mu.Lock()
+	c.undo.backups[to] = backup
+	c.undo.mu.Unlock()
+	return nil
+}
+
+// settle finishes a copy that ended with err, rolling it back if it failed
+// and otherwise discarding the backups.
+func (c *copier) settle(err error) error {
+	if c.undo == nil {
+		return err
+	}
+	var errs []error
+	if err != nil {
+		errs = c.rollback()
+		if len(errs) == 0 {
+			return err
+		}
+		if f, ok := err.(Failures); ok {
+			errs = append(f.list, errs...)
+		} else {
+			errs = append([]error{err}, errs...)
+		}
+		return Failures{errs}
+	}
+	for to, backup := range c.undo.backups {
+		if err := c.dst.RemoveAll(backup); err != nil {
+			errs = append(errs, errors.Wrapf(err, "removing the backup of %s", to))
+		}
+	}
+	if len(errs) > 0 {
+		return Failures{errs}
+	}
+	return nil
+}
+
+// rollback removes the files and directories the copy created and puts
+// back those it overwrote.
+func (c *copier) rollback() []error {
+	var errs []error
+	for to := range c.undo.created {
+		if err := c.dst.Remove(to); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, errors.Wrapf(err, "rolling back %s", to))
+		}
+	}
+	for to, backup := range c.undo.backups {
+		if err := c.dst.Rename(backup, to); err != nil {
+			errs = append(errs, errors.Wrapf(err, "restoring %s", to))
+		}
+	}
+	dirs := *c.dirs
+	for ii := len(dirs) - 1; ii >= 0; ii-- {
+		if !dirs[ii].created {
+			continue
+		}
+		if err := c.dst.Remove(dirs[ii].To); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, errors.Wrapf(err, "rolling back %s", dirs[ii].To))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,256 @@
+// Package rpc runs copies on remote agents over gRPC, streaming the progress
+// of each back to the caller along with the result.
+//
+//	srv := grpc.NewServer(rpc.ServerCodec())
+//	rpc.Register(srv, &rpc.Server{})
+//	go srv.Serve(listener)
+//
+//	conn, err := grpc.NewClient("agent:7000", grpc.WithTransportCredentials(creds))
+//	client := rpc.NewClient(conn)
+//	result, err := client.Copy(ctx, rpc.CopyRequest{From: "/data", To: "/backup"}, func(e rpc.Event) {
+//		fmt.Println(e.File.To)
+//	})
+//
+// The service is cp.rpc.Copier, with the one server streaming method Copy,
+// as described by copier.proto for clients in other languages. Messages are
+// the types here encoded as JSON, under the "json" content subtype, rather
+// than protocol buffers, so that there is no generated code to keep in step.
+// The codec is passed to each call, and to the server through ServerCodec,
+// rather than registered for the whole process.
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"google.golang.org/grpc"
+
+	"github.com/jackmordaunt/cp"
+)
+
+// CopyRequest is a copy for an agent to make, between paths on its
+// filesystem.
+type CopyRequest struct {
+	From, To    string
+	Clobber     bool
+	IncludeRoot bool
+	Parallel    int
+}
+
+// Event is sent as each file is finished, and once more with the Result
+// when the copy is done.
+type Event struct {
+	// File is the file finished, unset on the last event.
+	File *File `json:",omitempty"`
+	// Stats are the totals so far.
+	Stats Stats
+	// Result is set on the last event.
+	Result *Result `json:",omitempty"`
+}
+
+// File is the outcome of copying one file.
+type File struct {
+	From, To string
+	Bytes    int64
+	Duration time.Duration
+	Skipped  bool
+	// Error is why the file failed, if it did.
+	Error string `json:",omitempty"`
+}
+
+// Stats mirror cp.Stats.
+type Stats struct {
+	Files, Bytes           int64
+	FoundFiles, FoundBytes int64
+	Elapsed                time.Duration
+	Throughput, Rate       float64
+	ETA                    time.Duration
+}
+
+// Result is the outcome of a copy.
+type Result struct {
+	Copied, Overwritten, Linked, Skipped int
+	// Failed are the files that failed to copy.
+	Failed []File `json:",omitempty"`
+	// Error is why the copy failed, if it did.
+	Error string `json:",omitempty"`
+}
+
+// ErrRemote is a copy that failed on the agent.
+type ErrRemote struct {
+	Message string
+}
+
+func (err ErrRemote) Error() string {
+	return fmt.Sprintf("remote copy: %s", err.Message)
+}
+
+// Server runs the copies requested of an agent.
+type Server struct {
+	// Fs is copied within, defaulting to the OS filesystem.
+	Fs afero.Fs
+}
+
+// ServerCodec is the option a server must be made with to decode the
+// service's messages. It applies to every service on the server, so they
+// should all expect JSON.
+func ServerCodec() grpc.ServerOption {
+	return grpc.ForceServerCodec(codec{})
+}
+
+// Register adds the service to a gRPC server, which must be made with
+// ServerCodec.
+func Register(s grpc.ServiceRegistrar, srv *Server) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+// copy makes the copy, sending an event as each file is finished. A failed
+// copy is reported in the result rather than failing the call.
+func (s *Server) copy(req *CopyRequest, stream grpc.ServerStream) error {
+	var mu sync.Mutex
+	copier := &cp.Copier{
+		Fs:          s.Fs,
+		Clobber:     req.Clobber,
+		IncludeRoot: req.IncludeRoot,
+		Parallel:    req.Parallel,
+		Progress: func(p cp.Progress) {
+			f := file(p.File, p.Skipped)
+			mu.Lock()
+			defer mu.Unlock()
+			// A client that has gone away cancels the copy through the
+			// context, so there's nothing to do if this fails.
+			stream.SendMsg(&Event{File: &f, Stats: stats(p.Stats)})
+		},
+	}
+	report, err := copier.CopyContext(stream.Context(), req.From, req.To)
+	if err := stream.Context().Err(); err != nil {
+		return err
+	}
+	result := Result{
+		Copied:      len(report.Copied),
+		Overwritten: len(report.Overwritten),
+		Linked:      len(report.Linked),
+		Skipped:     len(report.Skipped),
+	}
+	for _, f := range report.Failed {
+		result.Failed = append(result.Failed, file(f, false))
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return stream.SendMsg(&Event{Stats: stats(copier.Stats()), Result: &result})
+}
+
+func file(f cp.FileReport, skipped bool) File {
+	out := File{From: f.From, To: f.To, Bytes: f.Bytes, Duration: f.Duration, Skipped: skipped}
+	if f.Err != nil {
+		out.Error = f.Err.Error()
+	}
+	return out
+}
+
+func stats(s cp.Stats) Stats {
+	return Stats{
+		Files:      s.Files,
+		Bytes:      s.Bytes,
+		FoundFiles: s.Found.Files,
+		FoundBytes: s.Found.Bytes,
+		Elapsed:    s.Elapsed,
+		Throughput: s.Throughput,
+		Rate:       s.Rate,
+		ETA:        s.ETA,
+	}
+}
+
+// Client requests copies of an agent.
+type Client struct {
+	conn grpc.ClientConnInterface
+}
+
+// NewClient makes requests over conn.
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{conn: conn}
+}
+
+// Copy has the agent make the copy, passing each event but the last to
+// progress if it is set, and returns the result. A copy that fails on the
+// agent returns ErrRemote along with the result.
+func (c *Client) Copy(ctx context.Context, req CopyRequest, progress func(Event)) (Result, error) {
+	stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[0], "/cp.rpc.Copier/Copy", grpc.ForceCodec(codec{}))
+	if err != nil {
+		return Result{}, errors.Wrap(err, "starting copy")
+	}
+	if err := stream.SendMsg(&req); err != nil {
+		return Result{}, errors.Wrap(err, "sending request")
+	}
+	if err := stream.CloseSend(); err != nil {
+		return Result{}, errors.Wrap(err, "sending request")
+	}
+	for {
+		var e Event
+		err := stream.RecvMsg(&e)
+		if err == io.EOF {
+			return Result{}, errors.New("copy ended without a result")
+		}
+		if err != nil {
+			return Result{}, errors.Wrap(err, "receiving progress")
+		}
+		if e.Result != nil {
+			if e.Result.Error != "" {
+				return *e.Result, ErrRemote{e.Result.Error}
+			}
+			return *e.Result, nil
+		}
+		if progress != nil {
+			progress(e)
+		}
+	}
+}
+
+// copier is the service as registered, for gRPC to check the Server
+// against.
+type copier interface {
+	copy(*CopyRequest, grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "cp.rpc.Copier",
+	HandlerType: (*copier)(nil),
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Copy",
+		ServerStreams: true,
+		Handler: func(srv interface{}, stream grpc.ServerStream) error {
+			req := &CopyRequest{}
+			if err := stream.RecvMsg(req); err != nil {
+				return err
+			}
+			return srv.(copier).copy(req, stream)
+		},
+	}},
+}
+
+// codecName is the content subtype messages are sent under.
+const codecName = "json"
+
+// codec encodes messages as JSON.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (codec) Name() string {
+	return codecName
+}
@@ -0,0 +1,60 @@
+package rpc
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// TestClient_Copy tests that a copy made by the server streams an event per
+// file and then its result.
+func TestClient_Copy(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	for _, path := range []string{"/from/a", "/from/dir/b"} {
+		if err := afero.WriteFile(fs, path, []byte("data"), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	listener := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer(ServerCodec())
+	Register(srv, &Server{Fs: fs})
+	go srv.Serve(listener)
+	defer srv.Stop()
+	conn, err := grpc.NewClient("passthrough:///agent",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("unexpected error while connecting: %v", err)
+	}
+	defer conn.Close()
+	client := NewClient(conn)
+	var events []Event
+	result, err := client.Copy(context.Background(), CopyRequest{From: "/from", To: "/to"}, func(e Event) {
+		events = append(events, e)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error while copying: %v", err)
+	}
+	if result.Copied != 2 || len(events) != 2 {
+		t.Fatalf("want 2 files copied with an event each, got %+v and %d events", result, len(events))
+	}
+	if ok, _ := afero.Exists(fs, "/to/dir/b"); !ok {
+		t.Errorf("want /to/dir/b to exist")
+	}
+	_, err = client.Copy(context.Background(), CopyRequest{From: "/missing", To: "/to"}, nil)
+	if _, ok := errors.Cause(err).(ErrRemote); !ok {
+		t.Errorf("want ErrRemote copying a missing file, got %v", err)
+	}
+	if encoding.GetCodec(codecName) != nil {
+		t.Errorf("want no %s codec registered for the whole process", codecName)
+	}
+}
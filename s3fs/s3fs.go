@@ -0,0 +1,154 @@
+// Package s3fs copies to and from S3, or any S3 compatible object storage,
+// by presenting a bucket as an afero.Fs.
+//
+//	client := s3.NewFromConfig(cfg)
+//	copier := cp.Copier{DstFs: s3fs.New(client, "bucket")}
+//	err := copier.Copy("/data", "/prefix")
+//
+// Large files are uploaded in parts, concurrently, by the upload manager.
+package s3fs
+
+import (
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+
+	"github.com/jackmordaunt/cp/objectfs"
+)
+
+// Store is an objectfs.Store backed by an S3 bucket.
+type Store struct {
+	client   *s3.Client
+	uploader *manager.Uploader
+	bucket   string
+}
+
+var _ objectfs.Store = &Store{}
+
+// New presents the bucket as a filesystem.
+func New(client *s3.Client, bucket string) *objectfs.Fs {
+	return objectfs.New(NewStore(client, bucket))
+}
+
+// NewStore creates a Store for the bucket. Uploads larger than the
+// manager's part size (5 MiB by default) are split into a multipart upload;
+// options can adjust the part size and upload concurrency.
+func NewStore(client *s3.Client, bucket string, options ...func(*manager.Uploader)) *Store {
+	return &Store{
+		client:   client,
+		uploader: manager.NewUploader(client, options...),
+		bucket:   bucket,
+	}
+}
+
+// Head describes the object at key.
+func (s *Store) Head(ctx context.Context, key string) (objectfs.Object, error) {
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return objectfs.Object{}, translate(err)
+	}
+	return objectfs.Object{
+		Key:     key,
+		Size:    aws.ToInt64(out.ContentLength),
+		ModTime: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+// List describes the objects and common prefixes directly under prefix.
+func (s *Store) List(ctx context.Context, prefix string) ([]objectfs.Object, error) {
+	var objects []objectfs.Object
+	pages := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return nil, translate(err)
+		}
+		for _, p := range page.CommonPrefixes {
+			objects = append(objects, objectfs.Object{
+				Key: strings.TrimSuffix(aws.ToString(p.Prefix), "/"),
+				Dir: true,
+			})
+		}
+		for _, obj := range page.Contents {
+			if aws.ToString(obj.Key) == prefix {
+				continue
+			}
+			objects = append(objects, objectfs.Object{
+				Key:     aws.ToString(obj.Key),
+				Size:    aws.ToInt64(obj.Size),
+				ModTime: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+// Get reads the object at key from offset.
+func (s *Store) Get(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	}
+	if offset > 0 {
+		in.Range = aws.String("bytes=" + strconv.FormatInt(offset, 10) + "-")
+	}
+	out, err := s.client.GetObject(ctx, in)
+	if err != nil {
+		return nil, translate(err)
+	}
+	return out.Body, nil
+}
+
+// Put uploads the object at key, in parts if it is large.
+func (s *Store) Put(ctx context.Context, key string, r io.Reader) error {
+	_, err := s.uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   r,
+	})
+	return translate(err)
+}
+
+// Delete removes the object at key.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return translate(err)
+}
+
+// translate maps missing objects onto os.ErrNotExist.
+func translate(err error) error {
+	if err == nil {
+		return nil
+	}
+	var (
+		notFound *types.NotFound
+		noKey    *types.NoSuchKey
+		api      smithy.APIError
+	)
+	if errors.As(err, &notFound) || errors.As(err, &noKey) {
+		return os.ErrNotExist
+	}
+	if errors.As(err, &api) && (api.ErrorCode() == "NotFound" || api.ErrorCode() == "NoSuchKey") {
+		return os.ErrNotExist
+	}
+	return err
+}
@@ -0,0 +1,31 @@
+//go:build linux
+
+package cp
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// selinuxAttr is the extended attribute holding a file's security context.
+const selinuxAttr = "security.selinux"
+
+// copySELinux copies the security context of one file onto the other. A
+// source without a context, as on systems without SELinux, leaves the
+// destination as labelled.
+func copySELinux(from, to string) error {
+	size, err := unix.Lgetxattr(from, selinuxAttr, nil)
+	if errors.Is(err, unix.ENODATA) || errors.Is(err, unix.ENOTSUP) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	label := make([]byte, size)
+	n, err := unix.Lgetxattr(from, selinuxAttr, label)
+	if err != nil {
+		return err
+	}
+	return unix.Lsetxattr(to, selinuxAttr, label[:n], 0)
+}
@@ -0,0 +1,44 @@
+//go:build linux
+
+package cp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestCopier_PreserveSELinux tests that the security context of files and
+// directories is copied.
+func TestCopier_PreserveSELinux(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "from"), filepath.Join(dir, "to")
+	if err := os.MkdirAll(from, 0755); err != nil {
+		t.Fatalf("unexpected error while creating directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(from, "index.html"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	want := make([]byte, 256)
+	n, err := unix.Lgetxattr(filepath.Join(from, "index.html"), selinuxAttr, want)
+	if err != nil {
+		t.Skipf("SELinux not enabled: %v", err)
+	}
+	want = want[:n]
+	copier := Copier{PreserveSELinux: true}
+	if err := copier.Copy(from, to); err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	for _, path := range []string{to, filepath.Join(to, "index.html")} {
+		got := make([]byte, 256)
+		n, err := unix.Lgetxattr(path, selinuxAttr, got)
+		if err != nil {
+			t.Fatalf("want %s labelled, got %v", path, err)
+		}
+		if string(got[:n]) != string(want) {
+			t.Errorf("want %s labelled %q, got %q", path, want, got[:n])
+		}
+	}
+}
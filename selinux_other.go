@@ -0,0 +1,8 @@
+//go:build !linux
+
+package cp
+
+// copySELinux does nothing, SELinux being particular to Linux.
+func copySELinux(from, to string) error {
+	return nil
+}
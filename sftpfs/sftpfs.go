@@ -0,0 +1,221 @@
+// Package sftpfs copies to and from remote hosts over SFTP by presenting a
+// pool of SFTP sessions as an afero.Fs.
+//
+//	fs, err := sftpfs.Dial("host:22", config, copier.Parallel)
+//	copier.DstFs = fs
+//	err = copier.Copy("./build", "/srv/app")
+//
+// Each operation is made on the next session in the pool, so the workers'
+// transfers are spread across sessions rather than queued behind one.
+package sftpfs
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/ssh"
+)
+
+// Fs is an afero.Fs over a pool of SFTP sessions.
+type Fs struct {
+	clients []*sftp.Client
+	conn    *ssh.Client
+	next    uint64
+}
+
+var _ afero.Fs = &Fs{}
+
+// Dial connects to addr and opens size sessions over the connection.
+func Dial(addr string, config *ssh.ClientConfig, size int) (*Fs, error) {
+	conn, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "connecting to %s", addr)
+	}
+	fs, err := New(conn, size)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	fs.conn = conn
+	return fs, nil
+}
+
+// New opens size sessions, at least one, over an existing connection.
+// Closing the Fs does not close the connection.
+func New(conn *ssh.Client, size int) (*Fs, error) {
+	if size < 1 {
+		size = 1
+	}
+	fs := &Fs{}
+	for ii := 0; ii < size; ii++ {
+		client, err := sftp.NewClient(conn)
+		if err != nil {
+			fs.Close()
+			return nil, errors.Wrap(err, "opening sftp session")
+		}
+		fs.clients = append(fs.clients, client)
+	}
+	return fs, nil
+}
+
+// Close closes the sessions, and the connection if the Fs dialled it.
+func (fs *Fs) Close() error {
+	var first error
+	for _, client := range fs.clients {
+		if err := client.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	if fs.conn != nil {
+		if err := fs.conn.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// client picks the next session in the pool.
+func (fs *Fs) client() *sftp.Client {
+	n := atomic.AddUint64(&fs.next, 1)
+	return fs.clients[n%uint64(len(fs.clients))]
+}
+
+// Name of the filesystem.
+func (fs *Fs) Name() string { return "sftpfs" }
+
+// Create creates or truncates the file.
+func (fs *Fs) Create(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
+}
+
+// Mkdir creates a directory.
+func (fs *Fs) Mkdir(name string, perm os.FileMode) error {
+	client := fs.client()
+	if err := client.Mkdir(name); err != nil {
+		return err
+	}
+	return client.Chmod(name, perm)
+}
+
+// MkdirAll creates a directory and any parents.
+func (fs *Fs) MkdirAll(path string, perm os.FileMode) error {
+	return fs.client().MkdirAll(path)
+}
+
+// Open opens the file for reading.
+func (fs *Fs) Open(name string) (afero.File, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the file with the given flags, setting perm on creation.
+func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	client := fs.client()
+	_, statErr := client.Stat(name)
+	f, err := client.OpenFile(name, flag)
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_CREATE != 0 && os.IsNotExist(statErr) {
+		if err := f.Chmod(perm); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &File{File: f, client: client}, nil
+}
+
+// Remove removes the file or empty directory.
+func (fs *Fs) Remove(name string) error {
+	return fs.client().Remove(name)
+}
+
+// RemoveAll removes the path and anything beneath it.
+func (fs *Fs) RemoveAll(path string) error {
+	return fs.client().RemoveAll(path)
+}
+
+// Rename renames the file.
+func (fs *Fs) Rename(oldname, newname string) error {
+	return fs.client().Rename(oldname, newname)
+}
+
+// Stat describes the file.
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	return fs.client().Stat(name)
+}
+
+// LstatIfPossible describes the file without following symlinks.
+func (fs *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	fi, err := fs.client().Lstat(name)
+	return fi, true, err
+}
+
+// Chmod changes the mode of the file.
+func (fs *Fs) Chmod(name string, mode os.FileMode) error {
+	return fs.client().Chmod(name, mode)
+}
+
+// Chown changes the owner of the file.
+func (fs *Fs) Chown(name string, uid, gid int) error {
+	return fs.client().Chown(name, uid, gid)
+}
+
+// Chtimes changes the access and modification times of the file.
+func (fs *Fs) Chtimes(name string, atime, mtime time.Time) error {
+	return fs.client().Chtimes(name, atime, mtime)
+}
+
+// File is an open remote file.
+type File struct {
+	*sftp.File
+	client *sftp.Client
+	dir    []os.FileInfo
+	read   bool
+}
+
+var _ afero.File = &File{}
+
+// Readdir lists the directory.
+func (f *File) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.read {
+		entries, err := f.client.ReadDir(f.Name())
+		if err != nil {
+			return nil, err
+		}
+		f.dir, f.read = entries, true
+	}
+	if count <= 0 {
+		entries := f.dir
+		f.dir = nil
+		return entries, nil
+	}
+	if len(f.dir) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.dir) {
+		count = len(f.dir)
+	}
+	entries := f.dir[:count]
+	f.dir = f.dir[count:]
+	return entries, nil
+}
+
+// Readdirnames lists the names in the directory.
+func (f *File) Readdirnames(n int) ([]string, error) {
+	entries, err := f.Readdir(n)
+	names := make([]string, len(entries))
+	for ii, e := range entries {
+		names[ii] = e.Name()
+	}
+	return names, err
+}
+
+// WriteString writes s.
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
@@ -0,0 +1,188 @@
+package sftpfs
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/afero"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/jackmordaunt/cp"
+)
+
+// serve runs an SSH server offering the sftp subsystem over the local
+// filesystem, and dials it with a pool of size sessions.
+func serve(t *testing.T, size int) *Fs {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error while generating host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error while making signer: %v", err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error while listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			nc, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go accept(nc, config)
+		}
+	}()
+	fs, err := Dial(listener.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}, size)
+	if err != nil {
+		t.Fatalf("unexpected error while dialling: %v", err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+// accept serves the sftp subsystem on each session opened over nc.
+func accept(nc net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(nc, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nch := range chans {
+		if nch.ChannelType() != "session" {
+			nch.Reject(ssh.UnknownChannelType, "sessions only")
+			continue
+		}
+		ch, reqs, err := nch.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range reqs {
+				req.Reply(req.Type == "subsystem" && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}()
+		go func() {
+			defer ch.Close()
+			server, err := sftp.NewServer(ch)
+			if err != nil {
+				return
+			}
+			server.Serve()
+		}()
+	}
+}
+
+// TestFs tests the file operations.
+func TestFs(t *testing.T) {
+	fs, dir := serve(t, 2), t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	f, err := fs.Create(path)
+	if err != nil {
+		t.Fatalf("unexpected error while creating: %v", err)
+	}
+	if _, err := f.WriteString("data"); err != nil {
+		t.Fatalf("unexpected error while writing: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("unexpected error while closing: %v", err)
+	}
+	if fi, err := fs.Stat(path); err != nil || fi.Size() != 4 {
+		t.Fatalf("want a 4 byte file, got %v, %v", fi, err)
+	}
+	f, err = fs.Open(path)
+	if err != nil {
+		t.Fatalf("unexpected error while opening: %v", err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil || string(data) != "data" {
+		t.Fatalf("want %q read back, got %q, %v", "data", data, err)
+	}
+	if err := fs.Mkdir(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatalf("unexpected error while making directory: %v", err)
+	}
+	if fi, err := os.Stat(filepath.Join(dir, "sub")); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("want sub made with mode 0700, got %v, %v", fi, err)
+	}
+	moved := filepath.Join(dir, "sub", "b.txt")
+	if err := fs.Rename(path, moved); err != nil {
+		t.Fatalf("unexpected error while renaming: %v", err)
+	}
+	if _, err := fs.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("want %s gone after renaming, got %v", path, err)
+	}
+	d, err := fs.Open(dir)
+	if err != nil {
+		t.Fatalf("unexpected error while opening directory: %v", err)
+	}
+	names, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil || len(names) != 1 || names[0] != "sub" {
+		t.Errorf("want only sub listed, got %v, %v", names, err)
+	}
+	if err := fs.Remove(moved); err != nil {
+		t.Fatalf("unexpected error while removing: %v", err)
+	}
+	if _, err := fs.Stat(moved); !os.IsNotExist(err) {
+		t.Errorf("want %s gone after removing, got %v", moved, err)
+	}
+	if _, err := fs.Open(moved); !os.IsNotExist(err) {
+		t.Errorf("want os.ErrNotExist opening a removed file, got %v", err)
+	}
+}
+
+// TestFs_RoundTrip tests that a tree copied to the host and back is
+// unchanged.
+func TestFs_RoundTrip(t *testing.T) {
+	local := afero.NewMemMapFs()
+	files := map[string]string{
+		"/from/foo.exe":         "foo",
+		"/from/dir/bar.exe":     "bar",
+		"/from/dir/sub/baz.exe": "baz",
+	}
+	for path, data := range files {
+		if err := afero.WriteFile(local, path, []byte(data), 0644); err != nil {
+			t.Fatalf("unexpected error while writing file: %v", err)
+		}
+	}
+	remote, dir := serve(t, 4), filepath.Join(t.TempDir(), "remote")
+	up := cp.Copier{SrcFs: local, DstFs: remote, Parallel: 4}
+	if err := up.Copy("/from", dir); err != nil {
+		t.Fatalf("unexpected error while uploading: %v", err)
+	}
+	down := cp.Copier{SrcFs: remote, DstFs: local, Parallel: 4}
+	if err := down.Copy(dir, "/to"); err != nil {
+		t.Fatalf("unexpected error while downloading: %v", err)
+	}
+	for path, want := range files {
+		got, err := afero.ReadFile(local, strings.Replace(path, "/from", "/to", 1))
+		if err != nil {
+			t.Fatalf("unexpected error while reading %s: %v", path, err)
+		}
+		if string(got) != want {
+			t.Errorf("want %s to contain %q, got %q", path, want, got)
+		}
+	}
+	if err := remote.RemoveAll(dir); err != nil {
+		t.Fatalf("unexpected error while removing: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("want %s removed, got %v", dir, err)
+	}
+}
@@ -0,0 +1,101 @@
+package cp
+
+import (
+	"bytes"
+	"io"
+	"os"
+
+	"github.com/spf13/afero"
+)
+
+// Sparse is whether runs of zeros are left as holes in the files written,
+// taking no space on disk, rather than written out.
+type Sparse int
+
+const (
+	// SparseNever writes every byte.
+	SparseNever Sparse = iota
+	// SparseAuto leaves holes in copies of files that have them.
+	SparseAuto
+	// SparseAlways leaves holes wherever there are zeros, whether or not
+	// the source has holes, such as to shrink a disk image.
+	SparseAlways
+)
+
+// sparseBlock is the size of the runs of zeros left as holes, that of a
+// filesystem block on most systems.
+const sparseBlock = 4096
+
+var zeros = make([]byte, sparseBlock)
+
+// holes returns a writer leaving holes in f if the file described by info
+// should be copied sparsely, or nil.
+func (c *copier) holes(f afero.File, info os.FileInfo) *sparseWriter {
+	switch {
+	case c.sparse == SparseAlways:
+	case c.sparse == SparseAuto && sparse(info):
+	default:
+		return nil
+	}
+	return &sparseWriter{f: f}
+}
+
+// sparseWriter seeks over blocks of zeros rather than writing them. close
+// must be called once it is done with to give the file its full size.
+type sparseWriter struct {
+	f afero.File
+	// hole is how far past the last byte written the file extends.
+	hole int64
+}
+
+func (w *sparseWriter) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		run := written
+		for run < len(p) && !zero(p[run:min(run+sparseBlock, len(p))]) {
+			run = min(run+sparseBlock, len(p))
+		}
+		if run > written {
+			if err := w.seek(); err != nil {
+				return written, err
+			}
+			n, err := w.f.Write(p[written:run])
+			written += n
+			if err != nil {
+				return written, err
+			}
+			continue
+		}
+		n := min(sparseBlock, len(p)-written)
+		w.hole += int64(n)
+		written += n
+	}
+	return written, nil
+}
+
+// seek moves past the hole before writing more.
+func (w *sparseWriter) seek() error {
+	if w.hole == 0 {
+		return nil
+	}
+	_, err := w.f.Seek(w.hole, io.SeekCurrent)
+	w.hole = 0
+	return err
+}
+
+// close extends the file over a hole at its end.
+func (w *sparseWriter) close() error {
+	if w.hole == 0 {
+		return nil
+	}
+	end, err := w.f.Seek(w.hole, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	w.hole = 0
+	return w.f.Truncate(end)
+}
+
+func zero(p []byte) bool {
+	return bytes.Equal(p, zeros[:len(p)])
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package cp
+
+import "os"
+
+// sparse reports false, there being no portable way to tell if a file has
+// holes.
+func sparse(os.FileInfo) bool {
+	return false
+}
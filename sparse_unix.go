@@ -0,0 +1,15 @@
+//go:build unix
+
+package cp
+
+import (
+	"os"
+	"syscall"
+)
+
+// sparse reports whether the file takes up less space on disk than its
+// size, and so has holes.
+func sparse(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int64(st.Blocks)*512 < info.Size()
+}
@@ -0,0 +1,74 @@
+package cp
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// SpecialFiles is what to do with files that hold no data to copy, such as
+// named pipes, sockets and device nodes.
+type SpecialFiles int
+
+const (
+	// SpecialSkip leaves special files out, reporting them as skipped.
+	SpecialSkip SpecialFiles = iota
+	// SpecialRecreate creates a file of the same kind at the destination,
+	// which the OS filesystem on Unix supports; elsewhere they fail.
+	SpecialRecreate
+	// SpecialError fails each special file.
+	SpecialError
+)
+
+// specialModes are the modes of files that can't be copied by reading
+// them: a pipe blocks until written and a device reads as garbage.
+const specialModes = os.ModeNamedPipe | os.ModeSocket | os.ModeDevice | os.ModeCharDevice | os.ModeIrregular
+
+// ErrSpecialFile describes a special file that was not copied.
+type ErrSpecialFile struct {
+	Path string
+	Mode os.FileMode
+}
+
+func (err ErrSpecialFile) Error() string {
+	return fmt.Sprintf("%q is a special file (%v)", err.Path, err.Mode.Type())
+}
+
+// special deals with the file if it is special, reporting false if it is
+// an ordinary file to be copied.
+func (c *copier) special(from, to string, info os.FileInfo) (result, bool) {
+	if info.Mode()&specialModes == 0 {
+		return result{}, false
+	}
+	r := result{FileReport: FileReport{From: from, To: to}}
+	switch c.specialFiles {
+	case SpecialRecreate:
+		r.Err = c.recreate(from, to, info)
+	case SpecialError:
+		r.Err = ErrSpecialFile{Path: from, Mode: info.Mode()}
+	default:
+		r.skipped = true
+	}
+	return r, true
+}
+
+// recreate makes a special file like the one at from at to.
+func (c *copier) recreate(from, to string, info os.FileInfo) error {
+	if !isOs(c.dst) {
+		return errors.Wrapf(ErrSpecialFile{Path: from, Mode: info.Mode()}, "recreating on %s", c.dst.Name())
+	}
+	toFi, err := c.dst.Stat(to)
+	if err == nil {
+		if toFi.Mode().Type() == info.Mode().Type() {
+			return nil
+		}
+		if err := c.dst.Remove(to); err != nil {
+			return errors.Wrapf(err, "replacing %s", to)
+		}
+	}
+	if err := mknod(longPath(to), info); err != nil {
+		return errors.Wrapf(err, "recreating %s", to)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package cp
+
+import "os"
+
+// mknod fails, special files being particular to Unix.
+func mknod(path string, info os.FileInfo) error {
+	return ErrSpecialFile{Path: path, Mode: info.Mode()}
+}
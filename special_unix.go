@@ -0,0 +1,38 @@
+//go:build unix
+
+package cp
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// mknod creates a special file of the same kind and device as info.
+func mknod(path string, info os.FileInfo) error {
+	mode := uint32(info.Mode().Perm())
+	switch t := info.Mode().Type(); {
+	case t&os.ModeNamedPipe != 0:
+		return unix.Mkfifo(path, mode)
+	case t&os.ModeSocket != 0:
+		mode |= unix.S_IFSOCK
+	case t&os.ModeCharDevice != 0:
+		mode |= unix.S_IFCHR
+	case t&os.ModeDevice != 0:
+		mode |= unix.S_IFBLK
+	default:
+		return ErrSpecialFile{Path: path, Mode: info.Mode()}
+	}
+	var dev uint64
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		dev = uint64(st.Rdev)
+	}
+	return makeNode(unix.Mknod, path, mode, dev)
+}
+
+// makeNode calls mknod with the device number in whichever type the
+// platform takes.
+func makeNode[T int | uint64](mknod func(string, uint32, T) error, path string, mode uint32, dev uint64) error {
+	return mknod(path, mode, T(dev))
+}
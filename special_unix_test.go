@@ -0,0 +1,85 @@
+//go:build unix
+
+package cp
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// TestCopier_SpecialFiles tests that named pipes are skipped, failed or
+// recreated rather than read, which would block forever.
+func TestCopier_SpecialFiles(t *testing.T) {
+	dir := t.TempDir()
+	from := filepath.Join(dir, "from")
+	if err := os.Mkdir(from, 0755); err != nil {
+		t.Fatalf("unexpected error creating directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(from, "file"), []byte("file"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(from, "pipe"), 0644); err != nil {
+		t.Skipf("named pipes not supported: %v", err)
+	}
+	tests := []struct {
+		desc   string
+		policy SpecialFiles
+		check  func(t *testing.T, report Report, err error, pipe string)
+	}{
+		{
+			"skip",
+			SpecialSkip,
+			func(t *testing.T, report Report, err error, pipe string) {
+				if err != nil {
+					t.Fatalf("unexpected error copying: %v", err)
+				}
+				if len(report.Skipped) != 1 || len(report.Copied) != 1 {
+					t.Errorf("want pipe skipped and file copied, got %+v", report)
+				}
+				if _, err := os.Lstat(pipe); !os.IsNotExist(err) {
+					t.Errorf("want no pipe, got %v", err)
+				}
+			},
+		},
+		{
+			"error",
+			SpecialError,
+			func(t *testing.T, report Report, err error, pipe string) {
+				if len(report.Failed) != 1 {
+					t.Fatalf("want pipe failed, got %+v", report)
+				}
+				if _, ok := errors.Cause(report.Failed[0].Err).(ErrSpecialFile); !ok {
+					t.Errorf("want ErrSpecialFile, got %v", report.Failed[0].Err)
+				}
+			},
+		},
+		{
+			"recreate",
+			SpecialRecreate,
+			func(t *testing.T, report Report, err error, pipe string) {
+				if err != nil {
+					t.Fatalf("unexpected error copying: %v", err)
+				}
+				fi, err := os.Lstat(pipe)
+				if err != nil {
+					t.Fatalf("want pipe recreated, got %v", err)
+				}
+				if fi.Mode()&os.ModeNamedPipe == 0 {
+					t.Errorf("want named pipe, got %v", fi.Mode())
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			to := filepath.Join(dir, tt.desc)
+			copier := Copier{SpecialFiles: tt.policy}
+			report, err := copier.CopyReport(from, to)
+			tt.check(t, report, err, filepath.Join(to, "pipe"))
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package cp
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// State is a snapshot of a copy's progress, as written to StateFile.
+type State struct {
+	Stats
+	// Running is whether a copy was still going when the snapshot was
+	// taken.
+	Running bool
+	// Updated is when the snapshot was taken.
+	Updated time.Time
+}
+
+// snapshots writes the state every StateInterval until the returned func
+// is called, which writes it a last time.
+func (c *Copier) snapshots() (stop func()) {
+	if c.StateFile == "" {
+		return func() {}
+	}
+	interval := c.StateInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	done, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-tick.C:
+				c.writeState()
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+		c.writeState()
+	}
+}
+
+// writeState replaces the state file through a rename, so that a monitor
+// never reads it half written. Failing to is logged rather than failing the
+// copy.
+func (c *Copier) writeState() {
+	state := State{
+		Stats:   c.Stats(),
+		Running: c.counters().running(),
+		Updated: time.Now(),
+	}
+	if err := writeState(c.StateFile, state); err != nil {
+		c.log(slog.LevelWarn, "state not written", "path", c.StateFile, "error", err)
+	}
+}
+
+func writeState(path string, state State) error {
+	data, err := json.MarshalIndent(state, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
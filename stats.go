@@ -0,0 +1,188 @@
+package cp
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of how much a Copier has done.
+type Stats struct {
+	// Files is the number of files copied.
+	Files int64
+	// Bytes is the number of bytes written.
+	Bytes int64
+	// Elapsed is the time spent copying, measured from the start of the
+	// first copy.
+	Elapsed time.Duration
+	// Throughput is the average rate of copying in megabytes per second.
+	Throughput float64
+	// Rate is the rate of copying over the last few seconds, in megabytes
+	// per second, which follows changes in speed that Throughput averages
+	// away. It is zero once no copy is running.
+	Rate float64
+	// Found is the files queued to be copied so far, which grows as the
+	// walk goes on.
+	Found Totals
+	// Queued is how many of the files found are waiting for a worker.
+	Queued int64
+	// ETA is how much longer copying the rest of Found, or of
+	// Copier.Expected if that is more, should take at the current Rate;
+	// zero if there is nothing left or no way to tell.
+	ETA time.Duration
+}
+
+// rateWindow is how far back Rate looks.
+const rateWindow = 5 * time.Second
+
+// Stats returns the statistics accumulated so far.
+// Safe to call while a copy is in progress.
+func (c *Copier) Stats() Stats {
+	s := c.counters().snapshot()
+	total := s.Found.Bytes
+	if c.Expected.Bytes > total {
+		total = c.Expected.Bytes
+	}
+	if s.Rate > 0 && total > s.Bytes {
+		s.ETA = time.Duration(float64(total-s.Bytes) / (1 << 20) / s.Rate * float64(time.Second))
+	}
+	return s
+}
+
+func (c *Copier) counters() *counters {
+	c.init()
+	return c.stats
+}
+
+// counters are updated atomically by the workers.
+type counters struct {
+	files  int64
+	bytes  int64
+	start  int64
+	end    int64
+	active int64
+	// foundFiles and foundBytes are updated as files are queued.
+	foundFiles int64
+	foundBytes int64
+	// queued is the files pushed onto a queue and not yet taken off.
+	queued int64
+
+	// samples are the bytes written by times over the last rateWindow,
+	// oldest first, from which Rate is worked out.
+	mu      sync.Mutex
+	samples []sample
+}
+
+// sample is the bytes written by a point in time.
+type sample struct {
+	at    time.Time
+	bytes int64
+}
+
+// found counts a file queued to be copied.
+func (c *counters) found(size int64) {
+	atomic.AddInt64(&c.foundFiles, 1)
+	atomic.AddInt64(&c.foundBytes, size)
+}
+
+// begin marks the start of a copy, reporting whether no other was running.
+func (c *counters) begin() bool {
+	atomic.CompareAndSwapInt64(&c.start, 0, time.Now().UnixNano())
+	return atomic.AddInt64(&c.active, 1) == 1
+}
+
+// done marks the end of a copy.
+func (c *counters) done() {
+	if atomic.AddInt64(&c.active, -1) == 0 {
+		atomic.StoreInt64(&c.end, time.Now().UnixNano())
+	}
+}
+
+// running reports whether a copy is in progress.
+func (c *counters) running() bool {
+	return atomic.LoadInt64(&c.active) > 0
+}
+
+func (c *counters) snapshot() Stats {
+	s := Stats{
+		Files: atomic.LoadInt64(&c.files),
+		Bytes: atomic.LoadInt64(&c.bytes),
+		Found: Totals{
+			Files: atomic.LoadInt64(&c.foundFiles),
+			Bytes: atomic.LoadInt64(&c.foundBytes),
+		},
+		Queued: atomic.LoadInt64(&c.queued),
+	}
+	start := atomic.LoadInt64(&c.start)
+	if start == 0 {
+		return s
+	}
+	end := time.Now().UnixNano()
+	active := atomic.LoadInt64(&c.active) > 0
+	if !active {
+		end = atomic.LoadInt64(&c.end)
+	}
+	s.Elapsed = time.Duration(end - start)
+	if s.Elapsed > 0 {
+		s.Throughput = float64(s.Bytes) / (1 << 20) / s.Elapsed.Seconds()
+	}
+	if active {
+		s.Rate = c.rate(time.Unix(0, end), s.Bytes)
+	}
+	return s
+}
+
+// rate records how much has been written by now and works out the rate
+// since the oldest sample in the window, or since the start if the window
+// has no history yet.
+func (c *counters) rate(now time.Time, bytes int64) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n := len(c.samples); n == 0 || now.Sub(c.samples[n-1].at) >= rateWindow/20 {
+		c.samples = append(c.samples, sample{at: now, bytes: bytes})
+	}
+	drop := 0
+	for drop < len(c.samples)-1 && now.Sub(c.samples[drop].at) > rateWindow {
+		drop++
+	}
+	c.samples = c.samples[drop:]
+	oldest := c.samples[0]
+	if start := time.Unix(0, atomic.LoadInt64(&c.start)); now.Sub(oldest.at) < rateWindow/20 && now.Sub(start) > 0 {
+		// Too little history: fall back on the average so far.
+		oldest = sample{at: start}
+	}
+	elapsed := now.Sub(oldest.at).Seconds()
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(bytes-oldest.bytes) / (1 << 20) / elapsed
+}
+
+// countingWriter adds the bytes written through it to the counters so that
+// progress can be observed mid-file.
+type countingWriter struct {
+	io.Writer
+	c *counters
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	atomic.AddInt64(&w.c.bytes, int64(n))
+	return n, err
+}
+
+// countingReader adds the bytes read through it to the counters, for when
+// the writing end is out of our hands.
+type countingReader struct {
+	io.Reader
+	c *counters
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	atomic.AddInt64(&r.c.bytes, int64(n))
+	return n, err
+}
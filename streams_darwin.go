@@ -0,0 +1,9 @@
+//go:build darwin
+
+package cp
+
+// copyStreams copies the resource fork, which macOS keeps as an extended
+// attribute.
+func copyStreams(from, to string) error {
+	return copyXattrs(from, to, "com.apple.ResourceFork")
+}
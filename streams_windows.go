@@ -0,0 +1,85 @@
+//go:build windows
+
+package cp
+
+import (
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procFindFirstStreamW = kernel32.NewProc("FindFirstStreamW")
+	procFindNextStreamW  = kernel32.NewProc("FindNextStreamW")
+)
+
+// findStreamData is WIN32_FIND_STREAM_DATA.
+type findStreamData struct {
+	size int64
+	name [syscall.MAX_PATH + 36]uint16
+}
+
+// streams lists the alternate data streams of path, named as ":name:$DATA",
+// leaving out the main stream.
+func streams(path string) ([]string, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	var data findStreamData
+	h, _, err := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if syscall.Handle(h) == syscall.InvalidHandle {
+		if err == syscall.ERROR_HANDLE_EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer syscall.FindClose(syscall.Handle(h))
+	var names []string
+	for {
+		if name := syscall.UTF16ToString(data.name[:]); name != "::$DATA" {
+			names = append(names, name)
+		}
+		ok, _, err := procFindNextStreamW.Call(h, uintptr(unsafe.Pointer(&data)))
+		if ok == 0 {
+			if err == syscall.ERROR_HANDLE_EOF {
+				return names, nil
+			}
+			return names, err
+		}
+	}
+}
+
+// copyStreams copies the alternate data streams of from onto to.
+func copyStreams(from, to string) error {
+	names, err := streams(from)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := copyStream(from+name, to+strings.TrimSuffix(name, ":$DATA")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyStream(from, to string) error {
+	in, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(to)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
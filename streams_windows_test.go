@@ -0,0 +1,35 @@
+//go:build windows
+
+package cp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestCopier_PreserveStreams tests that alternate data streams are copied.
+func TestCopier_PreserveStreams(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "from.exe"), filepath.Join(dir, "to.exe")
+	if err := os.WriteFile(from, []byte("main"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	if err := os.WriteFile(from+":Zone.Identifier", []byte("[ZoneTransfer]\r\nZoneId=3\r\n"), 0644); err != nil {
+		t.Skipf("alternate data streams not supported: %v", err)
+	}
+	copier := Copier{PreserveStreams: true}
+	if err := copier.Copy(from, to); err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	data, err := os.ReadFile(to + ":Zone.Identifier")
+	if err != nil {
+		t.Fatalf("want stream copied, got %v", err)
+	}
+	if string(data) != "[ZoneTransfer]\r\nZoneId=3\r\n" {
+		t.Errorf("want stream contents copied, got %q", data)
+	}
+	if data, _ := os.ReadFile(to); string(data) != "main" {
+		t.Errorf("want main stream copied, got %q", data)
+	}
+}
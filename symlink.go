@@ -0,0 +1,206 @@
+package cp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/afero"
+)
+
+// ErrSymlinkCycle means a symlink leads back to a directory that contains
+// it, so following it would copy the same tree forever.
+type ErrSymlinkCycle struct {
+	// Path is the symlink and Target the directory it leads back to.
+	Path   string
+	Target string
+}
+
+func (err ErrSymlinkCycle) Error() string {
+	return fmt.Sprintf("symlink %q leads back to %q", err.Path, err.Target)
+}
+
+// walkFollowing walks the tree like afero.Walk, but descends into symlinked
+// directories as if they were the directories they link to. Each link back
+// to a directory being walked is reported as a failure rather than followed.
+func (c *copier) walkFollowing(root string, walkFn filepath.WalkFunc) error {
+	info, err := c.src.Stat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	return c.follow(root, info, map[any]string{}, walkFn)
+}
+
+// follow walks path, whose ancestors are keyed by their identity.
+func (c *copier) follow(path string, info os.FileInfo, ancestors map[any]string, walkFn filepath.WalkFunc) error {
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := c.src.Stat(path)
+		if err != nil {
+			return walkFn(path, info, err)
+		}
+		if target.IsDir() {
+			info = target
+		}
+	}
+	if !info.IsDir() {
+		return walkFn(path, info, nil)
+	}
+	key := c.identity(path, info)
+	if other, ok := ancestors[key]; ok {
+		c.log(slog.LevelWarn, "symlink cycle", "path", path, "target", other)
+		c.results <- result{FileReport: FileReport{From: path, Err: ErrSymlinkCycle{Path: path, Target: other}}}
+		return nil
+	}
+	if err := walkFn(path, info, nil); err != nil {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	f, err := c.src.Open(path)
+	if err != nil {
+		return walkFn(path, info, err)
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return walkFn(path, info, err)
+	}
+	sort.Strings(names)
+	ancestors[key] = path
+	defer delete(ancestors, key)
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+		fi, err := lstat(c.src, filename)
+		if err != nil {
+			if err := walkFn(filename, fi, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		if err := c.follow(filename, fi, ancestors, walkFn); err != nil && err != filepath.SkipDir {
+			return err
+		}
+	}
+	return nil
+}
+
+// relink recreates the file at from as a symlink to the same target if it
+// is one and symlinks are copied as such, reporting false otherwise.
+func (c *copier) relink(from, to string, info os.FileInfo) (result, bool) {
+	if !c.symlinks || info.Mode()&os.ModeSymlink == 0 {
+		return result{}, false
+	}
+	r := result{FileReport: FileReport{From: from, To: to}}
+	reader, ok := c.src.(afero.LinkReader)
+	if !ok {
+		r.Err = errors.Errorf("reading symlink %s: %s doesn't support symlinks", from, c.src.Name())
+		return r, true
+	}
+	linker, ok := c.dst.(afero.Linker)
+	if !ok {
+		r.Err = errors.Errorf("creating symlink %s: %s doesn't support symlinks", to, c.dst.Name())
+		return r, true
+	}
+	target, err := reader.ReadlinkIfPossible(from)
+	if err != nil {
+		r.Err = errors.Wrapf(err, "reading symlink %s", from)
+		return r, true
+	}
+	if err := c.dst.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		r.Err = errors.Wrapf(err, "preparing directories for %s", to)
+		return r, true
+	}
+	if _, err := lstat(c.dst, to); err == nil {
+		r.overwrote = true
+		if r.Err = c.prepare(to); r.Err != nil {
+			return r, true
+		}
+		if err := c.dst.Remove(to); err != nil && !os.IsNotExist(err) {
+			r.Err = errors.Wrapf(err, "replacing %s", to)
+			return r, true
+		}
+	} else {
+		c.create(to)
+	}
+	if err := linker.SymlinkIfPossible(target, to); err != nil {
+		r.Err = errors.Wrapf(err, "creating symlink %s", to)
+		return r, true
+	}
+	atomic.AddInt64(&c.stats.files, 1)
+	return r, true
+}
+
+// copyLink copies the symlink from as a symlink, refusing to replace an
+// existing destination without Clobber.
+func (c *Copier) copyLink(ctx context.Context, seen *sync.Map, from, to string, info os.FileInfo) (Report, error) {
+	if _, err := lstat(c.dstFs(), to); err == nil && !c.Clobber && c.OnConflict == nil {
+		return Report{}, ErrClobberAvoided{to}
+	}
+	cp := c.copier()
+	cp.ctx = ctx
+	cp.seen = seen
+	r, _ := cp.relink(from, to, info)
+	r.Err = cp.settle(r.Err)
+	report := Report{}
+	report.add(r)
+	c.finished(r)
+	return report, r.Err
+}
+
+// resolve follows path to what it finally links to, reporting false if it
+// isn't a symlink or can't be followed.
+func (c *copier) resolve(path string) (string, bool) {
+	reader, ok := c.src.(afero.LinkReader)
+	if !ok {
+		return "", false
+	}
+	// As many links as Linux follows before giving up with ELOOP.
+	for hops := 0; hops < 40; hops++ {
+		info, err := lstat(c.src, path)
+		if err != nil {
+			return "", false
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return path, hops > 0
+		}
+		target, err := reader.ReadlinkIfPossible(path)
+		if err != nil {
+			return "", false
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return "", false
+}
+
+// identity distinguishes directories by device and inode where the file
+// system reports them, and otherwise by their real path.
+func (c *copier) identity(path string, info os.FileInfo) any {
+	if id, ok := fileID(info); ok {
+		return id
+	}
+	if isOs(c.src) {
+		if real, err := filepath.EvalSymlinks(path); err == nil {
+			return real
+		}
+	}
+	return filepath.Clean(path)
+}
+
+// lstat stats path without following a final symlink, if fs can.
+func lstat(fs afero.Fs, path string) (os.FileInfo, error) {
+	if lfs, ok := fs.(afero.Lstater); ok {
+		fi, _, err := lfs.LstatIfPossible(path)
+		return fi, err
+	}
+	return fs.Stat(path)
+}
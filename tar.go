@@ -0,0 +1,63 @@
+package cp
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// TarWriter is an ArchiveWriter that produces a tar stream, optionally
+// gzipped. Modes and modification times are preserved.
+type TarWriter struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+// NewTarWriter writes a tar archive to w.
+func NewTarWriter(w io.Writer) *TarWriter {
+	return &TarWriter{tw: tar.NewWriter(w)}
+}
+
+// NewTarGzWriter writes a gzipped tar archive to w.
+func NewTarGzWriter(w io.Writer) *TarWriter {
+	gz := gzip.NewWriter(w)
+	return &TarWriter{tw: tar.NewWriter(gz), gz: gz}
+}
+
+// WriteDir adds a directory entry.
+func (t *TarWriter) WriteDir(path string, info os.FileInfo) error {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return errors.Wrap(err, "preparing header")
+	}
+	hdr.Name = path + "/"
+	return t.tw.WriteHeader(hdr)
+}
+
+// WriteFile adds a file entry.
+func (t *TarWriter) WriteFile(path string, info os.FileInfo, r io.Reader) error {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return errors.Wrap(err, "preparing header")
+	}
+	hdr.Name = path
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(t.tw, r)
+	return err
+}
+
+// Close finishes the archive. The underlying writer is not closed.
+func (t *TarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	if t.gz != nil {
+		return t.gz.Close()
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package cp
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// limiter is a token bucket holding up to a second's worth of bytes.
+// Readers take tokens as they read and sleep off any debt, so concurrent
+// readers share the rate between them.
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(bytesPerSecond int64) *limiter {
+	return &limiter{
+		rate:   float64(bytesPerSecond),
+		burst:  float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		last:   time.Now(),
+	}
+}
+
+// wait takes n tokens, blocking until the bucket has paid them back.
+func (l *limiter) wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	debt := l.tokens
+	l.mu.Unlock()
+	if debt < 0 {
+		time.Sleep(time.Duration(-debt / l.rate * float64(time.Second)))
+	}
+}
+
+// max is the most a single read may take, so one reader cannot run the
+// bucket into long debt.
+func (l *limiter) max() int {
+	if l.burst < 1 {
+		return 1
+	}
+	return int(l.burst)
+}
+
+// throttledReader reads no faster than its limiter allows.
+type throttledReader struct {
+	io.Reader
+	l *limiter
+}
+
+func (r throttledReader) Read(p []byte) (int, error) {
+	if max := r.l.max(); len(p) > max {
+		p = p[:max]
+	}
+	n, err := r.Reader.Read(p)
+	r.l.wait(n)
+	return n, err
+}
@@ -0,0 +1,38 @@
+package cp
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrFileTimeout means a file took longer than FileTimeout to copy.
+type ErrFileTimeout struct {
+	From, To string
+	Timeout  time.Duration
+}
+
+func (err ErrFileTimeout) Error() string {
+	return fmt.Sprintf("copying %q to %q timed out after %v", err.From, err.To, err.Timeout)
+}
+
+// timed copies the file within FileTimeout, if set. A copy stuck in a call
+// that never returns, such as a read from a dead network mount, can't be
+// interrupted, so it is left behind and the worker moves on.
+func (c *copier) timed(j job, r result, copy func(job, result) result) result {
+	if c.fileTimeout <= 0 {
+		return copy(j, r)
+	}
+	done := make(chan result, 1)
+	go func(r result) {
+		done <- copy(j, r)
+	}(r)
+	timer := time.NewTimer(c.fileTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r
+	case <-timer.C:
+		r.Err = ErrFileTimeout{From: j.From, To: j.To, Timeout: c.fileTimeout}
+		return r
+	}
+}
@@ -0,0 +1,174 @@
+package cp
+
+import (
+	"log/slog"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// storage is the kind of storage a path is on, as far as can be told.
+type storage int
+
+const (
+	storageUnknown storage = iota
+	storageSSD
+	storageHDD
+	storageNetwork
+)
+
+func (s storage) String() string {
+	switch s {
+	case storageSSD:
+		return "ssd"
+	case storageHDD:
+		return "hdd"
+	case storageNetwork:
+		return "network"
+	default:
+		return "unknown"
+	}
+}
+
+// probe guesses the kind of storage path is on. Filesystems other than the
+// OS's and memory are taken to be remote stores. An empty path, for a copy
+// with no one place at that end, is unknown.
+func probe(fs afero.Fs, path string) storage {
+	switch {
+	case path == "":
+		return storageUnknown
+	case isOs(fs):
+		return probeDevice(path)
+	case isMem(fs):
+		return storageSSD
+	default:
+		return storageNetwork
+	}
+}
+
+func isMem(fs afero.Fs) bool {
+	_, ok := fs.(*afero.MemMapFs)
+	return ok
+}
+
+// workers is the number of workers to start copying from src to dst with.
+// Spinning disks lose more to seeking between files than they gain from
+// overlapping them, while remote stores are bound by latency rather than
+// the disks, so take many requests in flight.
+func workers(src, dst storage) int {
+	procs := runtime.GOMAXPROCS(0)
+	switch {
+	case src == storageHDD || dst == storageHDD:
+		return 2
+	case src == storageNetwork || dst == storageNetwork:
+		return min(max(procs*8, 16), 64)
+	default:
+		return min(max(procs*2, 4), 32)
+	}
+}
+
+// autoParallel chooses the number of workers for copying from to to, if
+// Parallel left it to the copier, and has it tuned while copying.
+func (c *copier) autoParallel(from, to string) {
+	if c.parallel > 0 {
+		return
+	}
+	src, dst := probe(c.src, from), probe(c.dst, to)
+	c.parallel = workers(src, dst)
+	c.tuning = true
+	c.log(slog.LevelDebug, "parallel chosen", "workers", c.parallel, "from", src.String(), "to", dst.String())
+}
+
+// tuneInterval is how often throughput is measured to tune the number of
+// workers by.
+var tuneInterval = time.Second
+
+// tuner limits the number of workers copying at once to a limit it moves up
+// and down between min and max.
+type tuner struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	active   int
+	min, max int
+}
+
+func newTuner(limit, min, max int) *tuner {
+	t := &tuner{limit: limit, min: min, max: max}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// acquire waits for the number copying to fall below the limit, and counts
+// one more. A nil tuner imposes no limit.
+func (t *tuner) acquire() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.active >= t.limit {
+		t.cond.Wait()
+	}
+	t.active++
+}
+
+// release counts one fewer copying.
+func (t *tuner) release() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.active--
+	t.mu.Unlock()
+	t.cond.Signal()
+}
+
+// move steps the limit up or down, as dir is positive or negative, by an
+// eighth or at least one, within min and max, and returns it.
+func (t *tuner) move(dir int) int {
+	t.mu.Lock()
+	step := max(t.limit/8, 1)
+	if dir < 0 {
+		step = -step
+	}
+	t.limit = min(max(t.limit+step, t.min), t.max)
+	limit := t.limit
+	t.mu.Unlock()
+	t.cond.Broadcast()
+	return limit
+}
+
+// tune climbs towards the number of workers that copies fastest until stop
+// is closed: each interval the limit steps the same way as before unless
+// throughput fell, in which case it turns back. Intervals in which the
+// queue ran dry say nothing of the workers, so are passed over.
+func (c *copier) tune(t *tuner, stop chan struct{}) {
+	tick := time.NewTicker(tuneInterval)
+	defer tick.Stop()
+	last := atomic.LoadInt64(&c.stats.bytes)
+	prev, dir := 0.0, 1
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+		bytes := atomic.LoadInt64(&c.stats.bytes)
+		rate := float64(bytes - last)
+		last = bytes
+		if c.work.len() == 0 {
+			prev = 0
+			continue
+		}
+		if prev > 0 && rate < prev*0.9 {
+			dir = -dir
+		}
+		prev = rate
+		limit := t.move(dir)
+		c.log(slog.LevelDebug, "parallel tuned", "workers", limit)
+	}
+}
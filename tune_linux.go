@@ -0,0 +1,59 @@
+//go:build linux
+
+package cp
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// remoteFilesystems are the statfs types of network filesystems, and FUSE,
+// which mostly fronts remote stores.
+var remoteFilesystems = map[uint32]bool{
+	unix.NFS_SUPER_MAGIC:  true,
+	unix.SMB_SUPER_MAGIC:  true,
+	unix.SMB2_SUPER_MAGIC: true,
+	unix.CIFS_SUPER_MAGIC: true,
+	unix.CEPH_SUPER_MAGIC: true,
+	unix.AFS_SUPER_MAGIC:  true,
+	unix.CODA_SUPER_MAGIC: true,
+	unix.FUSE_SUPER_MAGIC: true,
+}
+
+// probeDevice tells network filesystems by their type and spinning disks
+// by the rotational flag the kernel keeps for the block device beneath
+// path, or its nearest existing parent. Partitions keep the flag of their
+// disk.
+func probeDevice(path string) storage {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return storageUnknown
+	}
+	var st unix.Stat_t
+	for unix.Stat(path, &st) != nil {
+		if path == filepath.Dir(path) {
+			return storageUnknown
+		}
+		path = filepath.Dir(path)
+	}
+	var fs unix.Statfs_t
+	if err := unix.Statfs(path, &fs); err == nil && remoteFilesystems[uint32(fs.Type)] {
+		return storageNetwork
+	}
+	dev := fmt.Sprintf("/sys/dev/block/%d:%d", unix.Major(uint64(st.Dev)), unix.Minor(uint64(st.Dev)))
+	for _, flag := range []string{dev + "/queue/rotational", dev + "/../queue/rotational"} {
+		b, err := os.ReadFile(flag)
+		if err != nil {
+			continue
+		}
+		if strings.TrimSpace(string(b)) == "1" {
+			return storageHDD
+		}
+		return storageSSD
+	}
+	return storageUnknown
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package cp
+
+// probeDevice can't tell what storage is beneath path here.
+func probeDevice(path string) storage {
+	return storageUnknown
+}
@@ -0,0 +1,157 @@
+package cp
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/spf13/afero"
+)
+
+// walkParallel walks the tree like afero.Walk, or walkFollowing when
+// following symlinks, but reads up to c.walkers directories at once. walkFn
+// is called concurrently, each directory before its contents. Once it
+// returns an error other than filepath.SkipDir the walk winds down and that
+// error is returned.
+func (c *copier) walkParallel(root string, walkFn filepath.WalkFunc) error {
+	stat := lstat
+	if c.followSymlinks {
+		stat = func(fs afero.Fs, path string) (os.FileInfo, error) { return fs.Stat(path) }
+	}
+	info, err := stat(c.src, root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	w := &walker{c: c, walkFn: walkFn, slots: make(chan struct{}, c.walkers-1)}
+	w.visit(root, info, nil)
+	w.wg.Wait()
+	return w.err
+}
+
+// walker is the state shared by the goroutines of a parallel walk.
+type walker struct {
+	c      *copier
+	walkFn filepath.WalkFunc
+	// slots bounds the goroutines walking beside the first.
+	slots chan struct{}
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	err   error
+}
+
+// ancestor is a directory above the one being walked, linked to its own
+// parent so that each branch has its own chain.
+type ancestor struct {
+	key    any
+	path   string
+	parent *ancestor
+}
+
+func (a *ancestor) find(key any) (string, bool) {
+	for ; a != nil; a = a.parent {
+		if a.key == key {
+			return a.path, true
+		}
+	}
+	return "", false
+}
+
+// stopped reports whether the walk has failed.
+func (w *walker) stopped() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err != nil
+}
+
+// call calls walkFn, recording the first error that stops the walk. It
+// reports whether to carry on into the path.
+func (w *walker) call(path string, info os.FileInfo, err error) bool {
+	if w.stopped() {
+		return false
+	}
+	err = w.walkFn(path, info, err)
+	if err == nil {
+		return true
+	}
+	if err != filepath.SkipDir {
+		w.mu.Lock()
+		if w.err == nil {
+			w.err = err
+		}
+		w.mu.Unlock()
+	}
+	return false
+}
+
+// visit walks path, handing its subdirectories to other goroutines while
+// there are slots free and walking them itself otherwise.
+func (w *walker) visit(path string, info os.FileInfo, parents *ancestor) {
+	c := w.c
+	if c.followSymlinks && info.Mode()&os.ModeSymlink != 0 {
+		target, err := c.src.Stat(path)
+		if err != nil {
+			w.call(path, info, err)
+			return
+		}
+		if target.IsDir() {
+			info = target
+		}
+	}
+	if !info.IsDir() {
+		w.call(path, info, nil)
+		return
+	}
+	var key any
+	if c.followSymlinks {
+		key = c.identity(path, info)
+		if other, ok := parents.find(key); ok {
+			c.log(slog.LevelWarn, "symlink cycle", "path", path, "target", other)
+			c.results <- result{FileReport: FileReport{From: path, Err: ErrSymlinkCycle{Path: path, Target: other}}}
+			return
+		}
+	}
+	if !w.call(path, info, nil) {
+		return
+	}
+	f, err := c.src.Open(path)
+	if err != nil {
+		w.call(path, info, err)
+		return
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		w.call(path, info, err)
+		return
+	}
+	sort.Strings(names)
+	here := &ancestor{key: key, path: path, parent: parents}
+	for _, name := range names {
+		if w.stopped() {
+			return
+		}
+		filename := filepath.Join(path, name)
+		fi, err := lstat(c.src, filename)
+		if err != nil {
+			w.call(filename, fi, err)
+			continue
+		}
+		if !fi.IsDir() && !(c.followSymlinks && fi.Mode()&os.ModeSymlink != 0) {
+			w.call(filename, fi, nil)
+			continue
+		}
+		select {
+		case w.slots <- struct{}{}:
+			w.wg.Add(1)
+			go func() {
+				defer w.wg.Done()
+				defer func() { <-w.slots }()
+				w.visit(filename, fi, here)
+			}()
+		default:
+			w.visit(filename, fi, here)
+		}
+	}
+}
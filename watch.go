@@ -0,0 +1,194 @@
+package cp
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// Watcher mirrors a source directory one way onto a destination: after an
+// initial copy, files created, modified, renamed or deleted in the source are
+// copied or removed at the destination as they change.
+//
+//	w := &cp.Watcher{Copier: &copier, From: "assets", To: "build/assets"}
+//	go w.Run()
+//	defer w.Close()
+//
+// Changes are noticed through the operating system, so the source must be on
+// the OS filesystem; the destination may be on any DstFs.
+type Watcher struct {
+	// Copier performs the copies. Its Clobber setting applies to the
+	// initial copy only, since mirroring overwrites the destination.
+	Copier *Copier
+	// From is the directory watched, To is the one kept in sync.
+	From, To string
+	// Debounce is how long the source must be quiet before a burst of
+	// changes is applied, defaulting to 100ms. Editors save in several
+	// steps, so applying each change as it happens would copy needlessly.
+	Debounce time.Duration
+	// Synced, when set, is called once each burst of changes is applied.
+	Synced func(Report, error)
+
+	once sync.Once
+	stop chan struct{}
+}
+
+// Run performs the initial copy and then mirrors changes until Close is
+// called. Errors applying changes are passed to Synced rather than stopping
+// the watch.
+func (w *Watcher) Run() error {
+	w.init()
+	c := w.Copier
+	if _, err := c.CopyReport(w.From, w.To); err != nil {
+		return errors.Wrap(err, "initial copy")
+	}
+	to := w.To
+	if c.IncludeRoot {
+		to = filepath.Join(to, filepath.Base(filepath.Clean(w.From)))
+	}
+	notify, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "watching for changes")
+	}
+	defer notify.Close()
+	if err := watchTree(notify, w.From); err != nil {
+		return err
+	}
+	debounce := w.Debounce
+	if debounce <= 0 {
+		debounce = 100 * time.Millisecond
+	}
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+	changed := map[string]bool{}
+	for {
+		select {
+		case <-w.stop:
+			return nil
+		case err := <-notify.Errors:
+			return errors.Wrap(err, "watching for changes")
+		case ev := <-notify.Events:
+			if ev.Op&fsnotify.Create != 0 {
+				if fi, err := os.Stat(ev.Name); err == nil && fi.IsDir() {
+					if err := watchTree(notify, ev.Name); err != nil {
+						return err
+					}
+				}
+			}
+			if ev.Op != fsnotify.Chmod {
+				changed[ev.Name] = true
+				timer.Reset(debounce)
+			}
+		case <-timer.C:
+			report, err := w.sync(changed, to)
+			changed = map[string]bool{}
+			if w.Synced != nil {
+				w.Synced(report, err)
+			}
+		}
+	}
+}
+
+// Close stops the watch.
+func (w *Watcher) Close() error {
+	w.init()
+	select {
+	case <-w.stop:
+	default:
+		close(w.stop)
+	}
+	return nil
+}
+
+func (w *Watcher) init() {
+	w.once.Do(func() {
+		w.stop = make(chan struct{})
+	})
+}
+
+// sync brings the destination up to date with the changed source paths.
+// Paths that still exist are copied over whatever is there, and those that
+// don't are removed.
+func (w *Watcher) sync(changed map[string]bool, to string) (Report, error) {
+	c := w.Copier
+	defer c.start()()
+	paths := make([]string, 0, len(changed))
+	for path := range changed {
+		paths = append(paths, path)
+	}
+	// Parents sort first, so a new directory is copied before its contents
+	// are considered; those are then skipped as already seen.
+	sort.Strings(paths)
+	cp := c.copier()
+	cp.seen = &sync.Map{}
+	// Mirroring applies each change as it comes, so isn't rolled back.
+	cp.undo = nil
+	report := Report{}
+	var errs []error
+	for _, path := range paths {
+		rel, err := filepath.Rel(w.From, path)
+		if err != nil {
+			continue
+		}
+		dst := filepath.Join(to, rel)
+		fi, err := c.srcFs().Stat(path)
+		switch {
+		case os.IsNotExist(err):
+			if err := c.dstFs().RemoveAll(dst); err != nil {
+				errs = append(errs, errors.Wrapf(err, "removing %s", dst))
+			}
+		case err != nil:
+			errs = append(errs, errors.Wrap(err, "reading file metadata"))
+		case fi.IsDir():
+			if err := c.dstFs().MkdirAll(dst, fi.Mode()); err != nil {
+				errs = append(errs, errors.Wrapf(err, "creating %s", dst))
+				continue
+			}
+			tree := c.copier()
+			tree.seen = cp.seen
+			tree.undo = nil
+			r, err := tree.copy(path, dst)
+			report.merge(r)
+			if err != nil {
+				errs = append(errs, err)
+			}
+		default:
+			if _, ok := cp.seen.LoadOrStore(dst, struct{}{}); ok {
+				continue
+			}
+			r := result{FileReport: FileReport{From: path, To: dst}}
+			if r.Err = cp.quota.reserve(path, fi.Size()); r.Err == nil {
+				r.Bytes, r.overwrote, r.Err = cp.copyFile(path, dst)
+			}
+			report.add(r)
+			c.finished(r)
+			if r.Err != nil {
+				errs = append(errs, r.Err)
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return report, Failures{errs}
+	}
+	return report, nil
+}
+
+// watchTree watches dir and every directory beneath it.
+func watchTree(notify *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if err := notify.Add(path); err != nil {
+				return errors.Wrapf(err, "watching %s", path)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,31 @@
+//go:build darwin
+
+package cp
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyXattrs copies the named extended attributes that from has onto to.
+func copyXattrs(from, to string, names ...string) error {
+	for _, name := range names {
+		size, err := unix.Getxattr(from, name, nil)
+		if errors.Is(err, unix.ENOATTR) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		data := make([]byte, size)
+		n, err := unix.Getxattr(from, name, data)
+		if err != nil {
+			return err
+		}
+		if err := unix.Setxattr(to, name, data[:n], 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
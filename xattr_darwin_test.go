@@ -0,0 +1,47 @@
+//go:build darwin
+
+package cp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// TestCopier_MacMetadata tests that the resource fork, Finder information
+// and quarantine are copied.
+func TestCopier_MacMetadata(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "from"), filepath.Join(dir, "to")
+	if err := os.WriteFile(from, []byte("data"), 0644); err != nil {
+		t.Fatalf("unexpected error while writing file: %v", err)
+	}
+	attrs := map[string][]byte{
+		"com.apple.ResourceFork": []byte("fork"),
+		"com.apple.FinderInfo":   make([]byte, 32),
+		"com.apple.quarantine":   []byte("0081;00000000;Safari;"),
+	}
+	attrs["com.apple.FinderInfo"][8] = 0x40 // kIsInvisible
+	for name, data := range attrs {
+		if err := unix.Setxattr(from, name, data, 0); err != nil {
+			t.Skipf("extended attributes not supported: %v", err)
+		}
+	}
+	copier := Copier{PreserveAttributes: true, PreserveStreams: true}
+	if err := copier.Copy(from, to); err != nil {
+		t.Fatalf("unexpected error copying: %v", err)
+	}
+	for name, want := range attrs {
+		got := make([]byte, 256)
+		n, err := unix.Getxattr(to, name, got)
+		if err != nil {
+			t.Errorf("want %s copied, got %v", name, err)
+			continue
+		}
+		if string(got[:n]) != string(want) {
+			t.Errorf("want %s to be %q, got %q", name, want, got[:n])
+		}
+	}
+}
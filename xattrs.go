@@ -0,0 +1,46 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package cp
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyAllXattrs copies the extended attributes of one file onto the other,
+// leaving out the security namespace.
+func copyAllXattrs(from, to string) error {
+	size, err := unix.Listxattr(from, nil)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil
+	}
+	if err != nil || size == 0 {
+		return err
+	}
+	list := make([]byte, size)
+	n, err := unix.Listxattr(from, list)
+	if err != nil {
+		return err
+	}
+	for _, name := range strings.Split(string(bytes.TrimRight(list[:n], "\x00")), "\x00") {
+		if name == "" || strings.HasPrefix(name, "security.") {
+			continue
+		}
+		size, err := unix.Getxattr(from, name, nil)
+		if err != nil {
+			return err
+		}
+		data := make([]byte, size)
+		n, err := unix.Getxattr(from, name, data)
+		if err != nil {
+			return err
+		}
+		if err := unix.Setxattr(to, name, data[:n], 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
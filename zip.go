@@ -0,0 +1,86 @@
+package cp
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ZipWriter is an ArchiveWriter that produces a zip archive.
+type ZipWriter struct {
+	zw *zip.Writer
+}
+
+// NewZipWriter writes a zip archive to w.
+func NewZipWriter(w io.Writer) *ZipWriter {
+	return &ZipWriter{zw: zip.NewWriter(w)}
+}
+
+// WriteDir adds a directory entry.
+func (z *ZipWriter) WriteDir(path string, info os.FileInfo) error {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return errors.Wrap(err, "preparing header")
+	}
+	hdr.Name = path + "/"
+	_, err = z.zw.CreateHeader(hdr)
+	return err
+}
+
+// WriteFile adds a compressed file entry.
+func (z *ZipWriter) WriteFile(path string, info os.FileInfo, r io.Reader) error {
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return errors.Wrap(err, "preparing header")
+	}
+	hdr.Name = path
+	hdr.Method = zip.Deflate
+	w, err := z.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// Close finishes the archive. The underlying writer is not closed.
+func (z *ZipWriter) Close() error {
+	return z.zw.Close()
+}
+
+// ExtractZip copies the contents of the zip archive at path on the source
+// filesystem into the directory to, through the same workers, clobber rules
+// and error reporting as Copy.
+func (c *Copier) ExtractZip(archive, to string) error {
+	defer c.start()()
+	f, err := c.srcFs().Open(archive)
+	if err != nil {
+		return errors.Wrapf(err, "opening %s", archive)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.Wrap(err, "reading file metadata")
+	}
+	zr, err := zip.NewReader(&lockedReaderAt{r: f}, fi.Size())
+	if err != nil {
+		return errors.Wrapf(err, "reading %s", archive)
+	}
+	return c.CopyFS(zr, to)
+}
+
+// lockedReaderAt serialises reads, since the workers read entries
+// concurrently and not every afero.File supports concurrent ReadAt.
+type lockedReaderAt struct {
+	mu sync.Mutex
+	r  io.ReaderAt
+}
+
+func (l *lockedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.ReadAt(p, off)
+}